@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/rahulvramesh/gh-proxy-local/internal/config"
+	"github.com/rahulvramesh/gh-proxy-local/internal/models"
+)
+
+const configUsage = `Usage: gh-proxy-local config <command>
+
+Commands:
+  validate [FILE]  Check a configuration file (default: $COPILOT_CONFIG), with
+                   the environment applied, and the model aliases file
+`
+
+// runConfigCommand implements the config subcommands and returns the
+// process exit code.
+func runConfigCommand(args []string) int {
+	if len(args) == 0 || args[0] != "validate" || len(args) > 2 {
+		fmt.Fprint(os.Stderr, configUsage)
+		return 2
+	}
+
+	path := os.Getenv("COPILOT_CONFIG")
+	if len(args) == 2 {
+		path = args[1]
+	}
+	if path == "" {
+		fmt.Fprintln(os.Stderr, "Error: no configuration file given and COPILOT_CONFIG is not set")
+		return 2
+	}
+
+	cfg, err := config.Load(path)
+	if err == nil {
+		_, err = models.LoadAliases(cfg.AliasesFile)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s is invalid:\n", path)
+		for _, line := range strings.Split(err.Error(), "\n") {
+			fmt.Fprintf(os.Stderr, "  %s\n", line)
+		}
+		return 1
+	}
+
+	fmt.Printf("%s is valid\n", path)
+	return 0
+}
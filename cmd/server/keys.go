@@ -0,0 +1,174 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rahulvramesh/gh-proxy-local/internal/apikeys"
+	"github.com/rahulvramesh/gh-proxy-local/internal/config"
+)
+
+const keysUsage = `Usage: gh-proxy-local keys <command> [flags]
+
+Commands:
+  create --name NAME [--owner OWNER] [--models a,b*] [--endpoints /v1/messages*]
+         [--default-model MODEL] [--expires 720h|2006-01-02]
+         [--rpm N] [--tpm N] [--max-in-flight N]
+         [--daily-tokens N] [--monthly-tokens N]
+  list
+  rotate NAME
+  revoke NAME
+`
+
+// runKeysCommand implements the keys subcommands for managing the API key
+// registry and returns the process exit code.
+func runKeysCommand(cfg *config.Config, args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, keysUsage)
+		return 2
+	}
+
+	store, err := apikeys.NewStore(cfg.KeysFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	switch args[0] {
+	case "create":
+		err = keysCreate(store, args[1:])
+	case "list":
+		keysList(store)
+	case "rotate", "revoke":
+		if len(args) != 2 {
+			fmt.Fprint(os.Stderr, keysUsage)
+			return 2
+		}
+		if args[0] == "rotate" {
+			err = keysRotate(store, args[1])
+		} else {
+			_, err = store.Revoke(args[1])
+			if err == nil {
+				fmt.Printf("Revoked key %s\n", args[1])
+			}
+		}
+	default:
+		fmt.Fprint(os.Stderr, keysUsage)
+		return 2
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func keysCreate(store *apikeys.Store, args []string) error {
+	fs := flag.NewFlagSet("keys create", flag.ContinueOnError)
+	name := fs.String("name", "", "Key name (required)")
+	owner := fs.String("owner", "", "Key owner")
+	modelList := fs.String("models", "", "Comma-separated allowed models (default: all)")
+	endpointList := fs.String("endpoints", "", "Comma-separated allowed endpoints (default: all)")
+	defaultModel := fs.String("default-model", "", "Model used when a request names none")
+	expires := fs.String("expires", "", "Expiry as a duration (720h) or date (2006-01-02)")
+	rpm := fs.Int("rpm", 0, "Requests per minute (0: server default, -1: unlimited)")
+	tpm := fs.Int("tpm", 0, "Tokens per minute (0: server default, -1: unlimited)")
+	dailyTokens := fs.Int64("daily-tokens", 0, "Daily token budget (0: server default, -1: unlimited)")
+	monthlyTokens := fs.Int64("monthly-tokens", 0, "Monthly token budget (0: server default, -1: unlimited)")
+	maxInFlight := fs.Int("max-in-flight", 0, "Concurrent requests (0: server default, -1: unlimited)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	spec := apikeys.Spec{
+		Name:               *name,
+		Owner:              *owner,
+		AllowedModels:      splitList(*modelList),
+		AllowedEndpoints:   splitList(*endpointList),
+		DefaultModel:       *defaultModel,
+		RequestsPerMinute:  *rpm,
+		TokensPerMinute:    *tpm,
+		MaxInFlight:        *maxInFlight,
+		DailyTokenBudget:   *dailyTokens,
+		MonthlyTokenBudget: *monthlyTokens,
+	}
+	if *expires != "" {
+		expiresAt, err := parseExpiry(*expires)
+		if err != nil {
+			return err
+		}
+		spec.ExpiresAt = &expiresAt
+	}
+
+	key, secret, err := store.Create(spec)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Created key %s\n", key.Name)
+	fmt.Printf("Secret (shown once): %s\n", secret)
+	return nil
+}
+
+func keysRotate(store *apikeys.Store, name string) error {
+	key, secret, err := store.Rotate(name)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Rotated key %s\n", key.Name)
+	fmt.Printf("New secret (shown once): %s\n", secret)
+	return nil
+}
+
+func keysList(store *apikeys.Store) {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tOWNER\tPREFIX\tSTATUS\tEXPIRES\tMODELS\tENDPOINTS")
+	for _, key := range store.List() {
+		status := "active"
+		if err := key.Status(time.Now()); errors.Is(err, apikeys.ErrRevoked) {
+			status = "revoked"
+		} else if errors.Is(err, apikeys.ErrExpired) {
+			status = "expired"
+		}
+		expires := "-"
+		if key.ExpiresAt != nil {
+			expires = key.ExpiresAt.Format("2006-01-02")
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", key.Name, orDash(key.Owner), key.Prefix, status, expires,
+			orDash(strings.Join(key.AllowedModels, ",")), orDash(strings.Join(key.AllowedEndpoints, ",")))
+	}
+	tw.Flush()
+}
+
+// parseExpiry accepts a duration from now or a calendar date.
+func parseExpiry(value string) (time.Time, error) {
+	if d, err := time.ParseDuration(value); err == nil && d > 0 {
+		return time.Now().Add(d).UTC(), nil
+	}
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t.UTC(), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid expiry %q: use a duration such as 720h or a date such as 2006-01-02", value)
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
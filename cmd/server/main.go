@@ -5,50 +5,153 @@
 //
 // Usage:
 //
-//	go run cmd/server/main.go [--port PORT] [--host HOST]
+//	go run cmd/server/main.go [--config FILE] [--port PORT] [--host HOST]
+//	go run cmd/server/main.go keys create|list|rotate|revoke ...
+//	go run cmd/server/main.go config validate [FILE]
 //
 // First run will perform OAuth device flow authentication.
 // Credentials are cached in ~/.copilot_credentials.json
 //
+// Settings come from the defaults, then the configuration file, then the
+// environment, then the command line flags. Send SIGHUP to reload the
+// configuration file; aliases, routing, cors, limits.rate, limits.budget,
+// debug and the log level take effect without a restart.
+//
 // Environment Variables:
 //
+//	COPILOT_CONFIG=path  YAML or JSON configuration file (optional)
 //	COPILOT_DEBUG=1     Enable debug logging
+//	COPILOT_LOG_LEVEL=info  Log level: debug, info, warn or error (default: info, debug with COPILOT_DEBUG)
+//	COPILOT_LOG_FORMAT=text  Log format: text or json (default: text)
 //	COPILOT_PORT=8080   Server port (default: 8080)
 //	COPILOT_HOST=0.0.0.0  Server host (default: 0.0.0.0)
 //	COPILOT_API_KEY=key   API key for bearer auth (optional)
+//	COPILOT_KEYS_FILE=path  Per-client API key registry (default: $COPILOT_DATA_DIR/keys.json)
+//	COPILOT_ADMIN_KEY=key  Credential for the /admin endpoints (admin API disabled if unset)
+//	COPILOT_ADMIN_ADDR=127.0.0.1:9090  Separate listen address for the admin API and dashboard
+//	COPILOT_ALIASES_FILE=path  Model aliases, reloadable at runtime (default: $COPILOT_DATA_DIR/aliases.json)
+//	COPILOT_DATA_DIR=dir  Directory for local state (default: ~/.copilot_proxy)
+//	COPILOT_BATCH_CONCURRENCY=4  Max batch items in flight (default: 4)
+//	COPILOT_BATCH_RPM=60  Max batch items started per minute, 0 = unlimited (default: 60)
+//	COPILOT_FETCH_ALLOWED_HOSTS=hosts  Hosts for url image/document fetching (default: none)
+//	COPILOT_FETCH_MAX_BYTES=20971520  Max size of a fetched image or document
+//	COPILOT_FETCH_TIMEOUT=30s  Timeout for fetching url sources
+//	COPILOT_INITIATOR=user|agent  Force the X-Initiator header (default: derived per request)
+//	COPILOT_KEY_INITIATORS=key=agent,...  Force the X-Initiator header per API key
+//	COPILOT_UPSTREAM_RETRIES=0  Retries for upstream 429/503 responses with Retry-After
+//	COPILOT_OPENAI_INTENT=conversation-edits  Openai-Intent header for chat requests
+//	COPILOT_EDITOR_VERSION, COPILOT_EDITOR_PLUGIN_VERSION, COPILOT_USER_AGENT  Header overrides
+//	COPILOT_PREMIUM_MULTIPLIERS=model=1.5,...  Premium request multiplier overrides
+//	COPILOT_PREMIUM_DEFAULT_MULTIPLIER=1  Multiplier for models not in the table
+//	COPILOT_QUOTA_POLL_INTERVAL=5m  Quota snapshot refresh interval, 0 = startup only
+//	COPILOT_QUOTA_SOFT_LIMIT=0  Remaining premium requests at which to warn or block, 0 = off
+//	COPILOT_QUOTA_SOFT_ACTION=warn|block  Action once the soft limit is reached (default: warn)
+//	COPILOT_RATE_LIMIT_RPM=0  Default requests per minute per API key, 0 = unlimited
+//	COPILOT_RATE_LIMIT_TPM=0  Default tokens per minute per API key, 0 = unlimited
+//	COPILOT_MAX_IN_FLIGHT_PER_KEY=0  Default concurrent requests per API key, 0 = unlimited
+//	COPILOT_MAX_IN_FLIGHT_PER_MODEL=0  Concurrent upstream requests per model, 0 = unlimited
+//	COPILOT_QUEUE_SIZE=100  Max requests waiting for a concurrency slot
+//	COPILOT_QUEUE_TIMEOUT=60s  Max time a request waits for a concurrency slot
+//	COPILOT_DAILY_TOKEN_BUDGET=0  Default daily token budget per API key, 0 = none
+//	COPILOT_MONTHLY_TOKEN_BUDGET=0  Default monthly token budget per API key, 0 = none
+//	COPILOT_BUDGET_ALERT_THRESHOLDS=80,100  Budget percentages that raise an alert
+//	COPILOT_BUDGET_ALERT_WEBHOOK=url  URL receiving budget alerts as JSON POSTs
+//	OTEL_EXPORTER_OTLP_ENDPOINT=url  OTLP/HTTP collector; enables tracing (spans go to url/v1/traces)
+//	OTEL_EXPORTER_OTLP_TRACES_ENDPOINT=url  Full OTLP/HTTP traces URL, overriding the above
+//	OTEL_EXPORTER_OTLP_HEADERS=k=v,...  Headers sent to the collector
+//	OTEL_SERVICE_NAME=gh-proxy-local  Service name of exported spans
+//	COPILOT_REDACT=email,jwt,...|all  Detectors redacting telemetry payloads (default: none)
+//	COPILOT_REDACT_PATTERNS=regex ...  Whitespace-separated custom patterns to redact
+//	COPILOT_REDACT_MODE=redact|hash|truncate  Replace matches, or hash or truncate whole payloads
+//	COPILOT_REDACT_TRUNCATE=1000  Payload length kept in truncate mode
+//	COPILOT_TELEMETRY_FILE=path  Rotating JSONL file receiving a record per request
+//	COPILOT_TELEMETRY_WEBHOOK_URL=url  URL receiving batches of records as JSON POSTs
+//	COPILOT_TELEMETRY_WEBHOOK_SECRET=secret  HMAC-SHA256 key signing webhook requests
+//	COPILOT_TELEMETRY_STDOUT=false  Write records as JSON lines to stdout
+//	COPILOT_TELEMETRY_PAYLOADS=false  Include input and output in exported records
+//	COPILOT_HISTORY_MAX_BYTES=104857600  Request history size limit, 0 = disabled
+//	COPILOT_HISTORY_TTL=168h  How long the request history keeps requests
+//	COPILOT_HISTORY_PAYLOADS=true  Record request and response bodies in the history
+//	COPILOT_FEEDBACK_MAX_BYTES=10485760  Feedback history size limit
 package main
 
 import (
 	"context"
+	"crypto/subtle"
 	"flag"
 	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"syscall"
 	"time"
 
+	"github.com/rahulvramesh/gh-proxy-local/internal/apikeys"
 	"github.com/rahulvramesh/gh-proxy-local/internal/auth"
+	"github.com/rahulvramesh/gh-proxy-local/internal/batch"
+	"github.com/rahulvramesh/gh-proxy-local/internal/budget"
 	"github.com/rahulvramesh/gh-proxy-local/internal/config"
+	"github.com/rahulvramesh/gh-proxy-local/internal/converter"
 	"github.com/rahulvramesh/gh-proxy-local/internal/copilot"
+	"github.com/rahulvramesh/gh-proxy-local/internal/feedback"
+	"github.com/rahulvramesh/gh-proxy-local/internal/files"
 	"github.com/rahulvramesh/gh-proxy-local/internal/handlers"
+	"github.com/rahulvramesh/gh-proxy-local/internal/history"
+	"github.com/rahulvramesh/gh-proxy-local/internal/inflight"
 	"github.com/rahulvramesh/gh-proxy-local/internal/langfuse"
+	"github.com/rahulvramesh/gh-proxy-local/internal/logging"
+	"github.com/rahulvramesh/gh-proxy-local/internal/metrics"
+	"github.com/rahulvramesh/gh-proxy-local/internal/models"
+	"github.com/rahulvramesh/gh-proxy-local/internal/ratelimit"
+	"github.com/rahulvramesh/gh-proxy-local/internal/redact"
+	"github.com/rahulvramesh/gh-proxy-local/internal/telemetry"
+	"github.com/rahulvramesh/gh-proxy-local/internal/tracing"
+	"github.com/rahulvramesh/gh-proxy-local/internal/ui"
+	"github.com/rahulvramesh/gh-proxy-local/internal/usage"
 )
 
 func main() {
-	cfg := config.NewConfig()
+	if len(os.Args) > 1 && os.Args[1] == "config" {
+		os.Exit(runConfigCommand(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "keys" {
+		cfg := loadConfig(os.Getenv("COPILOT_CONFIG"), func(*config.Config) {})
+		os.Exit(runKeysCommand(cfg, os.Args[2:]))
+	}
 
-	// Parse command line flags
-	port := flag.Int("port", cfg.Port, "Port to listen on")
-	flag.IntVar(port, "p", cfg.Port, "Port to listen on (shorthand)")
-	host := flag.String("host", cfg.Host, "Host to bind to")
-	flag.StringVar(host, "H", cfg.Host, "Host to bind to (shorthand)")
+	// Parse command line flags. Only flags given on the command line
+	// override the configuration.
+	configFile := flag.String("config", os.Getenv("COPILOT_CONFIG"), "Configuration file, YAML or JSON")
+	flag.StringVar(configFile, "c", os.Getenv("COPILOT_CONFIG"), "Configuration file (shorthand)")
+	port := flag.Int("port", 0, "Port to listen on (default 8080)")
+	flag.IntVar(port, "p", 0, "Port to listen on (shorthand)")
+	host := flag.String("host", "", "Host to bind to (default 0.0.0.0)")
+	flag.StringVar(host, "H", "", "Host to bind to (shorthand)")
 	flag.Parse()
 
-	cfg.Port = *port
-	cfg.Host = *host
+	overrides := func(cfg *config.Config) {
+		flag.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "port", "p":
+				cfg.Port = *port
+			case "host", "H":
+				cfg.Host = *host
+			}
+		})
+	}
+	cfg := loadConfig(*configFile, overrides)
+
+	// Open the client API key registry
+	keyStore, err := apikeys.NewStore(cfg.KeysFile)
+	if err != nil {
+		log.Fatalf("Failed to open API key registry: %v", err)
+	}
 
 	// Initialize auth manager
 	authManager := auth.NewManager(cfg)
@@ -60,18 +163,166 @@ func main() {
 	}
 	fmt.Println("Authentication verified!")
 
+	// Load model aliases on top of the built-in ones
+	aliases, err := loadAliases(cfg)
+	if err != nil {
+		log.Fatalf("Invalid model aliases: %v", err)
+	}
+	models.SetAliases(aliases)
+	models.SetRouting(cfg.Routing.HiddenModels, cfg.Routing.AllowedModels)
+
 	// Initialize Copilot client
-	client := copilot.NewClient(authManager, cfg.Debug)
+	cfg.Upstream.ApplyHeaders()
+	client := copilot.NewClient(authManager, cfg.Upstream, cfg.Debug)
+
+	// Initialize premium request accounting
+	usageTracker, err := usage.NewTracker(filepath.Join(cfg.DataDir, "usage.json"), cfg.Usage, quotaSnapshot(authManager), cfg.Debug)
+	if err != nil {
+		log.Fatalf("Failed to open usage store: %v", err)
+	}
+	client.SetUsageObserver(usageTracker)
+	usageTracker.Start()
+
+	// Initialize rate and concurrency limits
+	limiter := ratelimit.NewLimiter(cfg.RateLimit)
+	client.SetLimiter(limiter)
+	client.AddTokenObserver(limiter)
+	client.AddTokenObserver(tokenMetrics{})
+
+	// Initialize token budgets
+	budgetTracker, err := budget.NewTracker(filepath.Join(cfg.DataDir, "budgets.json"), cfg.Budget, func(name string) *apikeys.Key {
+		key, _ := keyStore.Get(name)
+		return key
+	}, cfg.Debug)
+	if err != nil {
+		log.Fatalf("Failed to open budget store: %v", err)
+	}
+	budgetTracker.Start()
 
 	// Initialize Langfuse client
 	langfuseClient := langfuse.NewClient(cfg.Langfuse, cfg.Debug)
+	metrics.NewGaugeFunc("copilot_proxy_langfuse_queue_depth", "Langfuse events waiting to be sent.", func() float64 {
+		return float64(langfuseClient.QueueDepth())
+	})
+	metrics.NewGaugeFunc("copilot_proxy_langfuse_spool_events", "Langfuse events buffered in the disk spool.", func() float64 {
+		events, _ := langfuseClient.SpoolStats()
+		return float64(events)
+	})
+	metrics.NewGaugeFunc("copilot_proxy_langfuse_spool_bytes", "Size of the Langfuse disk spool in bytes.", func() float64 {
+		_, bytes := langfuseClient.SpoolStats()
+		return float64(bytes)
+	})
+
+	// Initialize OpenTelemetry tracing
+	tracer := tracing.NewTracer(cfg.Tracing, cfg.Debug)
+
+	// Redact payloads before they reach any telemetry sink
+	redactor, err := redact.New(cfg.Redact)
+	if err != nil {
+		log.Fatalf("Invalid redaction configuration: %v", err)
+	}
+
+	// Export request records to the configured file, webhook and stdout
+	// sinks, with payloads only when enabled
+	var exporters telemetry.Sinks
+	var fileSink *telemetry.FileSink
+	if cfg.Telemetry.File != "" {
+		fileSink, err = telemetry.NewFileSink(cfg.Telemetry.File, cfg.Telemetry.FileMaxBytes, cfg.Telemetry.FileMaxFiles)
+		if err != nil {
+			log.Fatalf("Failed to open telemetry file: %v", err)
+		}
+		exporters = append(exporters, fileSink)
+	}
+	var webhookSink *telemetry.WebhookSink
+	if cfg.Telemetry.WebhookURL != "" {
+		webhookSink = telemetry.NewWebhookSink(cfg.Telemetry.WebhookURL, cfg.Telemetry.WebhookSecret,
+			cfg.Telemetry.WebhookBatchSize, cfg.Telemetry.WebhookFlushInterval, cfg.Telemetry.WebhookMaxRetries)
+		exporters = append(exporters, webhookSink)
+	}
+	if cfg.Telemetry.Stdout {
+		exporters = append(exporters, telemetry.NewWriterSink("stdout", os.Stdout))
+	}
+	var exported telemetry.Sink = exporters
+	if !cfg.Telemetry.Payloads {
+		exported = telemetry.WithoutPayloads(exporters)
+	}
+
+	// Keep a local history of requests for the admin API
+	historyStore, err := history.NewStore(filepath.Join(cfg.DataDir, "history.jsonl"), cfg.History, redactor)
+	if err != nil {
+		log.Fatalf("Failed to open request history: %v", err)
+	}
+	metrics.NewGaugeFunc("copilot_proxy_history_entries", "Requests kept in the request history.", func() float64 {
+		entries, _ := historyStore.Stats()
+		return float64(entries)
+	})
+	metrics.NewGaugeFunc("copilot_proxy_history_bytes", "Size of the request history in bytes.", func() float64 {
+		_, bytes := historyStore.Stats()
+		return float64(bytes)
+	})
+
+	// Track the inference requests being served
+	inFlight := inflight.NewRegistry()
+	metrics.NewGaugeFunc("copilot_proxy_in_flight_requests", "Inference requests being served.", func() float64 {
+		return float64(inFlight.Len())
+	})
+
+	sinks := telemetry.Redact(telemetry.Sinks{telemetry.Langfuse(langfuseClient), tracer, historyStore, exported}, redactor)
 
 	// Initialize handlers
 	modelsHandler := handlers.NewModelsHandler(client)
-	chatHandler := handlers.NewChatHandler(client, langfuseClient, cfg.Debug)
-	responsesHandler := handlers.NewResponsesHandler(client, langfuseClient, cfg.Debug)
-	anthropicHandler := handlers.NewAnthropicHandler(client, langfuseClient, cfg.Debug)
+	chatHandler := handlers.NewChatHandler(client, sinks, budgetTracker, cfg.Debug)
+	responsesHandler := handlers.NewResponsesHandler(client, sinks, budgetTracker, cfg.Debug)
+	fetcher := converter.NewFetcher(cfg.Fetch.AllowedHosts, cfg.Fetch.MaxBytes, cfg.Fetch.Timeout)
+	anthropicHandler := handlers.NewAnthropicHandler(client, sinks, budgetTracker, fetcher, cfg.Debug)
+	embeddingsHandler := handlers.NewEmbeddingsHandler(client, sinks, budgetTracker, cfg.Debug)
 	healthHandler := handlers.NewHealthHandler(authManager, client)
+	usageHandler := handlers.NewUsageHandler(usageTracker)
+	keysHandler := handlers.NewKeysHandler(keyStore)
+	budgetsHandler := handlers.NewBudgetsHandler(budgetTracker, keyStore)
+	feedbackStore, err := feedback.NewStore(filepath.Join(cfg.DataDir, "feedback.jsonl"), cfg.History.FeedbackMaxBytes)
+	if err != nil {
+		log.Fatalf("Failed to open feedback store: %v", err)
+	}
+	feedbackHandler := handlers.NewFeedbackHandler(langfuseClient, feedbackStore, redactor)
+	historyHandler := handlers.NewHistoryHandler(historyStore, feedbackStore)
+	inFlightHandler := handlers.NewInFlightHandler(inFlight)
+	var cors atomic.Pointer[config.CORSConfig]
+	cors.Store(&cfg.CORS)
+	live := &liveConfig{
+		path:      *configFile,
+		overrides: overrides,
+		client:    client,
+		limiter:   limiter,
+		budgets:   budgetTracker,
+		cors:      &cors,
+		running:   *cfg,
+	}
+	adminHandler := handlers.NewAdminHandler(client, authManager, langfuseClient, map[string]handlers.Reloader{
+		"config": live.Reload,
+		"keys": func() (string, error) {
+			if err := keyStore.Reload(); err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("%d keys from %s", keyStore.Len(), cfg.KeysFile), nil
+		},
+	})
+
+	// Initialize batch processing
+	batchStore, err := batch.NewStore(filepath.Join(cfg.DataDir, "batches"))
+	if err != nil {
+		log.Fatalf("Failed to open batch store: %v", err)
+	}
+	batchRunner := batch.NewRunner(batchStore, cfg.Batch.Concurrency, cfg.Batch.RequestsPerMinute, cfg.Debug)
+	fileStore, err := files.NewStore(filepath.Join(cfg.DataDir, "files"), files.DefaultMaxSize)
+	if err != nil {
+		log.Fatalf("Failed to open file store: %v", err)
+	}
+	batchItems := handlers.NewItemServer(keyStore, limiter)
+	anthropicBatchHandler := handlers.NewAnthropicBatchHandler(batchRunner, anthropicHandler, batchItems)
+	filesHandler := handlers.NewFilesHandler(fileStore)
+	batchesHandler := handlers.NewBatchesHandler(batchRunner, fileStore, batchItems, chatHandler, responsesHandler, embeddingsHandler)
+	batchRunner.Start()
 
 	// Set up router
 	mux := http.NewServeMux()
@@ -80,10 +331,13 @@ func main() {
 	mux.HandleFunc("GET /", healthHandler.Health)
 	mux.HandleFunc("GET /health", healthHandler.Health)
 	mux.HandleFunc("GET /info", healthHandler.Info)
+	mux.Handle("GET /metrics", metrics.Default.Handler())
 
 	// Account endpoints
 	mux.HandleFunc("GET /v1/account", healthHandler.Account)
 	mux.HandleFunc("GET /account", healthHandler.Account)
+	mux.HandleFunc("GET /v1/usage", usageHandler.Usage)
+	mux.HandleFunc("GET /usage", usageHandler.Usage)
 
 	// Models endpoints
 	mux.HandleFunc("GET /v1/models", modelsHandler.ListModels)
@@ -99,28 +353,102 @@ func main() {
 	mux.HandleFunc("POST /v1/responses", responsesHandler.Responses)
 	mux.HandleFunc("POST /responses", responsesHandler.Responses)
 
+	// OpenAI embeddings
+	mux.HandleFunc("POST /v1/embeddings", embeddingsHandler.Embeddings)
+	mux.HandleFunc("POST /embeddings", embeddingsHandler.Embeddings)
+
+	// Feedback on traces
+	mux.HandleFunc("POST /v1/feedback", feedbackHandler.Feedback)
+
+	// OpenAI files and batches
+	mux.HandleFunc("POST /v1/files", filesHandler.UploadFile)
+	mux.HandleFunc("GET /v1/files", filesHandler.ListFiles)
+	mux.HandleFunc("GET /v1/files/{file_id}", filesHandler.GetFile)
+	mux.HandleFunc("DELETE /v1/files/{file_id}", filesHandler.DeleteFile)
+	mux.HandleFunc("GET /v1/files/{file_id}/content", filesHandler.FileContent)
+	mux.HandleFunc("POST /v1/batches", batchesHandler.CreateBatch)
+	mux.HandleFunc("GET /v1/batches", batchesHandler.ListBatches)
+	mux.HandleFunc("GET /v1/batches/{batch_id}", batchesHandler.GetBatch)
+	mux.HandleFunc("POST /v1/batches/{batch_id}/cancel", batchesHandler.CancelBatch)
+
 	// Anthropic messages API
 	mux.HandleFunc("POST /v1/messages", anthropicHandler.Messages)
 	mux.HandleFunc("POST /messages", anthropicHandler.Messages)
 	mux.HandleFunc("POST /v1/messages/count_tokens", anthropicHandler.CountTokens)
 	mux.HandleFunc("POST /messages/count_tokens", anthropicHandler.CountTokens)
 
-	// Anthropic batches (not supported, but handle gracefully)
-	mux.HandleFunc("POST /v1/messages/batches", anthropicHandler.Batches)
-	mux.HandleFunc("GET /v1/messages/batches", anthropicHandler.Batches)
-	mux.HandleFunc("GET /v1/messages/batches/{batch_id}", anthropicHandler.Batches)
+	// Anthropic message batches
+	mux.HandleFunc("POST /v1/messages/batches", anthropicBatchHandler.CreateBatch)
+	mux.HandleFunc("GET /v1/messages/batches", anthropicBatchHandler.ListBatches)
+	mux.HandleFunc("GET /v1/messages/batches/{batch_id}", anthropicBatchHandler.GetBatch)
+	mux.HandleFunc("DELETE /v1/messages/batches/{batch_id}", anthropicBatchHandler.DeleteBatch)
+	mux.HandleFunc("POST /v1/messages/batches/{batch_id}/cancel", anthropicBatchHandler.CancelBatch)
+	mux.HandleFunc("GET /v1/messages/batches/{batch_id}/results", anthropicBatchHandler.BatchResults)
+
+	// Admin endpoints and the dashboard, served on their own listener when
+	// COPILOT_ADMIN_ADDR is set
+	adminMux := mux
+	if cfg.AdminAddr != "" {
+		adminMux = http.NewServeMux()
+	}
+	sessions := ui.NewSessions(cfg.AdminKey, ui.SessionTTL)
+	admin := func(h http.HandlerFunc) http.Handler { return adminMiddleware(cfg.AdminKey, sessions, h) }
+	adminMux.Handle("GET /admin/keys", admin(keysHandler.ListKeys))
+	adminMux.Handle("POST /admin/keys", admin(keysHandler.CreateKey))
+	adminMux.Handle("GET /admin/keys/{name}", admin(keysHandler.GetKey))
+	adminMux.Handle("POST /admin/keys/{name}/rotate", admin(keysHandler.RotateKey))
+	adminMux.Handle("DELETE /admin/keys/{name}", admin(keysHandler.RevokeKey))
+	adminMux.Handle("GET /admin/budgets", admin(budgetsHandler.ListBudgets))
+	adminMux.Handle("GET /admin/budgets/{name}", admin(budgetsHandler.GetBudget))
+	adminMux.Handle("POST /admin/budgets/{name}/reset", admin(budgetsHandler.ResetBudget))
+	adminMux.Handle("GET /admin/requests", admin(historyHandler.ListRequests))
+	adminMux.Handle("GET /admin/requests/{id}", admin(historyHandler.GetRequest))
+	adminMux.Handle("GET /admin/inflight", admin(inFlightHandler.ListInFlight))
+	adminMux.Handle("POST /admin/inflight/{id}/cancel", admin(inFlightHandler.CancelInFlight))
+	adminMux.Handle("GET /admin/account", admin(healthHandler.Account))
+	adminMux.Handle("GET /admin/usage", admin(usageHandler.Usage))
+	adminMux.Handle("GET /admin/models", admin(modelsHandler.ListModels))
+	adminMux.Handle("POST /admin/models/flush", admin(adminHandler.FlushModels))
+	adminMux.Handle("POST /admin/reload", admin(adminHandler.Reload))
+	adminMux.Handle("POST /admin/token/refresh", admin(adminHandler.RefreshToken))
+	adminMux.Handle("GET /admin/langfuse", admin(adminHandler.LangfuseQueue))
+	adminMux.Handle("POST /admin/langfuse/flush", admin(adminHandler.FlushLangfuse))
+	adminMux.Handle("GET /admin/debug", admin(adminHandler.Debug))
+	adminMux.Handle("PUT /admin/debug", admin(adminHandler.SetDebug))
+
+	// Dashboard, signed in to with the admin credential
+	adminMux.HandleFunc("GET /ui/login", ui.LoginPage)
+	adminMux.HandleFunc("POST /ui/login", sessions.Login)
+	adminMux.HandleFunc("POST /ui/logout", sessions.Logout)
+	adminMux.Handle("GET /ui/", uiMiddleware(cfg.AdminKey, sessions, ui.Handler()))
 
 	// Add CORS middleware
-	handler := apiKeyMiddleware(cfg.APIKey, corsMiddleware(mux))
+	handler := requestIDMiddleware(metricsMiddleware(mux, tracingMiddleware(tracer, mux, apiKeyMiddleware(cfg.APIKey, keyStore, noLogMiddleware(historyMiddleware(historyStore, inFlightMiddleware(inFlight, corsMiddleware(&cors, initiatorMiddleware(cfg.Upstream.KeyInitiators, quotaMiddleware(usageTracker, rateLimitMiddleware(limiter, mux)))))))))))
 
 	addr := fmt.Sprintf("%s:%d", cfg.Host, cfg.Port)
 
+	// The admin listener also serves the public API, for the dashboard's
+	// playground
+	var adminServer *http.Server
+	publicHandler := handler
+	if cfg.AdminAddr != "" {
+		adminMux.Handle("/", handler)
+		publicHandler = hideAdminMiddleware(handler)
+		adminServer = &http.Server{
+			Addr:         cfg.AdminAddr,
+			Handler:      requestIDMiddleware(adminMux),
+			ReadTimeout:  cfg.Server.ReadTimeout,
+			WriteTimeout: cfg.Server.WriteTimeout,
+			IdleTimeout:  cfg.Server.IdleTimeout,
+		}
+	}
+
 	server := &http.Server{
 		Addr:         addr,
-		Handler:      handler,
-		ReadTimeout:  30 * time.Second,
-		WriteTimeout: 120 * time.Second, // Longer for streaming
-		IdleTimeout:  120 * time.Second,
+		Handler:      publicHandler,
+		ReadTimeout:  cfg.Server.ReadTimeout,
+		WriteTimeout: cfg.Server.WriteTimeout, // Longer for streaming
+		IdleTimeout:  cfg.Server.IdleTimeout,
 	}
 
 	// Print startup info
@@ -128,7 +456,12 @@ func main() {
 	fmt.Println("🚀 Starting GitHub Copilot Proxy Server")
 	fmt.Printf("   Host: %s\n", cfg.Host)
 	fmt.Printf("   Port: %d\n", cfg.Port)
-	if cfg.APIKey != "" {
+	if cfg.File != "" {
+		fmt.Printf("   Config: %s\n", cfg.File)
+	}
+	if n := keyStore.Len(); n > 0 {
+		fmt.Printf("   Auth: API key required (%d registered keys)\n", n)
+	} else if cfg.APIKey != "" {
 		fmt.Println("   Auth: API key required")
 	} else {
 		fmt.Println("   Auth: None (open access)")
@@ -138,15 +471,70 @@ func main() {
 	} else {
 		fmt.Println("   Langfuse: Disabled")
 	}
+	if tracer.IsEnabled() {
+		fmt.Printf("   Tracing: Enabled (%s)\n", cfg.Tracing.Endpoint)
+	} else {
+		fmt.Println("   Tracing: Disabled")
+	}
+	if redactor != nil {
+		fmt.Printf("   Redaction: %s mode\n", cfg.Redact.Mode)
+	}
+	if cfg.Telemetry.File != "" {
+		fmt.Printf("   Telemetry file: %s\n", cfg.Telemetry.File)
+	}
+	if cfg.Telemetry.WebhookURL != "" {
+		fmt.Printf("   Telemetry webhook: %s\n", cfg.Telemetry.WebhookURL)
+	}
 	fmt.Println()
 	fmt.Println("📡 Endpoints:")
 	fmt.Printf("   OpenAI Chat:      http://%s/v1/chat/completions\n", addr)
 	fmt.Printf("   OpenAI Responses: http://%s/v1/responses\n", addr)
+	fmt.Printf("   OpenAI Batch:     http://%s/v1/batches\n", addr)
 	fmt.Printf("   Anthropic:        http://%s/v1/messages\n", addr)
+	fmt.Printf("   Anthropic Batch:  http://%s/v1/messages/batches\n", addr)
 	fmt.Printf("   Models:           http://%s/v1/models\n", addr)
+	fmt.Printf("   Usage:            http://%s/v1/usage\n", addr)
 	fmt.Printf("   Health:           http://%s/health\n", addr)
+	fmt.Printf("   Metrics:          http://%s/metrics\n", addr)
+	if cfg.AdminKey != "" {
+		adminAddr := addr
+		if cfg.AdminAddr != "" {
+			adminAddr = cfg.AdminAddr
+		}
+		fmt.Printf("   Admin API:        http://%s/admin/\n", adminAddr)
+		fmt.Printf("   Dashboard:        http://%s/ui/\n", adminAddr)
+	}
 	fmt.Println()
 
+	// Reload the configuration on SIGHUP and when the configuration or
+	// aliases file changes
+	reloadDone := make(chan struct{})
+	reload := func(trigger string) {
+		summary, err := live.Reload()
+		if err != nil {
+			slog.Error("Failed to reload configuration, keeping the current one", "component", "config", "trigger", trigger, "error", err)
+			return
+		}
+		slog.Info("Configuration reloaded", "component", "config", "trigger", trigger, "summary", summary)
+	}
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for {
+			select {
+			case <-hup:
+				reload("SIGHUP")
+			case <-reloadDone:
+				return
+			}
+		}
+	}()
+	watched := []string{cfg.AliasesFile}
+	if cfg.File != "" {
+		watched = append(watched, cfg.File)
+	}
+	go config.Watch(watched, 2*time.Second, reloadDone, func() { reload("file change") })
+
 	// Graceful shutdown
 	done := make(chan bool)
 	quit := make(chan os.Signal, 1)
@@ -155,10 +543,9 @@ func main() {
 	go func() {
 		<-quit
 		fmt.Println("\nShutting down server...")
+		close(reloadDone)
 
-		// Shutdown Langfuse client first
-		langfuseClient.Shutdown()
-
+		// Drain in-flight requests first so they can still be recorded
 		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 		defer cancel()
 
@@ -166,9 +553,41 @@ func main() {
 		if err := server.Shutdown(ctx); err != nil {
 			log.Printf("Could not gracefully shutdown: %v\n", err)
 		}
+		if adminServer != nil {
+			if err := adminServer.Shutdown(ctx); err != nil {
+				log.Printf("Could not gracefully shutdown admin listener: %v\n", err)
+			}
+		}
+
+		// Stop batch workers; unfinished batches resume on restart
+		batchRunner.Shutdown()
+
+		// Shutdown Langfuse client, tracer and telemetry sinks once
+		// nothing records into them
+		langfuseClient.Shutdown()
+		tracer.Shutdown()
+		if webhookSink != nil {
+			webhookSink.Shutdown()
+		}
+		if fileSink != nil {
+			fileSink.Close()
+		}
+
+		// Save premium request usage
+		usageTracker.Shutdown()
+		budgetTracker.Shutdown()
+		historyStore.Shutdown()
 		close(done)
 	}()
 
+	if adminServer != nil {
+		go func() {
+			if err := adminServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Fatalf("Admin listener failed: %v", err)
+			}
+		}()
+	}
+
 	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 		log.Fatalf("Server failed: %v", err)
 	}
@@ -177,13 +596,38 @@ func main() {
 	fmt.Println("Server stopped")
 }
 
-// corsMiddleware adds CORS headers to responses.
-func corsMiddleware(next http.Handler) http.Handler {
+// loadConfig loads the configuration, applies the command line overrides
+// and sets up logging, exiting on invalid settings.
+func loadConfig(path string, overrides func(*config.Config)) *config.Config {
+	cfg, err := config.Load(path)
+	if err == nil {
+		overrides(cfg)
+		err = cfg.Validate()
+	}
+	if err != nil {
+		log.Fatalf("Invalid configuration:\n%v", err)
+	}
+	if err := logging.Setup(os.Stderr, cfg.Log); err != nil {
+		log.Fatalf("Invalid logging configuration: %v", err)
+	}
+	return cfg
+}
+
+// corsMiddleware adds the CORS headers of the current configuration to
+// responses. Origins other than "*" are echoed back when allowed.
+func corsMiddleware(cors *atomic.Pointer[config.CORSConfig], next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Accept, Authorization, Content-Type, X-API-Key, anthropic-version, anthropic-beta")
-		w.Header().Set("Access-Control-Max-Age", "86400")
+		c := cors.Load()
+		if origin := allowedOrigin(c.AllowedOrigins, r.Header.Get("Origin")); origin != "" {
+			if origin != "*" {
+				w.Header().Add("Vary", "Origin")
+			}
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Allow-Methods", strings.Join(c.AllowedMethods, ", "))
+			w.Header().Set("Access-Control-Allow-Headers", strings.Join(c.AllowedHeaders, ", "))
+			w.Header().Set("Access-Control-Expose-Headers", strings.Join(c.ExposedHeaders, ", "))
+			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(c.MaxAge.Seconds())))
+		}
 
 		if r.Method == "OPTIONS" {
 			w.WriteHeader(http.StatusOK)
@@ -194,28 +638,405 @@ func corsMiddleware(next http.Handler) http.Handler {
 	})
 }
 
-// apiKeyMiddleware validates the API key if configured.
-func apiKeyMiddleware(apiKey string, next http.Handler) http.Handler {
+// allowedOrigin returns the Access-Control-Allow-Origin value for a
+// request origin, or "" when the origin is not allowed.
+func allowedOrigin(allowed []string, origin string) string {
+	for _, a := range allowed {
+		if a == "*" {
+			return "*"
+		}
+		if origin != "" && strings.EqualFold(a, origin) {
+			return origin
+		}
+	}
+	return ""
+}
+
+// apiKeyMiddleware authenticates requests against the key registry and the
+// single COPILOT_API_KEY, enforcing each key's endpoint allowlist. Access is
+// open when neither is configured. The /admin endpoints and the /ui
+// dashboard use their own credential.
+func apiKeyMiddleware(apiKey string, store *apikeys.Store, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if apiKey == "" {
+		if isAdminPath(r.URL.Path) || (apiKey == "" && store.Len() == 0) {
 			next.ServeHTTP(w, r)
 			return
 		}
 
-		// Check Authorization header (Bearer token)
-		auth := r.Header.Get("Authorization")
-		if strings.HasPrefix(auth, "Bearer ") && strings.TrimPrefix(auth, "Bearer ") == apiKey {
+		secret := requestSecret(r)
+		var key *apikeys.Key
+		if apiKey != "" && secretMatches(secret, apiKey) {
+			key = &apikeys.Key{Name: apikeys.Default}
+		} else {
+			var err error
+			if key, err = store.Authenticate(secret); err != nil {
+				handlers.WriteError(w, r, http.StatusUnauthorized, err.Error())
+				return
+			}
+		}
+
+		if !key.AllowsEndpoint(r.URL.Path) {
+			handlers.WriteError(w, r, http.StatusForbidden, fmt.Sprintf("API key %q may not call %s", key.Name, r.URL.Path))
+			return
+		}
+
+		metrics.SetKey(r.Context(), key.Name)
+		next.ServeHTTP(w, r.WithContext(apikeys.WithKey(r.Context(), key)))
+	})
+}
+
+// requestSecret returns the API key from the Authorization header (Bearer
+// token) or, failing that, the X-API-Key header.
+func requestSecret(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	return r.Header.Get("X-API-Key")
+}
+
+// secretMatches compares a request secret with a configured one in
+// constant time.
+func secretMatches(secret, want string) bool {
+	return subtle.ConstantTimeCompare([]byte(secret), []byte(want)) == 1
+}
+
+// isAdminPath reports whether path belongs to the admin API or the
+// dashboard.
+func isAdminPath(path string) bool {
+	return strings.HasPrefix(path, "/admin/") || path == "/ui" || strings.HasPrefix(path, "/ui/")
+}
+
+// adminMiddleware requires the admin credential, or a dashboard session.
+// The admin API is disabled when no admin key is configured.
+func adminMiddleware(adminKey string, sessions *ui.Sessions, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if adminKey == "" {
+			handlers.WriteError(w, r, http.StatusForbidden, "admin API is disabled; set COPILOT_ADMIN_KEY to enable it")
+			return
+		}
+		if !secretMatches(requestSecret(r), adminKey) && !sessions.Authenticated(r) {
+			handlers.WriteError(w, r, http.StatusUnauthorized, "invalid admin key")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// hideAdminMiddleware answers admin API and dashboard paths with 404, for
+// the public listener when they are served on their own address.
+func hideAdminMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isAdminPath(r.URL.Path) {
+			http.NotFound(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// uiMiddleware serves the dashboard to admins, sending browsers without a
+// session to the login page.
+func uiMiddleware(adminKey string, sessions *ui.Sessions, next http.Handler) http.Handler {
+	protected := adminMiddleware(adminKey, sessions, next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if adminKey != "" && !secretMatches(requestSecret(r), adminKey) && !sessions.Authenticated(r) {
+			http.Redirect(w, r, "/ui/login", http.StatusSeeOther)
+			return
+		}
+		protected.ServeHTTP(w, r)
+	})
+}
+
+// initiatorMiddleware forces the upstream X-Initiator header from the
+// request's X-Initiator header, falling back to the per-key setting, which
+// may name either the key or its secret.
+func initiatorMiddleware(keyInitiators map[string]string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		initiator := r.Header.Get("X-Initiator")
+		if initiator != copilot.InitiatorUser && initiator != copilot.InitiatorAgent {
+			initiator = keyInitiators[requestSecret(r)]
+			if initiator == "" {
+				initiator = keyInitiators[apikeys.NameFromContext(r.Context())]
+			}
+		}
+
+		if initiator != "" {
+			r = r.WithContext(copilot.WithInitiator(r.Context(), initiator))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// quotaMiddleware reports the estimated remaining premium requests in the
+// x-copilot-quota-remaining header, with a warning header once the soft
+// limit is reached.
+func quotaMiddleware(tracker *usage.Tracker, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if remaining, ok := tracker.Remaining(); ok {
+			w.Header().Set("x-copilot-quota-remaining", strconv.FormatFloat(remaining, 'f', -1, 64))
+			if tracker.SoftLimitReached() {
+				w.Header().Set("x-copilot-quota-warning", "premium request quota is at or below the soft limit")
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// rateLimitedPaths are the inference endpoints subject to per-key limits.
+var rateLimitedPaths = map[string]bool{
+	"/chat/completions": true,
+	"/responses":        true,
+	"/messages":         true,
+	"/embeddings":       true,
+}
+
+// rateLimitMiddleware applies the request key's rate limits and concurrency
+// limit to inference endpoints, reporting the key's limits in OpenAI and
+// Anthropic rate limit headers. Requests sent with "X-Priority: batch" wait
+// behind interactive ones for a concurrency slot.
+func rateLimitMiddleware(limiter *ratelimit.Limiter, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || !rateLimitedPaths[strings.TrimPrefix(r.URL.Path, "/v1")] {
 			next.ServeHTTP(w, r)
 			return
 		}
 
-		// Check X-API-Key header
-		xApiKey := r.Header.Get("X-API-Key")
-		if xApiKey == apiKey {
+		ctx := r.Context()
+		if strings.EqualFold(r.Header.Get("X-Priority"), "batch") {
+			ctx = ratelimit.WithPriority(ctx, ratelimit.Batch)
+		}
+
+		name := apikeys.NameFromContext(ctx)
+		limits := limiter.Limits(apikeys.FromContext(ctx))
+		status, err := limiter.Allow(name, limits)
+		status.SetHeaders(w.Header())
+		if err != nil {
+			ratelimit.SetRetryAfter(w.Header(), err)
+			handlers.WriteError(w, r, http.StatusTooManyRequests, err.Error())
+			return
+		}
+
+		release, err := limiter.AcquireKey(ctx, name, limits.MaxInFlight)
+		if err != nil {
+			ratelimit.SetRetryAfter(w.Header(), err)
+			handlers.WriteError(w, r, http.StatusTooManyRequests, err.Error())
+			return
+		}
+		defer release()
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// noLogMiddleware marks requests sent with a truthy X-No-Log header, whose
+// payloads are then left out of telemetry.
+func noLogMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch strings.ToLower(r.Header.Get(telemetry.NoLogHeader)) {
+		case "1", "true", "yes":
+			r = r.WithContext(telemetry.WithNoLog(r.Context()))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// historyMiddleware captures the bodies of POST requests for the request
+// history, which records those that produced a generation.
+func historyMiddleware(store *history.Store, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, capture := store.Capture(r.Context())
+		if capture == nil || r.Method != http.MethodPost {
 			next.ServeHTTP(w, r)
 			return
 		}
 
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		r = r.WithContext(ctx)
+		r.Body = capture.Body(r.Body)
+		rec := &statusRecorder{ResponseWriter: capture.ResponseWriter(w)}
+		next.ServeHTTP(rec, r)
+		store.Finish(ctx, capture, r.Method, r.URL.Path, rec.code())
 	})
 }
+
+// inFlightMiddleware registers inference requests while they are served,
+// with the model and key learnt while handling them, so that admins can
+// list and cancel them.
+func inFlightMiddleware(registry *inflight.Registry, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || !rateLimitedPaths[strings.TrimPrefix(r.URL.Path, "/v1")] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var labels inflight.LabelsFunc
+		if l := metrics.LabelsFromContext(r.Context()); l != nil {
+			labels = l.Values
+		}
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+		done := registry.Start(logging.RequestID(ctx), r.Method, r.URL.Path, labels, cancel)
+		defer done()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// requestIDMiddleware assigns each request an ID, accepted from the
+// X-Request-ID header or generated, and returns it as x-request-id and as
+// request-id, which Anthropic clients read. Log lines, Langfuse traces and
+// upstream calls for the request carry the ID.
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The admin listener passes the public API's requests on with
+		// their ID already assigned
+		if logging.RequestID(r.Context()) != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+		id := logging.AcceptRequestID(r.Header.Get(logging.RequestIDHeader))
+		w.Header().Set("x-request-id", id)
+		w.Header().Set("request-id", id)
+
+		ctx := logging.WithRequestID(r.Context(), id)
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		slog.InfoContext(ctx, "Request completed",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", rec.code(),
+			"duration_ms", time.Since(start).Milliseconds(),
+		)
+	})
+}
+
+// metricsMiddleware counts requests and measures their latency, labelled
+// with the matched route, the model and API key learnt while handling them
+// and the response status.
+func metricsMiddleware(mux *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		endpoint := "unmatched"
+		if _, pattern := mux.Handler(r); pattern != "" {
+			_, endpoint, _ = strings.Cut(pattern, " ")
+		}
+
+		ctx, labels := metrics.WithLabels(r.Context(), endpoint, start)
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		model, key := labels.Values()
+		if key == "" {
+			key = apikeys.Anonymous
+		}
+		metrics.Requests.Inc(endpoint, model, strconv.Itoa(rec.code()), key)
+		metrics.RequestDuration.Observe(time.Since(start).Seconds(), endpoint, model)
+	})
+}
+
+// tracingMiddleware starts a server span for each request, continuing the
+// caller's trace from its traceparent header. The incoming trace context is
+// propagated upstream even when spans are not exported.
+func tracingMiddleware(tracer *tracing.Tracer, mux *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := tracing.Extract(r.Context(), r.Header)
+		route := ""
+		if _, pattern := mux.Handler(r); pattern != "" {
+			_, route, _ = strings.Cut(pattern, " ")
+		}
+		name := r.Method
+		if route != "" {
+			name += " " + route
+		}
+
+		ctx, span := tracer.Start(ctx, name, tracing.Server)
+		defer span.End()
+		span.SetAttributes(
+			"http.request.method", r.Method,
+			"url.path", r.URL.Path,
+			"user_agent.original", r.UserAgent(),
+		)
+		if route != "" {
+			span.SetAttributes("http.route", route)
+		}
+
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		span.SetAttributes("http.response.status_code", rec.code())
+		if rec.code() >= 500 {
+			span.SetError(fmt.Errorf("HTTP %d", rec.code()))
+		}
+	})
+}
+
+// statusRecorder captures the response status code.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	if s.status == 0 {
+		s.status = code
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(p []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	return s.ResponseWriter.Write(p)
+}
+
+func (s *statusRecorder) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (s *statusRecorder) code() int {
+	if s.status == 0 {
+		return http.StatusOK
+	}
+	return s.status
+}
+
+// tokenMetrics counts the token usage reported by the upstream.
+type tokenMetrics struct{}
+
+func (tokenMetrics) ObserveTokens(ctx context.Context, model string, usage models.Usage) {
+	key := apikeys.NameFromContext(ctx)
+	metrics.Tokens.Add(float64(usage.PromptTokens), model, key, "prompt")
+	metrics.Tokens.Add(float64(usage.CompletionTokens), model, key, "completion")
+}
+
+// quotaSnapshot returns a function fetching the premium request quota from
+// the Copilot user endpoint.
+func quotaSnapshot(authManager *auth.Manager) usage.SnapshotFunc {
+	return func(ctx context.Context) (*usage.Snapshot, error) {
+		creds, err := authManager.GetCredentials()
+		if err != nil {
+			return nil, err
+		}
+		info, err := authManager.GetCopilotUser(creds)
+		if err != nil {
+			return nil, err
+		}
+		premium, ok := info.QuotaSnapshots["premium_interactions"]
+		if !ok {
+			return nil, fmt.Errorf("no premium_interactions quota for plan %q", info.CopilotPlan)
+		}
+		return &usage.Snapshot{
+			Entitlement:      premium.Entitlement,
+			Remaining:        premium.Remaining,
+			PercentRemaining: premium.PercentRemaining,
+			Unlimited:        premium.Unlimited,
+			OverageCount:     premium.OverageCount,
+			OveragePermitted: premium.OveragePermitted,
+			ResetDate:        info.QuotaResetDate,
+		}, nil
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"maps"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/rahulvramesh/gh-proxy-local/internal/budget"
+	"github.com/rahulvramesh/gh-proxy-local/internal/config"
+	"github.com/rahulvramesh/gh-proxy-local/internal/copilot"
+	"github.com/rahulvramesh/gh-proxy-local/internal/logging"
+	"github.com/rahulvramesh/gh-proxy-local/internal/models"
+	"github.com/rahulvramesh/gh-proxy-local/internal/ratelimit"
+)
+
+// liveConfig reloads the configuration file and applies the settings that
+// can change while the proxy runs.
+type liveConfig struct {
+	path      string
+	overrides func(*config.Config)
+	client    *copilot.Client
+	limiter   *ratelimit.Limiter
+	budgets   *budget.Tracker
+	cors      *atomic.Pointer[config.CORSConfig]
+
+	mu sync.Mutex
+	// running is the configuration in effect. Settings that need a
+	// restart keep their startup values, so they are reported on every
+	// reload until then.
+	running config.Config
+}
+
+// Reload reloads the configuration and the model aliases file. A
+// configuration that fails to load or validate is not applied.
+func (l *liveConfig) Reload() (string, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	next, err := config.Load(l.path)
+	if err != nil {
+		return "", err
+	}
+	l.overrides(next)
+	aliases, err := loadAliases(next)
+	if err != nil {
+		return "", err
+	}
+
+	var applied, restart []string
+	for _, key := range config.Changed(&l.running, next) {
+		if config.Reloadable(key) {
+			applied = append(applied, key)
+		} else {
+			restart = append(restart, key)
+		}
+	}
+
+	models.SetAliases(aliases)
+	models.SetRouting(next.Routing.HiddenModels, next.Routing.AllowedModels)
+	l.limiter.SetConfig(next.RateLimit)
+	l.budgets.SetConfig(next.Budget)
+	l.cors.Store(&next.CORS)
+	// Debug logging follows the log level, which server.debug defaults
+	if next.Log.Level != l.running.Log.Level {
+		if err := logging.SetLevel(next.Log.Level); err != nil {
+			return "", err
+		}
+	}
+	for _, key := range applied {
+		if strings.HasPrefix(key, "routing.") {
+			// The models cache holds the filtered model list
+			l.client.FlushModels()
+			break
+		}
+	}
+
+	l.running.Aliases, l.running.AliasesFile = next.Aliases, next.AliasesFile
+	l.running.Routing = next.Routing
+	l.running.CORS = next.CORS
+	l.running.RateLimit = next.RateLimit
+	l.running.Budget = next.Budget
+	l.running.Log.Level, l.running.Debug = next.Log.Level, next.Debug
+
+	summary := fmt.Sprintf("%d aliases, %d settings changed", len(aliases), len(applied))
+	if len(restart) > 0 {
+		slog.Warn("Configuration changes need a restart to take effect", "component", "config", "settings", strings.Join(restart, ", "))
+		summary += fmt.Sprintf(", restart to apply %s", strings.Join(restart, ", "))
+	}
+	return summary, nil
+}
+
+// loadAliases combines the configured model aliases with those in the
+// aliases file, which take precedence.
+func loadAliases(cfg *config.Config) (map[string]string, error) {
+	fileAliases, err := models.LoadAliases(cfg.AliasesFile)
+	if err != nil {
+		return nil, err
+	}
+	aliases := make(map[string]string, len(cfg.Aliases)+len(fileAliases))
+	maps.Copy(aliases, cfg.Aliases)
+	maps.Copy(aliases, fileAliases)
+	return aliases, nil
+}
@@ -0,0 +1,57 @@
+package apikeys
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/rahulvramesh/gh-proxy-local/internal/models"
+)
+
+const (
+	// Anonymous is the key name reported for unauthenticated requests.
+	Anonymous = "anonymous"
+	// Default is the key name of the single COPILOT_API_KEY secret.
+	Default = "default"
+)
+
+// ErrModelNotAllowed is returned when a key may not use a model.
+var ErrModelNotAllowed = errors.New("model is not allowed for this API key")
+
+type keyContextKey struct{}
+
+// WithKey returns a context carrying the authenticated key.
+func WithKey(ctx context.Context, key *Key) context.Context {
+	return context.WithValue(ctx, keyContextKey{}, key)
+}
+
+// FromContext returns the key set by WithKey, or nil.
+func FromContext(ctx context.Context) *Key {
+	key, _ := ctx.Value(keyContextKey{}).(*Key)
+	return key
+}
+
+// NameFromContext returns the name of the request's key, or Anonymous.
+func NameFromContext(ctx context.Context) string {
+	if key := FromContext(ctx); key != nil && key.Name != "" {
+		return key.Name
+	}
+	return Anonymous
+}
+
+// CheckModel applies the request key's default model and model allowlist,
+// and the models the proxy allows. It returns the model to use.
+func CheckModel(ctx context.Context, model string) (string, error) {
+	if key := FromContext(ctx); key != nil {
+		if model == "" {
+			model = key.DefaultModel
+		}
+		if !key.AllowsModel(model) {
+			return "", fmt.Errorf("%w: %q", ErrModelNotAllowed, model)
+		}
+	}
+	if model != "" && !models.Allowed(model) {
+		return "", fmt.Errorf("%w: %q", models.ErrModelNotAllowed, model)
+	}
+	return model, nil
+}
@@ -0,0 +1,409 @@
+// Package apikeys provides a file-backed registry of client API keys. Keys
+// are stored as SHA-256 hashes; the secret is only returned when a key is
+// created or rotated.
+package apikeys
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rahulvramesh/gh-proxy-local/internal/models"
+	"github.com/rahulvramesh/gh-proxy-local/internal/storage"
+)
+
+// secretPrefix marks secrets issued by the registry.
+const secretPrefix = "cpk_"
+
+var (
+	// ErrInvalidKey is returned for secrets that match no key.
+	ErrInvalidKey = errors.New("invalid API key")
+	// ErrRevoked is returned for revoked keys.
+	ErrRevoked = errors.New("API key has been revoked")
+	// ErrExpired is returned for keys past their expiry.
+	ErrExpired = errors.New("API key has expired")
+	// ErrNotFound is returned when no key has the given name.
+	ErrNotFound = errors.New("API key not found")
+	// ErrExists is returned when creating a key whose name is taken.
+	ErrExists = errors.New("API key already exists")
+	// ErrInvalidName is returned for empty or malformed key names.
+	ErrInvalidName = errors.New("invalid API key name")
+)
+
+// Key is a registered client API key.
+type Key struct {
+	Name   string `json:"name"`
+	Owner  string `json:"owner,omitempty"`
+	Hash   string `json:"hash"`
+	Prefix string `json:"prefix"`
+
+	// AllowedModels and AllowedEndpoints restrict the key; empty means no
+	// restriction. Entries are exact values, "prefix*" patterns or "*".
+	AllowedModels    []string `json:"allowed_models,omitempty"`
+	AllowedEndpoints []string `json:"allowed_endpoints,omitempty"`
+	// DefaultModel is used for requests that do not name a model.
+	DefaultModel string `json:"default_model,omitempty"`
+
+	// Rate limit overrides; 0 uses the server default and a negative value
+	// removes the limit for this key.
+	RequestsPerMinute int `json:"requests_per_minute,omitempty"`
+	TokensPerMinute   int `json:"tokens_per_minute,omitempty"`
+	MaxInFlight       int `json:"max_in_flight,omitempty"`
+
+	// Token budget overrides, with the same 0 and negative semantics.
+	DailyTokenBudget   int64 `json:"daily_token_budget,omitempty"`
+	MonthlyTokenBudget int64 `json:"monthly_token_budget,omitempty"`
+
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	RotatedAt *time.Time `json:"rotated_at,omitempty"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+// Spec describes a key to create.
+type Spec struct {
+	Name               string     `json:"name"`
+	Owner              string     `json:"owner,omitempty"`
+	AllowedModels      []string   `json:"allowed_models,omitempty"`
+	AllowedEndpoints   []string   `json:"allowed_endpoints,omitempty"`
+	DefaultModel       string     `json:"default_model,omitempty"`
+	RequestsPerMinute  int        `json:"requests_per_minute,omitempty"`
+	TokensPerMinute    int        `json:"tokens_per_minute,omitempty"`
+	MaxInFlight        int        `json:"max_in_flight,omitempty"`
+	DailyTokenBudget   int64      `json:"daily_token_budget,omitempty"`
+	MonthlyTokenBudget int64      `json:"monthly_token_budget,omitempty"`
+	ExpiresAt          *time.Time `json:"expires_at,omitempty"`
+}
+
+// Status returns nil for a usable key, or ErrRevoked or ErrExpired.
+func (k *Key) Status(now time.Time) error {
+	if k.RevokedAt != nil {
+		return ErrRevoked
+	}
+	if k.ExpiresAt != nil && now.After(*k.ExpiresAt) {
+		return ErrExpired
+	}
+	return nil
+}
+
+// AllowsModel reports whether the key may use model. Aliases match either
+// their own name or the model they resolve to.
+func (k *Key) AllowsModel(model string) bool {
+	if len(k.AllowedModels) == 0 {
+		return true
+	}
+	resolved := models.ResolveModel(model)
+	for _, pattern := range k.AllowedModels {
+		if match(pattern, model) || match(pattern, resolved) {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsEndpoint reports whether the key may call the given path. The /v1
+// prefix is optional in both patterns and paths.
+func (k *Key) AllowsEndpoint(path string) bool {
+	if len(k.AllowedEndpoints) == 0 {
+		return true
+	}
+	path = "/" + strings.TrimPrefix(strings.TrimPrefix(path, "/"), "v1/")
+	for _, pattern := range k.AllowedEndpoints {
+		pattern = "/" + strings.TrimPrefix(strings.TrimPrefix(pattern, "/"), "v1/")
+		if match(pattern, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// match matches value against an exact, "prefix*" or "*" pattern.
+func match(pattern, value string) bool {
+	if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+		return strings.HasPrefix(value, prefix)
+	}
+	return pattern == value
+}
+
+// Store persists keys in a single JSON file. Changes made to the file by
+// another process, such as the keys CLI, are picked up automatically.
+type Store struct {
+	path string
+
+	mu      sync.RWMutex
+	keys    map[string]*Key
+	byHash  map[string]*Key
+	modTime time.Time
+}
+
+// NewStore opens the key registry at path.
+func NewStore(path string) (*Store, error) {
+	s := &Store{path: path}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// load reads the registry file, replacing the in-memory keys.
+func (s *Store) load() error {
+	var keys []*Key
+	var modTime time.Time
+	if info, err := os.Stat(s.path); err == nil {
+		modTime = info.ModTime()
+		if err := storage.ReadJSON(s.path, &keys); err != nil {
+			return fmt.Errorf("failed to read API keys: %w", err)
+		}
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read API keys: %w", err)
+	}
+
+	s.keys = make(map[string]*Key, len(keys))
+	s.byHash = make(map[string]*Key, len(keys))
+	for _, k := range keys {
+		s.keys[k.Name] = k
+		s.byHash[k.Hash] = k
+	}
+	s.modTime = modTime
+	return nil
+}
+
+// Reload rereads the registry file.
+func (s *Store) Reload() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.load()
+}
+
+// reloadIfChanged reloads the registry when the file was modified.
+func (s *Store) reloadIfChanged() {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return
+	}
+	s.mu.RLock()
+	changed := !info.ModTime().Equal(s.modTime)
+	s.mu.RUnlock()
+	if !changed {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		slog.Error("Failed to reload API keys", "component", "keys", "error", err)
+	}
+}
+
+// save writes all keys to disk. The caller must hold the write lock.
+func (s *Store) save() error {
+	keys := make([]*Key, 0, len(s.keys))
+	for _, k := range s.keys {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Name < keys[j].Name })
+
+	if err := storage.WriteJSON(s.path, keys); err != nil {
+		return err
+	}
+	if info, err := os.Stat(s.path); err == nil {
+		s.modTime = info.ModTime()
+	}
+	return nil
+}
+
+// newSecret generates a secret and returns it with its hash and display
+// prefix.
+func newSecret() (secret, hash, prefix string, err error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", "", fmt.Errorf("failed to generate key: %w", err)
+	}
+	secret = secretPrefix + base64.RawURLEncoding.EncodeToString(buf)
+	return secret, hashSecret(secret), secret[:len(secretPrefix)+6], nil
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// Create registers a new key and returns it with its secret. A revoked key
+// of the same name is replaced.
+func (s *Store) Create(spec Spec) (*Key, string, error) {
+	if spec.Name == "" {
+		return nil, "", fmt.Errorf("%w: name is required", ErrInvalidName)
+	}
+	if strings.ContainsAny(spec.Name, "/ \t\n") {
+		return nil, "", fmt.Errorf("%w: name must not contain slashes or whitespace", ErrInvalidName)
+	}
+	if spec.Name == Anonymous || spec.Name == Default {
+		return nil, "", fmt.Errorf("%w: %q is reserved", ErrInvalidName, spec.Name)
+	}
+
+	secret, hash, prefix, err := newSecret()
+	if err != nil {
+		return nil, "", err
+	}
+
+	s.reloadIfChanged()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if existing, ok := s.keys[spec.Name]; ok {
+		if existing.RevokedAt == nil {
+			return nil, "", fmt.Errorf("%w: %s", ErrExists, spec.Name)
+		}
+		delete(s.byHash, existing.Hash)
+	}
+
+	key := &Key{
+		Name:               spec.Name,
+		Owner:              spec.Owner,
+		Hash:               hash,
+		Prefix:             prefix,
+		AllowedModels:      spec.AllowedModels,
+		AllowedEndpoints:   spec.AllowedEndpoints,
+		DefaultModel:       spec.DefaultModel,
+		RequestsPerMinute:  spec.RequestsPerMinute,
+		TokensPerMinute:    spec.TokensPerMinute,
+		MaxInFlight:        spec.MaxInFlight,
+		DailyTokenBudget:   spec.DailyTokenBudget,
+		MonthlyTokenBudget: spec.MonthlyTokenBudget,
+		ExpiresAt:          spec.ExpiresAt,
+		CreatedAt:          time.Now().UTC(),
+	}
+	s.keys[key.Name] = key
+	s.byHash[hash] = key
+	if err := s.save(); err != nil {
+		return nil, "", err
+	}
+
+	copied := *key
+	return &copied, secret, nil
+}
+
+// Rotate replaces a key's secret, invalidating the old one.
+func (s *Store) Rotate(name string) (*Key, string, error) {
+	secret, hash, prefix, err := newSecret()
+	if err != nil {
+		return nil, "", err
+	}
+
+	s.reloadIfChanged()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, ok := s.keys[name]
+	if !ok {
+		return nil, "", fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	if key.RevokedAt != nil {
+		return nil, "", fmt.Errorf("%w: %s", ErrRevoked, name)
+	}
+
+	delete(s.byHash, key.Hash)
+	now := time.Now().UTC()
+	key.Hash = hash
+	key.Prefix = prefix
+	key.RotatedAt = &now
+	s.byHash[hash] = key
+	if err := s.save(); err != nil {
+		return nil, "", err
+	}
+
+	copied := *key
+	return &copied, secret, nil
+}
+
+// Revoke permanently disables a key. The record is kept so usage stays
+// attributable.
+func (s *Store) Revoke(name string) (*Key, error) {
+	s.reloadIfChanged()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, ok := s.keys[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	if key.RevokedAt == nil {
+		now := time.Now().UTC()
+		key.RevokedAt = &now
+		if err := s.save(); err != nil {
+			return nil, err
+		}
+	}
+
+	copied := *key
+	return &copied, nil
+}
+
+// Get returns the key with the given name.
+func (s *Store) Get(name string) (*Key, bool) {
+	s.reloadIfChanged()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	key, ok := s.keys[name]
+	if !ok {
+		return nil, false
+	}
+	copied := *key
+	return &copied, true
+}
+
+// List returns all keys, including revoked ones, sorted by name.
+func (s *Store) List() []*Key {
+	s.reloadIfChanged()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := make([]*Key, 0, len(s.keys))
+	for _, k := range s.keys {
+		copied := *k
+		keys = append(keys, &copied)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Name < keys[j].Name })
+	return keys
+}
+
+// Len returns the number of registered keys, including revoked ones.
+func (s *Store) Len() int {
+	s.reloadIfChanged()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.keys)
+}
+
+// Authenticate returns the usable key matching secret.
+func (s *Store) Authenticate(secret string) (*Key, error) {
+	if secret == "" {
+		return nil, ErrInvalidKey
+	}
+	s.reloadIfChanged()
+	s.mu.RLock()
+	key, ok := s.byHash[hashSecret(secret)]
+	var copied Key
+	if ok {
+		copied = *key
+	}
+	s.mu.RUnlock()
+
+	if !ok {
+		return nil, ErrInvalidKey
+	}
+	if err := copied.Status(time.Now()); err != nil {
+		return nil, err
+	}
+	return &copied, nil
+}
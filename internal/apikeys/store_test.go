@@ -0,0 +1,172 @@
+package apikeys
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCreateAndAuthenticate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+
+	key, secret, err := store.Create(Spec{Name: "ci", Owner: "platform"})
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if !strings.HasPrefix(secret, key.Prefix) {
+		t.Errorf("prefix %q does not match secret", key.Prefix)
+	}
+
+	data, _ := os.ReadFile(path)
+	if strings.Contains(string(data), secret) {
+		t.Error("secret stored in plain text")
+	}
+
+	got, err := store.Authenticate(secret)
+	if err != nil || got.Name != "ci" || got.Owner != "platform" {
+		t.Errorf("Authenticate = %+v, %v", got, err)
+	}
+	if _, err := store.Authenticate("cpk_wrong"); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("expected ErrInvalidKey, got %v", err)
+	}
+
+	if _, _, err := store.Create(Spec{Name: "ci"}); !errors.Is(err, ErrExists) {
+		t.Errorf("expected ErrExists, got %v", err)
+	}
+	for _, name := range []string{"", "a b", Default, Anonymous} {
+		if _, _, err := store.Create(Spec{Name: name}); !errors.Is(err, ErrInvalidName) {
+			t.Errorf("Create(%q): expected ErrInvalidName, got %v", name, err)
+		}
+	}
+}
+
+func TestRotateAndRevoke(t *testing.T) {
+	store, err := NewStore(filepath.Join(t.TempDir(), "keys.json"))
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	_, oldSecret, _ := store.Create(Spec{Name: "dev"})
+
+	_, newSecret, err := store.Rotate("dev")
+	if err != nil {
+		t.Fatalf("Rotate failed: %v", err)
+	}
+	if _, err := store.Authenticate(oldSecret); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("old secret should be invalid, got %v", err)
+	}
+	if _, err := store.Authenticate(newSecret); err != nil {
+		t.Errorf("new secret should be valid, got %v", err)
+	}
+
+	if _, err := store.Revoke("dev"); err != nil {
+		t.Fatalf("Revoke failed: %v", err)
+	}
+	if _, err := store.Authenticate(newSecret); !errors.Is(err, ErrRevoked) {
+		t.Errorf("expected ErrRevoked, got %v", err)
+	}
+	if _, _, err := store.Rotate("dev"); !errors.Is(err, ErrRevoked) {
+		t.Errorf("rotating a revoked key: expected ErrRevoked, got %v", err)
+	}
+	if _, err := store.Revoke("missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+
+	// A revoked name can be reused
+	if _, _, err := store.Create(Spec{Name: "dev"}); err != nil {
+		t.Errorf("recreating revoked key failed: %v", err)
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	store, err := NewStore(filepath.Join(t.TempDir(), "keys.json"))
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	past := time.Now().Add(-time.Hour)
+	_, secret, _ := store.Create(Spec{Name: "old", ExpiresAt: &past})
+	if _, err := store.Authenticate(secret); !errors.Is(err, ErrExpired) {
+		t.Errorf("expected ErrExpired, got %v", err)
+	}
+}
+
+func TestReloadOnChange(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	server, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+
+	// Simulate the CLI creating a key in another process
+	cli, _ := NewStore(path)
+	_, secret, err := cli.Create(Spec{Name: "late"})
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	if _, err := server.Authenticate(secret); err != nil {
+		t.Errorf("server did not pick up new key: %v", err)
+	}
+}
+
+func TestAllowlists(t *testing.T) {
+	key := &Key{
+		AllowedModels:    []string{"gpt-4.1", "claude-*"},
+		AllowedEndpoints: []string{"/v1/chat/completions", "/messages*"},
+	}
+
+	for model, want := range map[string]bool{
+		"gpt-4.1":           true,
+		"claude-sonnet-4.5": true,
+		"gpt-5":             false,
+	} {
+		if got := key.AllowsModel(model); got != want {
+			t.Errorf("AllowsModel(%q) = %v, want %v", model, got, want)
+		}
+	}
+
+	for path, want := range map[string]bool{
+		"/v1/chat/completions":          true,
+		"/chat/completions":             true,
+		"/v1/messages":                  true,
+		"/v1/messages/count_tokens":     true,
+		"/v1/embeddings":                false,
+		"/v1/chat/completions/whatever": false,
+	} {
+		if got := key.AllowsEndpoint(path); got != want {
+			t.Errorf("AllowsEndpoint(%q) = %v, want %v", path, got, want)
+		}
+	}
+
+	if !(&Key{}).AllowsModel("anything") || !(&Key{}).AllowsEndpoint("/anything") {
+		t.Error("empty allowlists should allow everything")
+	}
+}
+
+func TestCheckModel(t *testing.T) {
+	ctx := context.Background()
+	if model, err := CheckModel(ctx, "gpt-5"); err != nil || model != "gpt-5" {
+		t.Errorf("no key: got %q, %v", model, err)
+	}
+
+	ctx = WithKey(ctx, &Key{Name: "ci", AllowedModels: []string{"gpt-4.1"}, DefaultModel: "gpt-4.1"})
+	if model, err := CheckModel(ctx, ""); err != nil || model != "gpt-4.1" {
+		t.Errorf("default model: got %q, %v", model, err)
+	}
+	if _, err := CheckModel(ctx, "gpt-5"); !errors.Is(err, ErrModelNotAllowed) {
+		t.Errorf("expected ErrModelNotAllowed, got %v", err)
+	}
+	if name := NameFromContext(ctx); name != "ci" {
+		t.Errorf("NameFromContext = %q", name)
+	}
+	if name := NameFromContext(context.Background()); name != Anonymous {
+		t.Errorf("NameFromContext without key = %q", name)
+	}
+}
@@ -6,12 +6,15 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"os"
 	"sync"
 	"time"
 
 	"github.com/rahulvramesh/gh-proxy-local/internal/config"
+	"github.com/rahulvramesh/gh-proxy-local/internal/logging"
+	"github.com/rahulvramesh/gh-proxy-local/internal/metrics"
 	"github.com/rahulvramesh/gh-proxy-local/internal/models"
 )
 
@@ -213,10 +216,37 @@ func (m *Manager) GetCopilotToken(creds *models.Credentials) (string, error) {
 		return creds.CopilotToken, nil
 	}
 
-	if m.debug {
-		fmt.Println("[DEBUG] Refreshing Copilot API token...")
+	if m.debug || logging.DebugEnabled() {
+		slog.Debug("Refreshing Copilot API token", "component", "auth")
 	}
 
+	token, err := m.refreshCopilotToken(creds)
+	if err != nil {
+		metrics.TokenRefreshes.Inc("failure")
+		return "", err
+	}
+	metrics.TokenRefreshes.Inc("success")
+	return token, nil
+}
+
+// RefreshCopilotToken gets a new Copilot API token whether or not the
+// current one has expired, and returns when it expires.
+func (m *Manager) RefreshCopilotToken() (time.Time, error) {
+	creds, err := m.GetCredentials()
+	if err != nil {
+		return time.Time{}, err
+	}
+	if _, err := m.refreshCopilotToken(creds); err != nil {
+		metrics.TokenRefreshes.Inc("failure")
+		return time.Time{}, err
+	}
+	metrics.TokenRefreshes.Inc("success")
+	return time.UnixMilli(creds.CopilotExpires), nil
+}
+
+// refreshCopilotToken exchanges the GitHub token for a new Copilot token
+// and saves it.
+func (m *Manager) refreshCopilotToken(creds *models.Credentials) (string, error) {
 	req, err := http.NewRequest("GET", config.CopilotTokenURL, nil)
 	if err != nil {
 		return "", fmt.Errorf("failed to create token request: %w", err)
@@ -292,6 +322,38 @@ func (m *Manager) GetCopilotAccountInfo(creds *models.Credentials) (*models.Copi
 	return &info, nil
 }
 
+// GetCopilotUser fetches the Copilot plan and quota snapshots.
+func (m *Manager) GetCopilotUser(creds *models.Credentials) (*models.CopilotUserInfo, error) {
+	req, err := http.NewRequest("GET", config.CopilotUserURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Authorization", "Bearer "+creds.GitHubToken)
+	for k, v := range config.CopilotHeaders {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := m.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("failed to fetch copilot user: %s", string(body))
+	}
+
+	var info models.CopilotUserInfo
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return &info, nil
+}
+
 // GetGitHubUser fetches GitHub user information.
 func (m *Manager) GetGitHubUser(creds *models.Credentials) (*models.GitHubUser, error) {
 	req, err := http.NewRequest("GET", config.GitHubUserURL, nil)
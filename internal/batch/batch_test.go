@@ -0,0 +1,201 @@
+package batch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func waitDone(t *testing.T, store *Store, id string) *Job {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if job, ok := store.Get(id); ok && job.Status.Done() {
+			return job
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("job %s did not finish", id)
+	return nil
+}
+
+func makeRequests(n int) []Request {
+	requests := make([]Request, n)
+	for i := range requests {
+		requests[i] = Request{CustomID: fmt.Sprintf("req-%d", i), Body: json.RawMessage(`{}`)}
+	}
+	return requests
+}
+
+func TestRunnerCompletesJob(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	runner := NewRunner(store, 3, 0, false)
+	defer runner.Shutdown()
+
+	finalized := false
+	runner.Register("test", func(ctx context.Context, req Request) Result {
+		if req.CustomID == "req-1" {
+			return Result{Outcome: OutcomeErrored, Error: "boom"}
+		}
+		return Result{Outcome: OutcomeSucceeded, Body: json.RawMessage(`{"ok":true}`)}
+	}, func(job *Job) error {
+		finalized = true
+		return nil
+	})
+
+	job, err := runner.Submit(&Job{ID: "job-1", Kind: "test"}, makeRequests(5))
+	if err != nil {
+		t.Fatalf("Submit failed: %v", err)
+	}
+
+	job = waitDone(t, store, job.ID)
+	if job.Status != StatusCompleted {
+		t.Errorf("expected completed, got %s", job.Status)
+	}
+	if job.Counts.Succeeded != 4 || job.Counts.Errored != 1 {
+		t.Errorf("unexpected counts: %+v", job.Counts)
+	}
+	if !finalized {
+		t.Error("expected finalizer to run")
+	}
+
+	results, err := store.Results(job.ID)
+	if err != nil {
+		t.Fatalf("Results failed: %v", err)
+	}
+	if len(results) != 5 {
+		t.Errorf("expected 5 results, got %d", len(results))
+	}
+}
+
+func TestRunnerCancel(t *testing.T) {
+	store, _ := NewStore(t.TempDir())
+	runner := NewRunner(store, 1, 0, false)
+	defer runner.Shutdown()
+
+	release := make(chan struct{})
+	runner.Register("test", func(ctx context.Context, req Request) Result {
+		<-release
+		return Result{Outcome: OutcomeSucceeded}
+	}, nil)
+
+	job, _ := runner.Submit(&Job{ID: "job-cancel", Kind: "test"}, makeRequests(4))
+	time.Sleep(20 * time.Millisecond)
+
+	canceled, err := runner.Cancel(job.ID)
+	if err != nil {
+		t.Fatalf("Cancel failed: %v", err)
+	}
+	if canceled.Status != StatusCanceling {
+		t.Errorf("expected canceling, got %s", canceled.Status)
+	}
+	close(release)
+
+	job = waitDone(t, store, job.ID)
+	if job.Status != StatusCanceled {
+		t.Errorf("expected canceled, got %s", job.Status)
+	}
+	if job.Counts.Canceled == 0 || job.Counts.Processed() != 4 {
+		t.Errorf("unexpected counts: %+v", job.Counts)
+	}
+}
+
+func TestRunnerCancelAfterLastItem(t *testing.T) {
+	store, _ := NewStore(t.TempDir())
+	runner := NewRunner(store, 2, 0, false)
+	defer runner.Shutdown()
+
+	release := make(chan struct{})
+	runner.Register("test", func(ctx context.Context, req Request) Result {
+		<-release
+		return Result{Outcome: OutcomeSucceeded}
+	}, nil)
+
+	// Both items are in flight, so the cancel skips nothing
+	job, _ := runner.Submit(&Job{ID: "job-late-cancel", Kind: "test"}, makeRequests(2))
+	time.Sleep(20 * time.Millisecond)
+	if _, err := runner.Cancel(job.ID); err != nil {
+		t.Fatalf("Cancel failed: %v", err)
+	}
+	close(release)
+
+	job = waitDone(t, store, job.ID)
+	if job.Status != StatusCompleted {
+		t.Errorf("expected completed, got %s", job.Status)
+	}
+	if job.Counts.Succeeded != 2 || job.Counts.Canceled != 0 {
+		t.Errorf("unexpected counts: %+v", job.Counts)
+	}
+}
+
+func TestRunnerResumesAfterRestart(t *testing.T) {
+	dir := t.TempDir()
+	store, _ := NewStore(dir)
+	runner := NewRunner(store, 1, 0, false)
+
+	var calls int32
+	block := make(chan struct{})
+	runner.Register("test", func(ctx context.Context, req Request) Result {
+		if atomic.AddInt32(&calls, 1) > 2 {
+			select {
+			case <-block:
+			case <-ctx.Done():
+			}
+		}
+		return Result{Outcome: OutcomeSucceeded}
+	}, nil)
+
+	job, _ := runner.Submit(&Job{ID: "job-resume", Kind: "test"}, makeRequests(5))
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(&calls) < 3 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	runner.Shutdown()
+
+	interrupted, _ := store.Get(job.ID)
+	if interrupted.Status.Done() {
+		t.Fatalf("expected job to be unfinished after shutdown, got %s", interrupted.Status)
+	}
+
+	// Reopen the store as a fresh process would.
+	store2, err := NewStore(dir)
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	runner2 := NewRunner(store2, 2, 0, false)
+	defer runner2.Shutdown()
+
+	var resumed int32
+	runner2.Register("test", func(ctx context.Context, req Request) Result {
+		atomic.AddInt32(&resumed, 1)
+		return Result{Outcome: OutcomeSucceeded}
+	}, nil)
+	runner2.Start()
+
+	final := waitDone(t, store2, job.ID)
+	if final.Status != StatusCompleted || final.Counts.Succeeded != 5 {
+		t.Errorf("unexpected final job: %s %+v", final.Status, final.Counts)
+	}
+	if got := atomic.LoadInt32(&resumed); got != 3 {
+		t.Errorf("expected 3 items to run after restart, got %d", got)
+	}
+}
+
+func TestRateLimiterSpacesStarts(t *testing.T) {
+	limiter := newRateLimiter(600) // one every 100ms
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := limiter.wait(context.Background()); err != nil {
+			t.Fatalf("wait failed: %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 180*time.Millisecond {
+		t.Errorf("expected rate limiting, elapsed %v", elapsed)
+	}
+}
@@ -0,0 +1,413 @@
+package batch
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/rahulvramesh/gh-proxy-local/internal/logging"
+)
+
+// DefaultExpiry is how long a job may run before unprocessed items expire.
+const DefaultExpiry = 24 * time.Hour
+
+// Executor runs a single batch item and returns its result. The item's job
+// is available from the context via JobFromContext.
+type Executor func(ctx context.Context, req Request) Result
+
+type jobContextKey struct{}
+
+// WithJob returns a context for running an item of job.
+func WithJob(ctx context.Context, job *Job) context.Context {
+	return context.WithValue(ctx, jobContextKey{}, job)
+}
+
+// JobFromContext returns the job an executor is running an item of.
+func JobFromContext(ctx context.Context) *Job {
+	job, _ := ctx.Value(jobContextKey{}).(*Job)
+	return job
+}
+
+// FinalizeFunc is called once a job of a given kind has finished processing,
+// before its terminal status is persisted. Changes it makes to the job's
+// Attributes are saved. Returning an error marks the job as failed.
+type FinalizeFunc func(job *Job) error
+
+// Runner processes batch jobs with a bounded, rate-limited worker pool shared
+// by all jobs. Progress is persisted after every item, so jobs interrupted by
+// a restart resume where they left off.
+type Runner struct {
+	store *Store
+	debug bool
+
+	slots   chan struct{}
+	limiter *rateLimiter
+
+	mu         sync.Mutex
+	executors  map[string]Executor
+	finalizers map[string]FinalizeFunc
+	running    map[string]context.CancelFunc
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewRunner creates a runner executing at most concurrency items at once and
+// starting at most requestsPerMinute items per minute (0 means unlimited).
+func NewRunner(store *Store, concurrency, requestsPerMinute int, debug bool) *Runner {
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Runner{
+		store:      store,
+		debug:      debug,
+		slots:      make(chan struct{}, concurrency),
+		limiter:    newRateLimiter(requestsPerMinute),
+		executors:  make(map[string]Executor),
+		finalizers: make(map[string]FinalizeFunc),
+		running:    make(map[string]context.CancelFunc),
+		ctx:        ctx,
+		cancel:     cancel,
+	}
+}
+
+// debugLog logs a debug message if debugging is enabled. args are slog
+// key-value pairs.
+func (r *Runner) debugLog(msg string, args ...interface{}) {
+	if r.debug || logging.DebugEnabled() {
+		slog.Debug(msg, append([]interface{}{"component", "batch"}, args...)...)
+	}
+}
+
+// Store returns the underlying job store.
+func (r *Runner) Store() *Store {
+	return r.store
+}
+
+// Register installs the executor and optional finalizer for a job kind.
+func (r *Runner) Register(kind string, exec Executor, finalize FinalizeFunc) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.executors[kind] = exec
+	if finalize != nil {
+		r.finalizers[kind] = finalize
+	}
+}
+
+// Start resumes every unfinished job found in the store. Executors must be
+// registered before calling Start.
+func (r *Runner) Start() {
+	for _, job := range r.store.List("") {
+		if !job.Status.Done() {
+			r.debugLog("Resuming batch", "batch", job.ID, "processed", job.Counts.Processed(), "total", job.Counts.Total)
+			r.launch(job.ID)
+		}
+	}
+}
+
+// Submit persists a new job and schedules it for processing.
+func (r *Runner) Submit(job *Job, requests []Request) (*Job, error) {
+	r.mu.Lock()
+	_, ok := r.executors[job.Kind]
+	r.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("no executor registered for batch kind %q", job.Kind)
+	}
+
+	now := time.Now().UTC()
+	job.Status = StatusQueued
+	job.Counts = Counts{Total: len(requests)}
+	job.CreatedAt = now
+	if job.ExpiresAt.IsZero() {
+		job.ExpiresAt = now.Add(DefaultExpiry)
+	}
+
+	if err := r.store.Create(job, requests); err != nil {
+		return nil, err
+	}
+	r.debugLog("Batch submitted", "batch", job.ID, "requests", len(requests))
+
+	r.launch(job.ID)
+	created, _ := r.store.Get(job.ID)
+	return created, nil
+}
+
+// Cancel requests cancellation of a job. Items already executing finish;
+// items not yet started are recorded as canceled.
+func (r *Runner) Cancel(id string) (*Job, error) {
+	job, ok := r.store.Get(id)
+	if !ok {
+		return nil, fmt.Errorf("batch %s not found", id)
+	}
+	if job.Status.Done() || job.CancelRequestedAt != nil {
+		return job, nil
+	}
+
+	job, err := r.store.Update(id, func(j *Job) {
+		now := time.Now().UTC()
+		j.CancelRequestedAt = &now
+		j.Status = StatusCanceling
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	stop, running := r.running[id]
+	r.mu.Unlock()
+	if running {
+		stop()
+	} else {
+		r.launch(id)
+	}
+	return job, nil
+}
+
+// Shutdown stops dispatching new items and waits for in-flight items.
+// Unfinished jobs are resumed by the next Start.
+func (r *Runner) Shutdown() {
+	r.cancel()
+	r.wg.Wait()
+}
+
+// launch starts processing a job in the background unless it is running.
+func (r *Runner) launch(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.running[id]; ok {
+		return
+	}
+	stopCtx, stop := context.WithCancel(r.ctx)
+	r.running[id] = stop
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		defer func() {
+			r.mu.Lock()
+			delete(r.running, id)
+			r.mu.Unlock()
+			stop()
+		}()
+		r.run(stopCtx, id)
+	}()
+}
+
+// run processes the pending items of a job until it finishes, is canceled
+// or the runner shuts down.
+func (r *Runner) run(stopCtx context.Context, id string) {
+	job, ok := r.store.Get(id)
+	if !ok || job.Status.Done() {
+		return
+	}
+
+	r.mu.Lock()
+	exec := r.executors[job.Kind]
+	finalize := r.finalizers[job.Kind]
+	r.mu.Unlock()
+	if exec == nil {
+		r.debugLog("No executor for batch, leaving queued", "batch", id, "kind", job.Kind)
+		return
+	}
+
+	requests, err := r.store.Requests(id)
+	if err != nil {
+		r.fail(id, err)
+		return
+	}
+	results, err := r.store.Results(id)
+	if err != nil {
+		r.fail(id, err)
+		return
+	}
+
+	done := make(map[string]bool, len(results))
+	counts := Counts{Total: len(requests)}
+	for _, res := range results {
+		done[res.CustomID] = true
+		counts.add(res.Outcome)
+	}
+
+	r.store.Update(id, func(j *Job) {
+		j.Counts = counts
+		if j.Status == StatusQueued {
+			j.Status = StatusInProgress
+		}
+		if j.StartedAt == nil {
+			now := time.Now().UTC()
+			j.StartedAt = &now
+		}
+	})
+
+	var items sync.WaitGroup
+	remaining := make([]Request, 0, len(requests)-len(done))
+	for _, req := range requests {
+		if !done[req.CustomID] {
+			remaining = append(remaining, req)
+		}
+	}
+
+	next := 0
+	for ; next < len(remaining); next++ {
+		if stopCtx.Err() != nil || time.Now().After(job.ExpiresAt) {
+			break
+		}
+		acquired := false
+		select {
+		case r.slots <- struct{}{}:
+			acquired = true
+		case <-stopCtx.Done():
+		}
+		if stopCtx.Err() != nil {
+			// The slot may have been won just before the stop
+			if acquired {
+				<-r.slots
+			}
+			break
+		}
+		if err := r.limiter.wait(stopCtx); err != nil {
+			<-r.slots
+			break
+		}
+
+		items.Add(1)
+		go func(req Request) {
+			defer items.Done()
+			defer func() { <-r.slots }()
+
+			result := exec(WithJob(r.ctx, job), req)
+			if r.ctx.Err() != nil {
+				// Interrupted by shutdown; the item is retried on restart.
+				return
+			}
+			result.CustomID = req.CustomID
+			if result.FinishedAt.IsZero() {
+				result.FinishedAt = time.Now().UTC()
+			}
+			r.record(id, result)
+		}(remaining[next])
+	}
+	items.Wait()
+
+	if r.ctx.Err() != nil {
+		return
+	}
+
+	current, _ := r.store.Get(id)
+	outcome := OutcomeCanceled
+	status := StatusCompleted
+	// A cancel that arrives after every item has finished leaves the batch
+	// completed.
+	switch {
+	case next == len(remaining):
+	case current.CancelRequestedAt != nil:
+		status = StatusCanceled
+	default:
+		outcome = OutcomeExpired
+		status = StatusExpired
+	}
+	for _, req := range remaining[next:] {
+		r.record(id, Result{CustomID: req.CustomID, Outcome: outcome, FinishedAt: time.Now().UTC()})
+	}
+
+	var finalizeErr error
+	current, _ = r.store.Get(id)
+	if finalize != nil {
+		finalizeErr = finalize(current)
+	}
+
+	r.store.Update(id, func(j *Job) {
+		now := time.Now().UTC()
+		j.EndedAt = &now
+		j.Status = status
+		j.Attributes = current.Attributes
+		if finalizeErr != nil {
+			j.Status = StatusFailed
+			j.Error = finalizeErr.Error()
+		}
+	})
+	r.debugLog("Batch finished", "batch", id, "status", status)
+}
+
+// record persists an item result and updates the job counts.
+func (r *Runner) record(id string, result Result) {
+	if err := r.store.AppendResult(id, result); err != nil {
+		r.debugLog("Failed to record batch result", "batch", id, "custom_id", result.CustomID, "error", err)
+		return
+	}
+	r.store.Update(id, func(j *Job) {
+		j.Counts.add(result.Outcome)
+	})
+}
+
+// fail marks a job as failed.
+func (r *Runner) fail(id string, err error) {
+	r.debugLog("Batch failed", "batch", id, "error", err)
+	r.store.Update(id, func(j *Job) {
+		now := time.Now().UTC()
+		j.Status = StatusFailed
+		j.Error = err.Error()
+		j.EndedAt = &now
+	})
+}
+
+func (c *Counts) add(outcome Outcome) {
+	switch outcome {
+	case OutcomeSucceeded:
+		c.Succeeded++
+	case OutcomeErrored:
+		c.Errored++
+	case OutcomeCanceled:
+		c.Canceled++
+	case OutcomeExpired:
+		c.Expired++
+	}
+}
+
+// rateLimiter spaces out item starts evenly across a minute.
+type rateLimiter struct {
+	interval time.Duration
+
+	mu   sync.Mutex
+	next time.Time
+}
+
+func newRateLimiter(perMinute int) *rateLimiter {
+	if perMinute <= 0 {
+		return &rateLimiter{}
+	}
+	return &rateLimiter{interval: time.Minute / time.Duration(perMinute)}
+}
+
+// wait blocks until the next start slot or until ctx is done.
+func (l *rateLimiter) wait(ctx context.Context) error {
+	if l.interval == 0 {
+		return nil
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	at := l.next
+	if at.Before(now) {
+		at = now
+	}
+	l.next = at.Add(l.interval)
+	l.mu.Unlock()
+
+	delay := time.Until(at)
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,272 @@
+// Package batch provides a persistent job store and a rate-limited worker
+// pool for asynchronous batch processing.
+package batch
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rahulvramesh/gh-proxy-local/internal/storage"
+)
+
+// Status is the lifecycle state of a batch job.
+type Status string
+
+const (
+	StatusQueued     Status = "queued"
+	StatusInProgress Status = "in_progress"
+	StatusCanceling  Status = "canceling"
+	StatusCompleted  Status = "completed"
+	StatusCanceled   Status = "canceled"
+	StatusExpired    Status = "expired"
+	StatusFailed     Status = "failed"
+)
+
+// Done reports whether the status is terminal.
+func (s Status) Done() bool {
+	switch s {
+	case StatusCompleted, StatusCanceled, StatusExpired, StatusFailed:
+		return true
+	}
+	return false
+}
+
+// Outcome is the final state of a single batch item.
+type Outcome string
+
+const (
+	OutcomeSucceeded Outcome = "succeeded"
+	OutcomeErrored   Outcome = "errored"
+	OutcomeCanceled  Outcome = "canceled"
+	OutcomeExpired   Outcome = "expired"
+)
+
+// Counts tracks per-outcome item counts for a job.
+type Counts struct {
+	Total     int `json:"total"`
+	Succeeded int `json:"succeeded"`
+	Errored   int `json:"errored"`
+	Canceled  int `json:"canceled"`
+	Expired   int `json:"expired"`
+}
+
+// Processed returns the number of items with a final outcome.
+func (c Counts) Processed() int {
+	return c.Succeeded + c.Errored + c.Canceled + c.Expired
+}
+
+// Job is a persisted batch job. Metadata is caller-supplied and echoed back
+// to clients; Attributes holds kind-specific state such as input and output
+// file references.
+type Job struct {
+	ID                string            `json:"id"`
+	Kind              string            `json:"kind"`
+	Status            Status            `json:"status"`
+	Counts            Counts            `json:"counts"`
+	CreatedAt         time.Time         `json:"created_at"`
+	StartedAt         *time.Time        `json:"started_at,omitempty"`
+	EndedAt           *time.Time        `json:"ended_at,omitempty"`
+	CancelRequestedAt *time.Time        `json:"cancel_requested_at,omitempty"`
+	ExpiresAt         time.Time         `json:"expires_at"`
+	Metadata          map[string]string `json:"metadata,omitempty"`
+	Attributes        map[string]string `json:"attributes,omitempty"`
+	Error             string            `json:"error,omitempty"`
+	// APIKey names the client key that submitted the job.
+	APIKey string `json:"api_key,omitempty"`
+}
+
+// Request is a single item of a batch job.
+type Request struct {
+	CustomID string          `json:"custom_id"`
+	Method   string          `json:"method,omitempty"`
+	URL      string          `json:"url,omitempty"`
+	Body     json.RawMessage `json:"body"`
+}
+
+// Result is the persisted outcome of a single batch item.
+type Result struct {
+	CustomID   string          `json:"custom_id"`
+	Outcome    Outcome         `json:"outcome"`
+	StatusCode int             `json:"status_code,omitempty"`
+	Body       json.RawMessage `json:"body,omitempty"`
+	Error      string          `json:"error,omitempty"`
+	FinishedAt time.Time       `json:"finished_at"`
+}
+
+// Store persists batch jobs, their requests and results on disk. Each job
+// lives in its own directory holding job.json, requests.jsonl and
+// results.jsonl.
+type Store struct {
+	dir string
+
+	mu   sync.RWMutex
+	jobs map[string]*Job
+}
+
+// NewStore opens the store rooted at dir and loads all existing jobs.
+func NewStore(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create batch directory: %w", err)
+	}
+
+	s := &Store{dir: dir, jobs: make(map[string]*Job)}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read batch directory: %w", err)
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		var job Job
+		if err := storage.ReadJSON(filepath.Join(dir, entry.Name(), "job.json"), &job); err != nil {
+			continue
+		}
+		s.jobs[job.ID] = &job
+	}
+
+	return s, nil
+}
+
+func (s *Store) jobDir(id string) string {
+	return filepath.Join(s.dir, id)
+}
+
+// Create persists a new job together with its requests.
+func (s *Store) Create(job *Job, requests []Request) error {
+	lines := make([][]byte, 0, len(requests))
+	for _, req := range requests {
+		data, err := json.Marshal(req)
+		if err != nil {
+			return fmt.Errorf("failed to marshal request %s: %w", req.CustomID, err)
+		}
+		lines = append(lines, data)
+	}
+
+	dir := s.jobDir(job.ID)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to create job directory: %w", err)
+	}
+	if err := storage.AppendLines(filepath.Join(dir, "requests.jsonl"), lines); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := storage.WriteJSON(filepath.Join(dir, "job.json"), job); err != nil {
+		return err
+	}
+	s.jobs[job.ID] = job
+	return nil
+}
+
+// Get returns a copy of the job with the given ID.
+func (s *Store) Get(id string) (*Job, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	job, ok := s.jobs[id]
+	if !ok {
+		return nil, false
+	}
+	copied := *job
+	return &copied, true
+}
+
+// List returns copies of all jobs of the given kind, newest first.
+func (s *Store) List(kind string) []*Job {
+	s.mu.RLock()
+	result := make([]*Job, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		if kind != "" && job.Kind != kind {
+			continue
+		}
+		copied := *job
+		result = append(result, &copied)
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].CreatedAt.Equal(result[j].CreatedAt) {
+			return result[i].ID > result[j].ID
+		}
+		return result[i].CreatedAt.After(result[j].CreatedAt)
+	})
+	return result
+}
+
+// Update applies fn to the stored job and persists the result.
+func (s *Store) Update(id string, fn func(job *Job)) (*Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job, ok := s.jobs[id]
+	if !ok {
+		return nil, fmt.Errorf("batch %s not found", id)
+	}
+	fn(job)
+	if err := storage.WriteJSON(filepath.Join(s.jobDir(id), "job.json"), job); err != nil {
+		return nil, err
+	}
+	copied := *job
+	return &copied, nil
+}
+
+// Delete removes a job and all of its files.
+func (s *Store) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.jobs[id]; !ok {
+		return fmt.Errorf("batch %s not found", id)
+	}
+	if err := os.RemoveAll(s.jobDir(id)); err != nil {
+		return fmt.Errorf("failed to delete batch: %w", err)
+	}
+	delete(s.jobs, id)
+	return nil
+}
+
+// Requests returns the requests of a job in submission order.
+func (s *Store) Requests(id string) ([]Request, error) {
+	var requests []Request
+	err := storage.ReadJSONL(filepath.Join(s.jobDir(id), "requests.jsonl"), func(line []byte) error {
+		var req Request
+		if err := json.Unmarshal(line, &req); err != nil {
+			return fmt.Errorf("failed to parse request: %w", err)
+		}
+		requests = append(requests, req)
+		return nil
+	})
+	return requests, err
+}
+
+// AppendResult records the outcome of a single item.
+func (s *Store) AppendResult(id string, result Result) error {
+	return storage.AppendJSONL(filepath.Join(s.jobDir(id), "results.jsonl"), result)
+}
+
+// Results returns the recorded results of a job in completion order. If an
+// item was recorded more than once only the first result is kept.
+func (s *Store) Results(id string) ([]Result, error) {
+	seen := make(map[string]bool)
+	var results []Result
+	err := storage.ReadJSONL(filepath.Join(s.jobDir(id), "results.jsonl"), func(line []byte) error {
+		var result Result
+		if err := json.Unmarshal(line, &result); err != nil {
+			return fmt.Errorf("failed to parse result: %w", err)
+		}
+		if seen[result.CustomID] {
+			return nil
+		}
+		seen[result.CustomID] = true
+		results = append(results, result)
+		return nil
+	})
+	return results, err
+}
@@ -0,0 +1,409 @@
+// Package budget enforces daily and monthly token budgets per API key.
+package budget
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rahulvramesh/gh-proxy-local/internal/apikeys"
+	"github.com/rahulvramesh/gh-proxy-local/internal/config"
+	"github.com/rahulvramesh/gh-proxy-local/internal/langfuse"
+	"github.com/rahulvramesh/gh-proxy-local/internal/storage"
+)
+
+// ErrExhausted is returned by Check once a key has spent a budget.
+var ErrExhausted = errors.New("token budget exhausted")
+
+const (
+	dayLayout   = "2006-01-02"
+	monthLayout = "2006-01"
+)
+
+// Period is token consumption within one day or month.
+type Period struct {
+	Period string `json:"period"`
+	Tokens int64  `json:"tokens"`
+	// Alerted is the highest alert threshold raised for the period.
+	Alerted int `json:"alerted,omitempty"`
+}
+
+// roll starts a new period once the current one has ended.
+func (p *Period) roll(current string) {
+	if p.Period != current {
+		*p = Period{Period: current}
+	}
+}
+
+// account is the persisted consumption of one key.
+type account struct {
+	Daily   Period `json:"daily"`
+	Monthly Period `json:"monthly"`
+}
+
+// Window reports one budget of a key.
+type Window struct {
+	Period      string    `json:"period"`
+	Used        int64     `json:"used"`
+	Limit       int64     `json:"limit"`
+	Remaining   *int64    `json:"remaining"`
+	PercentUsed *float64  `json:"percent_used"`
+	ResetsAt    time.Time `json:"resets_at"`
+}
+
+// Status reports the budgets of a key.
+type Status struct {
+	Key     string `json:"key"`
+	Daily   Window `json:"daily"`
+	Monthly Window `json:"monthly"`
+}
+
+// Alert is raised when a key crosses an alert threshold of a budget.
+type Alert struct {
+	Key       string    `json:"key"`
+	Window    string    `json:"window"`
+	Period    string    `json:"period"`
+	Threshold int       `json:"threshold"`
+	Used      int64     `json:"used"`
+	Limit     int64     `json:"limit"`
+	Time      time.Time `json:"time"`
+}
+
+// LookupFunc returns a registered key by name, or nil.
+type LookupFunc func(name string) *apikeys.Key
+
+// Tracker counts token usage per key against its budgets. A nil Tracker
+// admits everything and records nothing.
+type Tracker struct {
+	path   string
+	cfg    atomic.Pointer[config.BudgetConfig]
+	lookup LookupFunc
+	debug  bool
+
+	mu       sync.Mutex
+	accounts map[string]*account
+	dirty    bool
+
+	httpClient *http.Client
+	now        func() time.Time
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewTracker opens the budget store at path. lookup resolves key names to
+// their registry entries for per-key budget overrides; it may be nil.
+func NewTracker(path string, cfg config.BudgetConfig, lookup LookupFunc, debug bool) (*Tracker, error) {
+	t := &Tracker{
+		path:       path,
+		lookup:     lookup,
+		debug:      debug,
+		accounts:   make(map[string]*account),
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+		now:        time.Now,
+		done:       make(chan struct{}),
+	}
+	t.cfg.Store(&cfg)
+
+	if err := storage.ReadJSON(path, &t.accounts); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read budgets: %w", err)
+	}
+	if t.accounts == nil {
+		t.accounts = make(map[string]*account)
+	}
+	return t, nil
+}
+
+// SetConfig replaces the default budgets and alert settings. Alerts
+// already raised in the current periods are not raised again.
+func (t *Tracker) SetConfig(cfg config.BudgetConfig) {
+	t.cfg.Store(&cfg)
+}
+
+// Start begins periodic persistence.
+func (t *Tracker) Start() {
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+		ticker := time.NewTicker(30 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-t.done:
+				return
+			case <-ticker.C:
+				t.save()
+			}
+		}
+	}()
+}
+
+// Shutdown stops persistence and saves pending usage.
+func (t *Tracker) Shutdown() {
+	close(t.done)
+	t.wg.Wait()
+	t.save()
+}
+
+// save persists budgets if they changed.
+func (t *Tracker) save() {
+	t.mu.Lock()
+	if !t.dirty {
+		t.mu.Unlock()
+		return
+	}
+	err := storage.WriteJSON(t.path, t.accounts)
+	if err == nil {
+		t.dirty = false
+	}
+	t.mu.Unlock()
+
+	if err != nil {
+		slog.Error("Failed to save budgets", "component", "budget", "error", err)
+	}
+}
+
+// Limits returns the daily and monthly token budgets of a key: its own
+// overrides, falling back to the configured defaults. 0 means no budget.
+func (t *Tracker) Limits(name string) (daily, monthly int64) {
+	cfg := t.cfg.Load()
+	daily, monthly = cfg.DailyTokens, cfg.MonthlyTokens
+	var key *apikeys.Key
+	if t.lookup != nil {
+		key = t.lookup(name)
+	}
+	if key == nil {
+		return daily, monthly
+	}
+	if key.DailyTokenBudget != 0 {
+		daily = max(key.DailyTokenBudget, 0)
+	}
+	if key.MonthlyTokenBudget != 0 {
+		monthly = max(key.MonthlyTokenBudget, 0)
+	}
+	return daily, monthly
+}
+
+// accountLocked returns the key's account rolled over to the current
+// periods.
+func (t *Tracker) accountLocked(name string, now time.Time) *account {
+	a, ok := t.accounts[name]
+	if !ok {
+		a = &account{}
+		t.accounts[name] = a
+	}
+	a.Daily.roll(now.Format(dayLayout))
+	a.Monthly.roll(now.Format(monthLayout))
+	return a
+}
+
+// Check refuses a request once its key has spent its daily or monthly
+// budget.
+func (t *Tracker) Check(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	name := apikeys.NameFromContext(ctx)
+	daily, monthly := t.Limits(name)
+	if daily == 0 && monthly == 0 {
+		return nil
+	}
+
+	now := t.now()
+	t.mu.Lock()
+	a := t.accountLocked(name, now)
+	dailyUsed, monthlyUsed := a.Daily.Tokens, a.Monthly.Tokens
+	t.mu.Unlock()
+
+	if monthly > 0 && monthlyUsed >= monthly {
+		return fmt.Errorf("%w: API key %q has used %d of its monthly budget of %d tokens; it resets at %s",
+			ErrExhausted, name, monthlyUsed, monthly, monthEnd(now).Format(time.RFC3339))
+	}
+	if daily > 0 && dailyUsed >= daily {
+		return fmt.Errorf("%w: API key %q has used %d of its daily budget of %d tokens; it resets at %s",
+			ErrExhausted, name, dailyUsed, daily, dayEnd(now).Format(time.RFC3339))
+	}
+	return nil
+}
+
+// Record counts a request's token usage against its key's budgets and
+// raises alerts for thresholds crossed.
+func (t *Tracker) Record(ctx context.Context, usage *langfuse.UsageData) {
+	if t == nil || usage == nil {
+		return
+	}
+	tokens := int64(usage.TotalTokens)
+	if tokens == 0 {
+		tokens = int64(usage.PromptTokens + usage.CompletionTokens)
+	}
+	if tokens <= 0 {
+		return
+	}
+
+	name := apikeys.NameFromContext(ctx)
+	daily, monthly := t.Limits(name)
+	now := t.now()
+
+	t.mu.Lock()
+	a := t.accountLocked(name, now)
+	a.Daily.Tokens += tokens
+	a.Monthly.Tokens += tokens
+	t.dirty = true
+	var alerts []Alert
+	if alert, ok := t.crossed(name, "daily", &a.Daily, daily, now); ok {
+		alerts = append(alerts, alert)
+	}
+	if alert, ok := t.crossed(name, "monthly", &a.Monthly, monthly, now); ok {
+		alerts = append(alerts, alert)
+	}
+	t.mu.Unlock()
+
+	if len(alerts) > 0 {
+		// Persist right away so an exhausted budget survives a crash.
+		t.save()
+	}
+	for _, alert := range alerts {
+		t.raise(alert)
+	}
+}
+
+// crossed returns an alert for the highest threshold p has newly reached.
+func (t *Tracker) crossed(name, window string, p *Period, limit int64, now time.Time) (Alert, bool) {
+	if limit <= 0 {
+		return Alert{}, false
+	}
+	percent := float64(p.Tokens) / float64(limit) * 100
+	reached := 0
+	for _, threshold := range t.cfg.Load().AlertThresholds {
+		if percent >= float64(threshold) && threshold > reached {
+			reached = threshold
+		}
+	}
+	if reached <= p.Alerted {
+		return Alert{}, false
+	}
+	p.Alerted = reached
+	return Alert{
+		Key:       name,
+		Window:    window,
+		Period:    p.Period,
+		Threshold: reached,
+		Used:      p.Tokens,
+		Limit:     limit,
+		Time:      now.UTC(),
+	}, true
+}
+
+// raise logs an alert and posts it to the alert webhook, if configured.
+func (t *Tracker) raise(alert Alert) {
+	slog.Warn("API key reached a token budget alert threshold", "component", "budget",
+		"key", alert.Key, "threshold", alert.Threshold, "window", alert.Window, "used", alert.Used, "limit", alert.Limit)
+	webhook := t.cfg.Load().AlertWebhook
+	if webhook == "" {
+		return
+	}
+
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return
+	}
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+		resp, err := t.httpClient.Post(webhook, "application/json", bytes.NewReader(body))
+		if err != nil {
+			slog.Error("Failed to send budget alert", "component", "budget", "error", err)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			slog.Error("Budget alert webhook failed", "component", "budget", "status", resp.StatusCode)
+		}
+	}()
+}
+
+// Status returns the budget state of a key.
+func (t *Tracker) Status(name string) Status {
+	daily, monthly := t.Limits(name)
+	now := t.now()
+
+	t.mu.Lock()
+	var a account
+	if existing, ok := t.accounts[name]; ok {
+		a = *existing
+	}
+	t.mu.Unlock()
+	a.Daily.roll(now.Format(dayLayout))
+	a.Monthly.roll(now.Format(monthLayout))
+
+	return Status{
+		Key:     name,
+		Daily:   window(a.Daily, daily, dayEnd(now)),
+		Monthly: window(a.Monthly, monthly, monthEnd(now)),
+	}
+}
+
+// Report returns the budget state of the given keys and of every key with
+// recorded usage, sorted by name.
+func (t *Tracker) Report(names []string) []Status {
+	seen := make(map[string]bool)
+	for _, name := range names {
+		seen[name] = true
+	}
+	t.mu.Lock()
+	for name := range t.accounts {
+		seen[name] = true
+	}
+	t.mu.Unlock()
+
+	all := make([]string, 0, len(seen))
+	for name := range seen {
+		all = append(all, name)
+	}
+	sort.Strings(all)
+
+	report := make([]Status, len(all))
+	for i, name := range all {
+		report[i] = t.Status(name)
+	}
+	return report
+}
+
+// Reset clears the current consumption of a key.
+func (t *Tracker) Reset(name string) {
+	t.mu.Lock()
+	delete(t.accounts, name)
+	t.dirty = true
+	t.mu.Unlock()
+	t.save()
+}
+
+func window(p Period, limit int64, resetsAt time.Time) Window {
+	w := Window{Period: p.Period, Used: p.Tokens, Limit: limit, ResetsAt: resetsAt}
+	if limit > 0 {
+		remaining := max(limit-p.Tokens, 0)
+		percent := float64(p.Tokens) / float64(limit) * 100
+		w.Remaining = &remaining
+		w.PercentUsed = &percent
+	}
+	return w
+}
+
+func dayEnd(now time.Time) time.Time {
+	y, m, d := now.Date()
+	return time.Date(y, m, d+1, 0, 0, 0, 0, now.Location())
+}
+
+func monthEnd(now time.Time) time.Time {
+	y, m, _ := now.Date()
+	return time.Date(y, m+1, 1, 0, 0, 0, 0, now.Location())
+}
@@ -0,0 +1,133 @@
+package budget
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rahulvramesh/gh-proxy-local/internal/apikeys"
+	"github.com/rahulvramesh/gh-proxy-local/internal/config"
+	"github.com/rahulvramesh/gh-proxy-local/internal/langfuse"
+)
+
+func keyContext(name string) context.Context {
+	return apikeys.WithKey(context.Background(), &apikeys.Key{Name: name})
+}
+
+func TestCheckAndRecord(t *testing.T) {
+	tracker, err := NewTracker(filepath.Join(t.TempDir(), "budgets.json"), config.BudgetConfig{DailyTokens: 100}, nil, false)
+	if err != nil {
+		t.Fatalf("NewTracker failed: %v", err)
+	}
+	ctx := keyContext("ci")
+
+	if err := tracker.Check(ctx); err != nil {
+		t.Fatalf("fresh budget rejected: %v", err)
+	}
+	tracker.Record(ctx, &langfuse.UsageData{PromptTokens: 60, CompletionTokens: 40})
+	if err := tracker.Check(ctx); !errors.Is(err, ErrExhausted) {
+		t.Errorf("expected ErrExhausted, got %v", err)
+	}
+	if err := tracker.Check(keyContext("other")); err != nil {
+		t.Errorf("budgets should be per key, got %v", err)
+	}
+
+	// The next day starts a new period
+	tracker.now = func() time.Time { return time.Now().Add(24 * time.Hour) }
+	if err := tracker.Check(ctx); err != nil {
+		t.Errorf("new day rejected: %v", err)
+	}
+
+	var nilTracker *Tracker
+	if err := nilTracker.Check(ctx); err != nil {
+		t.Errorf("nil tracker rejected: %v", err)
+	}
+	nilTracker.Record(ctx, &langfuse.UsageData{TotalTokens: 1})
+}
+
+func TestKeyOverrides(t *testing.T) {
+	keys := map[string]*apikeys.Key{
+		"big":  {Name: "big", MonthlyTokenBudget: 1000},
+		"free": {Name: "free", DailyTokenBudget: -1},
+	}
+	lookup := func(name string) *apikeys.Key { return keys[name] }
+	tracker, err := NewTracker(filepath.Join(t.TempDir(), "budgets.json"), config.BudgetConfig{DailyTokens: 10, MonthlyTokens: 100}, lookup, false)
+	if err != nil {
+		t.Fatalf("NewTracker failed: %v", err)
+	}
+
+	for name, want := range map[string][2]int64{
+		"big":   {10, 1000},
+		"free":  {0, 100},
+		"other": {10, 100},
+	} {
+		if daily, monthly := tracker.Limits(name); daily != want[0] || monthly != want[1] {
+			t.Errorf("Limits(%q) = %d, %d, want %v", name, daily, monthly, want)
+		}
+	}
+}
+
+func TestAlerts(t *testing.T) {
+	alerts := make(chan Alert, 4)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var alert Alert
+		json.NewDecoder(r.Body).Decode(&alert)
+		alerts <- alert
+	}))
+	defer server.Close()
+
+	cfg := config.BudgetConfig{MonthlyTokens: 100, AlertThresholds: []int{80, 100}, AlertWebhook: server.URL}
+	tracker, err := NewTracker(filepath.Join(t.TempDir(), "budgets.json"), cfg, nil, false)
+	if err != nil {
+		t.Fatalf("NewTracker failed: %v", err)
+	}
+	ctx := keyContext("ci")
+
+	tracker.Record(ctx, &langfuse.UsageData{TotalTokens: 50})
+	tracker.Record(ctx, &langfuse.UsageData{TotalTokens: 35})
+	tracker.Record(ctx, &langfuse.UsageData{TotalTokens: 5})
+	tracker.Record(ctx, &langfuse.UsageData{TotalTokens: 20})
+	tracker.wg.Wait()
+	close(alerts)
+
+	var thresholds []int
+	for alert := range alerts {
+		if alert.Key != "ci" || alert.Window != "monthly" || alert.Limit != 100 {
+			t.Errorf("unexpected alert %+v", alert)
+		}
+		thresholds = append(thresholds, alert.Threshold)
+	}
+	if len(thresholds) != 2 || thresholds[0]+thresholds[1] != 180 {
+		t.Errorf("thresholds = %v, want 80 and 100 once each", thresholds)
+	}
+}
+
+func TestPersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "budgets.json")
+	cfg := config.BudgetConfig{DailyTokens: 100, MonthlyTokens: 1000}
+	tracker, _ := NewTracker(path, cfg, nil, false)
+	tracker.Record(keyContext("ci"), &langfuse.UsageData{TotalTokens: 30})
+	tracker.save()
+
+	reopened, err := NewTracker(path, cfg, nil, false)
+	if err != nil {
+		t.Fatalf("NewTracker failed: %v", err)
+	}
+	status := reopened.Status("ci")
+	if status.Daily.Used != 30 || *status.Daily.Remaining != 70 || status.Monthly.Used != 30 {
+		t.Errorf("Status after reopen = %+v", status)
+	}
+
+	reopened.Reset("ci")
+	if status := reopened.Status("ci"); status.Monthly.Used != 0 {
+		t.Errorf("Status after reset = %+v", status)
+	}
+	if report := reopened.Report([]string{"new"}); len(report) != 1 || report[0].Key != "new" {
+		t.Errorf("Report = %+v", report)
+	}
+}
@@ -2,8 +2,10 @@
 package config
 
 import (
+	"net/url"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -17,6 +19,7 @@ const (
 	CopilotTokenURL = "https://api.github.com/copilot_internal/v2/token"
 	CopilotAPIBase  = "https://api.githubcopilot.com"
 	GitHubUserURL   = "https://api.github.com/user"
+	CopilotUserURL  = "https://api.github.com/copilot_internal/user"
 
 	// Cache TTL
 	ModelsCacheTTL = 300 // 5 minutes in seconds
@@ -33,88 +36,872 @@ var CopilotHeaders = map[string]string{
 	"Copilot-Integration-Id":  "vscode-chat",
 }
 
-// Config holds the server configuration.
+// Config holds the server configuration. The config tags name the
+// settings in the configuration file.
 type Config struct {
-	Host            string
-	Port            int
-	Debug           bool
-	CredentialsFile string
-	APIKey          string
-	Langfuse        LangfuseConfig
+	Host            string `config:"server.host"`
+	Port            int    `config:"server.port"`
+	Debug           bool   `config:"server.debug"`
+	CredentialsFile string `config:"auth.credentials_file"`
+	APIKey          string `config:"auth.api_key"`
+	AdminKey        string `config:"auth.admin_key"`
+	AdminAddr       string `config:"server.admin_addr"`
+	KeysFile        string `config:"auth.keys_file"`
+	AliasesFile     string `config:"routing.aliases_file"`
+	DataDir         string `config:"server.data_dir"`
+	// File is the configuration file the configuration was loaded from,
+	// if any.
+	File string
+	// Aliases map model names to Copilot models, ahead of the built-in
+	// aliases. Aliases in AliasesFile take precedence.
+	Aliases   map[string]string `config:"aliases"`
+	Server    ServerConfig      `config:"server"`
+	Routing   RoutingConfig     `config:"routing"`
+	CORS      CORSConfig        `config:"cors"`
+	Langfuse  LangfuseConfig    `config:"telemetry.langfuse"`
+	Batch     BatchConfig       `config:"limits.batch"`
+	Fetch     FetchConfig       `config:"limits.fetch"`
+	Upstream  UpstreamConfig    `config:"upstream"`
+	Usage     UsageConfig       `config:"limits.quota"`
+	RateLimit RateLimitConfig   `config:"limits.rate"`
+	Budget    BudgetConfig      `config:"limits.budget"`
+	Tracing   TracingConfig     `config:"telemetry.tracing"`
+	Log       LogConfig         `config:"server.log"`
+	Redact    RedactConfig      `config:"telemetry.redact"`
+	Telemetry TelemetryConfig   `config:"telemetry.sinks"`
+	History   HistoryConfig     `config:"telemetry.history"`
+}
+
+// ServerConfig holds the HTTP server timeouts; 0 means no timeout.
+type ServerConfig struct {
+	ReadTimeout  time.Duration `config:"read_timeout"`
+	WriteTimeout time.Duration `config:"write_timeout"`
+	IdleTimeout  time.Duration `config:"idle_timeout"`
+}
+
+// RoutingConfig filters the models clients see and may use. Patterns are
+// matched case-insensitively with path.Match, e.g. "claude-*".
+type RoutingConfig struct {
+	// HiddenModels are left out of model listings.
+	HiddenModels []string `config:"hidden_models"`
+	// AllowedModels restricts requests to matching models when set.
+	AllowedModels []string `config:"allowed_models"`
+}
+
+// CORSConfig holds the CORS headers sent to browsers.
+type CORSConfig struct {
+	// AllowedOrigins are the origins allowed to call the proxy, or "*".
+	AllowedOrigins []string      `config:"allowed_origins"`
+	AllowedMethods []string      `config:"allowed_methods"`
+	AllowedHeaders []string      `config:"allowed_headers"`
+	ExposedHeaders []string      `config:"exposed_headers"`
+	MaxAge         time.Duration `config:"max_age"`
+}
+
+// LogConfig holds structured logging configuration.
+type LogConfig struct {
+	// Level is debug, info, warn or error.
+	Level string `config:"level"`
+	// Format is text or json.
+	Format string `config:"format"`
 }
 
 // LangfuseConfig holds Langfuse observability configuration.
 type LangfuseConfig struct {
-	Enabled       bool
-	Host          string
-	PublicKey     string
-	SecretKey     string
-	BatchSize     int
-	FlushInterval time.Duration
+	Enabled       bool          `config:"enabled"`
+	Host          string        `config:"host"`
+	PublicKey     string        `config:"public_key"`
+	SecretKey     string        `config:"secret_key"`
+	BatchSize     int           `config:"batch_size"`
+	FlushInterval time.Duration `config:"flush_interval"`
+	// MaxRetries is how often a failed batch is retried before it is
+	// spooled or dropped.
+	MaxRetries int `config:"max_retries"`
+	// SpoolDir buffers undeliverable events on disk when set.
+	SpoolDir string `config:"spool_dir"`
+	// SpoolMaxBytes caps the spool; the oldest events are evicted first.
+	SpoolMaxBytes int64 `config:"spool_max_bytes"`
+	// Sampling decides which generations are sent.
+	Sampling SamplingConfig `config:"sampling"`
+}
+
+// SamplingConfig decides which generations are sent to Langfuse and how
+// much of each is captured. Endpoint overrides are keyed by the endpoint
+// name (chat/completions, responses, messages or embeddings) and key
+// overrides by API key name; a key override wins.
+type SamplingConfig struct {
+	// Percent of generations kept by head sampling, 0-100.
+	Percent         float64            `config:"percent"`
+	EndpointPercent map[string]float64 `config:"endpoint_percent"`
+	KeyPercent      map[string]float64 `config:"key_percent"`
+
+	// Capture is "full", or "metadata" to leave out input and output.
+	Capture         string            `config:"capture"`
+	EndpointCapture map[string]string `config:"endpoint_capture"`
+	KeyCapture      map[string]string `config:"key_capture"`
+
+	// Tail rules keep generations head sampling would drop: failed ones,
+	// ones taking at least KeepSlow and ones using more than KeepTokens
+	// tokens. 0 disables a rule.
+	KeepErrors bool          `config:"keep_errors"`
+	KeepSlow   time.Duration `config:"keep_slow"`
+	KeepTokens int           `config:"keep_tokens"`
+}
+
+// BatchConfig holds batch processing configuration.
+type BatchConfig struct {
+	Concurrency       int `config:"concurrency"`
+	RequestsPerMinute int `config:"requests_per_minute"`
+}
+
+// FetchConfig holds settings for server-side fetching of url-sourced
+// images and documents.
+type FetchConfig struct {
+	AllowedHosts []string      `config:"allowed_hosts"`
+	MaxBytes     int64         `config:"max_bytes"`
+	Timeout      time.Duration `config:"timeout"`
+}
+
+// UpstreamConfig holds settings for requests sent to the Copilot API.
+type UpstreamConfig struct {
+	// Header overrides; empty values keep the CopilotHeaders defaults.
+	UserAgent           string `config:"user_agent"`
+	EditorVersion       string `config:"editor_version"`
+	EditorPluginVersion string `config:"editor_plugin_version"`
+	OpenAIIntent        string `config:"openai_intent"`
+	// Headers are sent with every request, overriding CopilotHeaders.
+	Headers map[string]string `config:"headers"`
+
+	// Initiator forces the X-Initiator header to "user" or "agent" for
+	// every request. Empty means it is derived from the conversation.
+	Initiator string `config:"initiator"`
+	// KeyInitiators maps API keys to a forced initiator.
+	KeyInitiators map[string]string `config:"key_initiators"`
+
+	// MaxRetries is how often a request rejected with 429 or 503 and a
+	// Retry-After header is retried.
+	MaxRetries int `config:"max_retries"`
+	// Timeout bounds each upstream request, including streaming.
+	Timeout time.Duration `config:"timeout"`
+	// ModelsCacheTTL is how long the model list is cached.
+	ModelsCacheTTL time.Duration `config:"models_cache_ttl"`
+}
+
+// ApplyHeaders writes the configured header overrides into CopilotHeaders.
+func (u UpstreamConfig) ApplyHeaders() {
+	overrides := map[string]string{
+		"User-Agent":            u.UserAgent,
+		"Editor-Version":        u.EditorVersion,
+		"Editor-Plugin-Version": u.EditorPluginVersion,
+	}
+	for k, v := range u.Headers {
+		overrides[k] = v
+	}
+	for k, v := range overrides {
+		if v != "" {
+			CopilotHeaders[k] = v
+		}
+	}
+}
+
+// UsageConfig holds premium request accounting configuration.
+type UsageConfig struct {
+	// Multipliers overrides the built-in premium request multipliers.
+	Multipliers map[string]float64 `config:"multipliers"`
+	// DefaultMultiplier applies to models without a known multiplier.
+	DefaultMultiplier float64 `config:"default_multiplier"`
+	// PollInterval is how often the quota snapshot is refreshed; 0 disables polling.
+	PollInterval time.Duration `config:"poll_interval"`
+	// SoftLimit is the remaining premium request count at which SoftAction
+	// applies; 0 disables the soft limit.
+	SoftLimit float64 `config:"soft_limit"`
+	// SoftAction is "warn" or "block".
+	SoftAction string `config:"soft_action"`
+}
+
+// RateLimitConfig holds the default per-key rate limits and concurrency
+// limits. API keys may override the per-key values; 0 means unlimited.
+type RateLimitConfig struct {
+	RequestsPerMinute   int `config:"requests_per_minute"`
+	TokensPerMinute     int `config:"tokens_per_minute"`
+	MaxInFlightPerKey   int `config:"max_in_flight_per_key"`
+	MaxInFlightPerModel int `config:"max_in_flight_per_model"`
+	// QueueSize bounds the number of requests waiting for a concurrency slot.
+	QueueSize int `config:"queue_size"`
+	// QueueTimeout is how long a request waits for a slot before it is rejected.
+	QueueTimeout time.Duration `config:"queue_timeout"`
+}
+
+// BudgetConfig holds the default per-key token budgets. API keys may
+// override them; 0 means no budget.
+type BudgetConfig struct {
+	DailyTokens   int64 `config:"daily_tokens"`
+	MonthlyTokens int64 `config:"monthly_tokens"`
+	// AlertThresholds are the percentages of a budget at which an alert
+	// is raised.
+	AlertThresholds []int `config:"alert_thresholds"`
+	// AlertWebhook receives alerts as JSON POSTs when set.
+	AlertWebhook string `config:"alert_webhook"`
+}
+
+// TracingConfig holds OpenTelemetry trace export configuration. Tracing is
+// enabled when Endpoint is set.
+type TracingConfig struct {
+	// Endpoint is the full OTLP/HTTP traces URL.
+	Endpoint      string            `config:"endpoint"`
+	Headers       map[string]string `config:"headers"`
+	ServiceName   string            `config:"service_name"`
+	BatchSize     int               `config:"batch_size"`
+	FlushInterval time.Duration     `config:"flush_interval"`
+}
+
+// RedactConfig holds the redaction applied to payloads before they are
+// exported to telemetry sinks.
+type RedactConfig struct {
+	// Detectors are built-in detector names, or "all".
+	Detectors []string `config:"detectors"`
+	// Patterns are custom regular expressions whose matches are redacted.
+	Patterns []string `config:"patterns"`
+	// Mode is redact, hash or truncate.
+	Mode string `config:"mode"`
+	// TruncateLength is the payload length kept in truncate mode.
+	TruncateLength int `config:"truncate_length"`
+}
+
+// TelemetryConfig holds the telemetry sinks besides Langfuse and
+// OpenTelemetry. Each sink is enabled when its destination is set.
+type TelemetryConfig struct {
+	// Payloads includes request input and output in records.
+	Payloads bool `config:"payloads"`
+	// File is the JSONL file records are appended to. It is rotated at
+	// FileMaxBytes, keeping FileMaxFiles rotated files.
+	File         string `config:"file"`
+	FileMaxBytes int64  `config:"file_max_bytes"`
+	FileMaxFiles int    `config:"file_max_files"`
+	// WebhookURL receives batches of records as JSON POSTs, signed with
+	// WebhookSecret when set.
+	WebhookURL           string        `config:"webhook_url"`
+	WebhookSecret        string        `config:"webhook_secret"`
+	WebhookBatchSize     int           `config:"webhook_batch_size"`
+	WebhookFlushInterval time.Duration `config:"webhook_flush_interval"`
+	WebhookMaxRetries    int           `config:"webhook_max_retries"`
+	// Stdout writes records as JSON lines to standard output.
+	Stdout bool `config:"stdout"`
+}
+
+// HistoryConfig holds the local request history kept for the admin API.
+type HistoryConfig struct {
+	// MaxBytes bounds the history; the oldest requests are evicted
+	// first. 0 disables the history.
+	MaxBytes int64 `config:"max_bytes"`
+	// TTL is how long requests are kept; 0 keeps them until evicted.
+	TTL time.Duration `config:"ttl"`
+	// Payloads records the inbound body, the Copilot payload and the
+	// response of each request.
+	Payloads bool `config:"payloads"`
+	// MaxBodyBytes bounds each recorded body; longer ones are truncated.
+	MaxBodyBytes int64 `config:"max_body_bytes"`
+	// FeedbackMaxBytes bounds the feedback history; the oldest feedback
+	// is evicted first.
+	FeedbackMaxBytes int64 `config:"feedback_max_bytes"`
 }
 
 // NewConfig creates a new configuration from environment variables.
 func NewConfig() *Config {
-	port := 8080
+	c := Default()
+	c.applyEnv()
+	c.resolve()
+	return c
+}
+
+// Load creates a configuration from the file at path, if path is not
+// empty, and environment variables, which take precedence over the file.
+// The configuration is validated.
+func Load(path string) (*Config, error) {
+	c := Default()
+	if path != "" {
+		if err := c.applyFile(path); err != nil {
+			return nil, err
+		}
+		c.File = path
+	}
+	c.applyEnv()
+	c.resolve()
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Default returns the configuration used for settings neither the
+// configuration file nor the environment set.
+func Default() *Config {
+	homeDir, _ := os.UserHomeDir()
+
+	return &Config{
+		Host:            "0.0.0.0",
+		Port:            8080,
+		CredentialsFile: homeDir + "/.copilot_credentials.json",
+		DataDir:         homeDir + "/.copilot_proxy",
+		Aliases:         map[string]string{},
+		Server: ServerConfig{
+			ReadTimeout:  30 * time.Second,
+			WriteTimeout: 120 * time.Second, // Longer for streaming
+			IdleTimeout:  120 * time.Second,
+		},
+		Routing: RoutingConfig{
+			HiddenModels: []string{"*embedding*", "oswe-*"},
+		},
+		CORS: CORSConfig{
+			AllowedOrigins: []string{"*"},
+			AllowedMethods: []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+			AllowedHeaders: []string{"Accept", "Authorization", "Content-Type", "X-API-Key", "X-Initiator", "X-Priority", "X-Request-ID", "X-Session-ID", "X-No-Log", "traceparent", "anthropic-version", "anthropic-beta"},
+			ExposedHeaders: []string{"x-request-id", "request-id", "x-trace-id"},
+			MaxAge:         24 * time.Hour,
+		},
+		Langfuse: LangfuseConfig{
+			Host:          "https://cloud.langfuse.com",
+			BatchSize:     10,
+			FlushInterval: 5 * time.Second,
+			MaxRetries:    3,
+			SpoolMaxBytes: 100 * 1024 * 1024,
+			Sampling: SamplingConfig{
+				Percent:         100,
+				EndpointPercent: map[string]float64{},
+				KeyPercent:      map[string]float64{},
+				Capture:         "full",
+				EndpointCapture: map[string]string{},
+				KeyCapture:      map[string]string{},
+				KeepErrors:      true,
+			},
+		},
+		Batch: BatchConfig{
+			Concurrency:       4,
+			RequestsPerMinute: 60,
+		},
+		Fetch: FetchConfig{
+			MaxBytes: 20 << 20,
+			Timeout:  30 * time.Second,
+		},
+		Upstream: UpstreamConfig{
+			OpenAIIntent:   "conversation-edits",
+			Headers:        map[string]string{},
+			KeyInitiators:  map[string]string{},
+			MaxRetries:     0,
+			Timeout:        120 * time.Second, // Longer for streaming
+			ModelsCacheTTL: ModelsCacheTTL * time.Second,
+		},
+		Usage: UsageConfig{
+			Multipliers:       map[string]float64{},
+			DefaultMultiplier: 1,
+			PollInterval:      5 * time.Minute,
+			SoftAction:        "warn",
+		},
+		RateLimit: RateLimitConfig{
+			QueueSize:    100,
+			QueueTimeout: 60 * time.Second,
+		},
+		Budget: BudgetConfig{
+			AlertThresholds: []int{80, 100},
+		},
+		Tracing: TracingConfig{
+			Headers:       map[string]string{},
+			ServiceName:   "gh-proxy-local",
+			BatchSize:     512,
+			FlushInterval: 5 * time.Second,
+		},
+		Redact: RedactConfig{
+			Mode:           "redact",
+			TruncateLength: 1000,
+		},
+		Telemetry: TelemetryConfig{
+			FileMaxBytes:         100 * 1024 * 1024,
+			FileMaxFiles:         5,
+			WebhookBatchSize:     100,
+			WebhookFlushInterval: 5 * time.Second,
+			WebhookMaxRetries:    3,
+		},
+		History: HistoryConfig{
+			MaxBytes:         100 * 1024 * 1024,
+			TTL:              7 * 24 * time.Hour,
+			Payloads:         true,
+			MaxBodyBytes:     1024 * 1024,
+			FeedbackMaxBytes: 10 * 1024 * 1024,
+		},
+	}
+}
+
+// applyEnv overrides the configuration with the environment variables
+// that are set. Invalid values are ignored.
+func (c *Config) applyEnv() {
 	if p := os.Getenv("COPILOT_PORT"); p != "" {
 		if parsed, err := strconv.Atoi(p); err == nil {
-			port = parsed
+			c.Port = parsed
 		}
 	}
 
-	host := "0.0.0.0"
 	if h := os.Getenv("COPILOT_HOST"); h != "" {
-		host = h
+		c.Host = h
 	}
 
-	debug := false
-	if d := os.Getenv("COPILOT_DEBUG"); d == "1" || d == "true" || d == "yes" {
-		debug = true
+	// Debug mode logs at debug level unless COPILOT_LOG_LEVEL says otherwise
+	if d, ok := parseBool(os.Getenv("COPILOT_DEBUG")); ok {
+		c.Debug = d
+		if d {
+			c.Log.Level = "debug"
+		}
 	}
 
-	homeDir, _ := os.UserHomeDir()
-	credFile := homeDir + "/.copilot_credentials.json"
+	if ll := os.Getenv("COPILOT_LOG_LEVEL"); ll != "" {
+		c.Log.Level = strings.ToLower(ll)
+	}
+
+	if lf := os.Getenv("COPILOT_LOG_FORMAT"); lf != "" {
+		c.Log.Format = lf
+	}
 
-	apiKey := os.Getenv("COPILOT_API_KEY")
+	if ak := os.Getenv("COPILOT_API_KEY"); ak != "" {
+		c.APIKey = ak
+	}
+
+	if ak := os.Getenv("COPILOT_ADMIN_KEY"); ak != "" {
+		c.AdminKey = ak
+	}
+
+	if aa := os.Getenv("COPILOT_ADMIN_ADDR"); aa != "" {
+		c.AdminAddr = aa
+	}
+
+	if dd := os.Getenv("COPILOT_DATA_DIR"); dd != "" {
+		c.DataDir = dd
+	}
+
+	if kf := os.Getenv("COPILOT_KEYS_FILE"); kf != "" {
+		c.KeysFile = kf
+	}
+
+	if af := os.Getenv("COPILOT_ALIASES_FILE"); af != "" {
+		c.AliasesFile = af
+	}
+
+	if bc := os.Getenv("COPILOT_BATCH_CONCURRENCY"); bc != "" {
+		if parsed, err := strconv.Atoi(bc); err == nil && parsed > 0 {
+			c.Batch.Concurrency = parsed
+		}
+	}
+
+	if br := os.Getenv("COPILOT_BATCH_RPM"); br != "" {
+		if parsed, err := strconv.Atoi(br); err == nil && parsed >= 0 {
+			c.Batch.RequestsPerMinute = parsed
+		}
+	}
+
+	if fh := os.Getenv("COPILOT_FETCH_ALLOWED_HOSTS"); fh != "" {
+		c.Fetch.AllowedHosts = strings.Split(fh, ",")
+	}
+
+	if fm := os.Getenv("COPILOT_FETCH_MAX_BYTES"); fm != "" {
+		if parsed, err := strconv.ParseInt(fm, 10, 64); err == nil && parsed > 0 {
+			c.Fetch.MaxBytes = parsed
+		}
+	}
+
+	if ft := os.Getenv("COPILOT_FETCH_TIMEOUT"); ft != "" {
+		if parsed, err := time.ParseDuration(ft); err == nil && parsed > 0 {
+			c.Fetch.Timeout = parsed
+		}
+	}
+
+	if ua := os.Getenv("COPILOT_USER_AGENT"); ua != "" {
+		c.Upstream.UserAgent = ua
+	}
+
+	if ev := os.Getenv("COPILOT_EDITOR_VERSION"); ev != "" {
+		c.Upstream.EditorVersion = ev
+	}
+
+	if pv := os.Getenv("COPILOT_EDITOR_PLUGIN_VERSION"); pv != "" {
+		c.Upstream.EditorPluginVersion = pv
+	}
+
+	if oi := os.Getenv("COPILOT_OPENAI_INTENT"); oi != "" {
+		c.Upstream.OpenAIIntent = oi
+	}
+
+	if in := os.Getenv("COPILOT_INITIATOR"); in == "user" || in == "agent" {
+		c.Upstream.Initiator = in
+	}
+
+	if ur := os.Getenv("COPILOT_UPSTREAM_RETRIES"); ur != "" {
+		if parsed, err := strconv.Atoi(ur); err == nil && parsed >= 0 {
+			c.Upstream.MaxRetries = parsed
+		}
+	}
+
+	for _, pair := range strings.Split(os.Getenv("COPILOT_KEY_INITIATORS"), ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if ok && key != "" && (value == "user" || value == "agent") {
+			c.Upstream.KeyInitiators[key] = value
+		}
+	}
+
+	for _, pair := range strings.Split(os.Getenv("COPILOT_PREMIUM_MULTIPLIERS"), ",") {
+		model, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || model == "" {
+			continue
+		}
+		if parsed, err := strconv.ParseFloat(value, 64); err == nil && parsed >= 0 {
+			c.Usage.Multipliers[model] = parsed
+		}
+	}
+
+	if dm := os.Getenv("COPILOT_PREMIUM_DEFAULT_MULTIPLIER"); dm != "" {
+		if parsed, err := strconv.ParseFloat(dm, 64); err == nil && parsed >= 0 {
+			c.Usage.DefaultMultiplier = parsed
+		}
+	}
+
+	if qp := os.Getenv("COPILOT_QUOTA_POLL_INTERVAL"); qp != "" {
+		if parsed, err := time.ParseDuration(qp); err == nil && parsed >= 0 {
+			c.Usage.PollInterval = parsed
+		}
+	}
+
+	if sl := os.Getenv("COPILOT_QUOTA_SOFT_LIMIT"); sl != "" {
+		if parsed, err := strconv.ParseFloat(sl, 64); err == nil && parsed >= 0 {
+			c.Usage.SoftLimit = parsed
+		}
+	}
+
+	if sa := os.Getenv("COPILOT_QUOTA_SOFT_ACTION"); sa == "warn" || sa == "block" {
+		c.Usage.SoftAction = sa
+	}
+
+	rateLimitInts := map[string]*int{
+		"COPILOT_RATE_LIMIT_RPM":          &c.RateLimit.RequestsPerMinute,
+		"COPILOT_RATE_LIMIT_TPM":          &c.RateLimit.TokensPerMinute,
+		"COPILOT_MAX_IN_FLIGHT_PER_KEY":   &c.RateLimit.MaxInFlightPerKey,
+		"COPILOT_MAX_IN_FLIGHT_PER_MODEL": &c.RateLimit.MaxInFlightPerModel,
+		"COPILOT_QUEUE_SIZE":              &c.RateLimit.QueueSize,
+	}
+	for name, value := range rateLimitInts {
+		if v := os.Getenv(name); v != "" {
+			if parsed, err := strconv.Atoi(v); err == nil && parsed >= 0 {
+				*value = parsed
+			}
+		}
+	}
+
+	if qt := os.Getenv("COPILOT_QUEUE_TIMEOUT"); qt != "" {
+		if parsed, err := time.ParseDuration(qt); err == nil && parsed >= 0 {
+			c.RateLimit.QueueTimeout = parsed
+		}
+	}
+
+	budgetTokens := map[string]*int64{
+		"COPILOT_DAILY_TOKEN_BUDGET":   &c.Budget.DailyTokens,
+		"COPILOT_MONTHLY_TOKEN_BUDGET": &c.Budget.MonthlyTokens,
+	}
+	for name, value := range budgetTokens {
+		if v := os.Getenv(name); v != "" {
+			if parsed, err := strconv.ParseInt(v, 10, 64); err == nil && parsed >= 0 {
+				*value = parsed
+			}
+		}
+	}
+
+	if ba, ok := os.LookupEnv("COPILOT_BUDGET_ALERT_THRESHOLDS"); ok {
+		c.Budget.AlertThresholds = nil
+		for _, v := range strings.Split(ba, ",") {
+			if parsed, err := strconv.Atoi(strings.TrimSpace(v)); err == nil && parsed > 0 {
+				c.Budget.AlertThresholds = append(c.Budget.AlertThresholds, parsed)
+			}
+		}
+	}
+
+	if aw := os.Getenv("COPILOT_BUDGET_ALERT_WEBHOOK"); aw != "" {
+		c.Budget.AlertWebhook = aw
+	}
+
+	// OpenTelemetry configuration, using the standard OTEL_* variables
+	if te := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"); te != "" {
+		c.Tracing.Endpoint = te
+	} else if base := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"); base != "" {
+		c.Tracing.Endpoint = strings.TrimSuffix(base, "/") + "/v1/traces"
+	}
+	if exporter := os.Getenv("OTEL_TRACES_EXPORTER"); exporter == "none" {
+		c.Tracing.Endpoint = ""
+	}
+
+	for _, header := range []string{"OTEL_EXPORTER_OTLP_HEADERS", "OTEL_EXPORTER_OTLP_TRACES_HEADERS"} {
+		for _, pair := range strings.Split(os.Getenv(header), ",") {
+			if k, v, ok := strings.Cut(pair, "="); ok && strings.TrimSpace(k) != "" {
+				if unescaped, err := url.QueryUnescape(strings.TrimSpace(v)); err == nil {
+					c.Tracing.Headers[strings.TrimSpace(k)] = unescaped
+				}
+			}
+		}
+	}
+
+	if sn := os.Getenv("OTEL_SERVICE_NAME"); sn != "" {
+		c.Tracing.ServiceName = sn
+	}
+
+	if bs := os.Getenv("OTEL_BSP_MAX_EXPORT_BATCH_SIZE"); bs != "" {
+		if parsed, err := strconv.Atoi(bs); err == nil && parsed > 0 {
+			c.Tracing.BatchSize = parsed
+		}
+	}
+
+	// OTEL_BSP_SCHEDULE_DELAY is in milliseconds
+	if sd := os.Getenv("OTEL_BSP_SCHEDULE_DELAY"); sd != "" {
+		if parsed, err := strconv.Atoi(sd); err == nil && parsed > 0 {
+			c.Tracing.FlushInterval = time.Duration(parsed) * time.Millisecond
+		}
+	}
+
+	// Telemetry redaction; "none" turns off detectors set in the file
+	if rd := os.Getenv("COPILOT_REDACT"); rd != "" {
+		c.Redact.Detectors = nil
+		for _, name := range strings.Split(rd, ",") {
+			if name = strings.ToLower(strings.TrimSpace(name)); name != "" && name != "none" {
+				c.Redact.Detectors = append(c.Redact.Detectors, name)
+			}
+		}
+	}
+
+	// Patterns are whitespace-separated; use \s to match a space
+	if rp := os.Getenv("COPILOT_REDACT_PATTERNS"); rp != "" {
+		c.Redact.Patterns = strings.Fields(rp)
+	}
+
+	if rm := os.Getenv("COPILOT_REDACT_MODE"); rm != "" {
+		c.Redact.Mode = strings.ToLower(rm)
+	}
+
+	if rt := os.Getenv("COPILOT_REDACT_TRUNCATE"); rt != "" {
+		if parsed, err := strconv.Atoi(rt); err == nil && parsed > 0 {
+			c.Redact.TruncateLength = parsed
+		}
+	}
+
+	// Telemetry sinks
+	if tp, ok := parseBool(os.Getenv("COPILOT_TELEMETRY_PAYLOADS")); ok {
+		c.Telemetry.Payloads = tp
+	}
+
+	if ts, ok := parseBool(os.Getenv("COPILOT_TELEMETRY_STDOUT")); ok {
+		c.Telemetry.Stdout = ts
+	}
+
+	if tf := os.Getenv("COPILOT_TELEMETRY_FILE"); tf != "" {
+		c.Telemetry.File = tf
+	}
+
+	if fm := os.Getenv("COPILOT_TELEMETRY_FILE_MAX_BYTES"); fm != "" {
+		if parsed, err := strconv.ParseInt(fm, 10, 64); err == nil && parsed >= 0 {
+			c.Telemetry.FileMaxBytes = parsed
+		}
+	}
+
+	if fm := os.Getenv("COPILOT_TELEMETRY_FILE_MAX_FILES"); fm != "" {
+		if parsed, err := strconv.Atoi(fm); err == nil && parsed >= 0 {
+			c.Telemetry.FileMaxFiles = parsed
+		}
+	}
+
+	if wu := os.Getenv("COPILOT_TELEMETRY_WEBHOOK_URL"); wu != "" {
+		c.Telemetry.WebhookURL = wu
+	}
+
+	if ws := os.Getenv("COPILOT_TELEMETRY_WEBHOOK_SECRET"); ws != "" {
+		c.Telemetry.WebhookSecret = ws
+	}
+
+	if bs := os.Getenv("COPILOT_TELEMETRY_WEBHOOK_BATCH_SIZE"); bs != "" {
+		if parsed, err := strconv.Atoi(bs); err == nil && parsed > 0 {
+			c.Telemetry.WebhookBatchSize = parsed
+		}
+	}
+
+	if fi := os.Getenv("COPILOT_TELEMETRY_WEBHOOK_FLUSH_INTERVAL"); fi != "" {
+		if parsed, err := time.ParseDuration(fi); err == nil && parsed > 0 {
+			c.Telemetry.WebhookFlushInterval = parsed
+		}
+	}
+
+	if mr := os.Getenv("COPILOT_TELEMETRY_WEBHOOK_MAX_RETRIES"); mr != "" {
+		if parsed, err := strconv.Atoi(mr); err == nil && parsed >= 0 {
+			c.Telemetry.WebhookMaxRetries = parsed
+		}
+	}
+
+	// Request history
+	if hm := os.Getenv("COPILOT_HISTORY_MAX_BYTES"); hm != "" {
+		if parsed, err := strconv.ParseInt(hm, 10, 64); err == nil && parsed >= 0 {
+			c.History.MaxBytes = parsed
+		}
+	}
+
+	if ht := os.Getenv("COPILOT_HISTORY_TTL"); ht != "" {
+		if parsed, err := time.ParseDuration(ht); err == nil && parsed >= 0 {
+			c.History.TTL = parsed
+		}
+	}
+
+	if hp, ok := parseBool(os.Getenv("COPILOT_HISTORY_PAYLOADS")); ok {
+		c.History.Payloads = hp
+	}
+
+	if hb := os.Getenv("COPILOT_HISTORY_MAX_BODY_BYTES"); hb != "" {
+		if parsed, err := strconv.ParseInt(hb, 10, 64); err == nil && parsed > 0 {
+			c.History.MaxBodyBytes = parsed
+		}
+	}
+
+	if fm := os.Getenv("COPILOT_FEEDBACK_MAX_BYTES"); fm != "" {
+		if parsed, err := strconv.ParseInt(fm, 10, 64); err == nil && parsed > 0 {
+			c.History.FeedbackMaxBytes = parsed
+		}
+	}
 
 	// Langfuse configuration
-	langfuseEnabled := false
-	if lf := os.Getenv("LANGFUSE_ENABLED"); lf == "1" || lf == "true" || lf == "yes" {
-		langfuseEnabled = true
+	if lf, ok := parseBool(os.Getenv("LANGFUSE_ENABLED")); ok {
+		c.Langfuse.Enabled = lf
 	}
 
-	langfuseHost := "https://cloud.langfuse.com"
 	if lh := os.Getenv("LANGFUSE_HOST"); lh != "" {
-		langfuseHost = lh
+		c.Langfuse.Host = lh
+	}
+
+	if pk := os.Getenv("LANGFUSE_PUBLIC_KEY"); pk != "" {
+		c.Langfuse.PublicKey = pk
+	}
+
+	if sk := os.Getenv("LANGFUSE_SECRET_KEY"); sk != "" {
+		c.Langfuse.SecretKey = sk
 	}
 
-	langfuseBatchSize := 10
 	if bs := os.Getenv("LANGFUSE_BATCH_SIZE"); bs != "" {
 		if parsed, err := strconv.Atoi(bs); err == nil && parsed > 0 {
-			langfuseBatchSize = parsed
+			c.Langfuse.BatchSize = parsed
 		}
 	}
 
-	langfuseFlushInterval := 5 * time.Second
 	if fi := os.Getenv("LANGFUSE_FLUSH_INTERVAL"); fi != "" {
 		if parsed, err := time.ParseDuration(fi); err == nil && parsed > 0 {
-			langfuseFlushInterval = parsed
+			c.Langfuse.FlushInterval = parsed
 		}
 	}
 
-	return &Config{
-		Host:            host,
-		Port:            port,
-		Debug:           debug,
-		CredentialsFile: credFile,
-		APIKey:          apiKey,
-		Langfuse: LangfuseConfig{
-			Enabled:       langfuseEnabled,
-			Host:          langfuseHost,
-			PublicKey:     os.Getenv("LANGFUSE_PUBLIC_KEY"),
-			SecretKey:     os.Getenv("LANGFUSE_SECRET_KEY"),
-			BatchSize:     langfuseBatchSize,
-			FlushInterval: langfuseFlushInterval,
-		},
+	if mr := os.Getenv("LANGFUSE_MAX_RETRIES"); mr != "" {
+		if parsed, err := strconv.Atoi(mr); err == nil && parsed >= 0 {
+			c.Langfuse.MaxRetries = parsed
+		}
+	}
+
+	if sd := os.Getenv("LANGFUSE_SPOOL_DIR"); sd != "" {
+		c.Langfuse.SpoolDir = sd
+	}
+
+	if sm := os.Getenv("LANGFUSE_SPOOL_MAX_BYTES"); sm != "" {
+		if parsed, err := strconv.ParseInt(sm, 10, 64); err == nil && parsed > 0 {
+			c.Langfuse.SpoolMaxBytes = parsed
+		}
+	}
+
+	sampling := &c.Langfuse.Sampling
+	if sp := os.Getenv("LANGFUSE_SAMPLE_PERCENT"); sp != "" {
+		if parsed, err := strconv.ParseFloat(sp, 64); err == nil && parsed >= 0 && parsed <= 100 {
+			sampling.Percent = parsed
+		}
+	}
+
+	samplePercents := map[string]map[string]float64{
+		"LANGFUSE_SAMPLE_PERCENT_BY_ENDPOINT": sampling.EndpointPercent,
+		"LANGFUSE_SAMPLE_PERCENT_BY_KEY":      sampling.KeyPercent,
+	}
+	for name, percents := range samplePercents {
+		for k, v := range splitPairs(os.Getenv(name)) {
+			if parsed, err := strconv.ParseFloat(v, 64); err == nil && parsed >= 0 && parsed <= 100 {
+				percents[k] = parsed
+			}
+		}
+	}
+
+	if lc := os.Getenv("LANGFUSE_CAPTURE"); lc == "full" || lc == "metadata" {
+		sampling.Capture = lc
+	}
+
+	captures := map[string]map[string]string{
+		"LANGFUSE_CAPTURE_BY_ENDPOINT": sampling.EndpointCapture,
+		"LANGFUSE_CAPTURE_BY_KEY":      sampling.KeyCapture,
+	}
+	for name, levels := range captures {
+		for k, v := range splitPairs(os.Getenv(name)) {
+			if v == "full" || v == "metadata" {
+				levels[k] = v
+			}
+		}
+	}
+
+	if ke, ok := parseBool(os.Getenv("LANGFUSE_KEEP_ERRORS")); ok {
+		sampling.KeepErrors = ke
+	}
+
+	if ks := os.Getenv("LANGFUSE_KEEP_SLOW"); ks != "" {
+		if parsed, err := time.ParseDuration(ks); err == nil && parsed >= 0 {
+			sampling.KeepSlow = parsed
+		}
+	}
+
+	if kt := os.Getenv("LANGFUSE_KEEP_TOKENS"); kt != "" {
+		if parsed, err := strconv.Atoi(kt); err == nil && parsed >= 0 {
+			sampling.KeepTokens = parsed
+		}
+	}
+}
+
+// resolve fills in the settings that default to other settings.
+func (c *Config) resolve() {
+	if c.KeysFile == "" {
+		c.KeysFile = c.DataDir + "/keys.json"
+	}
+	if c.AliasesFile == "" {
+		c.AliasesFile = c.DataDir + "/aliases.json"
+	}
+
+	// Debug mode logs at debug level, and a debug log level enables the
+	// debug diagnostics
+	if c.Log.Level == "" {
+		c.Log.Level = "info"
+		if c.Debug {
+			c.Log.Level = "debug"
+		}
+	}
+	c.Debug = c.Debug || c.Log.Level == "debug"
+}
+
+// parseBool parses the boolean spellings accepted in environment
+// variables. ok is false for anything else.
+func parseBool(s string) (value, ok bool) {
+	switch strings.ToLower(s) {
+	case "1", "true", "yes":
+		return true, true
+	case "0", "false", "no":
+		return false, true
+	}
+	return false, false
+}
+
+// splitPairs parses a comma-separated list of name=value pairs.
+func splitPairs(s string) map[string]string {
+	pairs := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if name = strings.TrimSpace(name); ok && name != "" {
+			pairs[name] = strings.TrimSpace(value)
+		}
 	}
+	return pairs
 }
@@ -2,7 +2,9 @@ package config
 
 import (
 	"os"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestNewConfig(t *testing.T) {
@@ -103,3 +105,184 @@ func TestConstants(t *testing.T) {
 		t.Error("Expected RefreshBufferMS to be positive")
 	}
 }
+
+func TestUpstreamConfig(t *testing.T) {
+	os.Setenv("COPILOT_INITIATOR", "agent")
+	os.Setenv("COPILOT_KEY_INITIATORS", "key-a=user, key-b=agent,bad,key-c=robot")
+	os.Setenv("COPILOT_EDITOR_VERSION", "vscode/9.9.9")
+	defer func() {
+		os.Unsetenv("COPILOT_INITIATOR")
+		os.Unsetenv("COPILOT_KEY_INITIATORS")
+		os.Unsetenv("COPILOT_EDITOR_VERSION")
+	}()
+
+	cfg := NewConfig()
+
+	if cfg.Upstream.Initiator != "agent" {
+		t.Errorf("Expected initiator agent, got %q", cfg.Upstream.Initiator)
+	}
+	if cfg.Upstream.OpenAIIntent != "conversation-edits" {
+		t.Errorf("Expected default intent, got %q", cfg.Upstream.OpenAIIntent)
+	}
+	if len(cfg.Upstream.KeyInitiators) != 2 || cfg.Upstream.KeyInitiators["key-a"] != "user" || cfg.Upstream.KeyInitiators["key-b"] != "agent" {
+		t.Errorf("Unexpected key initiators: %v", cfg.Upstream.KeyInitiators)
+	}
+
+	original := CopilotHeaders["Editor-Version"]
+	defer func() { CopilotHeaders["Editor-Version"] = original }()
+	cfg.Upstream.ApplyHeaders()
+	if CopilotHeaders["Editor-Version"] != "vscode/9.9.9" {
+		t.Errorf("Expected Editor-Version override, got %q", CopilotHeaders["Editor-Version"])
+	}
+	if CopilotHeaders["User-Agent"] == "" {
+		t.Error("Expected unset overrides to keep defaults")
+	}
+}
+
+func TestTracingConfig(t *testing.T) {
+	os.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "http://collector:4318/")
+	os.Setenv("OTEL_EXPORTER_OTLP_HEADERS", "x-api-key=abc%3D, x-team = llm")
+	defer func() {
+		os.Unsetenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+		os.Unsetenv("OTEL_EXPORTER_OTLP_HEADERS")
+	}()
+
+	cfg := NewConfig()
+
+	if cfg.Tracing.Endpoint != "http://collector:4318/v1/traces" {
+		t.Errorf("Expected traces endpoint, got %q", cfg.Tracing.Endpoint)
+	}
+	if cfg.Tracing.Headers["x-api-key"] != "abc=" || cfg.Tracing.Headers["x-team"] != "llm" {
+		t.Errorf("Unexpected headers: %v", cfg.Tracing.Headers)
+	}
+	if cfg.Tracing.ServiceName != "gh-proxy-local" {
+		t.Errorf("Expected default service name, got %q", cfg.Tracing.ServiceName)
+	}
+
+	os.Setenv("OTEL_TRACES_EXPORTER", "none")
+	defer os.Unsetenv("OTEL_TRACES_EXPORTER")
+	if cfg := NewConfig(); cfg.Tracing.Endpoint != "" {
+		t.Errorf("Expected tracing disabled, got endpoint %q", cfg.Tracing.Endpoint)
+	}
+}
+
+func TestLogConfig(t *testing.T) {
+	os.Setenv("COPILOT_LOG_FORMAT", "json")
+	defer os.Unsetenv("COPILOT_LOG_FORMAT")
+
+	cfg := NewConfig()
+	if cfg.Log.Level != "info" || cfg.Log.Format != "json" || cfg.Debug {
+		t.Errorf("Unexpected defaults: %+v, debug %v", cfg.Log, cfg.Debug)
+	}
+
+	os.Setenv("COPILOT_LOG_LEVEL", "DEBUG")
+	defer os.Unsetenv("COPILOT_LOG_LEVEL")
+	if cfg := NewConfig(); cfg.Log.Level != "debug" || !cfg.Debug {
+		t.Errorf("Expected a debug level to enable debug mode, got %+v, debug %v", cfg.Log, cfg.Debug)
+	}
+}
+
+func TestRedactConfig(t *testing.T) {
+	os.Setenv("COPILOT_REDACT", "Email, jwt")
+	os.Setenv("COPILOT_REDACT_PATTERNS", `ACME-\d+  secret\s*=\S+`)
+	os.Setenv("COPILOT_REDACT_MODE", "TRUNCATE")
+	defer func() {
+		os.Unsetenv("COPILOT_REDACT")
+		os.Unsetenv("COPILOT_REDACT_PATTERNS")
+		os.Unsetenv("COPILOT_REDACT_MODE")
+	}()
+
+	cfg := NewConfig()
+
+	if got := strings.Join(cfg.Redact.Detectors, ","); got != "email,jwt" {
+		t.Errorf("Expected detectors email,jwt, got %q", got)
+	}
+	if len(cfg.Redact.Patterns) != 2 || cfg.Redact.Patterns[1] != `secret\s*=\S+` {
+		t.Errorf("Unexpected patterns: %q", cfg.Redact.Patterns)
+	}
+	if cfg.Redact.Mode != "truncate" || cfg.Redact.TruncateLength != 1000 {
+		t.Errorf("Unexpected mode %q and length %d", cfg.Redact.Mode, cfg.Redact.TruncateLength)
+	}
+}
+
+func TestSamplingConfig(t *testing.T) {
+	cfg := NewConfig()
+	if s := cfg.Langfuse.Sampling; s.Percent != 100 || s.Capture != "full" || !s.KeepErrors {
+		t.Errorf("Unexpected sampling defaults: %+v", s)
+	}
+
+	env := map[string]string{
+		"LANGFUSE_SAMPLE_PERCENT":             "12.5",
+		"LANGFUSE_SAMPLE_PERCENT_BY_ENDPOINT": "embeddings=0, messages=150",
+		"LANGFUSE_CAPTURE_BY_KEY":             "ci=metadata,bad=partial",
+		"LANGFUSE_KEEP_ERRORS":                "false",
+		"LANGFUSE_KEEP_SLOW":                  "20s",
+		"LANGFUSE_KEEP_TOKENS":                "50000",
+	}
+	for k, v := range env {
+		os.Setenv(k, v)
+		defer os.Unsetenv(k)
+	}
+
+	s := NewConfig().Langfuse.Sampling
+	if s.Percent != 12.5 || s.KeepErrors || s.KeepSlow != 20*time.Second || s.KeepTokens != 50000 {
+		t.Errorf("Unexpected sampling config: %+v", s)
+	}
+	if p, ok := s.EndpointPercent["embeddings"]; !ok || p != 0 {
+		t.Errorf("Expected embeddings override of 0, got %v", s.EndpointPercent)
+	}
+	if _, ok := s.EndpointPercent["messages"]; ok {
+		t.Error("Expected out-of-range percent to be ignored")
+	}
+	if len(s.KeyCapture) != 1 || s.KeyCapture["ci"] != "metadata" {
+		t.Errorf("Unexpected key capture levels: %v", s.KeyCapture)
+	}
+}
+
+func TestTelemetryConfig(t *testing.T) {
+	cfg := NewConfig()
+	if tc := cfg.Telemetry; tc.Payloads || tc.Stdout || tc.File != "" || tc.FileMaxFiles != 5 || tc.WebhookBatchSize != 100 {
+		t.Errorf("Unexpected telemetry defaults: %+v", tc)
+	}
+
+	env := map[string]string{
+		"COPILOT_TELEMETRY_PAYLOADS":               "true",
+		"COPILOT_TELEMETRY_FILE":                   "/var/log/proxy/requests.jsonl",
+		"COPILOT_TELEMETRY_FILE_MAX_BYTES":         "1048576",
+		"COPILOT_TELEMETRY_WEBHOOK_URL":            "https://warehouse.example.com/ingest",
+		"COPILOT_TELEMETRY_WEBHOOK_FLUSH_INTERVAL": "1s",
+		"COPILOT_TELEMETRY_WEBHOOK_BATCH_SIZE":     "0",
+	}
+	for k, v := range env {
+		os.Setenv(k, v)
+		defer os.Unsetenv(k)
+	}
+
+	tc := NewConfig().Telemetry
+	if !tc.Payloads || tc.File != "/var/log/proxy/requests.jsonl" || tc.FileMaxBytes != 1<<20 {
+		t.Errorf("Unexpected file settings: %+v", tc)
+	}
+	if tc.WebhookURL == "" || tc.WebhookFlushInterval != time.Second || tc.WebhookBatchSize != 100 {
+		t.Errorf("Unexpected webhook settings: %+v", tc)
+	}
+}
+
+func TestHistoryConfig(t *testing.T) {
+	cfg := NewConfig()
+	if h := cfg.History; h.MaxBytes != 100<<20 || h.TTL != 7*24*time.Hour || !h.Payloads || h.MaxBodyBytes != 1<<20 || h.FeedbackMaxBytes != 10<<20 {
+		t.Errorf("Unexpected history defaults: %+v", h)
+	}
+
+	os.Setenv("COPILOT_HISTORY_MAX_BYTES", "0")
+	os.Setenv("COPILOT_HISTORY_TTL", "24h")
+	os.Setenv("COPILOT_HISTORY_PAYLOADS", "false")
+	defer func() {
+		os.Unsetenv("COPILOT_HISTORY_MAX_BYTES")
+		os.Unsetenv("COPILOT_HISTORY_TTL")
+		os.Unsetenv("COPILOT_HISTORY_PAYLOADS")
+	}()
+
+	if h := NewConfig().History; h.MaxBytes != 0 || h.TTL != 24*time.Hour || h.Payloads {
+		t.Errorf("Unexpected history settings: %+v", h)
+	}
+}
@@ -0,0 +1,357 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// reloadable are the settings, by key or section, that take effect when
+// the configuration is reloaded. Others need a restart.
+var reloadable = []string{
+	"aliases",
+	"routing",
+	"cors",
+	"limits.rate",
+	"limits.budget",
+	"server.debug",
+	"server.log.level",
+}
+
+// Reloadable reports whether the setting with the given key takes effect
+// when the configuration is reloaded.
+func Reloadable(key string) bool {
+	for _, section := range reloadable {
+		if key == section || strings.HasPrefix(key, section+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// Changed returns the keys of the settings that differ between two
+// configurations, sorted.
+func Changed(old, new *Config) []string {
+	before := settings(old)
+	after := settings(new)
+
+	var keys []string
+	for key, v := range before {
+		if !reflect.DeepEqual(v.Interface(), after[key].Interface()) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Watch calls changed whenever one of the files at paths is written,
+// created or removed, checking every interval until done is closed.
+func Watch(paths []string, interval time.Duration, done <-chan struct{}, changed func()) {
+	stat := func() []string {
+		states := make([]string, len(paths))
+		for i, path := range paths {
+			if info, err := os.Stat(path); err == nil {
+				states[i] = fmt.Sprintf("%d %d", info.ModTime().UnixNano(), info.Size())
+			}
+		}
+		return states
+	}
+
+	last := stat()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if states := stat(); !reflect.DeepEqual(states, last) {
+				last = states
+				changed()
+			}
+		}
+	}
+}
+
+// applyFile sets the settings in the configuration file at path, a YAML
+// file ending in .yaml or .yml or a JSON file ending in .json.
+func (c *Config) applyFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var tree interface{}
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		tree, err = parseYAML(data)
+	case ".json":
+		tree, err = parseJSON(data)
+	default:
+		return fmt.Errorf("%s: unsupported config file type %q (want .yaml, .yml or .json)", path, ext)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	root, ok := tree.(map[string]interface{})
+	if !ok && tree != nil {
+		return fmt.Errorf("%s: expected a mapping of settings, got %s", path, describe(tree))
+	}
+
+	var errs []error
+	bindSection(root, "", settings(c), &errs)
+	for i, err := range errs {
+		errs[i] = fmt.Errorf("%s: %w", path, err)
+	}
+	return errors.Join(errs...)
+}
+
+// parseJSON decodes a JSON configuration file, reporting syntax errors by
+// line and column.
+func parseJSON(data []byte) (interface{}, error) {
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil, nil
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var tree interface{}
+	if err := dec.Decode(&tree); err != nil {
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			line, col := position(data, syntaxErr.Offset)
+			return nil, fmt.Errorf("line %d, column %d: %v", line, col, err)
+		}
+		if err == io.ErrUnexpectedEOF {
+			return nil, errors.New("unexpected end of file")
+		}
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		line, col := position(data, dec.InputOffset())
+		return nil, fmt.Errorf("line %d, column %d: unexpected data after the settings", line, col)
+	}
+	return tree, nil
+}
+
+// position converts a byte offset in data to a line and column.
+func position(data []byte, offset int64) (line, col int) {
+	before := data[:min(int(offset), len(data))]
+	line = bytes.Count(before, []byte("\n")) + 1
+	col = len(before) - bytes.LastIndexByte(before, '\n')
+	return line, col
+}
+
+// settings indexes the fields of a configuration by their key, the path
+// of config tags leading to them.
+func settings(c *Config) map[string]reflect.Value {
+	fields := make(map[string]reflect.Value)
+	var index func(v reflect.Value, prefix string)
+	index = func(v reflect.Value, prefix string) {
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			tag := t.Field(i).Tag.Get("config")
+			if tag == "" {
+				continue
+			}
+			key := joinKey(prefix, tag)
+			if f := v.Field(i); f.Kind() == reflect.Struct {
+				index(f, key)
+			} else {
+				fields[key] = f
+			}
+		}
+	}
+	index(reflect.ValueOf(c).Elem(), "")
+	return fields
+}
+
+func joinKey(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + "." + name
+}
+
+// bindSection sets the settings in a section of the configuration file,
+// collecting an error for each setting that is unknown or has the wrong
+// type.
+func bindSection(section map[string]interface{}, prefix string, fields map[string]reflect.Value, errs *[]error) {
+	for _, name := range sortedKeys(section) {
+		key, raw := joinKey(prefix, name), section[name]
+		if f, ok := fields[key]; ok {
+			if err := setValue(f, raw, key); err != nil {
+				*errs = append(*errs, err)
+			}
+			continue
+		}
+		if !isSection(fields, key) {
+			*errs = append(*errs, fmt.Errorf("%s: unknown setting", key))
+			continue
+		}
+		switch sub := raw.(type) {
+		case map[string]interface{}:
+			bindSection(sub, key, fields, errs)
+		case nil:
+			// An empty section
+		default:
+			*errs = append(*errs, fmt.Errorf("%s: expected a section of settings, got %s", key, describe(raw)))
+		}
+	}
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// isSection reports whether key names a group of settings.
+func isSection(fields map[string]reflect.Value, key string) bool {
+	for k := range fields {
+		if strings.HasPrefix(k, key+".") {
+			return true
+		}
+	}
+	return false
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// setValue sets a setting from its value in the configuration file. null
+// resets it to empty.
+func setValue(f reflect.Value, raw interface{}, key string) error {
+	if raw == nil {
+		if f.Kind() == reflect.Map {
+			f.Set(reflect.MakeMap(f.Type()))
+		} else {
+			f.Set(reflect.Zero(f.Type()))
+		}
+		return nil
+	}
+
+	switch {
+	case f.Type() == durationType:
+		s, ok := raw.(string)
+		if n, isNumber := raw.(json.Number); isNumber && n == "0" {
+			s, ok = "0", true
+		}
+		if !ok {
+			return typeError(key, `a duration such as "30s"`, raw)
+		}
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return fmt.Errorf(`%s: invalid duration %q (want e.g. "500ms", "30s" or "5m")`, key, s)
+		}
+		f.SetInt(int64(d))
+
+	case f.Kind() == reflect.String:
+		switch v := raw.(type) {
+		case string:
+			f.SetString(v)
+		case json.Number:
+			f.SetString(v.String())
+		case bool:
+			f.SetString(strconv.FormatBool(v))
+		default:
+			return typeError(key, "a string", raw)
+		}
+
+	case f.Kind() == reflect.Bool:
+		v, ok := raw.(bool)
+		if !ok {
+			return typeError(key, "true or false", raw)
+		}
+		f.SetBool(v)
+
+	case f.Kind() == reflect.Int || f.Kind() == reflect.Int64:
+		n, ok := raw.(json.Number)
+		if !ok {
+			return typeError(key, "an integer", raw)
+		}
+		i, err := n.Int64()
+		if err != nil || f.OverflowInt(i) {
+			return typeError(key, "an integer", raw)
+		}
+		f.SetInt(i)
+
+	case f.Kind() == reflect.Float64:
+		n, ok := raw.(json.Number)
+		if !ok {
+			return typeError(key, "a number", raw)
+		}
+		v, err := n.Float64()
+		if err != nil {
+			return typeError(key, "a number", raw)
+		}
+		f.SetFloat(v)
+
+	case f.Kind() == reflect.Slice:
+		items, ok := raw.([]interface{})
+		if !ok {
+			return typeError(key, "a list", raw)
+		}
+		s := reflect.MakeSlice(f.Type(), len(items), len(items))
+		for i, item := range items {
+			if err := setValue(s.Index(i), item, fmt.Sprintf("%s[%d]", key, i)); err != nil {
+				return err
+			}
+		}
+		f.Set(s)
+
+	case f.Kind() == reflect.Map:
+		entries, ok := raw.(map[string]interface{})
+		if !ok {
+			return typeError(key, "a mapping", raw)
+		}
+		m := reflect.MakeMapWithSize(f.Type(), len(entries))
+		for _, name := range sortedKeys(entries) {
+			v := reflect.New(f.Type().Elem()).Elem()
+			if err := setValue(v, entries[name], joinKey(key, name)); err != nil {
+				return err
+			}
+			m.SetMapIndex(reflect.ValueOf(name), v)
+		}
+		f.Set(m)
+
+	default:
+		return fmt.Errorf("%s: unsupported setting type %s", key, f.Type())
+	}
+	return nil
+}
+
+func typeError(key, want string, raw interface{}) error {
+	return fmt.Errorf("%s: expected %s, got %s", key, want, describe(raw))
+}
+
+// describe renders a configuration file value for error messages.
+func describe(raw interface{}) string {
+	switch v := raw.(type) {
+	case string:
+		return strconv.Quote(v)
+	case json.Number:
+		return v.String()
+	case bool:
+		return strconv.FormatBool(v)
+	case []interface{}:
+		return "a list"
+	case map[string]interface{}:
+		return "a mapping"
+	default:
+		return fmt.Sprint(v)
+	}
+}
@@ -0,0 +1,183 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadYAML(t *testing.T) {
+	t.Setenv("COPILOT_PORT", "9100")
+
+	path := writeConfig(t, "config.yaml", `
+# Proxy settings
+server:
+  host: 127.0.0.1
+  port: 9000            # overridden by COPILOT_PORT
+  read_timeout: 10s
+aliases:
+  fast: gpt-4o-mini
+routing:
+  allowed_models: [gpt-*, "claude-*"]
+cors:
+  allowed_origins:
+    - https://app.example.com
+limits:
+  rate:
+    requests_per_minute: 60
+upstream:
+  headers: {X-Team: platform}
+`)
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+
+	if cfg.File != path {
+		t.Errorf("File = %q, want %q", cfg.File, path)
+	}
+	if cfg.Host != "127.0.0.1" {
+		t.Errorf("Host = %q, want the file's 127.0.0.1", cfg.Host)
+	}
+	if cfg.Port != 9100 {
+		t.Errorf("Port = %d, want the environment's 9100", cfg.Port)
+	}
+	if cfg.Server.ReadTimeout != 10*time.Second || cfg.Server.WriteTimeout != 120*time.Second {
+		t.Errorf("Server = %+v, want read timeout from the file and default write timeout", cfg.Server)
+	}
+	if cfg.Aliases["fast"] != "gpt-4o-mini" {
+		t.Errorf("Aliases = %v", cfg.Aliases)
+	}
+	if got := strings.Join(cfg.Routing.AllowedModels, ","); got != "gpt-*,claude-*" {
+		t.Errorf("AllowedModels = %q", got)
+	}
+	if len(cfg.Routing.HiddenModels) != 2 {
+		t.Errorf("HiddenModels = %v, want the defaults", cfg.Routing.HiddenModels)
+	}
+	if got := strings.Join(cfg.CORS.AllowedOrigins, ","); got != "https://app.example.com" {
+		t.Errorf("AllowedOrigins = %q", got)
+	}
+	if cfg.RateLimit.RequestsPerMinute != 60 {
+		t.Errorf("RequestsPerMinute = %d, want 60", cfg.RateLimit.RequestsPerMinute)
+	}
+	if cfg.Upstream.Headers["X-Team"] != "platform" {
+		t.Errorf("Upstream.Headers = %v", cfg.Upstream.Headers)
+	}
+}
+
+func TestLoadJSON(t *testing.T) {
+	path := writeConfig(t, "config.json", `{
+  "server": {"log": {"level": "warn"}},
+  "limits": {"budget": {"daily_tokens": 1000, "alert_thresholds": [50, 100]}}
+}`)
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if cfg.Log.Level != "warn" {
+		t.Errorf("Log.Level = %q, want warn", cfg.Log.Level)
+	}
+	if cfg.Budget.DailyTokens != 1000 || len(cfg.Budget.AlertThresholds) != 2 {
+		t.Errorf("Budget = %+v", cfg.Budget)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name, file, content string
+		want                []string
+	}{
+		{
+			name: "unknown setting",
+			file: "config.yaml",
+			content: `server:
+  prot: 9000
+`,
+			want: []string{"server.prot: unknown setting"},
+		},
+		{
+			name: "wrong types",
+			file: "config.yaml",
+			content: `server:
+  port: abc
+  read_timeout: 30
+`,
+			want: []string{`server.port: expected an integer, got "abc"`, `server.read_timeout: expected a duration such as "30s", got 30`},
+		},
+		{
+			name:    "yaml syntax",
+			file:    "config.yaml",
+			content: "server:\n\tport: 1\n",
+			want:    []string{"line 2:"},
+		},
+		{
+			name:    "json syntax",
+			file:    "config.json",
+			content: "{\n  \"server\": {\n    \"port\": 1,\n  }\n}",
+			want:    []string{"line 4, column"},
+		},
+		{
+			name:    "invalid values",
+			file:    "config.yaml",
+			content: "server:\n  port: 70000\nlimits:\n  quota:\n    soft_action: stop\n",
+			want:    []string{"server.port: must be between 1 and 65535, got 70000", `limits.quota.soft_action: must be "warn", "block", got "stop"`},
+		},
+		{
+			name:    "file type",
+			file:    "config.toml",
+			content: "",
+			want:    []string{`unsupported config file type ".toml"`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Load(writeConfig(t, tt.file, tt.content))
+			if err == nil {
+				t.Fatal("Load() succeeded, want an error")
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error %q does not contain %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestChanged(t *testing.T) {
+	old := Default()
+	next := Default()
+	next.Port = 9000
+	next.RateLimit.RequestsPerMinute = 10
+	next.Aliases["fast"] = "gpt-4o-mini"
+
+	got := strings.Join(Changed(old, next), ",")
+	if want := "aliases,limits.rate.requests_per_minute,server.port"; got != want {
+		t.Errorf("Changed() = %q, want %q", got, want)
+	}
+
+	for key, want := range map[string]bool{
+		"aliases":                         true,
+		"limits.rate.requests_per_minute": true,
+		"server.log.level":                true,
+		"server.port":                     false,
+		"limits.ratelimit":                false,
+		"telemetry.langfuse.public_key":   false,
+	} {
+		if got := Reloadable(key); got != want {
+			t.Errorf("Reloadable(%q) = %v, want %v", key, got, want)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Validate checks the configuration and reports every invalid setting by
+// its key in the configuration file.
+func (c *Config) Validate() error {
+	v := &validator{}
+
+	// Server
+	v.check(c.Port >= 1 && c.Port <= 65535, "server.port", "must be between 1 and 65535, got %d", c.Port)
+	if c.AdminAddr != "" {
+		_, _, err := net.SplitHostPort(c.AdminAddr)
+		v.check(err == nil, "server.admin_addr", "must be host:port, got %q", c.AdminAddr)
+	}
+	v.check(c.DataDir != "", "server.data_dir", "must not be empty")
+	v.duration("server.read_timeout", c.Server.ReadTimeout, false)
+	v.duration("server.write_timeout", c.Server.WriteTimeout, false)
+	v.duration("server.idle_timeout", c.Server.IdleTimeout, false)
+	v.oneOf("server.log.level", c.Log.Level, "debug", "info", "warn", "error")
+	v.oneOf("server.log.format", strings.ToLower(c.Log.Format), "", "text", "json")
+
+	// Auth
+	v.check(c.AdminKey == "" || c.AdminKey != c.APIKey, "auth.admin_key", "must differ from auth.api_key")
+
+	// Aliases and routing
+	for _, name := range sortedNames(c.Aliases) {
+		v.check(name != "", "aliases", "alias names must not be empty")
+		v.check(c.Aliases[name] != "", "aliases."+name, "must name a model")
+	}
+	v.patterns("routing.hidden_models", c.Routing.HiddenModels)
+	v.patterns("routing.allowed_models", c.Routing.AllowedModels)
+
+	// CORS
+	for i, origin := range c.CORS.AllowedOrigins {
+		u, err := url.Parse(origin)
+		ok := origin == "*" || err == nil && u.Scheme != "" && u.Host != "" && u.Path == ""
+		v.check(ok, fmt.Sprintf("cors.allowed_origins[%d]", i), `must be "*" or an origin such as https://app.example.com, got %q`, origin)
+	}
+	v.duration("cors.max_age", c.CORS.MaxAge, false)
+
+	// Upstream
+	v.oneOf("upstream.initiator", c.Upstream.Initiator, "", "user", "agent")
+	for _, key := range sortedNames(c.Upstream.KeyInitiators) {
+		v.oneOf("upstream.key_initiators."+key, c.Upstream.KeyInitiators[key], "user", "agent")
+	}
+	v.nonNegative("upstream.max_retries", int64(c.Upstream.MaxRetries))
+	v.duration("upstream.timeout", c.Upstream.Timeout, true)
+	v.duration("upstream.models_cache_ttl", c.Upstream.ModelsCacheTTL, true)
+
+	// Limits
+	v.nonNegative("limits.rate.requests_per_minute", int64(c.RateLimit.RequestsPerMinute))
+	v.nonNegative("limits.rate.tokens_per_minute", int64(c.RateLimit.TokensPerMinute))
+	v.nonNegative("limits.rate.max_in_flight_per_key", int64(c.RateLimit.MaxInFlightPerKey))
+	v.nonNegative("limits.rate.max_in_flight_per_model", int64(c.RateLimit.MaxInFlightPerModel))
+	v.nonNegative("limits.rate.queue_size", int64(c.RateLimit.QueueSize))
+	v.duration("limits.rate.queue_timeout", c.RateLimit.QueueTimeout, false)
+	v.nonNegative("limits.budget.daily_tokens", c.Budget.DailyTokens)
+	v.nonNegative("limits.budget.monthly_tokens", c.Budget.MonthlyTokens)
+	for i, threshold := range c.Budget.AlertThresholds {
+		v.check(threshold > 0, fmt.Sprintf("limits.budget.alert_thresholds[%d]", i), "must be a positive percentage, got %d", threshold)
+	}
+	v.url("limits.budget.alert_webhook", c.Budget.AlertWebhook)
+	v.positive("limits.batch.concurrency", int64(c.Batch.Concurrency))
+	v.nonNegative("limits.batch.requests_per_minute", int64(c.Batch.RequestsPerMinute))
+	v.positive("limits.fetch.max_bytes", c.Fetch.MaxBytes)
+	v.duration("limits.fetch.timeout", c.Fetch.Timeout, true)
+	for _, model := range sortedNames(c.Usage.Multipliers) {
+		v.check(c.Usage.Multipliers[model] >= 0, "limits.quota.multipliers."+model, "must not be negative, got %g", c.Usage.Multipliers[model])
+	}
+	v.check(c.Usage.DefaultMultiplier >= 0, "limits.quota.default_multiplier", "must not be negative, got %g", c.Usage.DefaultMultiplier)
+	v.duration("limits.quota.poll_interval", c.Usage.PollInterval, false)
+	v.check(c.Usage.SoftLimit >= 0, "limits.quota.soft_limit", "must not be negative, got %g", c.Usage.SoftLimit)
+	v.oneOf("limits.quota.soft_action", c.Usage.SoftAction, "warn", "block")
+
+	// Telemetry
+	lf := c.Langfuse
+	v.url("telemetry.langfuse.host", lf.Host)
+	v.positive("telemetry.langfuse.batch_size", int64(lf.BatchSize))
+	v.duration("telemetry.langfuse.flush_interval", lf.FlushInterval, true)
+	v.nonNegative("telemetry.langfuse.max_retries", int64(lf.MaxRetries))
+	v.positive("telemetry.langfuse.spool_max_bytes", lf.SpoolMaxBytes)
+	v.percent("telemetry.langfuse.sampling.percent", lf.Sampling.Percent)
+	for name, percents := range map[string]map[string]float64{"endpoint_percent": lf.Sampling.EndpointPercent, "key_percent": lf.Sampling.KeyPercent} {
+		for _, k := range sortedNames(percents) {
+			v.percent("telemetry.langfuse.sampling."+name+"."+k, percents[k])
+		}
+	}
+	v.oneOf("telemetry.langfuse.sampling.capture", lf.Sampling.Capture, "full", "metadata")
+	for name, levels := range map[string]map[string]string{"endpoint_capture": lf.Sampling.EndpointCapture, "key_capture": lf.Sampling.KeyCapture} {
+		for _, k := range sortedNames(levels) {
+			v.oneOf("telemetry.langfuse.sampling."+name+"."+k, levels[k], "full", "metadata")
+		}
+	}
+	v.duration("telemetry.langfuse.sampling.keep_slow", lf.Sampling.KeepSlow, false)
+	v.nonNegative("telemetry.langfuse.sampling.keep_tokens", int64(lf.Sampling.KeepTokens))
+
+	v.url("telemetry.tracing.endpoint", c.Tracing.Endpoint)
+	v.positive("telemetry.tracing.batch_size", int64(c.Tracing.BatchSize))
+	v.duration("telemetry.tracing.flush_interval", c.Tracing.FlushInterval, true)
+
+	v.oneOf("telemetry.redact.mode", c.Redact.Mode, "redact", "hash", "truncate")
+	v.positive("telemetry.redact.truncate_length", int64(c.Redact.TruncateLength))
+	for i, pattern := range c.Redact.Patterns {
+		_, err := regexp.Compile(pattern)
+		v.check(err == nil, fmt.Sprintf("telemetry.redact.patterns[%d]", i), "%v", err)
+	}
+
+	v.nonNegative("telemetry.sinks.file_max_bytes", c.Telemetry.FileMaxBytes)
+	v.nonNegative("telemetry.sinks.file_max_files", int64(c.Telemetry.FileMaxFiles))
+	v.url("telemetry.sinks.webhook_url", c.Telemetry.WebhookURL)
+	v.positive("telemetry.sinks.webhook_batch_size", int64(c.Telemetry.WebhookBatchSize))
+	v.duration("telemetry.sinks.webhook_flush_interval", c.Telemetry.WebhookFlushInterval, true)
+	v.nonNegative("telemetry.sinks.webhook_max_retries", int64(c.Telemetry.WebhookMaxRetries))
+
+	v.nonNegative("telemetry.history.max_bytes", c.History.MaxBytes)
+	v.duration("telemetry.history.ttl", c.History.TTL, false)
+	v.positive("telemetry.history.max_body_bytes", c.History.MaxBodyBytes)
+	v.positive("telemetry.history.feedback_max_bytes", c.History.FeedbackMaxBytes)
+
+	if len(v.errs) == 0 {
+		return nil
+	}
+	sort.SliceStable(v.errs, func(i, j int) bool { return v.errs[i].Error() < v.errs[j].Error() })
+	return errors.Join(v.errs...)
+}
+
+// validator collects the invalid settings of a configuration.
+type validator struct {
+	errs []error
+}
+
+func (v *validator) check(ok bool, key, format string, args ...interface{}) {
+	if !ok {
+		v.errs = append(v.errs, fmt.Errorf("%s: %s", key, fmt.Sprintf(format, args...)))
+	}
+}
+
+func (v *validator) positive(key string, n int64) {
+	v.check(n > 0, key, "must be positive, got %d", n)
+}
+
+func (v *validator) nonNegative(key string, n int64) {
+	v.check(n >= 0, key, "must not be negative, got %d", n)
+}
+
+func (v *validator) percent(key string, p float64) {
+	v.check(p >= 0 && p <= 100, key, "must be a percentage between 0 and 100, got %g", p)
+}
+
+// duration checks a duration is not negative, and when required that it
+// is not zero.
+func (v *validator) duration(key string, d time.Duration, required bool) {
+	if required {
+		v.check(d > 0, key, "must be positive, got %s", d)
+	} else {
+		v.check(d >= 0, key, "must not be negative, got %s", d)
+	}
+}
+
+func (v *validator) oneOf(key, value string, allowed ...string) {
+	for _, a := range allowed {
+		if value == a {
+			return
+		}
+	}
+	var quoted []string
+	for _, a := range allowed {
+		if a != "" {
+			quoted = append(quoted, fmt.Sprintf("%q", a))
+		}
+	}
+	v.check(false, key, "must be %s, got %q", strings.Join(quoted, ", "), value)
+}
+
+// url checks an optional setting is an http or https URL.
+func (v *validator) url(key, value string) {
+	if value == "" {
+		return
+	}
+	u, err := url.Parse(value)
+	v.check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", key, "must be an http or https URL, got %q", value)
+}
+
+// patterns checks model name patterns are valid path.Match patterns.
+func (v *validator) patterns(key string, patterns []string) {
+	for i, pattern := range patterns {
+		_, err := path.Match(pattern, "")
+		v.check(pattern != "" && err == nil, fmt.Sprintf("%s[%d]", key, i), "invalid pattern %q", pattern)
+	}
+}
+
+func sortedNames[V any](m map[string]V) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
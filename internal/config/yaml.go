@@ -0,0 +1,414 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// parseYAML parses the subset of YAML configuration files need: block
+// mappings and sequences, one-line flow sequences and mappings, plain and
+// quoted scalars, and comments. Anchors, tags, multi-line scalars and
+// multiple documents are rejected. Numbers are returned as json.Number,
+// as JSON files are decoded, so both formats bind the same way.
+func parseYAML(data []byte) (interface{}, error) {
+	p := &yamlParser{}
+lines:
+	for i, raw := range strings.Split(strings.TrimPrefix(string(data), "\ufeff"), "\n") {
+		raw = strings.TrimRight(raw, " \t\r")
+		text := strings.TrimLeft(raw, " ")
+		line := yamlLine{num: i + 1, indent: len(raw) - len(text), text: text}
+		switch {
+		case text == "" || text[0] == '#':
+			continue
+		case text[0] == '\t':
+			return nil, line.errorf("tabs are not allowed in indentation")
+		case raw == "---" && len(p.lines) == 0:
+			continue
+		case raw == "---":
+			return nil, line.errorf("multiple documents are not supported")
+		case raw == "...":
+			break lines
+		}
+		p.lines = append(p.lines, line)
+	}
+
+	if len(p.lines) == 0 {
+		return nil, nil
+	}
+	root := p.lines[0]
+	value, err := p.parseBlock(root.indent)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.lines) {
+		return nil, p.lines[p.pos].errorf("unexpected indentation")
+	}
+	return value, nil
+}
+
+// yamlLine is a line holding content, without its indentation.
+type yamlLine struct {
+	num    int
+	indent int
+	text   string
+}
+
+func (l yamlLine) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("line %d: %s", l.num, fmt.Sprintf(format, args...))
+}
+
+type yamlParser struct {
+	lines []yamlLine
+	pos   int
+}
+
+// parseBlock parses the mapping or sequence starting at the current line,
+// indented by indent.
+func (p *yamlParser) parseBlock(indent int) (interface{}, error) {
+	if isSequenceItem(p.lines[p.pos].text) {
+		return p.parseSequence(indent)
+	}
+	return p.parseMapping(indent)
+}
+
+// parseNested parses the block nested under the line before the current
+// one, or returns nil when there is none. A sequence may be nested at the
+// parent's indentation.
+func (p *yamlParser) parseNested(indent int) (interface{}, error) {
+	if p.pos == len(p.lines) {
+		return nil, nil
+	}
+	next := p.lines[p.pos]
+	if next.indent > indent || next.indent == indent && isSequenceItem(next.text) {
+		return p.parseBlock(next.indent)
+	}
+	return nil, nil
+}
+
+func (p *yamlParser) parseMapping(indent int) (interface{}, error) {
+	m := make(map[string]interface{})
+	for p.pos < len(p.lines) {
+		line := p.lines[p.pos]
+		if line.indent < indent {
+			break
+		}
+		if line.indent > indent {
+			return nil, line.errorf("unexpected indentation")
+		}
+		if isSequenceItem(line.text) {
+			return nil, line.errorf("expected \"key: value\", found a list item")
+		}
+
+		key, rest, err := splitKey(line)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := m[key]; ok {
+			return nil, line.errorf("duplicate key %q", key)
+		}
+		p.pos++
+
+		var value interface{}
+		if rest == "" {
+			value, err = p.parseNested(indent)
+		} else {
+			value, err = parseScalar(rest, line)
+		}
+		if err != nil {
+			return nil, err
+		}
+		m[key] = value
+	}
+	return m, nil
+}
+
+func (p *yamlParser) parseSequence(indent int) (interface{}, error) {
+	seq := []interface{}{}
+	for p.pos < len(p.lines) {
+		line := p.lines[p.pos]
+		if line.indent < indent || line.indent == indent && !isSequenceItem(line.text) {
+			break
+		}
+		if line.indent > indent {
+			return nil, line.errorf("unexpected indentation")
+		}
+		p.pos++
+
+		item := strings.TrimSpace(strings.TrimPrefix(line.text, "-"))
+		var value interface{}
+		var err error
+		switch {
+		case item == "" || item[0] == '#':
+			value, err = p.parseNested(indent)
+		case colonIndex(item) >= 0:
+			err = line.errorf("mappings inside lists are not supported")
+		default:
+			value, err = parseScalar(item, line)
+		}
+		if err != nil {
+			return nil, err
+		}
+		seq = append(seq, value)
+	}
+	return seq, nil
+}
+
+func isSequenceItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// colonIndex returns the index of the colon ending the key of a plain
+// "key: value" line, or -1.
+func colonIndex(text string) int {
+	if text[0] == '"' || text[0] == '\'' || text[0] == '[' || text[0] == '{' {
+		return -1
+	}
+	for i := 0; i < len(text); i++ {
+		switch {
+		case text[i] == '#' && i > 0 && text[i-1] == ' ':
+			return -1
+		case text[i] == ':' && (i+1 == len(text) || text[i+1] == ' '):
+			return i
+		}
+	}
+	return -1
+}
+
+// splitKey splits a mapping line into its key and the rest of the line,
+// which is empty when the value is a nested block.
+func splitKey(line yamlLine) (key, rest string, err error) {
+	text := line.text
+	if text[0] == '"' || text[0] == '\'' {
+		key, n, err := parseQuoted(text)
+		if err != nil {
+			return "", "", line.errorf("%v", err)
+		}
+		if !strings.HasPrefix(text[n:], ":") {
+			return "", "", line.errorf("expected ':' after key %q", key)
+		}
+		text = text[n+1:]
+		if text != "" && text[0] != ' ' {
+			return "", "", line.errorf("expected a space after ':'")
+		}
+		rest = strings.TrimSpace(text)
+	} else {
+		i := colonIndex(text)
+		if i < 0 {
+			return "", "", line.errorf("expected \"key: value\", found %q", text)
+		}
+		key = strings.TrimSpace(text[:i])
+		rest = strings.TrimSpace(text[i+1:])
+	}
+	if key == "" {
+		return "", "", line.errorf("empty key")
+	}
+	if strings.HasPrefix(rest, "#") {
+		rest = ""
+	}
+	return key, rest, nil
+}
+
+// parseScalar parses the value on a line: a quoted, plain or flow scalar.
+func parseScalar(text string, line yamlLine) (interface{}, error) {
+	switch text[0] {
+	case '|', '>':
+		return nil, line.errorf("multi-line strings are not supported; use a quoted string")
+	case '&', '*', '!':
+		return nil, line.errorf("anchors, aliases and tags are not supported")
+	}
+
+	f := &flowParser{s: text}
+	value, err := f.value("")
+	if err != nil {
+		return nil, line.errorf("%v", err)
+	}
+	f.skipSpace()
+	if f.i < len(f.s) && f.s[f.i] != '#' {
+		return nil, line.errorf("unexpected %q after value", f.s[f.i:])
+	}
+	return value, nil
+}
+
+// flowParser parses a scalar, or a flow sequence or mapping, on one line.
+type flowParser struct {
+	s string
+	i int
+}
+
+func (f *flowParser) skipSpace() {
+	for f.i < len(f.s) && f.s[f.i] == ' ' {
+		f.i++
+	}
+}
+
+// value parses a value; stop holds the characters ending a plain scalar
+// inside a flow collection.
+func (f *flowParser) value(stop string) (interface{}, error) {
+	f.skipSpace()
+	if f.i == len(f.s) {
+		return nil, nil
+	}
+	switch f.s[f.i] {
+	case '[':
+		return f.sequence()
+	case '{':
+		return f.mapping()
+	case '"', '\'':
+		s, n, err := parseQuoted(f.s[f.i:])
+		f.i += n
+		return s, err
+	}
+
+	start := f.i
+	for f.i < len(f.s) && !strings.ContainsRune(stop, rune(f.s[f.i])) {
+		if f.s[f.i] == '#' && f.i > start && f.s[f.i-1] == ' ' {
+			break
+		}
+		f.i++
+	}
+	return plainValue(strings.TrimSpace(f.s[start:f.i])), nil
+}
+
+func (f *flowParser) sequence() (interface{}, error) {
+	f.i++
+	seq := []interface{}{}
+	for {
+		f.skipSpace()
+		if f.i < len(f.s) && f.s[f.i] == ']' {
+			f.i++
+			return seq, nil
+		}
+		value, err := f.value(",]")
+		if err != nil {
+			return nil, err
+		}
+		seq = append(seq, value)
+		f.skipSpace()
+		if f.i == len(f.s) {
+			return nil, errors.New("unterminated list, expected ']'")
+		}
+		switch f.s[f.i] {
+		case ',':
+			f.i++
+		case ']':
+		default:
+			return nil, fmt.Errorf("expected ',' or ']' in list, found %q", f.s[f.i])
+		}
+	}
+}
+
+func (f *flowParser) mapping() (interface{}, error) {
+	f.i++
+	m := make(map[string]interface{})
+	for {
+		f.skipSpace()
+		if f.i < len(f.s) && f.s[f.i] == '}' {
+			f.i++
+			return m, nil
+		}
+		key, err := f.value(":,}")
+		if err != nil {
+			return nil, err
+		}
+		name, ok := key.(string)
+		if !ok {
+			name = fmt.Sprint(key)
+		}
+		f.skipSpace()
+		if f.i == len(f.s) || f.s[f.i] != ':' {
+			return nil, fmt.Errorf("expected ':' after key %q", name)
+		}
+		f.i++
+		value, err := f.value(",}")
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := m[name]; ok {
+			return nil, fmt.Errorf("duplicate key %q", name)
+		}
+		m[name] = value
+		f.skipSpace()
+		if f.i == len(f.s) {
+			return nil, errors.New("unterminated mapping, expected '}'")
+		}
+		switch f.s[f.i] {
+		case ',':
+			f.i++
+		case '}':
+		default:
+			return nil, fmt.Errorf("expected ',' or '}' in mapping, found %q", f.s[f.i])
+		}
+	}
+}
+
+// parseQuoted parses the single- or double-quoted string s starts with and
+// returns it with the number of bytes it took up.
+func parseQuoted(s string) (string, int, error) {
+	quote := s[0]
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == quote && quote == '\'' && i+1 < len(s) && s[i+1] == '\'':
+			b.WriteByte('\'')
+			i++
+		case c == quote:
+			return b.String(), i + 1, nil
+		case c == '\\' && quote == '"':
+			if i+1 == len(s) {
+				return "", 0, errors.New("unterminated quoted string")
+			}
+			i++
+			switch e := s[i]; e {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			case 'r':
+				b.WriteByte('\r')
+			case '0':
+				b.WriteByte(0)
+			case '"', '\\', '/':
+				b.WriteByte(e)
+			case 'u':
+				if i+4 >= len(s) {
+					return "", 0, errors.New(`invalid \u escape`)
+				}
+				r, err := strconv.ParseUint(s[i+1:i+5], 16, 32)
+				if err != nil {
+					return "", 0, fmt.Errorf(`invalid \u escape %q`, s[i-1:i+5])
+				}
+				b.WriteRune(rune(r))
+				i += 4
+			default:
+				return "", 0, fmt.Errorf(`unsupported escape \%c`, e)
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", 0, errors.New("unterminated quoted string")
+}
+
+var yamlNumber = regexp.MustCompile(`^[-+]?(\d+(\.\d*)?|\.\d+)([eE][-+]?\d+)?$`)
+
+// plainValue types an unquoted scalar: null, a boolean, a number or a
+// string.
+func plainValue(s string) interface{} {
+	switch s {
+	case "", "~", "null", "Null", "NULL":
+		return nil
+	case "true", "True", "TRUE":
+		return true
+	case "false", "False", "FALSE":
+		return false
+	}
+	if yamlNumber.MatchString(s) {
+		return json.Number(strings.TrimPrefix(s, "+"))
+	}
+	return s
+}
@@ -1,21 +1,45 @@
 package converter
 
 import (
+	"encoding/base64"
 	"encoding/json"
+	"fmt"
+	"strings"
 
 	"github.com/google/uuid"
+	"github.com/rahulvramesh/gh-proxy-local/internal/pdf"
 )
 
+// AnthropicOptions describes the target model of an Anthropic conversion.
+type AnthropicOptions struct {
+	// NoVision is set when the target model lacks the Vision capability.
+	// Images returned by tools are then replaced by a text note.
+	NoVision bool
+}
+
 // ConvertAnthropicToCopilotMessages converts Anthropic message format to Copilot format.
-func ConvertAnthropicToCopilotMessages(messages []map[string]interface{}, system string) []map[string]interface{} {
+//
+// OpenAI tool messages can only hold text, so images returned by tools are
+// carried into the user message that follows the tool messages, in the
+// order they appeared among the turn's other content.
+//
+// system is a string or a list of Anthropic text blocks; block structure and
+// cache_control markers are kept, the latter as Copilot cache-control fields.
+//
+// A trailing assistant prefill is replaced by an instruction to begin the
+// reply with the prefill text; callers strip it from the output with a
+// PrefillStripper so the reply continues after the prefill.
+func ConvertAnthropicToCopilotMessages(messages []map[string]interface{}, system interface{}, opts AnthropicOptions) []map[string]interface{} {
 	result := make([]map[string]interface{}, 0, len(messages)+1)
 
+	prefill := AssistantPrefill(messages)
+	if prefill != "" {
+		messages = messages[:len(messages)-1]
+	}
+
 	// Add system message if provided
-	if system != "" {
-		result = append(result, map[string]interface{}{
-			"role":    "system",
-			"content": system,
-		})
+	if systemMsg := systemMessage(system); systemMsg != nil {
+		result = append(result, systemMsg)
 	}
 
 	for _, msg := range messages {
@@ -33,6 +57,7 @@ func ConvertAnthropicToCopilotMessages(messages []map[string]interface{}, system
 			openaiContent := make([]map[string]interface{}, 0)
 			toolCalls := make([]map[string]interface{}, 0)
 			toolResults := make([]map[string]interface{}, 0)
+			var toolCallsCache map[string]interface{}
 
 			for _, block := range c {
 				blockMap, ok := block.(map[string]interface{})
@@ -41,6 +66,7 @@ func ConvertAnthropicToCopilotMessages(messages []map[string]interface{}, system
 				}
 
 				blockType, _ := blockMap["type"].(string)
+				partsBefore := len(openaiContent)
 
 				switch blockType {
 				case "text":
@@ -50,21 +76,16 @@ func ConvertAnthropicToCopilotMessages(messages []map[string]interface{}, system
 						"text": text,
 					})
 				case "image":
-					source, _ := blockMap["source"].(map[string]interface{})
-					if sourceType, _ := source["type"].(string); sourceType == "base64" {
-						mediaType, _ := source["media_type"].(string)
-						if mediaType == "" {
-							mediaType = "image/png"
-						}
-						data, _ := source["data"].(string)
-						dataURL := "data:" + mediaType + ";base64," + data
-						openaiContent = append(openaiContent, map[string]interface{}{
-							"type": "image_url",
-							"image_url": map[string]interface{}{
-								"url": dataURL,
-							},
-						})
+					if part := imagePart(blockMap); part != nil {
+						openaiContent = append(openaiContent, part)
 					}
+				case "document":
+					openaiContent = append(openaiContent, documentParts(blockMap)...)
+				case "search_result":
+					openaiContent = append(openaiContent, map[string]interface{}{
+						"type": "text",
+						"text": searchResultText(blockMap),
+					})
 				case "tool_use":
 					id, _ := blockMap["id"].(string)
 					if id == "" {
@@ -84,75 +105,71 @@ func ConvertAnthropicToCopilotMessages(messages []map[string]interface{}, system
 					})
 				case "tool_result":
 					toolUseID, _ := blockMap["tool_use_id"].(string)
-					resultContent := blockMap["content"]
-					contentStr := extractTextContent(resultContent)
+					contentStr, images := toolResultContent(blockMap, opts)
 
 					toolResults = append(toolResults, map[string]interface{}{
 						"tool_call_id": toolUseID,
 						"content":      contentStr,
 					})
+					if len(images) > 0 {
+						openaiContent = append(openaiContent, map[string]interface{}{
+							"type": "text",
+							"text": fmt.Sprintf("Images returned by tool call %s:", toolUseID),
+						})
+						openaiContent = append(openaiContent, images...)
+					}
+				}
+
+				// Carry cache breakpoints over as Copilot cache-control fields.
+				if cc := copilotCacheControl(blockMap); cc != nil {
+					switch {
+					case blockType == "tool_use":
+						toolCallsCache = cc
+					case blockType == "tool_result":
+						toolResults[len(toolResults)-1]["copilot_cache_control"] = cc
+					case len(openaiContent) > partsBefore:
+						openaiContent[len(openaiContent)-1]["copilot_cache_control"] = cc
+					}
 				}
 			}
 
 			// Build the message(s)
 			if role == "assistant" {
-				converted := map[string]interface{}{
-					"role": "assistant",
-				}
-
-				if len(openaiContent) > 0 {
-					if len(openaiContent) == 1 && openaiContent[0]["type"] == "text" {
-						converted["content"] = openaiContent[0]["text"]
-					} else {
-						converted["content"] = openaiContent
-					}
-				} else {
+				converted := contentMessage("assistant", openaiContent)
+				if len(openaiContent) == 0 {
 					converted["content"] = nil
 				}
 
 				if len(toolCalls) > 0 {
 					converted["tool_calls"] = toolCalls
+					if toolCallsCache != nil {
+						converted["copilot_cache_control"] = toolCallsCache
+					}
 				}
 
 				result = append(result, converted)
 			} else if role == "user" {
 				// Handle tool results
 				for _, tr := range toolResults {
-					result = append(result, map[string]interface{}{
+					toolMsg := map[string]interface{}{
 						"role":         "tool",
 						"tool_call_id": tr["tool_call_id"],
 						"content":      tr["content"],
-					})
+					}
+					if cc, ok := tr["copilot_cache_control"]; ok {
+						toolMsg["copilot_cache_control"] = cc
+					}
+					result = append(result, toolMsg)
 				}
 
 				// Add regular content if present
 				if len(openaiContent) > 0 {
-					if len(openaiContent) == 1 && openaiContent[0]["type"] == "text" {
-						result = append(result, map[string]interface{}{
-							"role":    "user",
-							"content": openaiContent[0]["text"],
-						})
-					} else {
-						result = append(result, map[string]interface{}{
-							"role":    "user",
-							"content": openaiContent,
-						})
-					}
+					result = append(result, contentMessage("user", openaiContent))
 				}
 			} else {
 				// Other roles
 				if len(openaiContent) > 0 {
-					if len(openaiContent) == 1 && openaiContent[0]["type"] == "text" {
-						result = append(result, map[string]interface{}{
-							"role":    role,
-							"content": openaiContent[0]["text"],
-						})
-					} else {
-						result = append(result, map[string]interface{}{
-							"role":    role,
-							"content": openaiContent,
-						})
-					}
+					result = append(result, contentMessage(role, openaiContent))
 				}
 			}
 		default:
@@ -166,9 +183,381 @@ func ConvertAnthropicToCopilotMessages(messages []map[string]interface{}, system
 		}
 	}
 
+	if prefill != "" {
+		result = append(result, map[string]interface{}{
+			"role":    "user",
+			"content": fmt.Sprintf(prefillInstruction, prefill),
+		})
+	}
+
 	return result
 }
 
+// contentMessage builds a message from content parts, collapsing a lone
+// text part to a plain string. A cache marker on a collapsed part moves to
+// the message.
+func contentMessage(role string, parts []map[string]interface{}) map[string]interface{} {
+	msg := map[string]interface{}{"role": role}
+	if len(parts) == 1 && parts[0]["type"] == "text" {
+		msg["content"] = parts[0]["text"]
+		if cc, ok := parts[0]["copilot_cache_control"]; ok {
+			msg["copilot_cache_control"] = cc
+		}
+		return msg
+	}
+	msg["content"] = parts
+	return msg
+}
+
+// systemMessage converts the Anthropic system parameter to a system
+// message, or nil if it is empty. Block lists keep one text part per block.
+func systemMessage(system interface{}) map[string]interface{} {
+	switch s := system.(type) {
+	case string:
+		if s == "" {
+			return nil
+		}
+		return map[string]interface{}{"role": "system", "content": s}
+	case []interface{}:
+		parts := make([]map[string]interface{}, 0, len(s))
+		for _, block := range s {
+			switch b := block.(type) {
+			case string:
+				parts = append(parts, map[string]interface{}{"type": "text", "text": b})
+			case map[string]interface{}:
+				if b["type"] != "text" {
+					continue
+				}
+				text, _ := b["text"].(string)
+				part := map[string]interface{}{"type": "text", "text": text}
+				if cc := copilotCacheControl(b); cc != nil {
+					part["copilot_cache_control"] = cc
+				}
+				parts = append(parts, part)
+			}
+		}
+		if len(parts) == 0 {
+			return nil
+		}
+		return contentMessage("system", parts)
+	}
+	return nil
+}
+
+// copilotCacheControl translates an Anthropic cache_control marker into
+// Copilot's cache-control field, or returns nil if the block has none.
+func copilotCacheControl(block map[string]interface{}) map[string]interface{} {
+	cc, _ := block["cache_control"].(map[string]interface{})
+	if cc["type"] != "ephemeral" {
+		return nil
+	}
+	return map[string]interface{}{"type": "ephemeral"}
+}
+
+// prefillInstruction asks the model to reproduce an assistant prefill at the
+// start of its reply.
+const prefillInstruction = "Begin your reply with exactly the text between the markers below, " +
+	"character for character, then continue it seamlessly. Do not write anything before it " +
+	"and do not include the markers.\n<<<PREFILL\n%s\nPREFILL>>>"
+
+// AssistantPrefill returns the text of a trailing assistant message, which
+// Anthropic clients use to pre-fill the start of the reply. It returns ""
+// when the conversation does not end with a text-only assistant turn.
+func AssistantPrefill(messages []map[string]interface{}) string {
+	if len(messages) == 0 {
+		return ""
+	}
+	last := messages[len(messages)-1]
+	if role, _ := last["role"].(string); role != "assistant" {
+		return ""
+	}
+
+	switch c := last["content"].(type) {
+	case string:
+		return strings.TrimRight(c, " \t\r\n")
+	case []interface{}:
+		var b strings.Builder
+		for _, block := range c {
+			blockMap, ok := block.(map[string]interface{})
+			if !ok || blockMap["type"] != "text" {
+				return ""
+			}
+			text, _ := blockMap["text"].(string)
+			b.WriteString(text)
+		}
+		return strings.TrimRight(b.String(), " \t\r\n")
+	}
+	return ""
+}
+
+// PrefillStripper removes an echoed assistant prefill from the start of the
+// model's output, buffering streamed text until it can tell whether the
+// output begins with the prefill.
+type PrefillStripper struct {
+	prefill string
+	buf     strings.Builder
+	done    bool
+}
+
+// NewPrefillStripper creates a stripper for prefill. An empty prefill
+// passes all text through.
+func NewPrefillStripper(prefill string) *PrefillStripper {
+	return &PrefillStripper{prefill: prefill, done: prefill == ""}
+}
+
+// Write consumes a chunk of output and returns the text ready to emit.
+func (p *PrefillStripper) Write(text string) string {
+	if p.done {
+		return text
+	}
+	p.buf.WriteString(text)
+
+	buffered := p.buf.String()
+	candidate := strings.TrimLeft(buffered, " \t\r\n")
+	if len(candidate) < len(p.prefill) {
+		if strings.HasPrefix(p.prefill, candidate) {
+			return ""
+		}
+		p.done = true
+		return buffered
+	}
+
+	p.done = true
+	if strings.HasPrefix(candidate, p.prefill) {
+		return candidate[len(p.prefill):]
+	}
+	return buffered
+}
+
+// Flush returns any text still buffered at the end of the output. Output
+// that only repeated part of the prefill is dropped.
+func (p *PrefillStripper) Flush() string {
+	if p.done {
+		return ""
+	}
+	p.done = true
+	buffered := p.buf.String()
+	if strings.HasPrefix(p.prefill, strings.TrimLeft(buffered, " \t\r\n")) {
+		return ""
+	}
+	return buffered
+}
+
+// StripPrefill removes an echoed prefill from complete output text.
+func StripPrefill(text, prefill string) string {
+	p := NewPrefillStripper(prefill)
+	return p.Write(text) + p.Flush()
+}
+
+// toolResultContent flattens a tool_result block to the text of an OpenAI
+// tool message and returns its images separately. Errored results are
+// prefixed so the model can tell them apart from normal output.
+func toolResultContent(block map[string]interface{}, opts AnthropicOptions) (string, []map[string]interface{}) {
+	var texts []string
+	var images []map[string]interface{}
+
+	switch c := block["content"].(type) {
+	case nil:
+	case string:
+		texts = append(texts, c)
+	case []interface{}:
+		for _, item := range c {
+			itemMap, ok := item.(map[string]interface{})
+			if !ok {
+				if str, ok := item.(string); ok {
+					texts = append(texts, str)
+				}
+				continue
+			}
+
+			switch itemMap["type"] {
+			case "text":
+				if text, ok := itemMap["text"].(string); ok {
+					texts = append(texts, text)
+				}
+			case "image":
+				if opts.NoVision {
+					texts = append(texts, "[Image omitted: the model does not support image input]")
+				} else if part := imagePart(itemMap); part != nil {
+					images = append(images, part)
+				}
+			case "document":
+				for _, part := range documentParts(itemMap) {
+					if part["type"] == "text" {
+						texts = append(texts, part["text"].(string))
+					} else if !opts.NoVision {
+						images = append(images, part)
+					}
+				}
+			case "search_result":
+				texts = append(texts, searchResultText(itemMap))
+			default:
+				if data, err := json.Marshal(itemMap); err == nil {
+					texts = append(texts, string(data))
+				}
+			}
+		}
+	default:
+		if data, err := json.Marshal(c); err == nil {
+			texts = append(texts, string(data))
+		}
+	}
+
+	if len(images) > 0 {
+		texts = append(texts, fmt.Sprintf("[%d image(s) attached in the following user message]", len(images)))
+	}
+
+	text := strings.Join(texts, "\n")
+	if isError, _ := block["is_error"].(bool); isError {
+		text = strings.TrimSpace("[Tool error] " + text)
+	}
+	return text, images
+}
+
+// imagePart converts an Anthropic image block to an OpenAI image_url part.
+// URL sources that were not resolved by a Fetcher are passed through for
+// the upstream to fetch.
+func imagePart(block map[string]interface{}) map[string]interface{} {
+	source, _ := block["source"].(map[string]interface{})
+	var imageURL string
+	switch sourceType, _ := source["type"].(string); sourceType {
+	case "base64":
+		mediaType, _ := source["media_type"].(string)
+		if mediaType == "" {
+			mediaType = "image/png"
+		}
+		data, _ := source["data"].(string)
+		imageURL = "data:" + mediaType + ";base64," + data
+	case "url":
+		imageURL, _ = source["url"].(string)
+	}
+	if imageURL == "" {
+		return nil
+	}
+	return map[string]interface{}{
+		"type": "image_url",
+		"image_url": map[string]interface{}{
+			"url": imageURL,
+		},
+	}
+}
+
+// documentParts converts an Anthropic document block to content parts.
+// Text and PDF documents become a single text part; content sources keep
+// their images as separate parts.
+func documentParts(block map[string]interface{}) []map[string]interface{} {
+	source, _ := block["source"].(map[string]interface{})
+	sourceType, _ := source["type"].(string)
+	mediaType, _ := source["media_type"].(string)
+
+	var body string
+	var images []map[string]interface{}
+
+	switch sourceType {
+	case "text":
+		body, _ = source["data"].(string)
+	case "base64":
+		data, _ := source["data"].(string)
+		decoded, err := base64.StdEncoding.DecodeString(data)
+		switch {
+		case err != nil:
+			body = fmt.Sprintf("[Document could not be decoded: %v]", err)
+		case mediaType == "application/pdf":
+			body = pdfText(decoded)
+		case strings.HasPrefix(mediaType, "text/"):
+			body = string(decoded)
+		default:
+			body = fmt.Sprintf("[Unsupported document type %q]", mediaType)
+		}
+	case "content":
+		switch c := source["content"].(type) {
+		case string:
+			body = c
+		case []interface{}:
+			var texts []string
+			for _, item := range c {
+				itemMap, ok := item.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				switch itemMap["type"] {
+				case "text":
+					if text, ok := itemMap["text"].(string); ok {
+						texts = append(texts, text)
+					}
+				case "image":
+					if part := imagePart(itemMap); part != nil {
+						images = append(images, part)
+					}
+				}
+			}
+			body = strings.Join(texts, "\n")
+		}
+	case "url":
+		url, _ := source["url"].(string)
+		body = fmt.Sprintf("[Document at %s was not fetched]", url)
+	default:
+		body = fmt.Sprintf("[Unsupported document source %q]", sourceType)
+	}
+
+	var header strings.Builder
+	header.WriteString("<document")
+	if title, _ := block["title"].(string); title != "" {
+		fmt.Fprintf(&header, " title=%q", title)
+	}
+	if context, _ := block["context"].(string); context != "" {
+		fmt.Fprintf(&header, " context=%q", context)
+	}
+	header.WriteString(">")
+
+	parts := []map[string]interface{}{{
+		"type": "text",
+		"text": header.String() + "\n" + body + "\n</document>",
+	}}
+	return append(parts, images...)
+}
+
+// pdfText extracts PDF text with a marker before each page.
+func pdfText(data []byte) string {
+	pages, err := pdf.ExtractPages(data)
+	if err != nil {
+		return fmt.Sprintf("[PDF text could not be extracted: %v]", err)
+	}
+
+	var b strings.Builder
+	empty := true
+	for i, text := range pages {
+		if i > 0 {
+			b.WriteString("\n\n")
+		}
+		fmt.Fprintf(&b, "--- Page %d ---\n", i+1)
+		b.WriteString(text)
+		if text != "" {
+			empty = false
+		}
+	}
+	if empty {
+		b.WriteString("\n[No extractable text; the PDF may contain only scanned images]")
+	}
+	return b.String()
+}
+
+// searchResultText renders an Anthropic search_result block as text.
+func searchResultText(block map[string]interface{}) string {
+	var b strings.Builder
+	b.WriteString("<search_result")
+	if source, _ := block["source"].(string); source != "" {
+		fmt.Fprintf(&b, " source=%q", source)
+	}
+	if title, _ := block["title"].(string); title != "" {
+		fmt.Fprintf(&b, " title=%q", title)
+	}
+	b.WriteString(">\n")
+	b.WriteString(extractTextContent(block["content"]))
+	b.WriteString("\n</search_result>")
+	return b.String()
+}
+
 // extractTextContent extracts text content from various formats.
 func extractTextContent(content interface{}) string {
 	if content == nil {
@@ -274,14 +663,18 @@ func ConvertAnthropicTools(tools []interface{}) []map[string]interface{} {
 			}
 		}
 
-		result = append(result, map[string]interface{}{
+		converted := map[string]interface{}{
 			"type": "function",
 			"function": map[string]interface{}{
 				"name":        name,
 				"description": description,
 				"parameters":  inputSchema,
 			},
-		})
+		}
+		if cc := copilotCacheControl(toolMap); cc != nil {
+			converted["copilot_cache_control"] = cc
+		}
+		result = append(result, converted)
 	}
 
 	if len(result) == 0 {
@@ -349,11 +742,6 @@ func ConvertOpenAIResponseToAnthropic(resp map[string]interface{}, model string)
 	}
 
 	usage, _ := resp["usage"].(map[string]interface{})
-	promptTokens, _ := usage["prompt_tokens"].(float64)
-	completionTokens, _ := usage["completion_tokens"].(float64)
-
-	promptDetails, _ := usage["prompt_tokens_details"].(map[string]interface{})
-	cachedTokens, _ := promptDetails["cached_tokens"].(float64)
 
 	return map[string]interface{}{
 		"id":            "msg_" + uuid.New().String(),
@@ -363,11 +751,37 @@ func ConvertOpenAIResponseToAnthropic(resp map[string]interface{}, model string)
 		"model":         model,
 		"stop_reason":   stopReason,
 		"stop_sequence": nil,
-		"usage": map[string]interface{}{
-			"input_tokens":               int(promptTokens),
-			"output_tokens":              int(completionTokens),
-			"cache_creation_input_tokens": 0,
-			"cache_read_input_tokens":     int(cachedTokens),
-		},
+		"usage":         AnthropicUsage(usage),
+	}
+}
+
+// AnthropicUsage converts OpenAI-style upstream usage to Anthropic usage.
+// Cache reads come from prompt_tokens_details.cached_tokens or
+// cache_read_input_tokens, cache writes from cache_creation_input_tokens.
+// As in the Messages API, input_tokens excludes both.
+func AnthropicUsage(usage map[string]interface{}) map[string]interface{} {
+	promptTokens, _ := usage["prompt_tokens"].(float64)
+	completionTokens, _ := usage["completion_tokens"].(float64)
+
+	promptDetails, _ := usage["prompt_tokens_details"].(map[string]interface{})
+	cacheRead, _ := promptDetails["cached_tokens"].(float64)
+	if v, ok := usage["cache_read_input_tokens"].(float64); ok && v > 0 {
+		cacheRead = v
+	}
+	cacheCreation, _ := usage["cache_creation_input_tokens"].(float64)
+	if v, ok := promptDetails["cache_creation_input_tokens"].(float64); ok && v > 0 {
+		cacheCreation = v
+	}
+
+	inputTokens := promptTokens - cacheRead - cacheCreation
+	if inputTokens < 0 {
+		inputTokens = 0
+	}
+
+	return map[string]interface{}{
+		"input_tokens":                int(inputTokens),
+		"output_tokens":               int(completionTokens),
+		"cache_creation_input_tokens": int(cacheCreation),
+		"cache_read_input_tokens":     int(cacheRead),
 	}
 }
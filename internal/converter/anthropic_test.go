@@ -1,6 +1,7 @@
 package converter
 
 import (
+	"encoding/base64"
 	"encoding/json"
 	"strings"
 	"testing"
@@ -89,7 +90,7 @@ func TestConvertAnthropicToCopilotMessages(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result := ConvertAnthropicToCopilotMessages(tt.messages, tt.system)
+			result := ConvertAnthropicToCopilotMessages(tt.messages, tt.system, AnthropicOptions{})
 
 			if len(result) != tt.wantMsgCount {
 				t.Errorf("ConvertAnthropicToCopilotMessages() returned %d messages, want %d", len(result), tt.wantMsgCount)
@@ -369,7 +370,7 @@ func TestConvertAnthropicToCopilotMessages_ImageContent(t *testing.T) {
 		},
 	}
 
-	result := ConvertAnthropicToCopilotMessages(messages, "")
+	result := ConvertAnthropicToCopilotMessages(messages, "", AnthropicOptions{})
 
 	if len(result) != 1 {
 		t.Errorf("Expected 1 message, got %d", len(result))
@@ -465,7 +466,7 @@ func TestConvertAnthropicToCopilotMessages_ToolResultContent(t *testing.T) {
 		},
 	}
 
-	result := ConvertAnthropicToCopilotMessages(messages, "")
+	result := ConvertAnthropicToCopilotMessages(messages, "", AnthropicOptions{})
 
 	if len(result) != 1 {
 		t.Errorf("Expected 1 message, got %d", len(result))
@@ -566,7 +567,7 @@ func TestConvertAnthropicToCopilotMessages_AssistantWithToolCalls(t *testing.T)
 		},
 	}
 
-	result := ConvertAnthropicToCopilotMessages(messages, "")
+	result := ConvertAnthropicToCopilotMessages(messages, "", AnthropicOptions{})
 
 	if len(result) != 1 {
 		t.Fatalf("Expected 1 message, got %d", len(result))
@@ -603,3 +604,337 @@ func TestConvertAnthropicToCopilotMessages_AssistantWithToolCalls(t *testing.T)
 		t.Errorf("Expected query 'test', got %v", args["query"])
 	}
 }
+
+func TestConvertAnthropicToCopilotMessages_Documents(t *testing.T) {
+	minimalPDF := "%PDF-1.4\n" +
+		"1 0 obj\n<< /Type /Catalog /Pages 2 0 R >>\nendobj\n" +
+		"2 0 obj\n<< /Type /Pages /Kids [3 0 R] /Count 1 >>\nendobj\n" +
+		"3 0 obj\n<< /Type /Page /Parent 2 0 R /Contents 4 0 R >>\nendobj\n" +
+		"4 0 obj\n<< /Length 27 >>\nstream\nBT (Quarterly report) Tj ET\nendstream\nendobj\n%%EOF\n"
+
+	messages := []map[string]interface{}{
+		{
+			"role": "user",
+			"content": []interface{}{
+				map[string]interface{}{
+					"type":  "document",
+					"title": "Notes",
+					"source": map[string]interface{}{
+						"type":       "text",
+						"media_type": "text/plain",
+						"data":       "Plain text body",
+					},
+				},
+				map[string]interface{}{
+					"type": "document",
+					"source": map[string]interface{}{
+						"type":       "base64",
+						"media_type": "application/pdf",
+						"data":       base64.StdEncoding.EncodeToString([]byte(minimalPDF)),
+					},
+				},
+				map[string]interface{}{
+					"type": "document",
+					"source": map[string]interface{}{
+						"type": "content",
+						"content": []interface{}{
+							map[string]interface{}{"type": "text", "text": "Chunk one"},
+							map[string]interface{}{
+								"type":   "image",
+								"source": map[string]interface{}{"type": "url", "url": "https://example.com/a.png"},
+							},
+						},
+					},
+				},
+				map[string]interface{}{
+					"type":   "search_result",
+					"source": "https://example.com/page",
+					"title":  "Example",
+					"content": []interface{}{
+						map[string]interface{}{"type": "text", "text": "Search snippet"},
+					},
+				},
+				map[string]interface{}{"type": "text", "text": "Summarize these."},
+			},
+		},
+	}
+
+	result := ConvertAnthropicToCopilotMessages(messages, "", AnthropicOptions{})
+	if len(result) != 1 {
+		t.Fatalf("Expected 1 message, got %d", len(result))
+	}
+
+	content, ok := result[0]["content"].([]map[string]interface{})
+	if !ok {
+		t.Fatal("Expected content to be a slice")
+	}
+	if len(content) != 6 {
+		t.Fatalf("Expected 6 content parts, got %d", len(content))
+	}
+
+	expected := []string{
+		"<document title=\"Notes\">\nPlain text body\n</document>",
+		"<document>\n--- Page 1 ---\nQuarterly report\n</document>",
+		"<document>\nChunk one\n</document>",
+	}
+	for i, want := range expected {
+		if content[i]["text"] != want {
+			t.Errorf("Part %d: expected %q, got %q", i, want, content[i]["text"])
+		}
+	}
+
+	imageURL, _ := content[3]["image_url"].(map[string]interface{})
+	if content[3]["type"] != "image_url" || imageURL["url"] != "https://example.com/a.png" {
+		t.Errorf("Expected document image to pass through as image_url, got %v", content[3])
+	}
+
+	searchText, _ := content[4]["text"].(string)
+	if !strings.Contains(searchText, `source="https://example.com/page"`) || !strings.Contains(searchText, "Search snippet") {
+		t.Errorf("Unexpected search result text: %q", searchText)
+	}
+	if content[5]["text"] != "Summarize these." {
+		t.Errorf("Expected trailing text part, got %v", content[5])
+	}
+}
+
+func TestConvertAnthropicToCopilotMessages_RichToolResults(t *testing.T) {
+	screenshot := map[string]interface{}{
+		"type": "image",
+		"source": map[string]interface{}{
+			"type":       "base64",
+			"media_type": "image/png",
+			"data":       "iVBORw0KGgo=",
+		},
+	}
+	messages := []map[string]interface{}{
+		{
+			"role": "user",
+			"content": []interface{}{
+				map[string]interface{}{
+					"type":        "tool_result",
+					"tool_use_id": "toolu_1",
+					"content": []interface{}{
+						map[string]interface{}{"type": "text", "text": "Took a screenshot"},
+						screenshot,
+					},
+				},
+				map[string]interface{}{"type": "text", "text": "Between results"},
+				map[string]interface{}{
+					"type":        "tool_result",
+					"tool_use_id": "toolu_2",
+					"is_error":    true,
+					"content":     "Element not found",
+				},
+			},
+		},
+	}
+
+	result := ConvertAnthropicToCopilotMessages(messages, "", AnthropicOptions{})
+	if len(result) != 3 {
+		t.Fatalf("Expected 3 messages, got %d", len(result))
+	}
+
+	if result[0]["role"] != "tool" || result[0]["tool_call_id"] != "toolu_1" {
+		t.Errorf("Expected first tool message, got %v", result[0])
+	}
+	if content := result[0]["content"].(string); !strings.Contains(content, "Took a screenshot") || !strings.Contains(content, "1 image(s) attached") {
+		t.Errorf("Unexpected tool content: %q", content)
+	}
+
+	if result[1]["role"] != "tool" || result[1]["content"] != "[Tool error] Element not found" {
+		t.Errorf("Expected errored tool message, got %v", result[1])
+	}
+
+	if result[2]["role"] != "user" {
+		t.Fatalf("Expected follow-up user message, got %v", result[2]["role"])
+	}
+	parts, ok := result[2]["content"].([]map[string]interface{})
+	if !ok || len(parts) != 3 {
+		t.Fatalf("Expected 3 user content parts, got %v", result[2]["content"])
+	}
+	if parts[0]["text"] != "Images returned by tool call toolu_1:" {
+		t.Errorf("Expected image label first, got %v", parts[0])
+	}
+	if parts[1]["type"] != "image_url" {
+		t.Errorf("Expected tool image second, got %v", parts[1])
+	}
+	if parts[2]["text"] != "Between results" {
+		t.Errorf("Expected interleaved text last, got %v", parts[2])
+	}
+
+	noVision := ConvertAnthropicToCopilotMessages(messages, "", AnthropicOptions{NoVision: true})
+	if len(noVision) != 3 {
+		t.Fatalf("Expected 3 messages without vision, got %d", len(noVision))
+	}
+	if content := noVision[0]["content"].(string); !strings.Contains(content, "[Image omitted") {
+		t.Errorf("Expected image note without vision, got %q", content)
+	}
+	if noVision[2]["content"] != "Between results" {
+		t.Errorf("Expected only text in user message without vision, got %v", noVision[2]["content"])
+	}
+}
+
+func TestConvertAnthropicToCopilotMessages_Prefill(t *testing.T) {
+	messages := []map[string]interface{}{
+		{"role": "user", "content": "Return the user as JSON"},
+		{"role": "assistant", "content": "{\n"},
+	}
+
+	if prefill := AssistantPrefill(messages); prefill != "{" {
+		t.Fatalf("Expected prefill '{', got %q", prefill)
+	}
+
+	result := ConvertAnthropicToCopilotMessages(messages, "", AnthropicOptions{})
+	if len(result) != 2 {
+		t.Fatalf("Expected 2 messages, got %d", len(result))
+	}
+	last := result[1]
+	if last["role"] != "user" {
+		t.Errorf("Expected prefill instruction as user message, got role %v", last["role"])
+	}
+	if content, _ := last["content"].(string); !strings.Contains(content, "<<<PREFILL\n{\nPREFILL>>>") {
+		t.Errorf("Expected prefill in instruction, got %q", content)
+	}
+
+	toolTurn := []map[string]interface{}{
+		{"role": "user", "content": "Hi"},
+		{"role": "assistant", "content": []interface{}{
+			map[string]interface{}{"type": "tool_use", "id": "toolu_1", "name": "f", "input": map[string]interface{}{}},
+		}},
+	}
+	if prefill := AssistantPrefill(toolTurn); prefill != "" {
+		t.Errorf("Expected no prefill for tool_use turn, got %q", prefill)
+	}
+}
+
+func TestPrefillStripper(t *testing.T) {
+	tests := []struct {
+		name    string
+		prefill string
+		chunks  []string
+		want    string
+	}{
+		{"echoed across chunks", `{"name":`, []string{`{"na`, `me":`, ` "Ada"}`}, ` "Ada"}`},
+		{"echoed after whitespace", "Dear", []string{"\n", "Dear Sir"}, " Sir"},
+		{"not echoed", "{", []string{`"name": "Ada"}`}, `"name": "Ada"}`},
+		{"diverges midway", "Hello there", []string{"Hello", " world"}, "Hello world"},
+		{"partial echo only", "Hello there", []string{"Hello"}, ""},
+		{"no prefill", "", []string{"a", "b"}, "ab"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewPrefillStripper(tt.prefill)
+			var got strings.Builder
+			for _, chunk := range tt.chunks {
+				got.WriteString(p.Write(chunk))
+			}
+			got.WriteString(p.Flush())
+			if got.String() != tt.want {
+				t.Errorf("Expected %q, got %q", tt.want, got.String())
+			}
+		})
+	}
+
+	if got := StripPrefill("{\"a\":1}", "{"); got != "\"a\":1}" {
+		t.Errorf("StripPrefill returned %q", got)
+	}
+}
+
+func TestConvertAnthropicToCopilotMessages_CacheControl(t *testing.T) {
+	ephemeral := map[string]interface{}{"type": "ephemeral"}
+	system := []interface{}{
+		map[string]interface{}{"type": "text", "text": "You are Claude."},
+		map[string]interface{}{"type": "text", "text": "Long project context", "cache_control": ephemeral},
+	}
+	messages := []map[string]interface{}{
+		{
+			"role": "user",
+			"content": []interface{}{
+				map[string]interface{}{"type": "text", "text": "Read the file", "cache_control": ephemeral},
+			},
+		},
+		{
+			"role": "assistant",
+			"content": []interface{}{
+				map[string]interface{}{"type": "tool_use", "id": "toolu_1", "name": "read", "input": map[string]interface{}{}, "cache_control": ephemeral},
+			},
+		},
+		{
+			"role": "user",
+			"content": []interface{}{
+				map[string]interface{}{"type": "tool_result", "tool_use_id": "toolu_1", "content": "data", "cache_control": ephemeral},
+				map[string]interface{}{"type": "text", "text": "Now summarize"},
+				map[string]interface{}{"type": "text", "text": "briefly", "cache_control": ephemeral},
+			},
+		},
+	}
+
+	result := ConvertAnthropicToCopilotMessages(messages, system, AnthropicOptions{})
+	if len(result) != 5 {
+		t.Fatalf("Expected 5 messages, got %d", len(result))
+	}
+
+	systemParts, ok := result[0]["content"].([]map[string]interface{})
+	if !ok || len(systemParts) != 2 {
+		t.Fatalf("Expected system block structure to be kept, got %v", result[0]["content"])
+	}
+	if _, ok := systemParts[0]["copilot_cache_control"]; ok {
+		t.Error("Expected no cache control on first system block")
+	}
+	if systemParts[1]["copilot_cache_control"] == nil {
+		t.Error("Expected cache control on second system block")
+	}
+
+	if result[1]["content"] != "Read the file" || result[1]["copilot_cache_control"] == nil {
+		t.Errorf("Expected collapsed user text with message-level cache control, got %v", result[1])
+	}
+	if result[2]["copilot_cache_control"] == nil {
+		t.Errorf("Expected cache control on assistant tool call message, got %v", result[2])
+	}
+	if result[3]["role"] != "tool" || result[3]["copilot_cache_control"] == nil {
+		t.Errorf("Expected cache control on tool message, got %v", result[3])
+	}
+
+	parts, ok := result[4]["content"].([]map[string]interface{})
+	if !ok || len(parts) != 2 {
+		t.Fatalf("Expected 2 user parts, got %v", result[4]["content"])
+	}
+	if _, ok := parts[0]["copilot_cache_control"]; ok || parts[1]["copilot_cache_control"] == nil {
+		t.Errorf("Expected cache control only on the marked part, got %v", parts)
+	}
+
+	tools := ConvertAnthropicTools([]interface{}{
+		map[string]interface{}{"name": "read", "input_schema": map[string]interface{}{"type": "object"}, "cache_control": ephemeral},
+	})
+	if tools[0]["copilot_cache_control"] == nil {
+		t.Errorf("Expected cache control on tool, got %v", tools[0])
+	}
+}
+
+func TestAnthropicUsage(t *testing.T) {
+	usage := AnthropicUsage(map[string]interface{}{
+		"prompt_tokens":               float64(1000),
+		"completion_tokens":           float64(50),
+		"cache_creation_input_tokens": float64(200),
+		"prompt_tokens_details": map[string]interface{}{
+			"cached_tokens": float64(700),
+		},
+	})
+
+	expected := map[string]int{
+		"input_tokens":                100,
+		"output_tokens":               50,
+		"cache_creation_input_tokens": 200,
+		"cache_read_input_tokens":     700,
+	}
+	for key, want := range expected {
+		if usage[key] != want {
+			t.Errorf("Expected %s %d, got %v", key, want, usage[key])
+		}
+	}
+
+	if empty := AnthropicUsage(nil); empty["input_tokens"] != 0 {
+		t.Errorf("Expected zero usage for nil input, got %v", empty)
+	}
+}
@@ -0,0 +1,234 @@
+package converter
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Fetcher downloads url-sourced images and documents so they can be
+// inlined into the upstream request. Only hosts on the allowlist are
+// fetched; private and loopback addresses are refused unless the host is
+// listed by exact name.
+type Fetcher struct {
+	allowedHosts []string
+	maxBytes     int64
+	public       *http.Client
+	trusted      *http.Client
+}
+
+// NewFetcher creates a fetcher. allowedHosts entries are exact host names,
+// "*.example.com" suffix patterns, or "*" for any public host. Responses
+// larger than maxBytes are rejected. It returns nil when no hosts are
+// allowed, which disables server-side fetching.
+func NewFetcher(allowedHosts []string, maxBytes int64, timeout time.Duration) *Fetcher {
+	hosts := make([]string, 0, len(allowedHosts))
+	for _, h := range allowedHosts {
+		if h = strings.ToLower(strings.TrimSpace(h)); h != "" {
+			hosts = append(hosts, h)
+		}
+	}
+	if len(hosts) == 0 {
+		return nil
+	}
+
+	f := &Fetcher{allowedHosts: hosts, maxBytes: maxBytes}
+	f.public = f.newClient(timeout, true)
+	f.trusted = f.newClient(timeout, false)
+	return f
+}
+
+func (f *Fetcher) newClient(timeout time.Duration, publicOnly bool) *http.Client {
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	if publicOnly {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !isPublicIP(ip) {
+				return fmt.Errorf("address %s is not publicly routable", host)
+			}
+			return nil
+		}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	transport.Proxy = nil
+
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 5 {
+				return errors.New("too many redirects")
+			}
+			exact, err := f.check(req.URL)
+			if err != nil {
+				return err
+			}
+			// The trusted client may reach private addresses, so it only
+			// follows redirects to hosts that are trusted too
+			if !publicOnly && !exact {
+				return fmt.Errorf("redirect to %q leaves the hosts listed by exact name", req.URL.Hostname())
+			}
+			return nil
+		},
+	}
+}
+
+func isPublicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast())
+}
+
+// check validates a URL against the allowlist. It reports whether the host
+// is listed by exact name.
+func (f *Fetcher) check(u *url.URL) (bool, error) {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return false, fmt.Errorf("unsupported URL scheme %q", u.Scheme)
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, allowed := range f.allowedHosts {
+		if allowed == host {
+			return true, nil
+		}
+	}
+	for _, allowed := range f.allowedHosts {
+		if allowed == "*" || (strings.HasPrefix(allowed, "*.") && strings.HasSuffix(host, allowed[1:])) {
+			return false, nil
+		}
+	}
+	return false, fmt.Errorf("host %q is not in the fetch allowlist", host)
+}
+
+// Fetch downloads rawURL and returns its media type and content.
+func (f *Fetcher) Fetch(ctx context.Context, rawURL string) (string, []byte, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid URL: %w", err)
+	}
+	exact, err := f.check(u)
+	if err != nil {
+		return "", nil, err
+	}
+
+	client := f.public
+	if exact {
+		client = f.trusted
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", nil, fmt.Errorf("fetch returned status %d", resp.StatusCode)
+	}
+	if f.maxBytes > 0 && resp.ContentLength > f.maxBytes {
+		return "", nil, fmt.Errorf("content is %d bytes, limit is %d", resp.ContentLength, f.maxBytes)
+	}
+
+	body := io.Reader(resp.Body)
+	if f.maxBytes > 0 {
+		body = io.LimitReader(resp.Body, f.maxBytes+1)
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return "", nil, err
+	}
+	if f.maxBytes > 0 && int64(len(data)) > f.maxBytes {
+		return "", nil, fmt.Errorf("content exceeds limit of %d bytes", f.maxBytes)
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType == "" || mediaType == "application/octet-stream" {
+		mediaType, _, _ = mime.ParseMediaType(http.DetectContentType(data))
+	}
+	return mediaType, data, nil
+}
+
+// ResolveURLSources fetches url-sourced image and document blocks in
+// Anthropic messages, including those nested in tool results and document
+// content, and rewrites them as base64 sources in place. Blocks that cannot
+// be fetched are replaced by a text note so the model knows an attachment
+// was present.
+func ResolveURLSources(ctx context.Context, messages []map[string]interface{}, f *Fetcher) {
+	if f == nil {
+		return
+	}
+	for _, msg := range messages {
+		if blocks, ok := msg["content"].([]interface{}); ok {
+			f.resolveBlocks(ctx, blocks)
+		}
+	}
+}
+
+func (f *Fetcher) resolveBlocks(ctx context.Context, blocks []interface{}) {
+	for i, block := range blocks {
+		blockMap, ok := block.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		blockType, _ := blockMap["type"].(string)
+		source, _ := blockMap["source"].(map[string]interface{})
+
+		switch blockType {
+		case "tool_result":
+			if nested, ok := blockMap["content"].([]interface{}); ok {
+				f.resolveBlocks(ctx, nested)
+			}
+		case "document":
+			if nested, ok := source["content"].([]interface{}); ok {
+				f.resolveBlocks(ctx, nested)
+			}
+		}
+
+		if sourceType, _ := source["type"].(string); sourceType != "url" {
+			continue
+		}
+		if blockType != "image" && blockType != "document" {
+			continue
+		}
+
+		rawURL, _ := source["url"].(string)
+		mediaType, data, err := f.Fetch(ctx, rawURL)
+		if err == nil && blockType == "image" && !strings.HasPrefix(mediaType, "image/") {
+			err = fmt.Errorf("unsupported image type %q", mediaType)
+		}
+		if err == nil && blockType == "document" && mediaType != "application/pdf" && !strings.HasPrefix(mediaType, "text/") {
+			err = fmt.Errorf("unsupported document type %q", mediaType)
+		}
+		if err != nil {
+			blocks[i] = map[string]interface{}{
+				"type": "text",
+				"text": fmt.Sprintf("[%s at %s could not be fetched: %v]", blockType, rawURL, err),
+			}
+			continue
+		}
+
+		blockMap["source"] = map[string]interface{}{
+			"type":       "base64",
+			"media_type": mediaType,
+			"data":       base64.StdEncoding.EncodeToString(data),
+		}
+	}
+}
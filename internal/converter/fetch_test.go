@@ -0,0 +1,155 @@
+package converter
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewFetcherDisabledWithoutHosts(t *testing.T) {
+	if NewFetcher(nil, 1024, time.Second) != nil {
+		t.Error("Expected nil fetcher without allowed hosts")
+	}
+	if NewFetcher([]string{" ", ""}, 1024, time.Second) != nil {
+		t.Error("Expected nil fetcher with blank allowed hosts")
+	}
+}
+
+func TestFetcherAllowlist(t *testing.T) {
+	f := NewFetcher([]string{"*.example.com", "docs.internal"}, 1024, time.Second)
+
+	tests := []struct {
+		url     string
+		allowed bool
+		exact   bool
+	}{
+		{"https://cdn.example.com/a.png", true, false},
+		{"https://example.com.evil.net/a.png", false, false},
+		{"https://badexample.com/a.png", false, false},
+		{"http://docs.internal/spec.pdf", true, true},
+		{"ftp://cdn.example.com/a.png", false, false},
+	}
+
+	for _, tt := range tests {
+		u, _ := url.Parse(tt.url)
+		exact, err := f.check(u)
+		if (err == nil) != tt.allowed {
+			t.Errorf("%s: expected allowed=%v, got err=%v", tt.url, tt.allowed, err)
+		}
+		if exact != tt.exact {
+			t.Errorf("%s: expected exact=%v, got %v", tt.url, tt.exact, exact)
+		}
+	}
+}
+
+func TestResolveURLSources(t *testing.T) {
+	png := []byte("\x89PNG\r\n\x1a\n0000")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/image.png":
+			w.Write(png)
+		case "/big.png":
+			w.Write([]byte(strings.Repeat("x", 100)))
+		case "/notes.txt":
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.Write([]byte("hello"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	host := strings.Split(strings.TrimPrefix(server.URL, "http://"), ":")[0]
+	f := NewFetcher([]string{host}, 64, 5*time.Second)
+
+	urlBlock := func(blockType, path string) map[string]interface{} {
+		return map[string]interface{}{
+			"type":   blockType,
+			"source": map[string]interface{}{"type": "url", "url": server.URL + path},
+		}
+	}
+
+	messages := []map[string]interface{}{
+		{
+			"role": "user",
+			"content": []interface{}{
+				urlBlock("image", "/image.png"),
+				urlBlock("image", "/big.png"),
+				urlBlock("document", "/notes.txt"),
+				map[string]interface{}{
+					"type":        "tool_result",
+					"tool_use_id": "toolu_1",
+					"content":     []interface{}{urlBlock("image", "/missing.png")},
+				},
+			},
+		},
+	}
+
+	ResolveURLSources(context.Background(), messages, f)
+	blocks := messages[0]["content"].([]interface{})
+
+	image := blocks[0].(map[string]interface{})["source"].(map[string]interface{})
+	if image["type"] != "base64" || image["media_type"] != "image/png" {
+		t.Errorf("Expected image to be inlined as base64 PNG, got %v", image)
+	}
+
+	if text, _ := blocks[1].(map[string]interface{})["text"].(string); !strings.Contains(text, "limit is 64") {
+		t.Errorf("Expected oversized image to become a note, got %v", blocks[1])
+	}
+
+	doc := blocks[2].(map[string]interface{})["source"].(map[string]interface{})
+	if doc["media_type"] != "text/plain" || doc["data"] != "aGVsbG8=" {
+		t.Errorf("Expected text document to be inlined, got %v", doc)
+	}
+
+	nested := blocks[3].(map[string]interface{})["content"].([]interface{})
+	if text, _ := nested[0].(map[string]interface{})["text"].(string); !strings.Contains(text, "status 404") {
+		t.Errorf("Expected missing nested image to become a note, got %v", nested[0])
+	}
+}
+
+func TestFetcherRefusesPrivateAddressForWildcard(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("secret"))
+	}))
+	defer server.Close()
+
+	f := NewFetcher([]string{"*"}, 1024, 5*time.Second)
+	if _, _, err := f.Fetch(context.Background(), server.URL); err == nil {
+		t.Error("Expected loopback fetch to be refused for wildcard allowlist")
+	}
+}
+
+func TestFetcherTrustedHostRedirects(t *testing.T) {
+	internal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("metadata"))
+	}))
+	defer internal.Close()
+	internalURL, _ := url.Parse(internal.URL)
+
+	trusted := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/leave":
+			// Reaches the loopback server under a name only the wildcard allows
+			http.Redirect(w, r, "http://localhost:"+internalURL.Port()+"/", http.StatusFound)
+		case "/stay":
+			http.Redirect(w, r, "/image", http.StatusFound)
+		default:
+			w.Write([]byte("image"))
+		}
+	}))
+	defer trusted.Close()
+	trustedURL, _ := url.Parse(trusted.URL)
+
+	f := NewFetcher([]string{trustedURL.Hostname(), "*"}, 1024, 5*time.Second)
+	if _, data, err := f.Fetch(context.Background(), trusted.URL+"/leave"); err == nil {
+		t.Errorf("Expected redirect from a trusted host to a loopback address to be refused, got %q", data)
+	}
+	if _, data, err := f.Fetch(context.Background(), trusted.URL+"/stay"); err != nil || string(data) != "image" {
+		t.Errorf("Expected redirect within the trusted host to be followed, got %q, %v", data, err)
+	}
+}
@@ -8,55 +8,164 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/rahulvramesh/gh-proxy-local/internal/apikeys"
 	"github.com/rahulvramesh/gh-proxy-local/internal/auth"
 	"github.com/rahulvramesh/gh-proxy-local/internal/config"
+	"github.com/rahulvramesh/gh-proxy-local/internal/history"
+	"github.com/rahulvramesh/gh-proxy-local/internal/logging"
+	"github.com/rahulvramesh/gh-proxy-local/internal/metrics"
 	"github.com/rahulvramesh/gh-proxy-local/internal/models"
+	"github.com/rahulvramesh/gh-proxy-local/internal/tracing"
 )
 
 // Client is the Copilot API client.
 type Client struct {
 	authManager *auth.Manager
 	httpClient  *http.Client
+	upstream    config.UpstreamConfig
+	usage       UsageObserver
+	limiter     Limiter
+	tokens      []TokenObserver
 	debug       bool
 
 	// Models cache
 	modelsCache     []models.CopilotModel
 	modelsCacheTime time.Time
-	modelsMu        sync.RWMutex
+	// knownModels holds every model ID of the last fetched list, hidden
+	// ones included.
+	knownModels map[string]bool
+	modelsMu    sync.RWMutex
 }
 
-// NewClient creates a new Copilot client.
-func NewClient(authManager *auth.Manager, debug bool) *Client {
+// NewClient creates a new Copilot client. A zero timeout or models cache
+// TTL in upstream takes the default.
+func NewClient(authManager *auth.Manager, upstream config.UpstreamConfig, debug bool) *Client {
+	if upstream.Timeout <= 0 {
+		upstream.Timeout = 120 * time.Second // Longer timeout for streaming
+	}
+	if upstream.ModelsCacheTTL <= 0 {
+		upstream.ModelsCacheTTL = config.ModelsCacheTTL * time.Second
+	}
 	return &Client{
 		authManager: authManager,
 		httpClient: &http.Client{
-			Timeout: 120 * time.Second, // Longer timeout for streaming
+			Timeout: upstream.Timeout,
 		},
-		debug: debug,
+		upstream: upstream,
+		debug:    debug,
+	}
+}
+
+// UsageObserver is notified of chat requests so premium request
+// consumption can be accounted for.
+type UsageObserver interface {
+	// Admit is called before a request is sent; an error rejects it.
+	Admit(ctx context.Context, model, initiator string) error
+	// Record is called once the upstream has accepted a request.
+	Record(ctx context.Context, model, initiator string)
+}
+
+// SetUsageObserver sets the observer notified of chat requests.
+func (c *Client) SetUsageObserver(o UsageObserver) {
+	c.usage = o
+}
+
+// Limiter bounds the number of concurrent upstream requests per model.
+type Limiter interface {
+	// Acquire waits for a slot for model and returns its release function.
+	Acquire(ctx context.Context, model string) (func(), error)
+}
+
+// SetLimiter sets the limiter consulted by Admit and Acquire.
+func (c *Client) SetLimiter(l Limiter) {
+	c.limiter = l
+}
+
+// TokenObserver is notified of the token usage reported by the upstream.
+type TokenObserver interface {
+	ObserveTokens(ctx context.Context, model string, usage models.Usage)
+}
+
+// AddTokenObserver registers an observer of upstream token usage.
+func (c *Client) AddTokenObserver(o TokenObserver) {
+	c.tokens = append(c.tokens, o)
+}
+
+// observeTokens notifies the token observers of a response's usage.
+func (c *Client) observeTokens(ctx context.Context, model string, usage *models.Usage) {
+	if usage == nil {
+		return
+	}
+	for _, o := range c.tokens {
+		o.ObserveTokens(ctx, model, *usage)
+	}
+}
+
+// Admit checks whether a chat request may be sent under the usage policy
+// and waits for a concurrency slot for its model. Handlers call it before
+// starting a response so a rejection can be reported with a proper status
+// code, and call the returned release function once the response is done.
+func (c *Client) Admit(ctx context.Context, req *ChatRequest) (func(), error) {
+	model := models.ResolveModel(req.Model)
+	if c.usage != nil {
+		if err := c.usage.Admit(ctx, model, c.initiator(ctx, req)); err != nil {
+			return nil, err
+		}
+	}
+	return c.Acquire(ctx, model)
+}
+
+// Acquire waits for a concurrency slot for model. The returned function
+// releases it.
+func (c *Client) Acquire(ctx context.Context, model string) (func(), error) {
+	if c.limiter == nil {
+		return func() {}, nil
+	}
+	return c.limiter.Acquire(ctx, models.ResolveModel(model))
+}
+
+// initiator returns the X-Initiator value for a request.
+func (c *Client) initiator(ctx context.Context, req *ChatRequest) string {
+	if initiator := initiatorFromContext(ctx); initiator != "" {
+		return initiator
+	}
+	if c.upstream.Initiator != "" {
+		return c.upstream.Initiator
+	}
+	messages := req.Messages
+	if req.Prefill && len(messages) > 0 {
+		// The prefill continues whichever turn came before it
+		messages = messages[:len(messages)-1]
 	}
+	return detectInitiator(messages)
 }
 
-// debugLog prints debug messages if debugging is enabled.
-func (c *Client) debugLog(format string, args ...interface{}) {
-	if c.debug {
-		fmt.Printf("[DEBUG] "+format+"\n", args...)
+// debugLog logs a debug message with the request ID of ctx if debugging
+// is enabled. args are slog key-value pairs.
+func (c *Client) debugLog(ctx context.Context, msg string, args ...interface{}) {
+	if c.debug || logging.DebugEnabled() {
+		slog.DebugContext(ctx, msg, args...)
 	}
 }
 
 // FetchModels fetches available models from Copilot API.
 func (c *Client) FetchModels(ctx context.Context) ([]models.CopilotModel, error) {
 	c.modelsMu.RLock()
-	if c.modelsCache != nil && time.Since(c.modelsCacheTime).Seconds() < config.ModelsCacheTTL {
+	if c.modelsCache != nil && time.Since(c.modelsCacheTime) < c.upstream.ModelsCacheTTL {
 		models := c.modelsCache
 		c.modelsMu.RUnlock()
+		metrics.ModelsCache.Inc("hit")
 		return models, nil
 	}
 	c.modelsMu.RUnlock()
+	metrics.ModelsCache.Inc("miss")
 
 	creds, err := c.authManager.GetCredentials()
 	if err != nil {
@@ -78,35 +187,33 @@ func (c *Client) FetchModels(ctx context.Context) ([]models.CopilotModel, error)
 	for k, v := range config.CopilotHeaders {
 		req.Header.Set(k, v)
 	}
+	setRequestID(ctx, req.Header)
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		c.debugLog("Failed to fetch models: %v", err)
+		c.debugLog(ctx, "Failed to fetch models", "error", err)
 		return models.FallbackModels(), nil
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		c.debugLog("Failed to fetch models: %d %s", resp.StatusCode, string(body))
+		c.debugLog(ctx, "Failed to fetch models", "status", resp.StatusCode, "body", string(body))
 		return models.FallbackModels(), nil
 	}
 
 	var modelsResp models.CopilotModelsResponse
 	if err := json.NewDecoder(resp.Body).Decode(&modelsResp); err != nil {
-		c.debugLog("Failed to decode models: %v", err)
+		c.debugLog(ctx, "Failed to decode models", "error", err)
 		return models.FallbackModels(), nil
 	}
 
-	// Convert to our model format, filter out embedding and oswe models
+	// Convert to our model format, leaving out hidden models
 	result := make([]models.CopilotModel, 0, len(modelsResp.Data))
+	known := make(map[string]bool, len(modelsResp.Data))
 	for _, m := range modelsResp.Data {
-		// Skip embedding models
-		if strings.Contains(strings.ToLower(m.ID), "embedding") {
-			continue
-		}
-		// Skip oswe models
-		if strings.HasPrefix(m.ID, "oswe-") {
+		known[m.ID] = true
+		if !models.Listed(m.ID) {
 			continue
 		}
 
@@ -136,12 +243,68 @@ func (c *Client) FetchModels(ctx context.Context) ([]models.CopilotModel, error)
 	c.modelsMu.Lock()
 	c.modelsCache = result
 	c.modelsCacheTime = time.Now()
+	c.knownModels = known
 	c.modelsMu.Unlock()
 
-	c.debugLog("Fetched %d models from Copilot API", len(result))
+	c.debugLog(ctx, "Fetched models from Copilot API", "count", len(result))
 	return result, nil
 }
 
+// FlushModels empties the models cache, so that the next request fetches
+// the model list from Copilot.
+func (c *Client) FlushModels() {
+	c.modelsMu.Lock()
+	c.modelsCache = nil
+	c.modelsCacheTime = time.Time{}
+	c.modelsMu.Unlock()
+}
+
+// KnownModel reports whether a model name or alias resolves to a model
+// Copilot serves, hidden models included. Until the model list has been
+// fetched, the fallback models are known.
+func (c *Client) KnownModel(ctx context.Context, model string) bool {
+	if model == "" {
+		return false
+	}
+
+	c.modelsMu.RLock()
+	stale := c.modelsCache == nil || time.Since(c.modelsCacheTime) >= c.upstream.ModelsCacheTTL
+	c.modelsMu.RUnlock()
+	if stale {
+		c.FetchModels(ctx)
+	}
+
+	resolved := models.ResolveModel(model)
+	c.modelsMu.RLock()
+	known := c.knownModels
+	c.modelsMu.RUnlock()
+	if known != nil {
+		return known[resolved]
+	}
+	for _, m := range models.FallbackModels() {
+		if m.ID == resolved {
+			return true
+		}
+	}
+	return false
+}
+
+// FindModel returns the model a name or alias resolves to, or nil when the
+// model is not in the (possibly cached) models list.
+func (c *Client) FindModel(ctx context.Context, model string) *models.CopilotModel {
+	available, err := c.FetchModels(ctx)
+	if err != nil {
+		return nil
+	}
+	resolved := models.ResolveModel(model)
+	for i := range available {
+		if available[i].ID == resolved {
+			return &available[i]
+		}
+	}
+	return nil
+}
+
 // ChatRequest represents a request to the chat completions API.
 type ChatRequest struct {
 	Model       string
@@ -150,157 +313,375 @@ type ChatRequest struct {
 	MaxTokens   int
 	Stream      bool
 	Tools       []map[string]interface{}
+	// Prefill marks a conversation whose last message is the user
+	// instruction an assistant prefill was converted into.
+	Prefill bool
 }
 
 // ChatCompletions makes a chat completions request to Copilot API.
 func (c *Client) ChatCompletions(ctx context.Context, req *ChatRequest) (*models.OpenAIChatResponse, error) {
-	creds, err := c.authManager.GetCredentials()
+	resolvedModel := models.ResolveModel(req.Model)
+	c.debugLog(ctx, "Chat request", "model", resolvedModel, "requested_model", req.Model, "key", apikeys.NameFromContext(ctx))
+
+	ctx, span := startChatSpan(ctx, req, resolvedModel)
+	defer span.End()
+
+	resp, err := c.postChat(ctx, req, resolvedModel, false)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get credentials: %w", err)
+		span.SetError(err)
+		return nil, err
 	}
+	defer resp.Body.Close()
 
-	copilotToken, err := c.authManager.GetCopilotToken(creds)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get copilot token: %w", err)
+	var result models.OpenAIChatResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		err = fmt.Errorf("failed to decode response: %w", err)
+		span.SetError(err)
+		return nil, err
 	}
+	c.observeTokens(ctx, resolvedModel, result.Usage)
 
-	resolvedModel := models.ResolveModel(req.Model)
-	c.debugLog("Request to model: %s (original: %s)", resolvedModel, req.Model)
+	var finishReasons []string
+	for _, choice := range result.Choices {
+		if choice.FinishReason != "" {
+			finishReasons = append(finishReasons, choice.FinishReason)
+		}
+	}
+	traceResponse(span, result.Model, result.Usage, finishReasons)
+
+	return &result, nil
+}
 
+// startSpan starts the client span of an upstream call, named and
+// attributed following the GenAI semantic conventions.
+func startSpan(ctx context.Context, operation, model string) (context.Context, *tracing.Span) {
+	ctx, span := tracing.StartSpan(ctx, operation+" "+model, tracing.Client)
+	span.SetAttributes(
+		"gen_ai.operation.name", operation,
+		"gen_ai.provider.name", "github_copilot",
+		"gen_ai.request.model", model,
+		"server.address", strings.TrimPrefix(config.CopilotAPIBase, "https://"),
+	)
+	return ctx, span
+}
+
+// startChatSpan starts the client span of a chat completions call.
+func startChatSpan(ctx context.Context, req *ChatRequest, resolvedModel string) (context.Context, *tracing.Span) {
+	ctx, span := startSpan(ctx, "chat", resolvedModel)
+	span.SetAttributes(
+		"gen_ai.request.temperature", req.Temperature,
+		"gen_ai.request.max_tokens", req.MaxTokens,
+	)
+	return ctx, span
+}
+
+// traceResponse records the GenAI response attributes on an upstream span.
+func traceResponse(span *tracing.Span, model string, usage *models.Usage, finishReasons []string) {
+	if model != "" {
+		span.SetAttributes("gen_ai.response.model", model)
+	}
+	if usage != nil {
+		span.SetAttributes(
+			"gen_ai.usage.input_tokens", usage.PromptTokens,
+			"gen_ai.usage.output_tokens", usage.CompletionTokens,
+		)
+	}
+	if len(finishReasons) > 0 {
+		span.SetAttributes("gen_ai.response.finish_reasons", finishReasons)
+	}
+}
+
+// postChat sends a chat completions request and returns the successful
+// upstream response. The caller must close the response body.
+func (c *Client) postChat(ctx context.Context, req *ChatRequest, resolvedModel string, stream bool) (*http.Response, error) {
 	payload := map[string]interface{}{
 		"model":       resolvedModel,
 		"messages":    req.Messages,
 		"temperature": req.Temperature,
 		"max_tokens":  req.MaxTokens,
-		"stream":      false,
+		"stream":      stream,
 	}
 
 	if len(req.Tools) > 0 {
 		payload["tools"] = req.Tools
 	}
 
-	body, err := json.Marshal(payload)
+	httpReq, err := c.newRequest(ctx, "/chat/completions", payload)
 	if err != nil {
-		return nil, fmt.Errorf("failed to marshal request: %w", err)
+		return nil, err
 	}
-
-	httpReq, err := http.NewRequestWithContext(ctx, "POST", config.CopilotAPIBase+"/chat/completions", bytes.NewReader(body))
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+	if c.upstream.OpenAIIntent != "" {
+		httpReq.Header.Set("Openai-Intent", c.upstream.OpenAIIntent)
 	}
 
-	httpReq.Header.Set("Content-Type", "application/json")
-	httpReq.Header.Set("Authorization", "Bearer "+copilotToken)
-	httpReq.Header.Set("Openai-Intent", "conversation-edits")
-	for k, v := range config.CopilotHeaders {
-		httpReq.Header.Set(k, v)
-	}
+	// Premium requests are only billed for user-initiated turns
+	initiator := c.initiator(ctx, req)
+	httpReq.Header.Set("X-Initiator", initiator)
+	c.debugLog(ctx, "Set X-Initiator", "initiator", initiator)
 
 	// Add vision header if images are present
 	if hasImageContent(req.Messages) {
 		httpReq.Header.Set("Copilot-Vision-Request", "true")
-		c.debugLog("Added Copilot-Vision-Request header for image content")
+		c.debugLog(ctx, "Added Copilot-Vision-Request header for image content")
 	}
 
-	resp, err := c.httpClient.Do(httpReq)
+	resp, err := c.do(httpReq)
 	if err != nil {
-		return nil, fmt.Errorf("request failed: %w", err)
+		return nil, err
 	}
-	defer resp.Body.Close()
+	if c.usage != nil {
+		c.usage.Record(ctx, resolvedModel, initiator)
+	}
+	return resp, nil
+}
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("API error %d: %s", resp.StatusCode, string(body))
+// newRequest builds an authenticated JSON POST request to the Copilot API.
+func (c *Client) newRequest(ctx context.Context, path string, payload interface{}) (*http.Request, error) {
+	copilotToken, err := c.token(ctx)
+	if err != nil {
+		return nil, err
 	}
 
-	var result models.OpenAIChatResponse
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return nil, fmt.Errorf("failed to decode response: %w", err)
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
 	}
+	history.RecordUpstream(ctx, body)
 
-	return &result, nil
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", config.CopilotAPIBase+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Authorization", "Bearer "+copilotToken)
+	for k, v := range config.CopilotHeaders {
+		httpReq.Header.Set(k, v)
+	}
+	setRequestID(ctx, httpReq.Header)
+	tracing.Inject(ctx, httpReq.Header)
+
+	return httpReq, nil
 }
 
-// StreamCallback is called for each chunk in a streaming response.
-type StreamCallback func(chunk []byte) error
+// setRequestID forwards the inbound request ID so upstream calls can be
+// correlated with the proxy's logs.
+func setRequestID(ctx context.Context, h http.Header) {
+	if id := logging.RequestID(ctx); id != "" {
+		h.Set("X-Request-Id", id)
+	}
+}
+
+// token returns a Copilot API token, refreshing it if needed.
+func (c *Client) token(ctx context.Context) (string, error) {
+	_, span := tracing.StartSpan(ctx, "copilot.token", tracing.Internal)
+	defer span.End()
 
-// ChatCompletionsStream makes a streaming chat completions request.
-func (c *Client) ChatCompletionsStream(ctx context.Context, req *ChatRequest, callback StreamCallback) error {
 	creds, err := c.authManager.GetCredentials()
 	if err != nil {
-		return fmt.Errorf("failed to get credentials: %w", err)
+		err = fmt.Errorf("failed to get credentials: %w", err)
+		span.SetError(err)
+		return "", err
 	}
 
 	copilotToken, err := c.authManager.GetCopilotToken(creds)
 	if err != nil {
-		return fmt.Errorf("failed to get copilot token: %w", err)
+		err = fmt.Errorf("failed to get copilot token: %w", err)
+		span.SetError(err)
+		return "", err
 	}
+	return copilotToken, nil
+}
 
-	resolvedModel := models.ResolveModel(req.Model)
-	c.debugLog("Streaming request to model: %s (original: %s)", resolvedModel, req.Model)
+// do sends a request and turns non-200 responses into errors.
+func (c *Client) do(httpReq *http.Request) (*http.Response, error) {
+	span := tracing.SpanFromContext(httpReq.Context())
+	for attempt := 0; ; attempt++ {
+		resp, err := c.httpClient.Do(httpReq)
+		if err != nil {
+			return nil, fmt.Errorf("request failed: %w", err)
+		}
+		span.SetAttributes("http.response.status_code", resp.StatusCode)
+		if attempt > 0 {
+			span.SetAttributes("http.request.resend_count", attempt)
+		}
 
-	payload := map[string]interface{}{
-		"model":       resolvedModel,
-		"messages":    req.Messages,
-		"temperature": req.Temperature,
-		"max_tokens":  req.MaxTokens,
-		"stream":      true,
+		if resp.StatusCode == http.StatusOK {
+			return resp, nil
+		}
+
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		delay, ok := retryDelay(resp.StatusCode, resp.Header.Get("Retry-After"))
+		if attempt >= c.upstream.MaxRetries || !ok || httpReq.GetBody == nil {
+			return nil, fmt.Errorf("API error %d: %s", resp.StatusCode, string(body))
+		}
+
+		metrics.UpstreamRetries.Inc(strconv.Itoa(resp.StatusCode))
+		c.debugLog(httpReq.Context(), "Retrying upstream request", "status", resp.StatusCode, "delay", delay, "retry", attempt+1, "max_retries", c.upstream.MaxRetries)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-httpReq.Context().Done():
+			timer.Stop()
+			return nil, fmt.Errorf("request failed: %w", httpReq.Context().Err())
+		case <-timer.C:
+		}
+
+		if httpReq.Body, err = httpReq.GetBody(); err != nil {
+			return nil, fmt.Errorf("request failed: %w", err)
+		}
 	}
+}
 
-	if len(req.Tools) > 0 {
-		payload["tools"] = req.Tools
+// retryDelay returns how long to wait before retrying a rejected request,
+// and whether to retry at all. Only 429 and 503 responses asking to be
+// retried within 10 seconds with Retry-After are retried; gateway errors
+// such as 502 and 504 may come after upstream processed the request, so a
+// retry could bill a premium request twice.
+func retryDelay(status int, retryAfter string) (time.Duration, bool) {
+	if status != http.StatusTooManyRequests && status != http.StatusServiceUnavailable {
+		return 0, false
+	}
+	seconds, err := strconv.Atoi(retryAfter)
+	if err != nil || seconds < 0 || seconds > 10 {
+		return 0, false
 	}
+	return time.Duration(seconds) * time.Second, true
+}
 
-	body, err := json.Marshal(payload)
-	if err != nil {
-		return fmt.Errorf("failed to marshal request: %w", err)
+// Embeddings makes an embeddings request to Copilot API. The payload is
+// forwarded as-is apart from model alias resolution, and the raw OpenAI
+// format response is returned.
+func (c *Client) Embeddings(ctx context.Context, payload map[string]interface{}) (json.RawMessage, error) {
+	model, _ := payload["model"].(string)
+	if model != "" {
+		payload["model"] = models.ResolveModel(model)
+		c.debugLog(ctx, "Embeddings request", "model", payload["model"], "requested_model", model, "key", apikeys.NameFromContext(ctx))
 	}
 
-	httpReq, err := http.NewRequestWithContext(ctx, "POST", config.CopilotAPIBase+"/chat/completions", bytes.NewReader(body))
+	ctx, span := startSpan(ctx, "embeddings", models.ResolveModel(model))
+	defer span.End()
+
+	body, err := c.postEmbeddings(ctx, payload)
 	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+		span.SetError(err)
+		return nil, err
 	}
 
-	httpReq.Header.Set("Content-Type", "application/json")
-	httpReq.Header.Set("Authorization", "Bearer "+copilotToken)
-	httpReq.Header.Set("Openai-Intent", "conversation-edits")
-	for k, v := range config.CopilotHeaders {
-		httpReq.Header.Set(k, v)
+	var result struct {
+		Model string        `json:"model"`
+		Usage *models.Usage `json:"usage"`
+	}
+	if json.Unmarshal(body, &result) == nil {
+		c.observeTokens(ctx, models.ResolveModel(model), result.Usage)
+		traceResponse(span, result.Model, result.Usage, nil)
 	}
 
-	// Add vision header if images are present
-	if hasImageContent(req.Messages) {
-		httpReq.Header.Set("Copilot-Vision-Request", "true")
-		c.debugLog("Added Copilot-Vision-Request header for image content")
+	return body, nil
+}
+
+// postEmbeddings sends an embeddings request and returns the response body.
+func (c *Client) postEmbeddings(ctx context.Context, payload map[string]interface{}) ([]byte, error) {
+	httpReq, err := c.newRequest(ctx, "/embeddings", payload)
+	if err != nil {
+		return nil, err
 	}
 
-	resp, err := c.httpClient.Do(httpReq)
+	resp, err := c.do(httpReq)
 	if err != nil {
-		return fmt.Errorf("request failed: %w", err)
+		return nil, err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("API error %d: %s", resp.StatusCode, string(body))
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
 	}
+	if !json.Valid(body) {
+		return nil, fmt.Errorf("failed to decode response: invalid JSON")
+	}
+	return body, nil
+}
+
+// StreamCallback is called for each chunk in a streaming response.
+type StreamCallback func(chunk []byte) error
 
-	reader := bufio.NewReader(resp.Body)
+// ChatCompletionsStream makes a streaming chat completions request.
+func (c *Client) ChatCompletionsStream(ctx context.Context, req *ChatRequest, callback StreamCallback) error {
+	resolvedModel := models.ResolveModel(req.Model)
+	c.debugLog(ctx, "Streaming chat request", "model", resolvedModel, "requested_model", req.Model, "key", apikeys.NameFromContext(ctx))
+
+	ctx, span := startChatSpan(ctx, req, resolvedModel)
+	defer span.End()
+
+	resp, err := c.postChat(ctx, req, resolvedModel, true)
+	if err != nil {
+		span.SetError(err)
+		return err
+	}
+	defer resp.Body.Close()
+
+	err = c.relayStream(ctx, span, resp.Body, resolvedModel, callback)
+	span.SetError(err)
+	return err
+}
+
+// relayStream passes each streamed line to callback, observing the usage
+// and finish reasons it carries.
+func (c *Client) relayStream(ctx context.Context, span *tracing.Span, body io.Reader, model string, callback StreamCallback) error {
+	_, relay := tracing.StartSpan(ctx, "stream relay", tracing.Internal)
+	defer relay.End()
+
+	var (
+		responseModel string
+		usage         *models.Usage
+		finishReasons []string
+		chunks        int
+	)
+	defer func() {
+		relay.SetAttributes("gen_ai.response.chunks", chunks)
+		traceResponse(span, responseModel, usage, finishReasons)
+	}()
+
+	reader := bufio.NewReader(body)
 	for {
 		line, err := reader.ReadBytes('\n')
 		if err != nil {
 			if err == io.EOF {
 				break
 			}
-			return fmt.Errorf("read error: %w", err)
+			err = fmt.Errorf("read error: %w", err)
+			relay.SetError(err)
+			return err
 		}
 
 		line = bytes.TrimSpace(line)
 		if len(line) == 0 {
 			continue
 		}
+		metrics.FirstToken(ctx)
+		chunks++
+
+		if bytes.Contains(line, []byte(`"usage"`)) || bytes.Contains(line, []byte(`"finish_reason":"`)) {
+			if chunk, ok := parseStreamChunk(line); ok {
+				if chunk.Model != "" {
+					responseModel = chunk.Model
+				}
+				if chunk.Usage != nil {
+					usage = chunk.Usage
+					c.observeTokens(ctx, model, chunk.Usage)
+				}
+				for _, choice := range chunk.Choices {
+					if choice.FinishReason != "" {
+						finishReasons = append(finishReasons, choice.FinishReason)
+					}
+				}
+			}
+		}
 
 		if err := callback(line); err != nil {
+			relay.SetError(err)
 			return err
 		}
 	}
@@ -308,6 +689,25 @@ func (c *Client) ChatCompletionsStream(ctx context.Context, req *ChatRequest, ca
 	return nil
 }
 
+// streamChunk is the part of a streamed chunk the client observes.
+type streamChunk struct {
+	Model   string        `json:"model"`
+	Usage   *models.Usage `json:"usage"`
+	Choices []struct {
+		FinishReason string `json:"finish_reason"`
+	} `json:"choices"`
+}
+
+// parseStreamChunk decodes an SSE data line.
+func parseStreamChunk(line []byte) (streamChunk, bool) {
+	var chunk streamChunk
+	data, ok := bytes.CutPrefix(line, []byte("data:"))
+	if !ok {
+		return chunk, false
+	}
+	return chunk, json.Unmarshal(bytes.TrimSpace(data), &chunk) == nil
+}
+
 // hasImageContent checks if any message contains image content.
 func hasImageContent(messages []map[string]interface{}) bool {
 	for _, msg := range messages {
@@ -3,13 +3,16 @@ package copilot
 import (
 	"context"
 	"encoding/json"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/rahulvramesh/gh-proxy-local/internal/auth"
 	"github.com/rahulvramesh/gh-proxy-local/internal/config"
+	"github.com/rahulvramesh/gh-proxy-local/internal/metrics"
 	"github.com/rahulvramesh/gh-proxy-local/internal/models"
 )
 
@@ -38,7 +41,7 @@ func TestNewClient(t *testing.T) {
 	cfg := &config.Config{Debug: true}
 	authManager := auth.NewManager(cfg)
 
-	client := NewClient(authManager, true)
+	client := NewClient(authManager, config.UpstreamConfig{}, true)
 
 	if client.authManager != authManager {
 		t.Error("Expected auth manager to be set")
@@ -134,7 +137,7 @@ func TestHasImageContent(t *testing.T) {
 func TestClient_FetchModels_Cached(t *testing.T) {
 	cfg := &config.Config{}
 	authManager := auth.NewManager(cfg)
-	client := NewClient(authManager, false)
+	client := NewClient(authManager, config.UpstreamConfig{}, false)
 
 	// Set cached models
 	client.modelsCache = []models.CopilotModel{
@@ -159,7 +162,7 @@ func TestClient_FetchModels_Cached(t *testing.T) {
 func TestClient_FetchModels_ExpiredCache(t *testing.T) {
 	cfg := &config.Config{}
 	authManager := auth.NewManager(cfg)
-	client := NewClient(authManager, false)
+	client := NewClient(authManager, config.UpstreamConfig{}, false)
 
 	// Set expired cache
 	client.modelsCache = []models.CopilotModel{
@@ -179,7 +182,7 @@ func TestClient_FetchModels_ExpiredCache(t *testing.T) {
 func TestClient_FetchModels_Fallback(t *testing.T) {
 	cfg := &config.Config{}
 	authManager := auth.NewManager(cfg)
-	client := NewClient(authManager, false)
+	client := NewClient(authManager, config.UpstreamConfig{}, false)
 
 	// No cache, auth will fail, should return fallback
 	result, _ := client.FetchModels(context.Background())
@@ -247,14 +250,14 @@ func TestClient_DebugLog(t *testing.T) {
 	authManager := auth.NewManager(cfg)
 
 	// Test with debug enabled
-	client := NewClient(authManager, true)
+	client := NewClient(authManager, config.UpstreamConfig{}, true)
 	// This should not panic
-	client.debugLog("Test message: %s", "hello")
+	client.debugLog(context.Background(), "Test message", "value", "hello")
 
 	// Test with debug disabled
-	client = NewClient(authManager, false)
+	client = NewClient(authManager, config.UpstreamConfig{}, false)
 	// This should also not panic
-	client.debugLog("Test message: %s", "hello")
+	client.debugLog(context.Background(), "Test message", "value", "hello")
 }
 
 func TestClient_FetchModels_MockServer(t *testing.T) {
@@ -339,7 +342,7 @@ func TestHasImageContent_EdgeCases(t *testing.T) {
 func TestClient_ChatCompletions_NoAuth(t *testing.T) {
 	cfg := &config.Config{}
 	authManager := auth.NewManager(cfg)
-	client := NewClient(authManager, false)
+	client := NewClient(authManager, config.UpstreamConfig{}, false)
 
 	req := &ChatRequest{
 		Model: "gpt-4o",
@@ -358,7 +361,7 @@ func TestClient_ChatCompletions_NoAuth(t *testing.T) {
 func TestClient_ChatCompletionsStream_NoAuth(t *testing.T) {
 	cfg := &config.Config{}
 	authManager := auth.NewManager(cfg)
-	client := NewClient(authManager, false)
+	client := NewClient(authManager, config.UpstreamConfig{}, false)
 
 	req := &ChatRequest{
 		Model: "gpt-4o",
@@ -393,7 +396,7 @@ func TestStreamCallback(t *testing.T) {
 func TestClient_ModelsCache_ThreadSafety(t *testing.T) {
 	cfg := &config.Config{}
 	authManager := auth.NewManager(cfg)
-	client := NewClient(authManager, false)
+	client := NewClient(authManager, config.UpstreamConfig{}, false)
 
 	// Set initial cache
 	client.modelsCache = []models.CopilotModel{
@@ -417,3 +420,155 @@ func TestClient_ModelsCache_ThreadSafety(t *testing.T) {
 		<-done
 	}
 }
+
+func TestDetectInitiator(t *testing.T) {
+	tests := []struct {
+		name     string
+		messages []map[string]interface{}
+		expected string
+	}{
+		{"empty", nil, InitiatorUser},
+		{"user question", []map[string]interface{}{
+			{"role": "system", "content": "sys"},
+			{"role": "user", "content": "hi"},
+		}, InitiatorUser},
+		{"tool result", []map[string]interface{}{
+			{"role": "user", "content": "hi"},
+			{"role": "assistant", "tool_calls": []interface{}{}},
+			{"role": "tool", "tool_call_id": "1", "content": "ok"},
+		}, InitiatorAgent},
+		{"tool images follow-up", []map[string]interface{}{
+			{"role": "tool", "tool_call_id": "1", "content": "see image"},
+			{"role": "user", "content": []interface{}{}},
+		}, InitiatorAgent},
+		{"assistant continuation", []map[string]interface{}{
+			{"role": "user", "content": "hi"},
+			{"role": "assistant", "content": "Hello"},
+		}, InitiatorAgent},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := detectInitiator(tt.messages); got != tt.expected {
+				t.Errorf("Expected %s, got %s", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestInitiatorPrefill(t *testing.T) {
+	client := NewClient(auth.NewManager(&config.Config{}), config.UpstreamConfig{}, false)
+	// A converted assistant prefill ends in a synthetic user instruction
+	instruction := map[string]interface{}{"role": "user", "content": "Begin your reply with exactly the text between the markers below"}
+
+	fresh := &ChatRequest{Prefill: true, Messages: []map[string]interface{}{
+		{"role": "user", "content": "Write a haiku"},
+		instruction,
+	}}
+	if got := client.initiator(context.Background(), fresh); got != InitiatorUser {
+		t.Errorf("Expected a new user prompt with a prefill to be a user turn, got %s", got)
+	}
+
+	afterTool := &ChatRequest{Prefill: true, Messages: []map[string]interface{}{
+		{"role": "user", "content": "What is the weather?"},
+		{"role": "assistant", "tool_calls": []interface{}{}},
+		{"role": "tool", "tool_call_id": "1", "content": "sunny"},
+		instruction,
+	}}
+	if got := client.initiator(context.Background(), afterTool); got != InitiatorAgent {
+		t.Errorf("Expected a prefill after a tool result to be an agent turn, got %s", got)
+	}
+	if got := client.initiator(WithInitiator(context.Background(), InitiatorUser), afterTool); got != InitiatorUser {
+		t.Errorf("Expected forced initiator to win, got %s", got)
+	}
+}
+
+func TestWithInitiator(t *testing.T) {
+	ctx := WithInitiator(context.Background(), InitiatorAgent)
+	if got := initiatorFromContext(ctx); got != InitiatorAgent {
+		t.Errorf("Expected agent, got %q", got)
+	}
+
+	ctx = WithInitiator(context.Background(), "robot")
+	if got := initiatorFromContext(ctx); got != "" {
+		t.Errorf("Expected invalid initiator to be ignored, got %q", got)
+	}
+}
+
+func TestClient_DoRetries(t *testing.T) {
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		if len(bodies) == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(nil, config.UpstreamConfig{MaxRetries: 2}, false)
+	before := metrics.UpstreamRetries.Value("503")
+
+	req, _ := http.NewRequest("POST", server.URL, strings.NewReader(`{"model":"gpt-4.1"}`))
+	resp, err := client.do(req)
+	if err != nil {
+		t.Fatalf("do failed: %v", err)
+	}
+	resp.Body.Close()
+
+	if len(bodies) != 2 || bodies[1] != `{"model":"gpt-4.1"}` {
+		t.Errorf("upstream saw bodies %q", bodies)
+	}
+	if got := metrics.UpstreamRetries.Value("503") - before; got != 1 {
+		t.Errorf("retries counted = %v, want 1", got)
+	}
+
+	// Retries are off by default
+	client.upstream.MaxRetries = 0
+	bodies = nil
+	req, _ = http.NewRequest("POST", server.URL, strings.NewReader(`{}`))
+	if _, err := client.do(req); err == nil || !strings.Contains(err.Error(), "API error 503") {
+		t.Errorf("expected API error 503, got %v", err)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		status     int
+		retryAfter string
+		want       bool
+	}{
+		{http.StatusTooManyRequests, "2", true},
+		{http.StatusServiceUnavailable, "0", true},
+		{http.StatusServiceUnavailable, "", false},
+		{http.StatusServiceUnavailable, "60", false},
+		{http.StatusBadGateway, "1", false},
+		{http.StatusGatewayTimeout, "1", false},
+	}
+	for _, tt := range tests {
+		if _, got := retryDelay(tt.status, tt.retryAfter); got != tt.want {
+			t.Errorf("retryDelay(%d, %q) retries = %v, want %v", tt.status, tt.retryAfter, got, tt.want)
+		}
+	}
+}
+
+func TestClient_KnownModel(t *testing.T) {
+	client := NewClient(auth.NewManager(&config.Config{}), config.UpstreamConfig{}, false)
+	client.modelsCache = []models.CopilotModel{{ID: "gpt-4.1"}}
+	client.modelsCacheTime = time.Now()
+	client.knownModels = map[string]bool{"gpt-4.1": true, "text-embedding-3-small": true}
+
+	for model, want := range map[string]bool{
+		"gpt-4.1":                true,
+		"text-embedding-3-small": true,
+		"":                       false,
+		"made-up-model-123":      false,
+	} {
+		if got := client.KnownModel(context.Background(), model); got != want {
+			t.Errorf("KnownModel(%q) = %v, want %v", model, got, want)
+		}
+	}
+}
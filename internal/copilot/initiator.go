@@ -0,0 +1,50 @@
+package copilot
+
+import "context"
+
+// Initiator values for the X-Initiator header. Copilot bills premium
+// requests for user-initiated turns; agent turns such as tool loop
+// iterations are not charged again.
+const (
+	InitiatorUser  = "user"
+	InitiatorAgent = "agent"
+)
+
+type initiatorKey struct{}
+
+// WithInitiator returns a context that forces the X-Initiator header of
+// chat requests made with it. Invalid values are ignored.
+func WithInitiator(ctx context.Context, initiator string) context.Context {
+	if initiator != InitiatorUser && initiator != InitiatorAgent {
+		return ctx
+	}
+	return context.WithValue(ctx, initiatorKey{}, initiator)
+}
+
+// initiatorFromContext returns the initiator forced by WithInitiator.
+func initiatorFromContext(ctx context.Context) string {
+	initiator, _ := ctx.Value(initiatorKey{}).(string)
+	return initiator
+}
+
+// detectInitiator derives the initiator from a converted conversation. A
+// conversation ending in a tool result or an assistant message continues an
+// agent turn; user messages that directly follow tool results (such as
+// carried-over tool images) belong to the same turn.
+func detectInitiator(messages []map[string]interface{}) string {
+	if len(messages) == 0 {
+		return InitiatorUser
+	}
+
+	switch role, _ := messages[len(messages)-1]["role"].(string); role {
+	case "tool", "assistant":
+		return InitiatorAgent
+	case "user":
+		if len(messages) >= 2 {
+			if prev, _ := messages[len(messages)-2]["role"].(string); prev == "tool" {
+				return InitiatorAgent
+			}
+		}
+	}
+	return InitiatorUser
+}
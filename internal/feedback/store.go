@@ -0,0 +1,151 @@
+// Package feedback keeps the feedback clients attach to proxy traces.
+package feedback
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rahulvramesh/gh-proxy-local/internal/storage"
+)
+
+// Feedback is one score a client gave a trace.
+type Feedback struct {
+	ID      string `json:"id"`
+	TraceID string `json:"trace_id"`
+	Name    string `json:"name"`
+	// Value is a float64, bool or string.
+	Value     interface{} `json:"value"`
+	Comment   string      `json:"comment,omitempty"`
+	Key       string      `json:"api_key"`
+	CreatedAt time.Time   `json:"created_at"`
+}
+
+// compactSlack is how far the feedback file may outgrow the retained
+// feedback before it is rewritten.
+const compactSlack = 1 << 20
+
+// stored is feedback with its encoding, which bounds the store's size.
+type stored struct {
+	traceID string
+	line    []byte
+}
+
+// Store is a history of feedback, kept in a JSONL file and indexed by
+// trace in memory. The oldest feedback is evicted once the history
+// reaches its size limit.
+type Store struct {
+	path     string
+	maxBytes int64
+
+	mu        sync.RWMutex
+	entries   []stored
+	byTrace   map[string][]Feedback
+	total     int64
+	fileBytes int64
+}
+
+// NewStore opens the feedback history at path, bounded by maxBytes.
+func NewStore(path string, maxBytes int64) (*Store, error) {
+	s := &Store{path: path, maxBytes: maxBytes, byTrace: make(map[string][]Feedback)}
+
+	err := storage.ReadJSONL(path, func(line []byte) error {
+		var f Feedback
+		if err := json.Unmarshal(line, &f); err != nil || f.TraceID == "" {
+			return nil
+		}
+		s.entries = append(s.entries, stored{traceID: f.TraceID, line: append([]byte(nil), line...)})
+		s.byTrace[f.TraceID] = append(s.byTrace[f.TraceID], f)
+		s.total += int64(len(line) + 1)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read feedback: %w", err)
+	}
+	s.fileBytes = s.total
+	s.prune()
+	if err := s.compact(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Add records f, assigning its ID and creation time when unset, and
+// returns the stored feedback.
+func (s *Store) Add(f Feedback) (Feedback, error) {
+	if f.ID == "" {
+		f.ID = "fb_" + uuid.New().String()
+	}
+	if f.CreatedAt.IsZero() {
+		f.CreatedAt = time.Now().UTC()
+	}
+
+	line, err := json.Marshal(f)
+	if err != nil {
+		return Feedback{}, fmt.Errorf("failed to marshal feedback: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := storage.AppendLines(s.path, [][]byte{line}); err != nil {
+		return Feedback{}, err
+	}
+	size := int64(len(line) + 1)
+	s.entries = append(s.entries, stored{traceID: f.TraceID, line: line})
+	s.byTrace[f.TraceID] = append(s.byTrace[f.TraceID], f)
+	s.total += size
+	s.fileBytes += size
+	s.prune()
+	if err := s.compact(); err != nil {
+		return Feedback{}, err
+	}
+	return f, nil
+}
+
+// prune evicts the oldest feedback beyond the size bound. The caller must
+// hold mu.
+func (s *Store) prune() {
+	n := 0
+	for n < len(s.entries) && s.total > s.maxBytes {
+		e := s.entries[n]
+		// Feedback is indexed oldest first, so a trace's oldest is first
+		if rest := s.byTrace[e.traceID][1:]; len(rest) > 0 {
+			s.byTrace[e.traceID] = rest
+		} else {
+			delete(s.byTrace, e.traceID)
+		}
+		s.total -= int64(len(e.line) + 1)
+		n++
+	}
+	if n > 0 {
+		s.entries = append([]stored(nil), s.entries[n:]...)
+	}
+}
+
+// compact rewrites the feedback file once evicted feedback makes up most
+// of it, or all of it. The caller must hold mu.
+func (s *Store) compact() error {
+	if s.fileBytes == s.total || s.total > 0 && s.fileBytes <= 2*s.total+compactSlack {
+		return nil
+	}
+	var buf bytes.Buffer
+	for _, e := range s.entries {
+		buf.Write(e.line)
+		buf.WriteByte('\n')
+	}
+	if err := storage.WriteFile(s.path, buf.Bytes()); err != nil {
+		return fmt.Errorf("failed to compact feedback: %w", err)
+	}
+	s.fileBytes = s.total
+	return nil
+}
+
+// ForTrace returns the feedback given to a trace, oldest first.
+func (s *Store) ForTrace(traceID string) []Feedback {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]Feedback(nil), s.byTrace[traceID]...)
+}
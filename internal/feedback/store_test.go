@@ -0,0 +1,76 @@
+package feedback
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStoreAddAndReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "feedback.jsonl")
+	store, err := NewStore(path, 1<<20)
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+
+	f, err := store.Add(Feedback{TraceID: "trace-1", Name: "thumbs", Value: true, Key: "ide"})
+	if err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	if !strings.HasPrefix(f.ID, "fb_") || f.CreatedAt.IsZero() {
+		t.Errorf("expected ID and creation time to be set, got %+v", f)
+	}
+	store.Add(Feedback{TraceID: "trace-1", Name: "rating", Value: 4.0, Comment: "good"})
+	store.Add(Feedback{TraceID: "trace-2", Name: "thumbs", Value: false})
+
+	reloaded, err := NewStore(path, 1<<20)
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	got := reloaded.ForTrace("trace-1")
+	if len(got) != 2 {
+		t.Fatalf("expected 2 entries for trace-1, got %d", len(got))
+	}
+	if got[0].ID != f.ID || got[0].Value != true || got[1].Comment != "good" {
+		t.Errorf("unexpected feedback after reload: %+v", got)
+	}
+	if len(reloaded.ForTrace("missing")) != 0 {
+		t.Error("expected no feedback for unknown trace")
+	}
+}
+
+func TestStoreEvictsOldest(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "feedback.jsonl")
+	store, err := NewStore(path, 1<<20)
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	comment := strings.Repeat("x", 1000)
+	for i := 0; i < 5; i++ {
+		store.Add(Feedback{TraceID: "trace-1", Name: "rating", Value: float64(i), Comment: comment})
+	}
+	store.Add(Feedback{TraceID: "trace-2", Name: "thumbs", Value: true, Comment: comment})
+
+	// Room for three entries
+	bounded, err := NewStore(path, 4000)
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	got := bounded.ForTrace("trace-1")
+	if len(got) != 2 || got[0].Value != 3.0 || got[1].Value != 4.0 {
+		t.Errorf("expected the two newest trace-1 entries to remain, got %+v", got)
+	}
+	if len(bounded.ForTrace("trace-2")) != 1 {
+		t.Error("expected trace-2 feedback to remain")
+	}
+
+	for i := 0; i < 3; i++ {
+		bounded.Add(Feedback{TraceID: "trace-3", Name: "thumbs", Value: false, Comment: comment})
+	}
+	if len(bounded.ForTrace("trace-1")) != 0 || len(bounded.ForTrace("trace-3")) != 3 {
+		t.Errorf("expected new feedback to evict the oldest")
+	}
+	if reloaded, _ := NewStore(path, 4000); len(reloaded.ForTrace("trace-3")) != 3 || len(reloaded.ForTrace("trace-2")) != 0 {
+		t.Error("expected eviction to survive a reload")
+	}
+}
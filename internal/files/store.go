@@ -0,0 +1,206 @@
+// Package files provides a local store for uploaded and generated files,
+// modelled on the OpenAI Files API.
+package files
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rahulvramesh/gh-proxy-local/internal/storage"
+)
+
+// DefaultMaxSize is the default upload size limit, matching the Batch API.
+const DefaultMaxSize = 200 << 20
+
+// ErrTooLarge is returned when a file exceeds the store's size limit.
+var ErrTooLarge = errors.New("file exceeds maximum size")
+
+// File describes a stored file in OpenAI file object format.
+type File struct {
+	ID        string `json:"id"`
+	Object    string `json:"object"`
+	Bytes     int64  `json:"bytes"`
+	CreatedAt int64  `json:"created_at"`
+	Filename  string `json:"filename"`
+	Purpose   string `json:"purpose"`
+	Status    string `json:"status"`
+	// APIKey names the client key that owns the file. It is kept in the
+	// store's metadata but not shown to clients.
+	APIKey string `json:"-"`
+}
+
+// record is the metadata kept on disk for a file.
+type record struct {
+	File
+	APIKey string `json:"api_key,omitempty"`
+}
+
+// Store keeps file metadata and contents on disk.
+type Store struct {
+	dir     string
+	maxSize int64
+
+	mu    sync.RWMutex
+	files map[string]*File
+}
+
+// NewStore opens the store rooted at dir. Files larger than maxSize bytes
+// are rejected.
+func NewStore(dir string, maxSize int64) (*Store, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create files directory: %w", err)
+	}
+
+	s := &Store{dir: dir, maxSize: maxSize, files: make(map[string]*File)}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read files directory: %w", err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		var rec record
+		if err := storage.ReadJSON(filepath.Join(dir, entry.Name()), &rec); err != nil {
+			continue
+		}
+		f := rec.File
+		f.APIKey = rec.APIKey
+		s.files[f.ID] = &f
+	}
+
+	return s, nil
+}
+
+func (s *Store) metaPath(id string) string {
+	return filepath.Join(s.dir, id+".json")
+}
+
+func (s *Store) dataPath(id string) string {
+	return filepath.Join(s.dir, id+".data")
+}
+
+// Create stores the content read from r under a new file ID, owned by
+// apiKey.
+func (s *Store) Create(apiKey, filename, purpose string, r io.Reader) (*File, error) {
+	id := "file-" + strings.ReplaceAll(uuid.New().String(), "-", "")[:24]
+
+	out, err := os.OpenFile(s.dataPath(id), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create file: %w", err)
+	}
+
+	limit := s.maxSize
+	if limit <= 0 {
+		limit = 1<<63 - 1
+	}
+	n, err := io.Copy(out, io.LimitReader(r, limit+1))
+	closeErr := out.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil && n > limit {
+		err = ErrTooLarge
+	}
+	if err != nil {
+		os.Remove(s.dataPath(id))
+		if errors.Is(err, ErrTooLarge) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to write file: %w", err)
+	}
+
+	f := &File{
+		ID:        id,
+		Object:    "file",
+		Bytes:     n,
+		CreatedAt: time.Now().Unix(),
+		Filename:  filename,
+		Purpose:   purpose,
+		Status:    "processed",
+		APIKey:    apiKey,
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := storage.WriteJSON(s.metaPath(id), record{File: *f, APIKey: apiKey}); err != nil {
+		os.Remove(s.dataPath(id))
+		return nil, err
+	}
+	s.files[id] = f
+
+	copied := *f
+	return &copied, nil
+}
+
+// Get returns the metadata of a file.
+func (s *Store) Get(id string) (*File, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	f, ok := s.files[id]
+	if !ok {
+		return nil, false
+	}
+	copied := *f
+	return &copied, true
+}
+
+// List returns files with the given purpose (all files if empty), newest
+// first.
+func (s *Store) List(purpose string) []*File {
+	s.mu.RLock()
+	result := make([]*File, 0, len(s.files))
+	for _, f := range s.files {
+		if purpose != "" && f.Purpose != purpose {
+			continue
+		}
+		copied := *f
+		result = append(result, &copied)
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].CreatedAt == result[j].CreatedAt {
+			return result[i].ID > result[j].ID
+		}
+		return result[i].CreatedAt > result[j].CreatedAt
+	})
+	return result
+}
+
+// Open returns a reader for the file's content.
+func (s *Store) Open(id string) (io.ReadCloser, error) {
+	if _, ok := s.Get(id); !ok {
+		return nil, fmt.Errorf("file %s not found", id)
+	}
+	f, err := os.Open(s.dataPath(id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	return f, nil
+}
+
+// Delete removes a file and its content.
+func (s *Store) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.files[id]; !ok {
+		return fmt.Errorf("file %s not found", id)
+	}
+	if err := os.Remove(s.metaPath(id)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete file: %w", err)
+	}
+	os.Remove(s.dataPath(id))
+	delete(s.files, id)
+	return nil
+}
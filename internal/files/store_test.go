@@ -0,0 +1,81 @@
+package files
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestStoreCreateAndReload(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewStore(dir, 1024)
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+
+	f, err := store.Create("ci", "input.jsonl", "batch", strings.NewReader("{\"a\":1}\n"))
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if !strings.HasPrefix(f.ID, "file-") {
+		t.Errorf("expected file- prefix, got %s", f.ID)
+	}
+	if f.Bytes != 8 || f.Object != "file" || f.Purpose != "batch" {
+		t.Errorf("unexpected file metadata: %+v", f)
+	}
+
+	reloaded, err := NewStore(dir, 1024)
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	got, ok := reloaded.Get(f.ID)
+	if !ok {
+		t.Fatal("expected file to survive reload")
+	}
+	if got.Filename != "input.jsonl" || got.APIKey != "ci" {
+		t.Errorf("expected input.jsonl owned by ci, got %s owned by %q", got.Filename, got.APIKey)
+	}
+
+	content, err := reloaded.Open(f.ID)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	data, _ := io.ReadAll(content)
+	content.Close()
+	if string(data) != "{\"a\":1}\n" {
+		t.Errorf("unexpected content %q", data)
+	}
+
+	if err := reloaded.Delete(f.ID); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, ok := reloaded.Get(f.ID); ok {
+		t.Error("expected file to be deleted")
+	}
+}
+
+func TestStoreRejectsLargeFiles(t *testing.T) {
+	store, _ := NewStore(t.TempDir(), 4)
+
+	_, err := store.Create("ci", "big.jsonl", "batch", strings.NewReader("too large"))
+	if !errors.Is(err, ErrTooLarge) {
+		t.Errorf("expected ErrTooLarge, got %v", err)
+	}
+	if len(store.List("")) != 0 {
+		t.Error("expected rejected file not to be listed")
+	}
+}
+
+func TestStoreListFiltersByPurpose(t *testing.T) {
+	store, _ := NewStore(t.TempDir(), 0)
+	store.Create("ci", "a.jsonl", "batch", strings.NewReader("a"))
+	store.Create("ci", "b.jsonl", "batch_output", strings.NewReader("b"))
+
+	if got := len(store.List("batch")); got != 1 {
+		t.Errorf("expected 1 batch file, got %d", got)
+	}
+	if got := len(store.List("")); got != 2 {
+		t.Errorf("expected 2 files, got %d", got)
+	}
+}
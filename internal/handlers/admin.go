@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/rahulvramesh/gh-proxy-local/internal/auth"
+	"github.com/rahulvramesh/gh-proxy-local/internal/copilot"
+	"github.com/rahulvramesh/gh-proxy-local/internal/langfuse"
+	"github.com/rahulvramesh/gh-proxy-local/internal/logging"
+)
+
+// Reloader reloads part of the configuration and summarizes what it
+// loaded.
+type Reloader func() (string, error)
+
+// AdminHandler handles the runtime operations of the admin API.
+type AdminHandler struct {
+	client      *copilot.Client
+	authManager *auth.Manager
+	langfuse    *langfuse.Client
+	reloaders   map[string]Reloader
+}
+
+// NewAdminHandler creates a new admin operations handler. reloaders are
+// run by Reload, by name.
+func NewAdminHandler(client *copilot.Client, authManager *auth.Manager, langfuseClient *langfuse.Client, reloaders map[string]Reloader) *AdminHandler {
+	return &AdminHandler{client: client, authManager: authManager, langfuse: langfuseClient, reloaders: reloaders}
+}
+
+// Reload handles POST /admin/reload, running every reloader. A reloader
+// that fails keeps its previous configuration; the others still apply.
+func (h *AdminHandler) Reload(w http.ResponseWriter, r *http.Request) {
+	names := make([]string, 0, len(h.reloaders))
+	for name := range h.reloaders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	reloaded := make(map[string]string)
+	var failed []string
+	for _, name := range names {
+		summary, err := h.reloaders[name]()
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", name, err))
+			continue
+		}
+		reloaded[name] = summary
+	}
+	if len(failed) > 0 {
+		writeOpenAIError(w, http.StatusInternalServerError, "Reload failed for "+strings.Join(failed, "; "))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"object":   "reload",
+		"reloaded": reloaded,
+	})
+}
+
+// FlushModels handles POST /admin/models/flush, emptying the models cache.
+func (h *AdminHandler) FlushModels(w http.ResponseWriter, r *http.Request) {
+	h.client.FlushModels()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"object":  "models_cache",
+		"flushed": true,
+	})
+}
+
+// RefreshToken handles POST /admin/token/refresh, fetching a new Copilot
+// API token.
+func (h *AdminHandler) RefreshToken(w http.ResponseWriter, r *http.Request) {
+	expires, err := h.authManager.RefreshCopilotToken()
+	if err != nil {
+		writeOpenAIError(w, http.StatusBadGateway, fmt.Sprintf("Token refresh failed: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"object":     "copilot_token",
+		"refreshed":  true,
+		"expires_at": expires.UTC(),
+	})
+}
+
+// langfuseQueue reports the Langfuse client's queue and spool.
+func (h *AdminHandler) langfuseQueue(w http.ResponseWriter) {
+	events, bytes := h.langfuse.SpoolStats()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"object":       "langfuse_queue",
+		"enabled":      h.langfuse.IsEnabled(),
+		"queued":       h.langfuse.QueueDepth(),
+		"spool_events": events,
+		"spool_bytes":  bytes,
+	})
+}
+
+// LangfuseQueue handles GET /admin/langfuse, reporting the events waiting
+// to be sent and those spooled to disk.
+func (h *AdminHandler) LangfuseQueue(w http.ResponseWriter, r *http.Request) {
+	h.langfuseQueue(w)
+}
+
+// FlushLangfuse handles POST /admin/langfuse/flush, sending the queued
+// events now and retrying the spool.
+func (h *AdminHandler) FlushLangfuse(w http.ResponseWriter, r *http.Request) {
+	h.langfuse.Flush()
+	h.langfuseQueue(w)
+}
+
+// debugStatus reports the runtime debug switch.
+func debugStatus(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"object":    "debug",
+		"enabled":   logging.DebugEnabled(),
+		"log_level": strings.ToLower(logging.Level().String()),
+	})
+}
+
+// Debug handles GET /admin/debug
+func (h *AdminHandler) Debug(w http.ResponseWriter, r *http.Request) {
+	debugStatus(w)
+}
+
+// SetDebug handles PUT /admin/debug, switching debug logging on or off
+// with {"enabled": true|false}.
+func (h *AdminHandler) SetDebug(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Enabled *bool `json:"enabled"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, fmt.Sprintf("Invalid request body: %v", err))
+		return
+	}
+	if req.Enabled == nil {
+		writeOpenAIError(w, http.StatusBadRequest, "enabled: must be true or false")
+		return
+	}
+
+	logging.SetDebug(*req.Enabled)
+	debugStatus(w)
+}
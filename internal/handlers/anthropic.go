@@ -1,35 +1,43 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"strings"
-	"time"
 
 	"github.com/google/uuid"
+	"github.com/rahulvramesh/gh-proxy-local/internal/apikeys"
+	"github.com/rahulvramesh/gh-proxy-local/internal/budget"
 	"github.com/rahulvramesh/gh-proxy-local/internal/converter"
 	"github.com/rahulvramesh/gh-proxy-local/internal/copilot"
 	"github.com/rahulvramesh/gh-proxy-local/internal/langfuse"
+	"github.com/rahulvramesh/gh-proxy-local/internal/logging"
+	"github.com/rahulvramesh/gh-proxy-local/internal/ratelimit"
+	"github.com/rahulvramesh/gh-proxy-local/internal/telemetry"
+	"github.com/rahulvramesh/gh-proxy-local/internal/tracing"
 )
 
 // AnthropicHandler handles Anthropic messages API endpoints.
 type AnthropicHandler struct {
-	client   *copilot.Client
-	langfuse *langfuse.Client
-	debug    bool
+	client  *copilot.Client
+	sink    telemetry.Sink
+	budgets *budget.Tracker
+	fetcher *converter.Fetcher
+	debug   bool
 }
 
-// NewAnthropicHandler creates a new Anthropic handler.
-func NewAnthropicHandler(client *copilot.Client, langfuseClient *langfuse.Client, debug bool) *AnthropicHandler {
-	return &AnthropicHandler{client: client, langfuse: langfuseClient, debug: debug}
+// NewAnthropicHandler creates a new Anthropic handler. fetcher may be nil,
+// in which case url-sourced images are passed through to the upstream.
+func NewAnthropicHandler(client *copilot.Client, sink telemetry.Sink, budgets *budget.Tracker, fetcher *converter.Fetcher, debug bool) *AnthropicHandler {
+	return &AnthropicHandler{client: client, sink: sink, budgets: budgets, fetcher: fetcher, debug: debug}
 }
 
 // Messages handles POST /v1/messages and /messages
 func (h *AnthropicHandler) Messages(w http.ResponseWriter, r *http.Request) {
-	startTime := time.Now()
-	traceID := langfuse.GenerateTraceID()
-	genID := langfuse.GenerateSpanID()
+	gen := newGeneration(w, "anthropic-message", "messages", "anthropic")
 
 	var req struct {
 		Model         string                   `json:"model"`
@@ -41,16 +49,37 @@ func (h *AnthropicHandler) Messages(w http.ResponseWriter, r *http.Request) {
 		Tools         []interface{}            `json:"tools"`
 		ToolChoice    interface{}              `json:"tool_choice"`
 		StopSequences []string                 `json:"stop_sequences"`
+		Metadata      struct {
+			UserID string `json:"user_id"`
+		} `json:"metadata"`
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+	if err := decodeRequest(r, &req); err != nil {
 		http.Error(w, fmt.Sprintf("Invalid request body: %v", err), http.StatusBadRequest)
 		return
 	}
 
-	systemText := converter.ExtractSystemText(req.System)
-	messages := converter.ConvertAnthropicToCopilotMessages(req.Messages, systemText)
+	model, err := apikeys.CheckModel(r.Context(), req.Model)
+	if err != nil {
+		writeAnthropicError(w, http.StatusForbidden, err.Error())
+		return
+	}
+	req.Model = model
+	setMetricsModel(r.Context(), h.client, model)
+	gen.model, gen.input = model, req.Messages
+
+	if err := h.budgets.Check(r.Context()); err != nil {
+		writeAnthropicError(w, http.StatusTooManyRequests, err.Error())
+		return
+	}
+
+	convertCtx, convertSpan := tracing.StartSpan(r.Context(), "convert request", tracing.Internal)
+	converter.ResolveURLSources(convertCtx, req.Messages, h.fetcher)
+
+	prefill := converter.AssistantPrefill(req.Messages)
+	messages := converter.ConvertAnthropicToCopilotMessages(req.Messages, req.System, h.conversionOptions(convertCtx, req.Model))
 	tools := converter.ConvertAnthropicTools(req.Tools)
+	convertSpan.End()
 
 	temperature := 0.7
 	if req.Temperature != nil {
@@ -69,10 +98,20 @@ func (h *AnthropicHandler) Messages(w http.ResponseWriter, r *http.Request) {
 		MaxTokens:   maxTokens,
 		Stream:      req.Stream,
 		Tools:       tools,
+		Prefill:     prefill != "",
 	}
+	gen.attribute(r, req.Metadata.UserID, chatReq)
+
+	release, err := h.client.Admit(r.Context(), chatReq)
+	if err != nil {
+		ratelimit.SetRetryAfter(w.Header(), err)
+		writeAnthropicError(w, http.StatusTooManyRequests, err.Error())
+		return
+	}
+	defer release()
 
 	if req.Stream {
-		h.streamMessages(w, r, chatReq, req.Model, traceID, genID, startTime, req.Messages)
+		h.streamMessages(w, r, chatReq, req.Model, prefill, gen)
 		return
 	}
 
@@ -82,61 +121,76 @@ func (h *AnthropicHandler) Messages(w http.ResponseWriter, r *http.Request) {
 		if strings.Contains(err.Error(), "API error") {
 			statusCode = http.StatusBadGateway
 		}
-		h.trackGeneration(traceID, genID, req.Model, req.Messages, nil, nil, startTime, "ERROR", err.Error(), r)
+		gen.track(r, h.sink, h.budgets, nil, nil, err)
 		http.Error(w, err.Error(), statusCode)
 		return
 	}
 
 	// Convert to Anthropic format
+	_, convertSpan = tracing.StartSpan(r.Context(), "convert response", tracing.Internal)
 	respMap := make(map[string]interface{})
 	data, _ := json.Marshal(resp)
 	json.Unmarshal(data, &respMap)
 
 	anthropicResp := converter.ConvertOpenAIResponseToAnthropic(respMap, req.Model)
+	if prefill != "" {
+		stripPrefillFromContent(anthropicResp, prefill)
+	}
+	convertSpan.End()
 
 	// Track to Langfuse
+	out := responseOutput(resp)
+	if prefill != "" {
+		text := converter.StripPrefill(out.content.String(), prefill)
+		out.content.Reset()
+		out.content.WriteString(text)
+	}
+	gen.finishReason = out.finishReason
 	usage := &langfuse.UsageData{
 		PromptTokens:     resp.Usage.PromptTokens,
 		CompletionTokens: resp.Usage.CompletionTokens,
 		TotalTokens:      resp.Usage.TotalTokens,
 	}
-	h.trackGeneration(traceID, genID, req.Model, req.Messages, anthropicResp, usage, startTime, "", "", r)
+	gen.track(r, h.sink, h.budgets, out.message(), usage, nil)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(anthropicResp)
 }
 
-// trackGeneration sends generation data to Langfuse.
-func (h *AnthropicHandler) trackGeneration(traceID, genID, model string, input, output interface{}, usage *langfuse.UsageData, startTime time.Time, level, statusMessage string, r *http.Request) {
-	if h.langfuse == nil || !h.langfuse.IsEnabled() {
+// stripPrefillFromContent removes an echoed prefill from the first text
+// block of a response, dropping the block if nothing remains.
+func stripPrefillFromContent(resp map[string]interface{}, prefill string) {
+	content, _ := resp["content"].([]interface{})
+	for i, block := range content {
+		blockMap, ok := block.(map[string]interface{})
+		if !ok || blockMap["type"] != "text" {
+			continue
+		}
+		text, _ := blockMap["text"].(string)
+		if text = converter.StripPrefill(text, prefill); text == "" {
+			resp["content"] = append(content[:i:i], content[i+1:]...)
+		} else {
+			blockMap["text"] = text
+		}
 		return
 	}
+}
 
-	metadata := map[string]interface{}{
-		"endpoint": "messages",
-		"api":      "anthropic",
+// conversionOptions describes the target model for message conversion.
+// Unknown models are assumed to accept images.
+func (h *AnthropicHandler) conversionOptions(ctx context.Context, model string) converter.AnthropicOptions {
+	var opts converter.AnthropicOptions
+	if h.client == nil {
+		return opts
 	}
-
-	gen := &langfuse.GenerationBody{
-		ID:            genID,
-		TraceID:       traceID,
-		Name:          "anthropic-message",
-		Model:         model,
-		Input:         input,
-		Output:        output,
-		Usage:         usage,
-		Metadata:      metadata,
-		StartTime:     startTime,
-		EndTime:       time.Now(),
-		Level:         level,
-		StatusMessage: statusMessage,
+	if m := h.client.FindModel(ctx, model); m != nil && m.Capabilities != nil {
+		opts.NoVision = !m.Capabilities.Vision
 	}
-
-	h.langfuse.TrackGeneration(gen)
+	return opts
 }
 
 // streamMessages handles streaming for Anthropic messages.
-func (h *AnthropicHandler) streamMessages(w http.ResponseWriter, r *http.Request, req *copilot.ChatRequest, model string, traceID, genID string, startTime time.Time, inputMessages []map[string]interface{}) {
+func (h *AnthropicHandler) streamMessages(w http.ResponseWriter, r *http.Request, req *copilot.ChatRequest, model, prefill string, gen *generation) {
 	flusher, ok := w.(http.Flusher)
 	if !ok {
 		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
@@ -153,13 +207,28 @@ func (h *AnthropicHandler) streamMessages(w http.ResponseWriter, r *http.Request
 	hasTextContent := false
 	toolCallsInProgress := make(map[int]map[string]interface{})
 	stopReason := "end_turn"
+	stripper := converter.NewPrefillStripper(prefill)
+	out := &generationOutput{}
 
-	usageData := map[string]int{
-		"input_tokens":            0,
-		"output_tokens":           0,
-		"cache_read_input_tokens": 0,
+	sendText := func(text string) {
+		if text == "" {
+			return
+		}
+		hasTextContent = true
+		out.content.WriteString(text)
+		gen.firstToken()
+		h.sendAnthropicEvent(w, flusher, "content_block_delta", map[string]interface{}{
+			"type":  "content_block_delta",
+			"index": contentBlockIndex,
+			"delta": map[string]interface{}{
+				"type": "text_delta",
+				"text": text,
+			},
+		})
 	}
 
+	var upstreamUsage map[string]interface{}
+
 	// Message start event
 	h.sendAnthropicEvent(w, flusher, "message_start", map[string]interface{}{
 		"type": "message_start",
@@ -201,17 +270,7 @@ func (h *AnthropicHandler) streamMessages(w http.ResponseWriter, r *http.Request
 			if err := json.Unmarshal([]byte(data), &chunkData); err == nil {
 				// Capture usage data if present
 				if usage, ok := chunkData["usage"].(map[string]interface{}); ok {
-					if pt, ok := usage["prompt_tokens"].(float64); ok {
-						usageData["input_tokens"] = int(pt)
-					}
-					if ct, ok := usage["completion_tokens"].(float64); ok {
-						usageData["output_tokens"] = int(ct)
-					}
-					if pd, ok := usage["prompt_tokens_details"].(map[string]interface{}); ok {
-						if cached, ok := pd["cached_tokens"].(float64); ok {
-							usageData["cache_read_input_tokens"] = int(cached)
-						}
-					}
+					upstreamUsage = usage
 				}
 
 				choices, _ := chunkData["choices"].([]interface{})
@@ -223,18 +282,13 @@ func (h *AnthropicHandler) streamMessages(w http.ResponseWriter, r *http.Request
 					if finishReason == "tool_calls" {
 						stopReason = "tool_use"
 					}
+					out.setFinishReason(finishReason)
+					out.addReasoning(delta)
+					out.addToolCalls(delta["tool_calls"])
 
 					// Handle text content
 					if content, ok := delta["content"].(string); ok && content != "" {
-						hasTextContent = true
-						h.sendAnthropicEvent(w, flusher, "content_block_delta", map[string]interface{}{
-							"type":  "content_block_delta",
-							"index": contentBlockIndex,
-							"delta": map[string]interface{}{
-								"type": "text_delta",
-								"text": content,
-							},
-						})
+						sendText(stripper.Write(content))
 					}
 
 					// Handle tool calls
@@ -247,6 +301,9 @@ func (h *AnthropicHandler) streamMessages(w http.ResponseWriter, r *http.Request
 
 							if tcID != "" {
 								// New tool call starting
+								if len(toolCallsInProgress) == 0 {
+									sendText(stripper.Flush())
+								}
 								if hasTextContent || len(toolCallsInProgress) > 0 {
 									// Close previous content block
 									h.sendAnthropicEvent(w, flusher, "content_block_stop", map[string]interface{}{
@@ -256,6 +313,7 @@ func (h *AnthropicHandler) streamMessages(w http.ResponseWriter, r *http.Request
 									contentBlockIndex++
 								}
 
+								gen.firstToken()
 								funcName, _ := tcFunc["name"].(string)
 								toolCallsInProgress[tcIndex] = map[string]interface{}{
 									"id":        tcID,
@@ -298,11 +356,15 @@ func (h *AnthropicHandler) streamMessages(w http.ResponseWriter, r *http.Request
 	})
 
 	if err != nil {
-		if h.debug {
-			fmt.Printf("[DEBUG] Streaming error: %v\n", err)
+		if h.debug || logging.DebugEnabled() {
+			slog.DebugContext(r.Context(), "Streaming error", "key", apikeys.NameFromContext(r.Context()), "error", err)
 		}
 	}
 
+	if len(toolCallsInProgress) == 0 {
+		sendText(stripper.Flush())
+	}
+
 	// Close the last content block
 	h.sendAnthropicEvent(w, flusher, "content_block_stop", map[string]interface{}{
 		"type":  "content_block_stop",
@@ -310,16 +372,14 @@ func (h *AnthropicHandler) streamMessages(w http.ResponseWriter, r *http.Request
 	})
 
 	// Message delta (final)
+	usageData := converter.AnthropicUsage(upstreamUsage)
 	h.sendAnthropicEvent(w, flusher, "message_delta", map[string]interface{}{
 		"type": "message_delta",
 		"delta": map[string]interface{}{
 			"stop_reason":   stopReason,
 			"stop_sequence": nil,
 		},
-		"usage": map[string]interface{}{
-			"output_tokens":           usageData["output_tokens"],
-			"cache_read_input_tokens": usageData["cache_read_input_tokens"],
-		},
+		"usage": usageData,
 	})
 
 	// Message stop
@@ -328,18 +388,14 @@ func (h *AnthropicHandler) streamMessages(w http.ResponseWriter, r *http.Request
 	})
 
 	// Track to Langfuse
-	level := ""
-	statusMsg := ""
-	if err != nil {
-		level = "ERROR"
-		statusMsg = err.Error()
-	}
+	promptTokens := usageData["input_tokens"].(int) + usageData["cache_creation_input_tokens"].(int) + usageData["cache_read_input_tokens"].(int)
 	usage := &langfuse.UsageData{
-		PromptTokens:     usageData["input_tokens"],
-		CompletionTokens: usageData["output_tokens"],
-		TotalTokens:      usageData["input_tokens"] + usageData["output_tokens"],
+		PromptTokens:     promptTokens,
+		CompletionTokens: usageData["output_tokens"].(int),
+		TotalTokens:      promptTokens + usageData["output_tokens"].(int),
 	}
-	h.trackGeneration(traceID, genID, model, inputMessages, map[string]string{"stop_reason": stopReason}, usage, startTime, level, statusMsg, r)
+	gen.finishReason = out.finishReason
+	gen.track(r, h.sink, h.budgets, out.message(), usage, err)
 }
 
 // sendAnthropicEvent sends an Anthropic SSE event.
@@ -359,7 +415,7 @@ func (h *AnthropicHandler) CountTokens(w http.ResponseWriter, r *http.Request) {
 		System   interface{}              `json:"system"`
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+	if err := decodeRequest(r, &req); err != nil {
 		http.Error(w, fmt.Sprintf("Invalid request body: %v", err), http.StatusBadRequest)
 		return
 	}
@@ -389,8 +445,3 @@ func (h *AnthropicHandler) CountTokens(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
-
-// Batches handles batch endpoints (not supported)
-func (h *AnthropicHandler) Batches(w http.ResponseWriter, r *http.Request) {
-	http.Error(w, "Batch API not supported by Copilot proxy", http.StatusNotImplemented)
-}
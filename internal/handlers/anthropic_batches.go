@@ -0,0 +1,363 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rahulvramesh/gh-proxy-local/internal/apikeys"
+	"github.com/rahulvramesh/gh-proxy-local/internal/batch"
+)
+
+// anthropicBatchKind identifies Anthropic message batches in the job store.
+const anthropicBatchKind = "anthropic_messages"
+
+// maxAnthropicBatchRequests mirrors the Message Batches API limit.
+const maxAnthropicBatchRequests = 100000
+
+// AnthropicBatchHandler handles the Anthropic Message Batches API.
+type AnthropicBatchHandler struct {
+	runner   *batch.Runner
+	messages *AnthropicHandler
+	items    *ItemServer
+}
+
+// NewAnthropicBatchHandler creates a new Anthropic batch handler and
+// registers its executor with the runner.
+func NewAnthropicBatchHandler(runner *batch.Runner, messages *AnthropicHandler, items *ItemServer) *AnthropicBatchHandler {
+	h := &AnthropicBatchHandler{runner: runner, messages: messages, items: items}
+	runner.Register(anthropicBatchKind, h.execute, nil)
+	return h
+}
+
+// execute runs a single batch item through the regular Messages handler.
+func (h *AnthropicBatchHandler) execute(ctx context.Context, req batch.Request) batch.Result {
+	var params map[string]interface{}
+	if err := json.Unmarshal(req.Body, &params); err != nil {
+		return batch.Result{
+			Outcome:    batch.OutcomeErrored,
+			StatusCode: http.StatusBadRequest,
+			Error:      fmt.Sprintf("Invalid params: %v", err),
+		}
+	}
+	// Batch results are always complete messages.
+	params["stream"] = false
+	body, _ := json.Marshal(params)
+
+	status, _, respBody := h.items.serve(ctx, h.messages.Messages, "POST", "/v1/messages", body)
+	if status == http.StatusOK {
+		return batch.Result{Outcome: batch.OutcomeSucceeded, StatusCode: status, Body: respBody}
+	}
+	return batch.Result{
+		Outcome:    batch.OutcomeErrored,
+		StatusCode: status,
+		Error:      errorMessage(respBody),
+	}
+}
+
+// CreateBatch handles POST /v1/messages/batches
+func (h *AnthropicBatchHandler) CreateBatch(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Requests []struct {
+			CustomID string          `json:"custom_id"`
+			Params   json.RawMessage `json:"params"`
+		} `json:"requests"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeAnthropicError(w, http.StatusBadRequest, fmt.Sprintf("Invalid request body: %v", err))
+		return
+	}
+
+	if len(req.Requests) == 0 {
+		writeAnthropicError(w, http.StatusBadRequest, "requests: must contain at least one request")
+		return
+	}
+	if len(req.Requests) > maxAnthropicBatchRequests {
+		writeAnthropicError(w, http.StatusBadRequest, fmt.Sprintf("requests: must contain at most %d requests", maxAnthropicBatchRequests))
+		return
+	}
+
+	seen := make(map[string]bool, len(req.Requests))
+	requests := make([]batch.Request, 0, len(req.Requests))
+	for i, item := range req.Requests {
+		if item.CustomID == "" {
+			writeAnthropicError(w, http.StatusBadRequest, fmt.Sprintf("requests.%d.custom_id: field required", i))
+			return
+		}
+		if seen[item.CustomID] {
+			writeAnthropicError(w, http.StatusBadRequest, fmt.Sprintf("requests.%d.custom_id: duplicate custom_id %q", i, item.CustomID))
+			return
+		}
+		seen[item.CustomID] = true
+
+		var params struct {
+			Model    string        `json:"model"`
+			Messages []interface{} `json:"messages"`
+		}
+		if len(item.Params) == 0 || json.Unmarshal(item.Params, &params) != nil {
+			writeAnthropicError(w, http.StatusBadRequest, fmt.Sprintf("requests.%d.params: must be an object", i))
+			return
+		}
+		if params.Model == "" {
+			writeAnthropicError(w, http.StatusBadRequest, fmt.Sprintf("requests.%d.params.model: field required", i))
+			return
+		}
+		if _, err := apikeys.CheckModel(r.Context(), params.Model); err != nil {
+			writeAnthropicError(w, http.StatusForbidden, fmt.Sprintf("requests.%d.params.model: %v", i, err))
+			return
+		}
+		if len(params.Messages) == 0 {
+			writeAnthropicError(w, http.StatusBadRequest, fmt.Sprintf("requests.%d.params.messages: field required", i))
+			return
+		}
+
+		requests = append(requests, batch.Request{CustomID: item.CustomID, Body: item.Params})
+	}
+
+	job, err := h.runner.Submit(&batch.Job{
+		ID:     "msgbatch_" + strings.ReplaceAll(uuid.New().String(), "-", ""),
+		Kind:   anthropicBatchKind,
+		APIKey: apikeys.NameFromContext(r.Context()),
+	}, requests)
+	if err != nil {
+		writeAnthropicError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(anthropicBatchObject(job))
+}
+
+// GetBatch handles GET /v1/messages/batches/{batch_id}
+func (h *AnthropicBatchHandler) GetBatch(w http.ResponseWriter, r *http.Request) {
+	job, ok := h.lookup(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(anthropicBatchObject(job))
+}
+
+// ListBatches handles GET /v1/messages/batches
+func (h *AnthropicBatchHandler) ListBatches(w http.ResponseWriter, r *http.Request) {
+	limit := 20
+	if l := r.URL.Query().Get("limit"); l != "" {
+		parsed, err := strconv.Atoi(l)
+		if err != nil || parsed < 1 || parsed > 1000 {
+			writeAnthropicError(w, http.StatusBadRequest, "limit: must be between 1 and 1000")
+			return
+		}
+		limit = parsed
+	}
+
+	jobs := ownedJobs(r, h.runner.Store().List(anthropicBatchKind))
+	start, end := 0, len(jobs)
+	hasMore := false
+	if beforeID := r.URL.Query().Get("before_id"); beforeID != "" {
+		end = indexOfJob(jobs, beforeID)
+		if end < 0 {
+			end = len(jobs)
+		}
+		if end > limit {
+			start = end - limit
+			hasMore = true
+		}
+	} else {
+		if afterID := r.URL.Query().Get("after_id"); afterID != "" {
+			start = indexOfJob(jobs, afterID) + 1
+		}
+		if end-start > limit {
+			end = start + limit
+			hasMore = true
+		}
+	}
+	page := jobs[start:end]
+
+	data := make([]interface{}, 0, len(page))
+	for _, job := range page {
+		data = append(data, anthropicBatchObject(job))
+	}
+
+	var firstID, lastID interface{}
+	if len(page) > 0 {
+		firstID = page[0].ID
+		lastID = page[len(page)-1].ID
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"data":     data,
+		"has_more": hasMore,
+		"first_id": firstID,
+		"last_id":  lastID,
+	})
+}
+
+// CancelBatch handles POST /v1/messages/batches/{batch_id}/cancel
+func (h *AnthropicBatchHandler) CancelBatch(w http.ResponseWriter, r *http.Request) {
+	if _, ok := h.lookup(w, r); !ok {
+		return
+	}
+
+	job, err := h.runner.Cancel(r.PathValue("batch_id"))
+	if err != nil {
+		writeAnthropicError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(anthropicBatchObject(job))
+}
+
+// DeleteBatch handles DELETE /v1/messages/batches/{batch_id}
+func (h *AnthropicBatchHandler) DeleteBatch(w http.ResponseWriter, r *http.Request) {
+	job, ok := h.lookup(w, r)
+	if !ok {
+		return
+	}
+	if !job.Status.Done() {
+		writeAnthropicError(w, http.StatusBadRequest, "Batch must be ended before it can be deleted; cancel it first")
+		return
+	}
+
+	if err := h.runner.Store().Delete(job.ID); err != nil {
+		writeAnthropicError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":   job.ID,
+		"type": "message_batch_deleted",
+	})
+}
+
+// BatchResults handles GET /v1/messages/batches/{batch_id}/results
+func (h *AnthropicBatchHandler) BatchResults(w http.ResponseWriter, r *http.Request) {
+	job, ok := h.lookup(w, r)
+	if !ok {
+		return
+	}
+	if !job.Status.Done() {
+		writeAnthropicError(w, http.StatusBadRequest, "Batch is still processing; results are available once processing_status is ended")
+		return
+	}
+
+	results, err := h.runner.Store().Results(job.ID)
+	if err != nil {
+		writeAnthropicError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-jsonl")
+	enc := json.NewEncoder(w)
+	for _, res := range results {
+		enc.Encode(map[string]interface{}{
+			"custom_id": res.CustomID,
+			"result":    anthropicBatchResult(res),
+		})
+	}
+}
+
+// ownedJobs returns the jobs that belong to the request's key.
+func ownedJobs(r *http.Request, jobs []*batch.Job) []*batch.Job {
+	owned := jobs[:0]
+	for _, job := range jobs {
+		if ownedByCaller(r, job.APIKey) {
+			owned = append(owned, job)
+		}
+	}
+	return owned
+}
+
+// indexOfJob returns the position of the job with the given ID, or -1.
+func indexOfJob(jobs []*batch.Job, id string) int {
+	for i, job := range jobs {
+		if job.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// lookup resolves the batch_id path value, writing a 404 if it is unknown
+// or belongs to another key.
+func (h *AnthropicBatchHandler) lookup(w http.ResponseWriter, r *http.Request) (*batch.Job, bool) {
+	id := r.PathValue("batch_id")
+	job, ok := h.runner.Store().Get(id)
+	if !ok || job.Kind != anthropicBatchKind || !ownedByCaller(r, job.APIKey) {
+		writeAnthropicError(w, http.StatusNotFound, fmt.Sprintf("Batch %s not found", id))
+		return nil, false
+	}
+	return job, true
+}
+
+// anthropicBatchObject renders a job as an Anthropic message_batch object.
+func anthropicBatchObject(job *batch.Job) map[string]interface{} {
+	processingStatus := "in_progress"
+	switch {
+	case job.Status.Done():
+		processingStatus = "ended"
+	case job.Status == batch.StatusCanceling:
+		processingStatus = "canceling"
+	}
+
+	var endedAt, cancelInitiatedAt, resultsURL interface{}
+	if job.EndedAt != nil {
+		endedAt = job.EndedAt.Format(time.RFC3339)
+	}
+	if job.CancelRequestedAt != nil {
+		cancelInitiatedAt = job.CancelRequestedAt.Format(time.RFC3339)
+	}
+	if job.Status.Done() {
+		resultsURL = "/v1/messages/batches/" + job.ID + "/results"
+	}
+
+	return map[string]interface{}{
+		"id":                job.ID,
+		"type":              "message_batch",
+		"processing_status": processingStatus,
+		"request_counts": map[string]int{
+			"processing": job.Counts.Total - job.Counts.Processed(),
+			"succeeded":  job.Counts.Succeeded,
+			"errored":    job.Counts.Errored,
+			"canceled":   job.Counts.Canceled,
+			"expired":    job.Counts.Expired,
+		},
+		"ended_at":            endedAt,
+		"created_at":          job.CreatedAt.Format(time.RFC3339),
+		"expires_at":          job.ExpiresAt.Format(time.RFC3339),
+		"archived_at":         nil,
+		"cancel_initiated_at": cancelInitiatedAt,
+		"results_url":         resultsURL,
+	}
+}
+
+// anthropicBatchResult renders an item result in Message Batches format.
+func anthropicBatchResult(res batch.Result) map[string]interface{} {
+	switch res.Outcome {
+	case batch.OutcomeSucceeded:
+		return map[string]interface{}{
+			"type":    "succeeded",
+			"message": json.RawMessage(res.Body),
+		}
+	case batch.OutcomeErrored:
+		status := res.StatusCode
+		if status == 0 {
+			status = http.StatusInternalServerError
+		}
+		return map[string]interface{}{
+			"type":  "errored",
+			"error": anthropicErrorBody(status, res.Error),
+		}
+	default:
+		return map[string]interface{}{"type": string(res.Outcome)}
+	}
+}
@@ -0,0 +1,478 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rahulvramesh/gh-proxy-local/internal/apikeys"
+	"github.com/rahulvramesh/gh-proxy-local/internal/batch"
+	"github.com/rahulvramesh/gh-proxy-local/internal/files"
+)
+
+// openAIBatchKind identifies OpenAI batches in the job store.
+const openAIBatchKind = "openai"
+
+// maxOpenAIBatchRequests mirrors the Batch API per-file request limit.
+const maxOpenAIBatchRequests = 50000
+
+// maxBatchValidationErrors caps the errors reported for an invalid input file.
+const maxBatchValidationErrors = 10
+
+// BatchesHandler handles the OpenAI Batch API. Each input line is run
+// against the local chat completions, responses or embeddings handler.
+type BatchesHandler struct {
+	runner    *batch.Runner
+	files     *files.Store
+	items     *ItemServer
+	endpoints map[string]http.HandlerFunc
+}
+
+// NewBatchesHandler creates a new OpenAI batches handler and registers its
+// executor with the runner.
+func NewBatchesHandler(runner *batch.Runner, fileStore *files.Store, items *ItemServer, chat *ChatHandler, responses *ResponsesHandler, embeddings *EmbeddingsHandler) *BatchesHandler {
+	h := &BatchesHandler{
+		runner: runner,
+		files:  fileStore,
+		items:  items,
+		endpoints: map[string]http.HandlerFunc{
+			"/v1/chat/completions": chat.ChatCompletions,
+			"/v1/responses":        responses.Responses,
+			"/v1/embeddings":       embeddings.Embeddings,
+		},
+	}
+	runner.Register(openAIBatchKind, h.execute, h.finalize)
+	return h
+}
+
+// execute runs a single batch line through the matching local handler.
+func (h *BatchesHandler) execute(ctx context.Context, req batch.Request) batch.Result {
+	handler, ok := h.endpoints[req.URL]
+	if !ok {
+		msg := fmt.Sprintf("Unsupported url %q", req.URL)
+		return batch.Result{Outcome: batch.OutcomeErrored, StatusCode: http.StatusBadRequest, Error: msg}
+	}
+
+	body := []byte(req.Body)
+	if req.URL != "/v1/embeddings" {
+		var params map[string]interface{}
+		if err := json.Unmarshal(req.Body, &params); err == nil {
+			// Batch output always holds complete responses.
+			params["stream"] = false
+			body, _ = json.Marshal(params)
+		}
+	}
+
+	status, _, respBody := h.items.serve(ctx, handler, "POST", req.URL, body)
+	if status == http.StatusOK {
+		return batch.Result{Outcome: batch.OutcomeSucceeded, StatusCode: status, Body: respBody}
+	}
+	msg := errorMessage(respBody)
+	errBody, _ := json.Marshal(openAIErrorBody(status, msg))
+	return batch.Result{Outcome: batch.OutcomeErrored, StatusCode: status, Body: errBody, Error: msg}
+}
+
+// finalize writes the output and error files of a finished batch.
+func (h *BatchesHandler) finalize(job *batch.Job) error {
+	requests, err := h.runner.Store().Requests(job.ID)
+	if err != nil {
+		return err
+	}
+	results, err := h.runner.Store().Results(job.ID)
+	if err != nil {
+		return err
+	}
+	byID := make(map[string]batch.Result, len(results))
+	for _, res := range results {
+		byID[res.CustomID] = res
+	}
+
+	var output, errorsOut bytes.Buffer
+	outEnc := json.NewEncoder(&output)
+	errEnc := json.NewEncoder(&errorsOut)
+	for _, req := range requests {
+		res, ok := byID[req.CustomID]
+		if !ok {
+			continue
+		}
+
+		line := map[string]interface{}{
+			"id":        "batch_req_" + strings.ReplaceAll(uuid.New().String(), "-", "")[:24],
+			"custom_id": res.CustomID,
+			"response":  nil,
+			"error":     nil,
+		}
+		if res.StatusCode != 0 {
+			line["response"] = map[string]interface{}{
+				"status_code": res.StatusCode,
+				"request_id":  strings.ReplaceAll(uuid.New().String(), "-", ""),
+				"body":        json.RawMessage(res.Body),
+			}
+		}
+
+		switch res.Outcome {
+		case batch.OutcomeSucceeded:
+			outEnc.Encode(line)
+			continue
+		case batch.OutcomeCanceled:
+			line["error"] = map[string]string{"code": "batch_cancelled", "message": "This line was not executed because the batch was cancelled."}
+		case batch.OutcomeExpired:
+			line["error"] = map[string]string{"code": "batch_expired", "message": "This line could not be executed before the completion window expired."}
+		default:
+			if res.StatusCode == 0 {
+				line["error"] = map[string]string{"code": "server_error", "message": res.Error}
+			}
+		}
+		errEnc.Encode(line)
+	}
+
+	attributes := make(map[string]string, len(job.Attributes)+2)
+	for k, v := range job.Attributes {
+		attributes[k] = v
+	}
+	if output.Len() > 0 {
+		f, err := h.files.Create(job.APIKey, job.ID+"_output.jsonl", "batch_output", &output)
+		if err != nil {
+			return fmt.Errorf("failed to write output file: %w", err)
+		}
+		attributes["output_file_id"] = f.ID
+	}
+	if errorsOut.Len() > 0 {
+		f, err := h.files.Create(job.APIKey, job.ID+"_error.jsonl", "batch_output", &errorsOut)
+		if err != nil {
+			return fmt.Errorf("failed to write error file: %w", err)
+		}
+		attributes["error_file_id"] = f.ID
+	}
+	job.Attributes = attributes
+	return nil
+}
+
+// batchValidationError describes a problem with a line of the input file.
+type batchValidationError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Param   string `json:"param,omitempty"`
+	Line    int    `json:"line,omitempty"`
+}
+
+// parseBatchInput reads and validates a batch input file.
+func (h *BatchesHandler) parseBatchInput(ctx context.Context, fileID, endpoint string) ([]batch.Request, []batchValidationError) {
+	content, err := h.files.Open(fileID)
+	if err != nil {
+		return nil, []batchValidationError{{Code: "invalid_file", Message: err.Error(), Param: "input_file_id"}}
+	}
+	defer content.Close()
+
+	var requests []batch.Request
+	var problems []batchValidationError
+	seen := make(map[string]bool)
+
+	scanner := bufio.NewScanner(content)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	lineNo := 0
+	for scanner.Scan() && len(problems) < maxBatchValidationErrors {
+		lineNo++
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		var item batch.Request
+		if err := json.Unmarshal(line, &item); err != nil {
+			problems = append(problems, batchValidationError{Code: "invalid_json_line", Message: fmt.Sprintf("This line is not parseable as valid JSON: %v", err), Line: lineNo})
+			continue
+		}
+
+		switch {
+		case item.CustomID == "":
+			problems = append(problems, batchValidationError{Code: "missing_required_parameter", Message: "custom_id is required", Param: "custom_id", Line: lineNo})
+		case seen[item.CustomID]:
+			problems = append(problems, batchValidationError{Code: "duplicate_custom_id", Message: fmt.Sprintf("The custom_id %q is used more than once", item.CustomID), Param: "custom_id", Line: lineNo})
+		case item.Method != "POST":
+			problems = append(problems, batchValidationError{Code: "invalid_method", Message: "method must be POST", Param: "method", Line: lineNo})
+		case item.URL != endpoint:
+			problems = append(problems, batchValidationError{Code: "mismatched_endpoint", Message: fmt.Sprintf("url %q does not match the batch endpoint %q", item.URL, endpoint), Param: "url", Line: lineNo})
+		case len(item.Body) == 0 || item.Body[0] != '{':
+			problems = append(problems, batchValidationError{Code: "invalid_request", Message: "body must be an object", Param: "body", Line: lineNo})
+		default:
+			var body struct {
+				Model string `json:"model"`
+			}
+			json.Unmarshal(item.Body, &body)
+			if _, err := apikeys.CheckModel(ctx, body.Model); err != nil {
+				problems = append(problems, batchValidationError{Code: "model_not_allowed", Message: err.Error(), Param: "body.model", Line: lineNo})
+			}
+		}
+		seen[item.CustomID] = true
+		requests = append(requests, item)
+
+		if len(requests) > maxOpenAIBatchRequests {
+			problems = append(problems, batchValidationError{Code: "too_many_requests", Message: fmt.Sprintf("The input file may contain at most %d requests", maxOpenAIBatchRequests)})
+			break
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		problems = append(problems, batchValidationError{Code: "invalid_file", Message: err.Error()})
+	}
+	if len(problems) == 0 && len(requests) == 0 {
+		problems = append(problems, batchValidationError{Code: "empty_file", Message: "The input file contains no requests", Param: "input_file_id"})
+	}
+
+	return requests, problems
+}
+
+// CreateBatch handles POST /v1/batches
+func (h *BatchesHandler) CreateBatch(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		InputFileID      string            `json:"input_file_id"`
+		Endpoint         string            `json:"endpoint"`
+		CompletionWindow string            `json:"completion_window"`
+		Metadata         map[string]string `json:"metadata"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, fmt.Sprintf("Invalid request body: %v", err))
+		return
+	}
+
+	if _, ok := h.endpoints[req.Endpoint]; !ok {
+		writeOpenAIError(w, http.StatusBadRequest, fmt.Sprintf("endpoint: %q is not supported, expected one of /v1/chat/completions, /v1/responses, /v1/embeddings", req.Endpoint))
+		return
+	}
+	if req.CompletionWindow != "24h" {
+		writeOpenAIError(w, http.StatusBadRequest, "completion_window: only \"24h\" is supported")
+		return
+	}
+	if f, ok := h.files.Get(req.InputFileID); !ok || f.Purpose != "batch" || !ownedByCaller(r, f.APIKey) {
+		writeOpenAIError(w, http.StatusBadRequest, fmt.Sprintf("input_file_id: no batch file with id %q", req.InputFileID))
+		return
+	}
+
+	job := &batch.Job{
+		ID:       "batch_" + strings.ReplaceAll(uuid.New().String(), "-", "")[:24],
+		Kind:     openAIBatchKind,
+		Metadata: req.Metadata,
+		APIKey:   apikeys.NameFromContext(r.Context()),
+		Attributes: map[string]string{
+			"endpoint":      req.Endpoint,
+			"input_file_id": req.InputFileID,
+		},
+	}
+
+	requests, problems := h.parseBatchInput(r.Context(), req.InputFileID, req.Endpoint)
+	if len(problems) > 0 {
+		// Invalid input files produce a failed batch rather than a request
+		// error, as with the hosted API.
+		data, _ := json.Marshal(problems)
+		now := time.Now().UTC()
+		job.Status = batch.StatusFailed
+		job.CreatedAt = now
+		job.EndedAt = &now
+		job.ExpiresAt = now.Add(batch.DefaultExpiry)
+		job.Attributes["errors"] = string(data)
+		if err := h.runner.Store().Create(job, nil); err != nil {
+			writeOpenAIError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(openAIBatchObject(job))
+		return
+	}
+
+	created, err := h.runner.Submit(job, requests)
+	if err != nil {
+		writeOpenAIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(openAIBatchObject(created))
+}
+
+// GetBatch handles GET /v1/batches/{batch_id}
+func (h *BatchesHandler) GetBatch(w http.ResponseWriter, r *http.Request) {
+	job, ok := h.lookup(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(openAIBatchObject(job))
+}
+
+// ListBatches handles GET /v1/batches
+func (h *BatchesHandler) ListBatches(w http.ResponseWriter, r *http.Request) {
+	limit := 20
+	if l := r.URL.Query().Get("limit"); l != "" {
+		parsed, err := strconv.Atoi(l)
+		if err != nil || parsed < 1 || parsed > 100 {
+			writeOpenAIError(w, http.StatusBadRequest, "limit: must be between 1 and 100")
+			return
+		}
+		limit = parsed
+	}
+
+	jobs := ownedJobs(r, h.runner.Store().List(openAIBatchKind))
+	start := 0
+	if after := r.URL.Query().Get("after"); after != "" {
+		start = indexOfJob(jobs, after) + 1
+	}
+	end := len(jobs)
+	hasMore := false
+	if end-start > limit {
+		end = start + limit
+		hasMore = true
+	}
+	page := jobs[start:end]
+
+	data := make([]interface{}, 0, len(page))
+	for _, job := range page {
+		data = append(data, openAIBatchObject(job))
+	}
+
+	var firstID, lastID interface{}
+	if len(page) > 0 {
+		firstID = page[0].ID
+		lastID = page[len(page)-1].ID
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"object":   "list",
+		"data":     data,
+		"first_id": firstID,
+		"last_id":  lastID,
+		"has_more": hasMore,
+	})
+}
+
+// CancelBatch handles POST /v1/batches/{batch_id}/cancel
+func (h *BatchesHandler) CancelBatch(w http.ResponseWriter, r *http.Request) {
+	job, ok := h.lookup(w, r)
+	if !ok {
+		return
+	}
+	if job.Status.Done() {
+		writeOpenAIError(w, http.StatusConflict, fmt.Sprintf("Cannot cancel a batch with status %s", openAIBatchStatus(job)))
+		return
+	}
+
+	job, err := h.runner.Cancel(job.ID)
+	if err != nil {
+		writeOpenAIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(openAIBatchObject(job))
+}
+
+// lookup resolves the batch_id path value, writing a 404 if it is unknown
+// or belongs to another key.
+func (h *BatchesHandler) lookup(w http.ResponseWriter, r *http.Request) (*batch.Job, bool) {
+	id := r.PathValue("batch_id")
+	job, ok := h.runner.Store().Get(id)
+	if !ok || job.Kind != openAIBatchKind || !ownedByCaller(r, job.APIKey) {
+		writeOpenAIError(w, http.StatusNotFound, fmt.Sprintf("No batch found with id '%s'", id))
+		return nil, false
+	}
+	return job, true
+}
+
+// openAIBatchStatus maps a job to its OpenAI batch status.
+func openAIBatchStatus(job *batch.Job) string {
+	switch job.Status {
+	case batch.StatusQueued:
+		return "validating"
+	case batch.StatusInProgress:
+		if job.Counts.Total > 0 && job.Counts.Processed() == job.Counts.Total {
+			return "finalizing"
+		}
+		return "in_progress"
+	case batch.StatusCanceling:
+		return "cancelling"
+	case batch.StatusCanceled:
+		return "cancelled"
+	default:
+		return string(job.Status)
+	}
+}
+
+// openAIBatchObject renders a job as an OpenAI batch object.
+func openAIBatchObject(job *batch.Job) map[string]interface{} {
+	unix := func(t *time.Time) interface{} {
+		if t == nil {
+			return nil
+		}
+		return t.Unix()
+	}
+	optional := func(key string) interface{} {
+		if v, ok := job.Attributes[key]; ok {
+			return v
+		}
+		return nil
+	}
+
+	var completedAt, failedAt, expiredAt, cancelledAt interface{}
+	switch job.Status {
+	case batch.StatusCompleted:
+		completedAt = unix(job.EndedAt)
+	case batch.StatusFailed:
+		failedAt = unix(job.EndedAt)
+	case batch.StatusExpired:
+		expiredAt = unix(job.EndedAt)
+	case batch.StatusCanceled:
+		cancelledAt = unix(job.EndedAt)
+	}
+
+	var errs interface{}
+	if raw, ok := job.Attributes["errors"]; ok {
+		errs = map[string]interface{}{"object": "list", "data": json.RawMessage(raw)}
+	} else if job.Error != "" {
+		errs = map[string]interface{}{
+			"object": "list",
+			"data":   []batchValidationError{{Code: "server_error", Message: job.Error}},
+		}
+	}
+
+	metadata := job.Metadata
+	if metadata == nil {
+		metadata = map[string]string{}
+	}
+
+	return map[string]interface{}{
+		"id":                job.ID,
+		"object":            "batch",
+		"endpoint":          job.Attributes["endpoint"],
+		"errors":            errs,
+		"input_file_id":     job.Attributes["input_file_id"],
+		"completion_window": "24h",
+		"status":            openAIBatchStatus(job),
+		"output_file_id":    optional("output_file_id"),
+		"error_file_id":     optional("error_file_id"),
+		"created_at":        job.CreatedAt.Unix(),
+		"in_progress_at":    unix(job.StartedAt),
+		"expires_at":        job.ExpiresAt.Unix(),
+		"finalizing_at":     nil,
+		"completed_at":      completedAt,
+		"failed_at":         failedAt,
+		"expired_at":        expiredAt,
+		"cancelling_at":     unix(job.CancelRequestedAt),
+		"cancelled_at":      cancelledAt,
+		"request_counts": map[string]int{
+			"total":     job.Counts.Total,
+			"completed": job.Counts.Succeeded,
+			"failed":    job.Counts.Errored,
+		},
+		"metadata": metadata,
+	}
+}
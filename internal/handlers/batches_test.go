@@ -0,0 +1,489 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rahulvramesh/gh-proxy-local/internal/apikeys"
+	"github.com/rahulvramesh/gh-proxy-local/internal/batch"
+	"github.com/rahulvramesh/gh-proxy-local/internal/config"
+	"github.com/rahulvramesh/gh-proxy-local/internal/files"
+	"github.com/rahulvramesh/gh-proxy-local/internal/ratelimit"
+)
+
+func newTestBatchRunner(t *testing.T) *batch.Runner {
+	t.Helper()
+	store, err := batch.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	runner := batch.NewRunner(store, 2, 0, false)
+	t.Cleanup(runner.Shutdown)
+	return runner
+}
+
+func waitForBatch(t *testing.T, runner *batch.Runner, id string) *batch.Job {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if job, ok := runner.Store().Get(id); ok && job.Status.Done() {
+			return job
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Batch %s did not finish", id)
+	return nil
+}
+
+func TestItemServerUsesRegisteredKey(t *testing.T) {
+	store, err := apikeys.NewStore(filepath.Join(t.TempDir(), "keys.json"))
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	if _, _, err := store.Create(apikeys.Spec{Name: "ci", DefaultModel: "gpt-4.1"}); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	items := NewItemServer(store, nil)
+
+	var seen *apikeys.Key
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		seen = apikeys.FromContext(r.Context())
+		w.WriteHeader(http.StatusOK)
+	}
+	run := func(name string) (int, []byte) {
+		seen = nil
+		ctx := batch.WithJob(context.Background(), &batch.Job{APIKey: name})
+		status, _, body := items.serve(ctx, handler, "POST", "/v1/chat/completions", []byte(`{}`))
+		return status, body
+	}
+
+	if status, _ := run("ci"); status != http.StatusOK || seen == nil || seen.DefaultModel != "gpt-4.1" {
+		t.Fatalf("expected the item to run with the registered key, got status %d and key %+v", status, seen)
+	}
+
+	store.Revoke("ci")
+	if status, body := run("ci"); status != http.StatusUnauthorized || seen != nil || !strings.Contains(errorMessage(body), "revoked") {
+		t.Errorf("expected a revoked key to fail the item, got %d %s", status, body)
+	}
+	if status, _ := run("deleted"); status != http.StatusUnauthorized || seen != nil {
+		t.Errorf("expected an unknown key to fail the item, got %d", status)
+	}
+}
+
+func TestItemServerAppliesKeyLimits(t *testing.T) {
+	store, err := apikeys.NewStore(filepath.Join(t.TempDir(), "keys.json"))
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	store.Create(apikeys.Spec{Name: "ci", RequestsPerMinute: 2, MaxInFlight: 1})
+	limiter := ratelimit.NewLimiter(config.RateLimitConfig{QueueSize: 10, QueueTimeout: time.Minute})
+	items := NewItemServer(store, limiter)
+
+	calls := 0
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusOK)
+	}
+	run := func() int {
+		ctx, cancel := context.WithTimeout(batch.WithJob(context.Background(), &batch.Job{APIKey: "ci"}), 50*time.Millisecond)
+		defer cancel()
+		status, _, _ := items.serve(ctx, handler, "POST", "/v1/chat/completions", []byte(`{}`))
+		return status
+	}
+
+	// A slot held by an interactive request leaves the item queued
+	release, err := limiter.AcquireKey(context.Background(), "ci", 1)
+	if err != nil {
+		t.Fatalf("AcquireKey failed: %v", err)
+	}
+	if status := run(); status != http.StatusTooManyRequests || calls != 0 {
+		t.Errorf("expected the item to wait for the key's concurrency slot, got %d after %d calls", status, calls)
+	}
+	release()
+
+	if status := run(); status != http.StatusOK || calls != 1 {
+		t.Fatalf("expected the item to run, got %d after %d calls", status, calls)
+	}
+	// Both of the key's requests per minute are used up
+	if status := run(); status != http.StatusTooManyRequests || calls != 1 {
+		t.Errorf("expected the item to wait for the key's request bucket, got %d after %d calls", status, calls)
+	}
+}
+
+func TestAnthropicBatchHandler_CreateInvalid(t *testing.T) {
+	handler := NewAnthropicBatchHandler(newTestBatchRunner(t), &AnthropicHandler{}, NewItemServer(nil, nil))
+
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"invalid json", "invalid"},
+		{"no requests", `{"requests": []}`},
+		{"missing custom_id", `{"requests": [{"params": {"model": "m", "messages": [{"role": "user", "content": "hi"}]}}]}`},
+		{"duplicate custom_id", `{"requests": [
+			{"custom_id": "a", "params": {"model": "m", "messages": [{"role": "user", "content": "hi"}]}},
+			{"custom_id": "a", "params": {"model": "m", "messages": [{"role": "user", "content": "hi"}]}}]}`},
+		{"missing model", `{"requests": [{"custom_id": "a", "params": {"messages": [{"role": "user", "content": "hi"}]}}]}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/v1/messages/batches", strings.NewReader(tt.body))
+			rec := httptest.NewRecorder()
+
+			handler.CreateBatch(rec, req)
+
+			if rec.Code != http.StatusBadRequest {
+				t.Errorf("Expected status 400, got %d", rec.Code)
+			}
+
+			var response map[string]interface{}
+			json.NewDecoder(rec.Body).Decode(&response)
+			if response["type"] != "error" {
+				t.Errorf("Expected Anthropic error envelope, got %v", response)
+			}
+		})
+	}
+}
+
+func TestAnthropicBatchHandler_Lifecycle(t *testing.T) {
+	runner := newTestBatchRunner(t)
+	handler := NewAnthropicBatchHandler(runner, &AnthropicHandler{}, NewItemServer(nil, nil))
+
+	// Replace the executor so the test does not need a Copilot backend.
+	runner.Register(anthropicBatchKind, func(ctx context.Context, req batch.Request) batch.Result {
+		if req.CustomID == "bad" {
+			return batch.Result{Outcome: batch.OutcomeErrored, StatusCode: http.StatusBadGateway, Error: "upstream failed"}
+		}
+		return batch.Result{Outcome: batch.OutcomeSucceeded, StatusCode: http.StatusOK, Body: json.RawMessage(`{"type":"message"}`)}
+	}, nil)
+
+	body := `{"requests": [
+		{"custom_id": "good", "params": {"model": "claude-sonnet-4", "max_tokens": 10, "messages": [{"role": "user", "content": "hi"}]}},
+		{"custom_id": "bad", "params": {"model": "claude-sonnet-4", "max_tokens": 10, "messages": [{"role": "user", "content": "hi"}]}}
+	]}`
+	req := httptest.NewRequest("POST", "/v1/messages/batches", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	handler.CreateBatch(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var created map[string]interface{}
+	json.NewDecoder(rec.Body).Decode(&created)
+	id, _ := created["id"].(string)
+	if !strings.HasPrefix(id, "msgbatch_") {
+		t.Fatalf("Expected msgbatch_ id, got %v", created["id"])
+	}
+	if created["type"] != "message_batch" {
+		t.Errorf("Expected type message_batch, got %v", created["type"])
+	}
+
+	waitForBatch(t, runner, id)
+
+	req = httptest.NewRequest("GET", "/v1/messages/batches/"+id, nil)
+	req.SetPathValue("batch_id", id)
+	rec = httptest.NewRecorder()
+	handler.GetBatch(rec, req)
+
+	var retrieved map[string]interface{}
+	json.NewDecoder(rec.Body).Decode(&retrieved)
+	if retrieved["processing_status"] != "ended" {
+		t.Errorf("Expected processing_status ended, got %v", retrieved["processing_status"])
+	}
+	counts, _ := retrieved["request_counts"].(map[string]interface{})
+	if counts["succeeded"] != float64(1) || counts["errored"] != float64(1) {
+		t.Errorf("Unexpected request_counts: %v", counts)
+	}
+
+	req = httptest.NewRequest("GET", "/v1/messages/batches/"+id+"/results", nil)
+	req.SetPathValue("batch_id", id)
+	rec = httptest.NewRecorder()
+	handler.BatchResults(rec, req)
+
+	lines := strings.Split(strings.TrimSpace(rec.Body.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 result lines, got %d", len(lines))
+	}
+	types := map[string]string{}
+	for _, line := range lines {
+		var entry struct {
+			CustomID string                 `json:"custom_id"`
+			Result   map[string]interface{} `json:"result"`
+		}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("Invalid result line %q: %v", line, err)
+		}
+		types[entry.CustomID], _ = entry.Result["type"].(string)
+	}
+	if types["good"] != "succeeded" || types["bad"] != "errored" {
+		t.Errorf("Unexpected result types: %v", types)
+	}
+
+	req = httptest.NewRequest("GET", "/v1/messages/batches", nil)
+	rec = httptest.NewRecorder()
+	handler.ListBatches(rec, req)
+
+	var list map[string]interface{}
+	json.NewDecoder(rec.Body).Decode(&list)
+	if data, _ := list["data"].([]interface{}); len(data) != 1 {
+		t.Errorf("Expected 1 batch in list, got %v", list["data"])
+	}
+
+	req = httptest.NewRequest("DELETE", "/v1/messages/batches/"+id, nil)
+	req.SetPathValue("batch_id", id)
+	rec = httptest.NewRecorder()
+	handler.DeleteBatch(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected delete status 200, got %d", rec.Code)
+	}
+}
+
+func TestAnthropicBatchHandler_NotFound(t *testing.T) {
+	handler := NewAnthropicBatchHandler(newTestBatchRunner(t), &AnthropicHandler{}, NewItemServer(nil, nil))
+
+	req := httptest.NewRequest("GET", "/v1/messages/batches/msgbatch_missing", nil)
+	req.SetPathValue("batch_id", "msgbatch_missing")
+	rec := httptest.NewRecorder()
+
+	handler.GetBatch(rec, req)
+
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", rec.Code)
+	}
+}
+
+func TestBatchesHandler_Lifecycle(t *testing.T) {
+	runner := newTestBatchRunner(t)
+	fileStore, err := files.NewStore(t.TempDir(), files.DefaultMaxSize)
+	if err != nil {
+		t.Fatalf("Failed to create file store: %v", err)
+	}
+	filesHandler := NewFilesHandler(fileStore)
+	handler := NewBatchesHandler(runner, fileStore, NewItemServer(nil, nil), &ChatHandler{}, &ResponsesHandler{}, &EmbeddingsHandler{})
+
+	// Replace the executor so the test does not need a Copilot backend.
+	runner.Register(openAIBatchKind, func(ctx context.Context, req batch.Request) batch.Result {
+		if req.CustomID == "bad" {
+			return batch.Result{Outcome: batch.OutcomeErrored, StatusCode: http.StatusBadGateway, Body: json.RawMessage(`{"error":{"message":"upstream failed"}}`)}
+		}
+		return batch.Result{Outcome: batch.OutcomeSucceeded, StatusCode: http.StatusOK, Body: json.RawMessage(`{"object":"chat.completion"}`)}
+	}, handler.finalize)
+
+	input := `{"custom_id": "good", "method": "POST", "url": "/v1/chat/completions", "body": {"model": "gpt-4o", "messages": [{"role": "user", "content": "hi"}]}}
+{"custom_id": "bad", "method": "POST", "url": "/v1/chat/completions", "body": {"model": "gpt-4o", "messages": [{"role": "user", "content": "hi"}]}}
+`
+	var form bytes.Buffer
+	mw := multipart.NewWriter(&form)
+	fw, _ := mw.CreateFormFile("file", "input.jsonl")
+	fw.Write([]byte(input))
+	mw.WriteField("purpose", "batch")
+	mw.Close()
+
+	req := httptest.NewRequest("POST", "/v1/files", &form)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	rec := httptest.NewRecorder()
+	filesHandler.UploadFile(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected upload status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var uploaded files.File
+	json.NewDecoder(rec.Body).Decode(&uploaded)
+
+	body := `{"input_file_id": "` + uploaded.ID + `", "endpoint": "/v1/chat/completions", "completion_window": "24h"}`
+	req = httptest.NewRequest("POST", "/v1/batches", strings.NewReader(body))
+	rec = httptest.NewRecorder()
+	handler.CreateBatch(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var created map[string]interface{}
+	json.NewDecoder(rec.Body).Decode(&created)
+	id, _ := created["id"].(string)
+	waitForBatch(t, runner, id)
+
+	req = httptest.NewRequest("GET", "/v1/batches/"+id, nil)
+	req.SetPathValue("batch_id", id)
+	rec = httptest.NewRecorder()
+	handler.GetBatch(rec, req)
+
+	var retrieved map[string]interface{}
+	json.NewDecoder(rec.Body).Decode(&retrieved)
+	if retrieved["status"] != "completed" {
+		t.Fatalf("Expected status completed, got %v", retrieved["status"])
+	}
+	counts, _ := retrieved["request_counts"].(map[string]interface{})
+	if counts["completed"] != float64(1) || counts["failed"] != float64(1) {
+		t.Errorf("Unexpected request_counts: %v", counts)
+	}
+
+	outputID, _ := retrieved["output_file_id"].(string)
+	errorID, _ := retrieved["error_file_id"].(string)
+	if outputID == "" || errorID == "" {
+		t.Fatalf("Expected output and error files, got %v / %v", retrieved["output_file_id"], retrieved["error_file_id"])
+	}
+
+	req = httptest.NewRequest("GET", "/v1/files/"+outputID+"/content", nil)
+	req.SetPathValue("file_id", outputID)
+	rec = httptest.NewRecorder()
+	filesHandler.FileContent(rec, req)
+
+	var line struct {
+		CustomID string `json:"custom_id"`
+		Response struct {
+			StatusCode int `json:"status_code"`
+		} `json:"response"`
+	}
+	if err := json.Unmarshal(bytes.TrimSpace(rec.Body.Bytes()), &line); err != nil {
+		t.Fatalf("Invalid output line: %v", err)
+	}
+	if line.CustomID != "good" || line.Response.StatusCode != http.StatusOK {
+		t.Errorf("Unexpected output line: %+v", line)
+	}
+}
+
+func TestBatchesHandler_InvalidInputFails(t *testing.T) {
+	runner := newTestBatchRunner(t)
+	fileStore, _ := files.NewStore(t.TempDir(), files.DefaultMaxSize)
+	handler := NewBatchesHandler(runner, fileStore, NewItemServer(nil, nil), &ChatHandler{}, &ResponsesHandler{}, &EmbeddingsHandler{})
+
+	f, _ := fileStore.Create(apikeys.Anonymous, "input.jsonl", "batch", strings.NewReader(`{"custom_id": "a", "method": "POST", "url": "/v1/responses", "body": {}}`+"\n"))
+
+	body := `{"input_file_id": "` + f.ID + `", "endpoint": "/v1/chat/completions", "completion_window": "24h"}`
+	req := httptest.NewRequest("POST", "/v1/batches", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	handler.CreateBatch(rec, req)
+
+	var created map[string]interface{}
+	json.NewDecoder(rec.Body).Decode(&created)
+	if created["status"] != "failed" {
+		t.Errorf("Expected failed batch, got %v", created["status"])
+	}
+	errs, _ := created["errors"].(map[string]interface{})
+	if data, _ := errs["data"].([]interface{}); len(data) != 1 {
+		t.Errorf("Expected one validation error, got %v", created["errors"])
+	}
+}
+
+func TestBatchesHandler_UnsupportedEndpoint(t *testing.T) {
+	fileStore, _ := files.NewStore(t.TempDir(), files.DefaultMaxSize)
+	handler := NewBatchesHandler(newTestBatchRunner(t), fileStore, NewItemServer(nil, nil), &ChatHandler{}, &ResponsesHandler{}, &EmbeddingsHandler{})
+
+	req := httptest.NewRequest("POST", "/v1/batches", strings.NewReader(`{"input_file_id": "file-x", "endpoint": "/v1/completions", "completion_window": "24h"}`))
+	rec := httptest.NewRecorder()
+	handler.CreateBatch(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", rec.Code)
+	}
+}
+
+// asKey returns r authenticated as the named key.
+func asKey(r *http.Request, name string) *http.Request {
+	return r.WithContext(apikeys.WithKey(r.Context(), &apikeys.Key{Name: name}))
+}
+
+func TestBatchesAndFilesScopedToKey(t *testing.T) {
+	runner := newTestBatchRunner(t)
+	fileStore, _ := files.NewStore(t.TempDir(), files.DefaultMaxSize)
+	filesHandler := NewFilesHandler(fileStore)
+	handler := NewBatchesHandler(runner, fileStore, NewItemServer(nil, nil), &ChatHandler{}, &ResponsesHandler{}, &EmbeddingsHandler{})
+	anthropic := NewAnthropicBatchHandler(runner, &AnthropicHandler{}, NewItemServer(nil, nil))
+	runner.Register(openAIBatchKind, func(ctx context.Context, req batch.Request) batch.Result {
+		return batch.Result{Outcome: batch.OutcomeSucceeded, StatusCode: http.StatusOK, Body: json.RawMessage(`{}`)}
+	}, handler.finalize)
+	runner.Register(anthropicBatchKind, func(ctx context.Context, req batch.Request) batch.Result {
+		return batch.Result{Outcome: batch.OutcomeSucceeded, StatusCode: http.StatusOK, Body: json.RawMessage(`{}`)}
+	}, nil)
+
+	input, _ := fileStore.Create("team-a", "input.jsonl", "batch", strings.NewReader(`{"custom_id": "a", "method": "POST", "url": "/v1/chat/completions", "body": {"model": "gpt-4o"}}`+"\n"))
+	create := func(name string) *httptest.ResponseRecorder {
+		body := `{"input_file_id": "` + input.ID + `", "endpoint": "/v1/chat/completions", "completion_window": "24h"}`
+		rec := httptest.NewRecorder()
+		handler.CreateBatch(rec, asKey(httptest.NewRequest("POST", "/v1/batches", strings.NewReader(body)), name))
+		return rec
+	}
+	if rec := create("team-b"); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected another key's input file to be rejected, got %d", rec.Code)
+	}
+	rec := create("team-a")
+	var created map[string]interface{}
+	json.NewDecoder(rec.Body).Decode(&created)
+	id, _ := created["id"].(string)
+	job := waitForBatch(t, runner, id)
+	outputID := job.Attributes["output_file_id"]
+
+	msgBody := `{"requests": [{"custom_id": "a", "params": {"model": "claude-sonnet-4", "messages": [{"role": "user", "content": "hi"}]}}]}`
+	rec = httptest.NewRecorder()
+	anthropic.CreateBatch(rec, asKey(httptest.NewRequest("POST", "/v1/messages/batches", strings.NewReader(msgBody)), "team-a"))
+	json.NewDecoder(rec.Body).Decode(&created)
+	msgID, _ := created["id"].(string)
+	waitForBatch(t, runner, msgID)
+
+	call := func(fn http.HandlerFunc, name, method, path, param, value string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, nil)
+		if param != "" {
+			r.SetPathValue(param, value)
+		}
+		rec := httptest.NewRecorder()
+		fn(rec, asKey(r, name))
+		return rec
+	}
+	listed := func(rec *httptest.ResponseRecorder) int {
+		var list struct {
+			Data []interface{} `json:"data"`
+		}
+		json.NewDecoder(rec.Body).Decode(&list)
+		return len(list.Data)
+	}
+
+	for name, want := range map[string]int{"team-a": 2, "team-b": 0} {
+		if got := listed(call(filesHandler.ListFiles, name, "GET", "/v1/files", "", "")); got != want {
+			t.Errorf("%s: expected %d files, got %d", name, want, got)
+		}
+		if got := listed(call(handler.ListBatches, name, "GET", "/v1/batches", "", "")); got != want/2 {
+			t.Errorf("%s: expected %d batches, got %d", name, want/2, got)
+		}
+		if got := listed(call(anthropic.ListBatches, name, "GET", "/v1/messages/batches", "", "")); got != want/2 {
+			t.Errorf("%s: expected %d message batches, got %d", name, want/2, got)
+		}
+	}
+
+	for _, tc := range []struct {
+		name         string
+		fn           http.HandlerFunc
+		method, path string
+		param, value string
+	}{
+		{"get file", filesHandler.GetFile, "GET", "/v1/files/" + outputID, "file_id", outputID},
+		{"file content", filesHandler.FileContent, "GET", "/v1/files/" + outputID + "/content", "file_id", outputID},
+		{"delete file", filesHandler.DeleteFile, "DELETE", "/v1/files/" + input.ID, "file_id", input.ID},
+		{"get batch", handler.GetBatch, "GET", "/v1/batches/" + id, "batch_id", id},
+		{"cancel batch", handler.CancelBatch, "POST", "/v1/batches/" + id + "/cancel", "batch_id", id},
+		{"get message batch", anthropic.GetBatch, "GET", "/v1/messages/batches/" + msgID, "batch_id", msgID},
+		{"message batch results", anthropic.BatchResults, "GET", "/v1/messages/batches/" + msgID + "/results", "batch_id", msgID},
+		{"delete message batch", anthropic.DeleteBatch, "DELETE", "/v1/messages/batches/" + msgID, "batch_id", msgID},
+	} {
+		if rec := call(tc.fn, "team-b", tc.method, tc.path, tc.param, tc.value); rec.Code != http.StatusNotFound {
+			t.Errorf("%s: expected 404 for another key, got %d", tc.name, rec.Code)
+		}
+		if tc.method == "GET" {
+			if rec := call(tc.fn, "team-a", tc.method, tc.path, tc.param, tc.value); rec.Code != http.StatusOK {
+				t.Errorf("%s: expected 200 for the owner, got %d", tc.name, rec.Code)
+			}
+		}
+	}
+	if _, ok := fileStore.Get(input.ID); !ok {
+		t.Error("expected another key's delete to leave the file")
+	}
+}
@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/rahulvramesh/gh-proxy-local/internal/apikeys"
+	"github.com/rahulvramesh/gh-proxy-local/internal/budget"
+)
+
+// BudgetsHandler handles the token budget admin endpoints.
+type BudgetsHandler struct {
+	tracker *budget.Tracker
+	store   *apikeys.Store
+}
+
+// NewBudgetsHandler creates a new budgets handler.
+func NewBudgetsHandler(tracker *budget.Tracker, store *apikeys.Store) *BudgetsHandler {
+	return &BudgetsHandler{tracker: tracker, store: store}
+}
+
+// ListBudgets handles GET /admin/budgets. It reports every registered key
+// and every key with recorded usage.
+func (h *BudgetsHandler) ListBudgets(w http.ResponseWriter, r *http.Request) {
+	var names []string
+	for _, key := range h.store.List() {
+		if key.RevokedAt == nil {
+			names = append(names, key.Name)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"object": "list",
+		"data":   h.tracker.Report(names),
+	})
+}
+
+// GetBudget handles GET /admin/budgets/{name}
+func (h *BudgetsHandler) GetBudget(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.tracker.Status(r.PathValue("name")))
+}
+
+// ResetBudget handles POST /admin/budgets/{name}/reset, clearing the key's
+// consumption for the current day and month.
+func (h *BudgetsHandler) ResetBudget(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	h.tracker.Reset(name)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.tracker.Status(name))
+}
@@ -6,33 +6,39 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"strings"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/rahulvramesh/gh-proxy-local/internal/apikeys"
+	"github.com/rahulvramesh/gh-proxy-local/internal/budget"
 	"github.com/rahulvramesh/gh-proxy-local/internal/converter"
 	"github.com/rahulvramesh/gh-proxy-local/internal/copilot"
 	"github.com/rahulvramesh/gh-proxy-local/internal/langfuse"
+	"github.com/rahulvramesh/gh-proxy-local/internal/logging"
+	"github.com/rahulvramesh/gh-proxy-local/internal/ratelimit"
+	"github.com/rahulvramesh/gh-proxy-local/internal/telemetry"
+	"github.com/rahulvramesh/gh-proxy-local/internal/tracing"
 )
 
 // ChatHandler handles OpenAI chat completions endpoints.
 type ChatHandler struct {
-	client   *copilot.Client
-	langfuse *langfuse.Client
-	debug    bool
+	client  *copilot.Client
+	sink    telemetry.Sink
+	budgets *budget.Tracker
+	debug   bool
 }
 
 // NewChatHandler creates a new chat handler.
-func NewChatHandler(client *copilot.Client, langfuseClient *langfuse.Client, debug bool) *ChatHandler {
-	return &ChatHandler{client: client, langfuse: langfuseClient, debug: debug}
+func NewChatHandler(client *copilot.Client, sink telemetry.Sink, budgets *budget.Tracker, debug bool) *ChatHandler {
+	return &ChatHandler{client: client, sink: sink, budgets: budgets, debug: debug}
 }
 
 // ChatCompletions handles POST /v1/chat/completions and /chat/completions
 func (h *ChatHandler) ChatCompletions(w http.ResponseWriter, r *http.Request) {
-	startTime := time.Now()
-	traceID := langfuse.GenerateTraceID()
-	genID := langfuse.GenerateSpanID()
+	gen := newGeneration(w, "chat-completion", "chat/completions", "openai")
 
 	var req struct {
 		Model       string                   `json:"model"`
@@ -42,15 +48,32 @@ func (h *ChatHandler) ChatCompletions(w http.ResponseWriter, r *http.Request) {
 		Stream      bool                     `json:"stream"`
 		Tools       []interface{}            `json:"tools"`
 		ToolChoice  interface{}              `json:"tool_choice"`
+		User        string                   `json:"user"`
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+	if err := decodeRequest(r, &req); err != nil {
 		http.Error(w, fmt.Sprintf("Invalid request body: %v", err), http.StatusBadRequest)
 		return
 	}
 
+	model, err := apikeys.CheckModel(r.Context(), req.Model)
+	if err != nil {
+		writeOpenAIError(w, http.StatusForbidden, err.Error())
+		return
+	}
+	req.Model = model
+	setMetricsModel(r.Context(), h.client, model)
+	gen.model, gen.input = model, req.Messages
+
+	if err := h.budgets.Check(r.Context()); err != nil {
+		writeOpenAIError(w, http.StatusTooManyRequests, err.Error())
+		return
+	}
+
+	_, convertSpan := tracing.StartSpan(r.Context(), "convert request", tracing.Internal)
 	messages := converter.ConvertOpenAIToCopilotMessages(req.Messages)
 	tools := converter.ConvertOpenAITools(req.Tools)
+	convertSpan.End()
 
 	temperature := 0.7
 	if req.Temperature != nil {
@@ -70,9 +93,18 @@ func (h *ChatHandler) ChatCompletions(w http.ResponseWriter, r *http.Request) {
 		Stream:      req.Stream,
 		Tools:       tools,
 	}
+	gen.attribute(r, req.User, chatReq)
+
+	release, err := h.client.Admit(r.Context(), chatReq)
+	if err != nil {
+		ratelimit.SetRetryAfter(w.Header(), err)
+		writeOpenAIError(w, http.StatusTooManyRequests, err.Error())
+		return
+	}
+	defer release()
 
 	if req.Stream {
-		h.streamChatCompletions(w, r, chatReq, traceID, genID, startTime, req.Messages)
+		h.streamChatCompletions(w, r, chatReq, gen)
 		return
 	}
 
@@ -82,7 +114,7 @@ func (h *ChatHandler) ChatCompletions(w http.ResponseWriter, r *http.Request) {
 		if strings.Contains(err.Error(), "API error") {
 			statusCode = http.StatusBadGateway
 		}
-		h.trackGeneration(traceID, genID, req.Model, req.Messages, nil, nil, startTime, "ERROR", err.Error(), r)
+		gen.track(r, h.sink, h.budgets, nil, nil, err)
 		http.Error(w, err.Error(), statusCode)
 		return
 	}
@@ -97,52 +129,21 @@ func (h *ChatHandler) ChatCompletions(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Track to Langfuse
-	var output interface{}
-	if len(resp.Choices) > 0 {
-		output = resp.Choices[0].Message
-	}
+	out := responseOutput(resp)
+	gen.finishReason = out.finishReason
 	usage := &langfuse.UsageData{
 		PromptTokens:     resp.Usage.PromptTokens,
 		CompletionTokens: resp.Usage.CompletionTokens,
 		TotalTokens:      resp.Usage.TotalTokens,
 	}
-	h.trackGeneration(traceID, genID, req.Model, req.Messages, output, usage, startTime, "", "", r)
+	gen.track(r, h.sink, h.budgets, out.message(), usage, nil)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(resp)
 }
 
-// trackGeneration sends generation data to Langfuse.
-func (h *ChatHandler) trackGeneration(traceID, genID, model string, input, output interface{}, usage *langfuse.UsageData, startTime time.Time, level, statusMessage string, r *http.Request) {
-	if h.langfuse == nil || !h.langfuse.IsEnabled() {
-		return
-	}
-
-	metadata := map[string]interface{}{
-		"endpoint": "chat/completions",
-		"api":      "openai",
-	}
-
-	gen := &langfuse.GenerationBody{
-		ID:              genID,
-		TraceID:         traceID,
-		Name:            "chat-completion",
-		Model:           model,
-		Input:           input,
-		Output:          output,
-		Usage:           usage,
-		Metadata:        metadata,
-		StartTime:       startTime,
-		EndTime:         time.Now(),
-		Level:           level,
-		StatusMessage:   statusMessage,
-	}
-
-	h.langfuse.TrackGeneration(gen)
-}
-
 // streamChatCompletions handles streaming chat completions.
-func (h *ChatHandler) streamChatCompletions(w http.ResponseWriter, r *http.Request, req *copilot.ChatRequest, traceID, genID string, startTime time.Time, inputMessages []map[string]interface{}) {
+func (h *ChatHandler) streamChatCompletions(w http.ResponseWriter, r *http.Request, req *copilot.ChatRequest, gen *generation) {
 	flusher, ok := w.(http.Flusher)
 	if !ok {
 		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
@@ -157,7 +158,7 @@ func (h *ChatHandler) streamChatCompletions(w http.ResponseWriter, r *http.Reque
 	requestID := uuid.New().String()
 	created := time.Now().Unix()
 
-	var fullContent strings.Builder
+	out := &generationOutput{}
 	var usageData *langfuse.UsageData
 
 	err := h.client.ChatCompletionsStream(r.Context(), req, func(chunk []byte) error {
@@ -182,15 +183,10 @@ func (h *ChatHandler) streamChatCompletions(w http.ResponseWriter, r *http.Reque
 					chunkData["created"] = created
 				}
 
-				// Capture content for Langfuse
-				if choices, ok := chunkData["choices"].([]interface{}); ok && len(choices) > 0 {
-					if choice, ok := choices[0].(map[string]interface{}); ok {
-						if delta, ok := choice["delta"].(map[string]interface{}); ok {
-							if content, ok := delta["content"].(string); ok {
-								fullContent.WriteString(content)
-							}
-						}
-					}
+				// Capture output for Langfuse
+				out.addChunk(chunkData)
+				if out.started() {
+					gen.firstToken()
 				}
 
 				// Capture usage data if present
@@ -217,21 +213,12 @@ func (h *ChatHandler) streamChatCompletions(w http.ResponseWriter, r *http.Reque
 	})
 
 	// Track streaming completion to Langfuse
-	level := ""
-	statusMsg := ""
-	if err != nil {
-		level = "ERROR"
-		statusMsg = err.Error()
-		if h.debug {
-			fmt.Printf("[DEBUG] Streaming error: %v\n", err)
-		}
+	if err != nil && (h.debug || logging.DebugEnabled()) {
+		slog.DebugContext(r.Context(), "Streaming error", "key", apikeys.NameFromContext(r.Context()), "error", err)
 	}
 
-	output := map[string]interface{}{
-		"role":    "assistant",
-		"content": fullContent.String(),
-	}
-	h.trackGeneration(traceID, genID, req.Model, inputMessages, output, usageData, startTime, level, statusMsg, r)
+	gen.finishReason = out.finishReason
+	gen.track(r, h.sink, h.budgets, out.message(), usageData, err)
 }
 
 // StreamOpenAIResponse streams an OpenAI format response.
@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/rahulvramesh/gh-proxy-local/internal/apikeys"
+	"github.com/rahulvramesh/gh-proxy-local/internal/budget"
+	"github.com/rahulvramesh/gh-proxy-local/internal/copilot"
+	"github.com/rahulvramesh/gh-proxy-local/internal/langfuse"
+	"github.com/rahulvramesh/gh-proxy-local/internal/ratelimit"
+	"github.com/rahulvramesh/gh-proxy-local/internal/telemetry"
+)
+
+// defaultEmbeddingModel is used when a request does not name a model.
+const defaultEmbeddingModel = "text-embedding-3-small"
+
+// EmbeddingsHandler handles OpenAI embeddings endpoints.
+type EmbeddingsHandler struct {
+	client  *copilot.Client
+	sink    telemetry.Sink
+	budgets *budget.Tracker
+	debug   bool
+}
+
+// NewEmbeddingsHandler creates a new embeddings handler.
+func NewEmbeddingsHandler(client *copilot.Client, sink telemetry.Sink, budgets *budget.Tracker, debug bool) *EmbeddingsHandler {
+	return &EmbeddingsHandler{client: client, sink: sink, budgets: budgets, debug: debug}
+}
+
+// Embeddings handles POST /v1/embeddings and /embeddings
+func (h *EmbeddingsHandler) Embeddings(w http.ResponseWriter, r *http.Request) {
+	gen := newGeneration(w, "embedding", "embeddings", "openai")
+
+	var req map[string]interface{}
+	if err := decodeRequest(r, &req); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid request body: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	if req["input"] == nil {
+		http.Error(w, "input is required", http.StatusBadRequest)
+		return
+	}
+	model, _ := req["model"].(string)
+	if model == "" {
+		model = defaultEmbeddingModel
+	}
+	model, err := apikeys.CheckModel(r.Context(), model)
+	if err != nil {
+		writeOpenAIError(w, http.StatusForbidden, err.Error())
+		return
+	}
+	req["model"] = model
+	setMetricsModel(r.Context(), h.client, model)
+
+	if err := h.budgets.Check(r.Context()); err != nil {
+		writeOpenAIError(w, http.StatusTooManyRequests, err.Error())
+		return
+	}
+	gen.model, gen.input = model, req["input"]
+	user, _ := req["user"].(string)
+	gen.attribute(r, user, nil)
+
+	release, err := h.client.Acquire(r.Context(), model)
+	if err != nil {
+		ratelimit.SetRetryAfter(w.Header(), err)
+		writeOpenAIError(w, http.StatusTooManyRequests, err.Error())
+		return
+	}
+	defer release()
+
+	resp, err := h.client.Embeddings(r.Context(), req)
+	if err != nil {
+		statusCode := http.StatusInternalServerError
+		if strings.Contains(err.Error(), "API error") {
+			statusCode = http.StatusBadGateway
+		}
+		gen.track(r, h.sink, h.budgets, nil, nil, err)
+		http.Error(w, err.Error(), statusCode)
+		return
+	}
+
+	var parsed struct {
+		Usage struct {
+			PromptTokens int `json:"prompt_tokens"`
+			TotalTokens  int `json:"total_tokens"`
+		} `json:"usage"`
+	}
+	json.Unmarshal(resp, &parsed)
+	usage := &langfuse.UsageData{
+		PromptTokens: parsed.Usage.PromptTokens,
+		TotalTokens:  parsed.Usage.TotalTokens,
+	}
+	// Vectors are not recorded as output
+	gen.track(r, h.sink, h.budgets, nil, usage, nil)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(resp)
+}
+
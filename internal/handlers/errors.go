@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// anthropicErrorType maps an HTTP status code to an Anthropic error type.
+func anthropicErrorType(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return "invalid_request_error"
+	case http.StatusUnauthorized:
+		return "authentication_error"
+	case http.StatusForbidden:
+		return "permission_error"
+	case http.StatusNotFound:
+		return "not_found_error"
+	case http.StatusRequestEntityTooLarge:
+		return "request_too_large"
+	case http.StatusTooManyRequests:
+		return "rate_limit_error"
+	case 529:
+		return "overloaded_error"
+	default:
+		return "api_error"
+	}
+}
+
+// anthropicErrorBody builds an Anthropic error envelope.
+func anthropicErrorBody(status int, message string) map[string]interface{} {
+	return map[string]interface{}{
+		"type": "error",
+		"error": map[string]interface{}{
+			"type":    anthropicErrorType(status),
+			"message": message,
+		},
+	}
+}
+
+// writeAnthropicError writes an error in Anthropic API format.
+func writeAnthropicError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(anthropicErrorBody(status, message))
+}
+
+// openAIErrorType maps an HTTP status code to an OpenAI error type.
+func openAIErrorType(status int) string {
+	switch status {
+	case http.StatusBadRequest, http.StatusNotFound, http.StatusRequestEntityTooLarge:
+		return "invalid_request_error"
+	case http.StatusUnauthorized:
+		return "authentication_error"
+	case http.StatusForbidden:
+		return "permission_error"
+	case http.StatusTooManyRequests:
+		return "rate_limit_exceeded"
+	default:
+		return "api_error"
+	}
+}
+
+// openAIErrorBody builds an OpenAI error envelope.
+func openAIErrorBody(status int, message string) map[string]interface{} {
+	return map[string]interface{}{
+		"error": map[string]interface{}{
+			"message": message,
+			"type":    openAIErrorType(status),
+			"param":   nil,
+			"code":    nil,
+		},
+	}
+}
+
+// writeOpenAIError writes an error in OpenAI API format.
+func writeOpenAIError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(openAIErrorBody(status, message))
+}
+
+// errorMessage extracts the message from an error response body, which may
+// be a JSON error envelope or plain text.
+func errorMessage(body []byte) string {
+	var envelope struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(body, &envelope) == nil && envelope.Error.Message != "" {
+		return envelope.Error.Message
+	}
+	return strings.TrimSpace(string(body))
+}
+
+// WriteError writes an error in the format of the API a request targets:
+// Anthropic for the messages endpoints, OpenAI otherwise. It is used by
+// middleware that rejects requests before they reach a handler.
+func WriteError(w http.ResponseWriter, r *http.Request, status int, message string) {
+	path := strings.TrimPrefix(r.URL.Path, "/v1")
+	if path == "/messages" || strings.HasPrefix(path, "/messages/") {
+		writeAnthropicError(w, status, message)
+		return
+	}
+	writeOpenAIError(w, status, message)
+}
@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/rahulvramesh/gh-proxy-local/internal/apikeys"
+	"github.com/rahulvramesh/gh-proxy-local/internal/feedback"
+	"github.com/rahulvramesh/gh-proxy-local/internal/langfuse"
+	"github.com/rahulvramesh/gh-proxy-local/internal/redact"
+	"github.com/rahulvramesh/gh-proxy-local/internal/telemetry"
+)
+
+// TraceIDHeader is the response header carrying the trace ID of a
+// generation, which feedback refers to.
+const TraceIDHeader = "X-Trace-ID"
+
+// defaultFeedbackName names feedback sent without a name.
+const defaultFeedbackName = "user-feedback"
+
+// maxFeedbackComment bounds feedback comments.
+const maxFeedbackComment = 4000
+
+// FeedbackHandler handles the feedback endpoint.
+type FeedbackHandler struct {
+	langfuse *langfuse.Client
+	store    *feedback.Store
+	redactor *redact.Redactor
+}
+
+// NewFeedbackHandler creates a new feedback handler. Comments are
+// redacted with redactor, which may be nil, before they are stored or sent
+// to Langfuse.
+func NewFeedbackHandler(client *langfuse.Client, store *feedback.Store, redactor *redact.Redactor) *FeedbackHandler {
+	return &FeedbackHandler{langfuse: client, store: store, redactor: redactor}
+}
+
+// Feedback handles POST /v1/feedback. It records a score for the trace
+// named by trace_id, as returned in the X-Trace-ID header. value is a
+// number, a boolean (thumbs up or down) or a category string.
+func (h *FeedbackHandler) Feedback(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		TraceID string      `json:"trace_id"`
+		Name    string      `json:"name"`
+		Value   interface{} `json:"value"`
+		Comment string      `json:"comment"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, fmt.Sprintf("Invalid request body: %v", err))
+		return
+	}
+
+	req.TraceID = strings.TrimSpace(req.TraceID)
+	if req.TraceID == "" {
+		writeOpenAIError(w, http.StatusBadRequest, "trace_id is required")
+		return
+	}
+	if req.Name = strings.TrimSpace(req.Name); req.Name == "" {
+		req.Name = defaultFeedbackName
+	}
+	if len(req.Comment) > maxFeedbackComment {
+		writeOpenAIError(w, http.StatusBadRequest, fmt.Sprintf("comment must be at most %d bytes", maxFeedbackComment))
+		return
+	}
+
+	comment := h.redactor.String(req.Comment)
+	if telemetry.NoLog(r.Context()) {
+		comment = ""
+	}
+	score := &langfuse.ScoreBody{
+		TraceID: req.TraceID,
+		Name:    req.Name,
+		Comment: comment,
+	}
+	switch v := req.Value.(type) {
+	case float64:
+		score.Value, score.DataType = v, langfuse.ScoreNumeric
+	case bool:
+		score.Value, score.DataType = 0.0, langfuse.ScoreBoolean
+		if v {
+			score.Value = 1.0
+		}
+	case string:
+		if v = strings.TrimSpace(v); v == "" {
+			writeOpenAIError(w, http.StatusBadRequest, "value must not be empty")
+			return
+		}
+		req.Value = v
+		score.Value, score.DataType = v, langfuse.ScoreCategorical
+	default:
+		writeOpenAIError(w, http.StatusBadRequest, "value must be a number, boolean or string")
+		return
+	}
+
+	fb, err := h.store.Add(feedback.Feedback{
+		TraceID: req.TraceID,
+		Name:    req.Name,
+		Value:   req.Value,
+		Comment: comment,
+		Key:     apikeys.NameFromContext(r.Context()),
+	})
+	if err != nil {
+		writeOpenAIError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to store feedback: %v", err))
+		return
+	}
+	score.ID = fb.ID
+	score.Timestamp = fb.CreatedAt
+	h.langfuse.TrackScore(score)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"object":     "feedback",
+		"id":         fb.ID,
+		"trace_id":   fb.TraceID,
+		"name":       fb.Name,
+		"value":      fb.Value,
+		"comment":    fb.Comment,
+		"created_at": fb.CreatedAt,
+	})
+}
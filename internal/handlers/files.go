@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/rahulvramesh/gh-proxy-local/internal/apikeys"
+	"github.com/rahulvramesh/gh-proxy-local/internal/files"
+)
+
+// ownedByCaller reports whether an object owned by apiKey, such as a file
+// or batch, belongs to the request's key. Other keys' objects are answered
+// as missing. Objects without an owner belong to anonymous callers.
+func ownedByCaller(r *http.Request, apiKey string) bool {
+	if apiKey == "" {
+		apiKey = apikeys.Anonymous
+	}
+	return apiKey == apikeys.NameFromContext(r.Context())
+}
+
+// FilesHandler handles the OpenAI Files API backed by the local file store.
+// Each key sees only the files it uploaded and its batches' output files.
+type FilesHandler struct {
+	store *files.Store
+}
+
+// NewFilesHandler creates a new files handler.
+func NewFilesHandler(store *files.Store) *FilesHandler {
+	return &FilesHandler{store: store}
+}
+
+// UploadFile handles POST /v1/files
+func (h *FilesHandler) UploadFile(w http.ResponseWriter, r *http.Request) {
+	// Parts beyond the memory limit are spooled to temporary files.
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, fmt.Sprintf("Expected multipart/form-data: %v", err))
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	purpose := r.FormValue("purpose")
+	if purpose == "" {
+		writeOpenAIError(w, http.StatusBadRequest, "purpose: field required")
+		return
+	}
+	if purpose != "batch" {
+		writeOpenAIError(w, http.StatusBadRequest, fmt.Sprintf("purpose: %q is not supported, only \"batch\" uploads are accepted", purpose))
+		return
+	}
+
+	upload, header, err := r.FormFile("file")
+	if err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, "file: field required")
+		return
+	}
+	defer upload.Close()
+
+	f, err := h.store.Create(apikeys.NameFromContext(r.Context()), header.Filename, purpose, upload)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, files.ErrTooLarge) {
+			status = http.StatusRequestEntityTooLarge
+		}
+		writeOpenAIError(w, status, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(f)
+}
+
+// ListFiles handles GET /v1/files
+func (h *FilesHandler) ListFiles(w http.ResponseWriter, r *http.Request) {
+	list := make([]*files.File, 0)
+	for _, f := range h.store.List(r.URL.Query().Get("purpose")) {
+		if ownedByCaller(r, f.APIKey) {
+			list = append(list, f)
+		}
+	}
+
+	if l := r.URL.Query().Get("limit"); l != "" {
+		if limit, err := strconv.Atoi(l); err == nil && limit > 0 && limit < len(list) {
+			list = list[:limit]
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"object":   "list",
+		"data":     list,
+		"has_more": false,
+	})
+}
+
+// GetFile handles GET /v1/files/{file_id}
+func (h *FilesHandler) GetFile(w http.ResponseWriter, r *http.Request) {
+	f, ok := h.lookup(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(f)
+}
+
+// FileContent handles GET /v1/files/{file_id}/content
+func (h *FilesHandler) FileContent(w http.ResponseWriter, r *http.Request) {
+	f, ok := h.lookup(w, r)
+	if !ok {
+		return
+	}
+	content, err := h.store.Open(f.ID)
+	if err != nil {
+		writeOpenAIError(w, http.StatusNotFound, fmt.Sprintf("No such File object: %s", f.ID))
+		return
+	}
+	defer content.Close()
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	io.Copy(w, content)
+}
+
+// DeleteFile handles DELETE /v1/files/{file_id}
+func (h *FilesHandler) DeleteFile(w http.ResponseWriter, r *http.Request) {
+	f, ok := h.lookup(w, r)
+	if !ok {
+		return
+	}
+	if err := h.store.Delete(f.ID); err != nil {
+		writeOpenAIError(w, http.StatusNotFound, fmt.Sprintf("No such File object: %s", f.ID))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":      f.ID,
+		"object":  "file",
+		"deleted": true,
+	})
+}
+
+// lookup resolves the file_id path value, writing a 404 if it is unknown
+// or belongs to another key.
+func (h *FilesHandler) lookup(w http.ResponseWriter, r *http.Request) (*files.File, bool) {
+	id := r.PathValue("file_id")
+	f, ok := h.store.Get(id)
+	if !ok || !ownedByCaller(r, f.APIKey) {
+		writeOpenAIError(w, http.StatusNotFound, fmt.Sprintf("No such File object: %s", id))
+		return nil, false
+	}
+	return f, true
+}
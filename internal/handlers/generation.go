@@ -0,0 +1,193 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rahulvramesh/gh-proxy-local/internal/apikeys"
+	"github.com/rahulvramesh/gh-proxy-local/internal/budget"
+	"github.com/rahulvramesh/gh-proxy-local/internal/copilot"
+	"github.com/rahulvramesh/gh-proxy-local/internal/langfuse"
+	"github.com/rahulvramesh/gh-proxy-local/internal/logging"
+	"github.com/rahulvramesh/gh-proxy-local/internal/telemetry"
+)
+
+// SessionIDHeader is the header a caller groups requests into a session
+// with.
+const SessionIDHeader = "X-Session-ID"
+
+// maxSessionIDLength bounds caller-supplied session IDs.
+const maxSessionIDLength = 200
+
+// generation describes one model call reported to the telemetry sinks.
+type generation struct {
+	traceID  string
+	id       string
+	name     string
+	endpoint string
+	api      string
+	start    time.Time
+	model    string
+	input    interface{}
+
+	userID          string
+	sessionID       string
+	modelParameters map[string]interface{}
+	completionStart *time.Time
+	finishReason    string
+}
+
+// newGeneration starts a generation named name, served by endpoint in the
+// api format, and returns its trace ID to the client in the X-Trace-ID
+// header of w.
+func newGeneration(w http.ResponseWriter, name, endpoint, api string) *generation {
+	g := &generation{
+		traceID:  langfuse.GenerateTraceID(),
+		id:       langfuse.GenerateSpanID(),
+		name:     name,
+		endpoint: endpoint,
+		api:      api,
+		start:    time.Now(),
+	}
+	w.Header().Set(TraceIDHeader, g.traceID)
+	return g
+}
+
+// attribute attributes the generation to user, or the request key's owner,
+// and to the request's session, and records the model parameters of req.
+// req may be nil for requests that are not chat completions.
+func (g *generation) attribute(r *http.Request, user string, req *copilot.ChatRequest) {
+	g.userID = traceUser(r, user)
+	if req == nil {
+		g.sessionID = traceSession(r, nil)
+		return
+	}
+	g.sessionID = traceSession(r, req.Messages)
+	g.modelParameters = map[string]interface{}{
+		"temperature": req.Temperature,
+		"max_tokens":  req.MaxTokens,
+	}
+	if names := toolNames(req.Tools); len(names) > 0 {
+		g.modelParameters["tools"] = names
+	}
+}
+
+// firstToken records when the first output token was relayed to the
+// client. Only the first call is recorded.
+func (g *generation) firstToken() {
+	if g.completionStart == nil {
+		now := time.Now()
+		g.completionStart = &now
+	}
+}
+
+// track counts the generation against the key's token budget and reports
+// it with its output to sink. A non-nil err marks the generation failed.
+func (g *generation) track(r *http.Request, sink telemetry.Sink, budgets *budget.Tracker, output interface{}, usage *langfuse.UsageData, err error) {
+	budgets.Record(r.Context(), usage)
+
+	if sink == nil || !sink.IsEnabled() {
+		return
+	}
+
+	end := time.Now()
+	rec := &telemetry.Record{
+		RequestID:       logging.RequestID(r.Context()),
+		TraceID:         g.traceID,
+		GenerationID:    g.id,
+		Name:            g.name,
+		Endpoint:        g.endpoint,
+		API:             g.api,
+		Model:           g.model,
+		Key:             apikeys.NameFromContext(r.Context()),
+		User:            g.userID,
+		Session:         g.sessionID,
+		StartTime:       g.start,
+		EndTime:         end,
+		LatencyMS:       end.Sub(g.start).Milliseconds(),
+		FirstTokenTime:  g.completionStart,
+		Status:          telemetry.StatusOK,
+		FinishReason:    g.finishReason,
+		ModelParameters: g.modelParameters,
+		Input:           g.input,
+		Output:          output,
+	}
+	if usage != nil {
+		rec.Usage = &telemetry.Usage{
+			PromptTokens:     usage.PromptTokens,
+			CompletionTokens: usage.CompletionTokens,
+			TotalTokens:      usage.TotalTokens,
+		}
+	}
+	if err != nil {
+		rec.Status = telemetry.StatusError
+		rec.Error = err.Error()
+	}
+
+	sink.Track(r.Context(), rec)
+}
+
+// traceUser returns the user the caller named, or the owner of the
+// request's key, falling back to the key name.
+func traceUser(r *http.Request, user string) string {
+	if user = strings.TrimSpace(user); user != "" {
+		return user
+	}
+	if key := apikeys.FromContext(r.Context()); key != nil && key.Owner != "" {
+		return key.Owner
+	}
+	return apikeys.NameFromContext(r.Context())
+}
+
+// traceSession returns the X-Session-ID header, or an ID derived from the
+// conversation's opening messages so every turn of a conversation shares
+// it. It returns "" when there is neither.
+func traceSession(r *http.Request, messages []map[string]interface{}) string {
+	if id := strings.TrimSpace(r.Header.Get(SessionIDHeader)); id != "" {
+		if len(id) > maxSessionIDLength {
+			id = id[:maxSessionIDLength]
+		}
+		return id
+	}
+	return conversationID(apikeys.NameFromContext(r.Context()), messages)
+}
+
+// conversationID hashes the messages up to and including the first user
+// message, which stay the same as a conversation grows, scoped to the key.
+func conversationID(keyName string, messages []map[string]interface{}) string {
+	end := -1
+	for i, msg := range messages {
+		if msg["role"] == "user" {
+			end = i
+			break
+		}
+	}
+	if end < 0 {
+		return ""
+	}
+	data, err := json.Marshal(messages[:end+1])
+	if err != nil {
+		return ""
+	}
+	h := sha256.New()
+	h.Write([]byte(keyName))
+	h.Write([]byte{0})
+	h.Write(data)
+	return "conv-" + hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+// toolNames returns the function names of Copilot-format tools.
+func toolNames(tools []map[string]interface{}) []string {
+	var names []string
+	for _, tool := range tools {
+		fn, _ := tool["function"].(map[string]interface{})
+		if name, _ := fn["name"].(string); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
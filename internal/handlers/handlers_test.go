@@ -2,12 +2,30 @@ package handlers
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/rahulvramesh/gh-proxy-local/internal/apikeys"
+	"github.com/rahulvramesh/gh-proxy-local/internal/budget"
+	"github.com/rahulvramesh/gh-proxy-local/internal/config"
+	"github.com/rahulvramesh/gh-proxy-local/internal/copilot"
+	"github.com/rahulvramesh/gh-proxy-local/internal/feedback"
+	"github.com/rahulvramesh/gh-proxy-local/internal/history"
+	"github.com/rahulvramesh/gh-proxy-local/internal/inflight"
+	"github.com/rahulvramesh/gh-proxy-local/internal/langfuse"
+	"github.com/rahulvramesh/gh-proxy-local/internal/logging"
+	"github.com/rahulvramesh/gh-proxy-local/internal/metrics"
+	"github.com/rahulvramesh/gh-proxy-local/internal/models"
+	"github.com/rahulvramesh/gh-proxy-local/internal/redact"
+	"github.com/rahulvramesh/gh-proxy-local/internal/telemetry"
 )
 
 // MockResponseWriter is a mock response writer for testing streaming
@@ -171,19 +189,6 @@ func TestAnthropicHandler_CountTokens_InvalidRequest(t *testing.T) {
 	}
 }
 
-func TestAnthropicHandler_Batches(t *testing.T) {
-	handler := &AnthropicHandler{}
-
-	req := httptest.NewRequest("POST", "/v1/messages/batches", nil)
-	rec := httptest.NewRecorder()
-
-	handler.Batches(rec, req)
-
-	if rec.Code != http.StatusNotImplemented {
-		t.Errorf("Expected status 501, got %d", rec.Code)
-	}
-}
-
 func TestResponsesHandler_FilterFunctionTools(t *testing.T) {
 	handler := &ResponsesHandler{}
 
@@ -522,3 +527,435 @@ func TestResponsesHandler_ConvertToResponsesOutput_EmptyChoices(t *testing.T) {
 		t.Errorf("Expected empty output for empty choices, got %d", len(result))
 	}
 }
+
+func TestStripPrefillFromContent(t *testing.T) {
+	resp := map[string]interface{}{
+		"content": []interface{}{
+			map[string]interface{}{"type": "text", "text": "{\"ok\": true}"},
+		},
+	}
+	stripPrefillFromContent(resp, "{")
+
+	content := resp["content"].([]interface{})
+	if text := content[0].(map[string]interface{})["text"]; text != "\"ok\": true}" {
+		t.Errorf("Expected prefill stripped, got %q", text)
+	}
+
+	echoOnly := map[string]interface{}{
+		"content": []interface{}{
+			map[string]interface{}{"type": "text", "text": "{"},
+			map[string]interface{}{"type": "tool_use", "id": "toolu_1"},
+		},
+	}
+	stripPrefillFromContent(echoOnly, "{")
+	if content := echoOnly["content"].([]interface{}); len(content) != 1 {
+		t.Errorf("Expected empty text block to be dropped, got %v", content)
+	}
+}
+
+func TestWriteError(t *testing.T) {
+	tests := []struct {
+		path      string
+		anthropic bool
+	}{
+		{"/v1/messages", true},
+		{"/messages/count_tokens", true},
+		{"/v1/chat/completions", false},
+		{"/v1/messagesx", false},
+	}
+
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		WriteError(rec, httptest.NewRequest("POST", tt.path, nil), http.StatusForbidden, "nope")
+
+		var body map[string]interface{}
+		json.Unmarshal(rec.Body.Bytes(), &body)
+		if isAnthropic := body["type"] == "error"; isAnthropic != tt.anthropic {
+			t.Errorf("%s: anthropic format = %v, want %v (%s)", tt.path, isAnthropic, tt.anthropic, rec.Body.String())
+		}
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("%s: status = %d", tt.path, rec.Code)
+		}
+	}
+}
+
+func TestKeysHandler(t *testing.T) {
+	store, err := apikeys.NewStore(filepath.Join(t.TempDir(), "keys.json"))
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	handler := NewKeysHandler(store)
+
+	rec := httptest.NewRecorder()
+	handler.CreateKey(rec, httptest.NewRequest("POST", "/admin/keys", strings.NewReader(`{"name": "ci", "owner": "platform", "allowed_models": ["gpt-4.1"], "expires_in": "24h"}`)))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create status = %d: %s", rec.Code, rec.Body.String())
+	}
+	var created map[string]interface{}
+	json.Unmarshal(rec.Body.Bytes(), &created)
+	secret, _ := created["key"].(string)
+	if secret == "" || created["expires_at"] == nil {
+		t.Fatalf("unexpected create response: %v", created)
+	}
+	if _, err := store.Authenticate(secret); err != nil {
+		t.Errorf("created secret does not authenticate: %v", err)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.CreateKey(rec, httptest.NewRequest("POST", "/admin/keys", strings.NewReader(`{"name": "ci"}`)))
+	if rec.Code != http.StatusConflict {
+		t.Errorf("duplicate create status = %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ListKeys(rec, httptest.NewRequest("GET", "/admin/keys", nil))
+	if strings.Contains(rec.Body.String(), secret) || strings.Contains(rec.Body.String(), "hash") {
+		t.Errorf("list leaks secret material: %s", rec.Body.String())
+	}
+
+	req := httptest.NewRequest("DELETE", "/admin/keys/ci", nil)
+	req.SetPathValue("name", "ci")
+	rec = httptest.NewRecorder()
+	handler.RevokeKey(rec, req)
+	if !strings.Contains(rec.Body.String(), `"status":"revoked"`) {
+		t.Errorf("revoke response = %s", rec.Body.String())
+	}
+
+	req = httptest.NewRequest("POST", "/admin/keys/missing/rotate", nil)
+	req.SetPathValue("name", "missing")
+	rec = httptest.NewRecorder()
+	handler.RotateKey(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("rotate missing status = %d", rec.Code)
+	}
+}
+
+func TestChatHandler_ModelNotAllowed(t *testing.T) {
+	handler := NewChatHandler(nil, nil, nil, false)
+	req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(`{"model": "gpt-5", "messages": []}`))
+	req = req.WithContext(apikeys.WithKey(req.Context(), &apikeys.Key{Name: "ci", AllowedModels: []string{"gpt-4.1"}}))
+	rec := httptest.NewRecorder()
+	handler.ChatCompletions(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("status = %d, want 403", rec.Code)
+	}
+}
+
+func TestAnthropicHandler_BudgetExhausted(t *testing.T) {
+	tracker, err := budget.NewTracker(filepath.Join(t.TempDir(), "budgets.json"), config.BudgetConfig{DailyTokens: 10}, nil, false)
+	if err != nil {
+		t.Fatalf("NewTracker failed: %v", err)
+	}
+	ctx := apikeys.WithKey(context.Background(), &apikeys.Key{Name: "ci"})
+	tracker.Record(ctx, &langfuse.UsageData{TotalTokens: 10})
+
+	handler := NewAnthropicHandler(nil, nil, tracker, nil, false)
+	req := httptest.NewRequest("POST", "/v1/messages", strings.NewReader(`{"model": "claude-sonnet-4.5", "max_tokens": 10, "messages": []}`))
+	rec := httptest.NewRecorder()
+	handler.Messages(rec, req.WithContext(ctx))
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("status = %d, want 429", rec.Code)
+	}
+	var body map[string]interface{}
+	json.Unmarshal(rec.Body.Bytes(), &body)
+	if body["type"] != "error" || !strings.Contains(errorMessage(rec.Body.Bytes()), "daily budget") {
+		t.Errorf("body = %s", rec.Body.String())
+	}
+}
+
+func TestGenerationAttribute(t *testing.T) {
+	key := &apikeys.Key{Name: "ci", Owner: "alice"}
+	newRequest := func(sessionID string) *http.Request {
+		req := httptest.NewRequest("POST", "/v1/chat/completions", nil)
+		if sessionID != "" {
+			req.Header.Set(SessionIDHeader, sessionID)
+		}
+		return req.WithContext(apikeys.WithKey(req.Context(), key))
+	}
+	turn := func(messages ...map[string]interface{}) *copilot.ChatRequest {
+		return &copilot.ChatRequest{
+			Messages:    messages,
+			Temperature: 0.2,
+			MaxTokens:   100,
+			Tools: []map[string]interface{}{
+				{"type": "function", "function": map[string]interface{}{"name": "get_weather"}},
+			},
+		}
+	}
+	attribute := func(r *http.Request, user string, req *copilot.ChatRequest) *generation {
+		g := newGeneration(httptest.NewRecorder(), "chat-completion", "chat/completions", "openai")
+		g.attribute(r, user, req)
+		return g
+	}
+	system := map[string]interface{}{"role": "system", "content": "Be brief."}
+	first := map[string]interface{}{"role": "user", "content": "Hi"}
+	reply := map[string]interface{}{"role": "assistant", "content": "Hello"}
+	second := map[string]interface{}{"role": "user", "content": "Weather?"}
+
+	a := attribute(newRequest(""), "", turn(system, first))
+	if a.userID != "alice" {
+		t.Errorf("userID = %q, want key owner", a.userID)
+	}
+	if a.modelParameters["temperature"] != 0.2 || a.modelParameters["max_tokens"] != 100 {
+		t.Errorf("modelParameters = %v", a.modelParameters)
+	}
+	if tools, _ := a.modelParameters["tools"].([]string); len(tools) != 1 || tools[0] != "get_weather" {
+		t.Errorf("tools = %v", a.modelParameters["tools"])
+	}
+
+	b := attribute(newRequest(""), "bob", turn(system, first, reply, second))
+	if b.userID != "bob" {
+		t.Errorf("userID = %q, want bob", b.userID)
+	}
+	if a.sessionID == "" || a.sessionID != b.sessionID {
+		t.Errorf("turns of a conversation got sessions %q and %q", a.sessionID, b.sessionID)
+	}
+	if other := attribute(newRequest(""), "", turn(system, second)); other.sessionID == a.sessionID {
+		t.Error("different conversations share a session")
+	}
+	if c := attribute(newRequest("s-1"), "", turn(system, first)); c.sessionID != "s-1" {
+		t.Errorf("sessionID = %q, want header value", c.sessionID)
+	}
+
+	if a.completionStart != nil {
+		t.Fatal("completionStart set before the first token")
+	}
+	a.firstToken()
+	start := a.completionStart
+	a.firstToken()
+	if start == nil || a.completionStart != start {
+		t.Error("firstToken did not record only the first call")
+	}
+}
+
+func TestGenerationOutput_Stream(t *testing.T) {
+	chunks := []string{
+		`{"choices":[{"delta":{"role":"assistant","reasoning_text":"Need the "}}]}`,
+		`{"choices":[{"delta":{"reasoning_text":"weather."}}]}`,
+		`{"choices":[{"delta":{"content":"Checking"}}]}`,
+		`{"choices":[{"delta":{"tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"get_weather","arguments":""}}]}}]}`,
+		`{"choices":[{"delta":{"tool_calls":[{"index":0,"function":{"arguments":"{\"city\":"}}]}}]}`,
+		`{"choices":[{"delta":{"tool_calls":[{"index":0,"function":{"arguments":"\"Paris\"}"}}]}}]}`,
+		`{"choices":[{"delta":{"tool_calls":[{"index":1,"id":"call_2","function":{"name":"get_time","arguments":"{}"}}]}}]}`,
+		`{"choices":[{"delta":{},"finish_reason":"tool_calls"}]}`,
+	}
+	out := &generationOutput{}
+	if out.started() {
+		t.Fatal("started before any output")
+	}
+	for _, c := range chunks {
+		var chunk map[string]interface{}
+		if err := json.Unmarshal([]byte(c), &chunk); err != nil {
+			t.Fatal(err)
+		}
+		out.addChunk(chunk)
+	}
+
+	msg := out.message()
+	if msg["content"] != "Checking" || msg["reasoning"] != "Need the weather." {
+		t.Errorf("message = %v", msg)
+	}
+	if out.finishReason != "tool_calls" {
+		t.Errorf("finishReason = %q", out.finishReason)
+	}
+	toolCalls, _ := msg["tool_calls"].([]map[string]interface{})
+	if len(toolCalls) != 2 {
+		t.Fatalf("tool_calls = %v", msg["tool_calls"])
+	}
+	fn, _ := toolCalls[0]["function"].(map[string]interface{})
+	if toolCalls[0]["id"] != "call_1" || fn["name"] != "get_weather" || fn["arguments"] != `{"city":"Paris"}` {
+		t.Errorf("first tool call = %v", toolCalls[0])
+	}
+	if toolCalls[1]["id"] != "call_2" {
+		t.Errorf("second tool call = %v", toolCalls[1])
+	}
+}
+
+func TestGenerationOutput_Response(t *testing.T) {
+	var resp models.OpenAIChatResponse
+	body := `{"choices":[{"message":{"role":"assistant","content":"","reasoning_text":"Think.","tool_calls":[{"id":"call_1","type":"function","function":{"name":"lookup","arguments":"{\"q\":1}"}}]},"finish_reason":"tool_calls"}]}`
+	if err := json.Unmarshal([]byte(body), &resp); err != nil {
+		t.Fatal(err)
+	}
+
+	out := responseOutput(&resp)
+	msg := out.message()
+	if msg["reasoning"] != "Think." || out.finishReason != "tool_calls" {
+		t.Errorf("message = %v, finishReason = %q", msg, out.finishReason)
+	}
+	toolCalls, _ := msg["tool_calls"].([]map[string]interface{})
+	if len(toolCalls) != 1 {
+		t.Fatalf("tool_calls = %v", msg["tool_calls"])
+	}
+	if fn, _ := toolCalls[0]["function"].(map[string]interface{}); fn["arguments"] != `{"q":1}` {
+		t.Errorf("tool call = %v", toolCalls[0])
+	}
+}
+
+func TestFeedbackHandler(t *testing.T) {
+	store, err := feedback.NewStore(filepath.Join(t.TempDir(), "feedback.jsonl"), 1<<20)
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	redactor, err := redact.New(config.RedactConfig{Detectors: []string{"email"}, Mode: "redact"})
+	if err != nil {
+		t.Fatalf("redact.New failed: %v", err)
+	}
+	handler := NewFeedbackHandler(langfuse.NewClient(config.LangfuseConfig{}, false), store, redactor)
+
+	post := func(body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.Feedback(rec, httptest.NewRequest("POST", "/v1/feedback", strings.NewReader(body)))
+		return rec
+	}
+
+	rec := post(`{"trace_id":"trace-1","value":true,"comment":"nice"}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp map[string]interface{}
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if resp["name"] != defaultFeedbackName || resp["value"] != true {
+		t.Errorf("unexpected response %v", resp)
+	}
+	if got := store.ForTrace("trace-1"); len(got) != 1 || got[0].Comment != "nice" || got[0].Key != apikeys.Anonymous {
+		t.Errorf("unexpected stored feedback %+v", got)
+	}
+
+	post(`{"trace_id":"trace-2","value":false,"comment":"mail me at jane@example.com"}`)
+	if got := store.ForTrace("trace-2"); len(got) != 1 || strings.Contains(got[0].Comment, "jane@example.com") {
+		t.Errorf("expected stored comment to be redacted, got %+v", got)
+	}
+	rec = httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/v1/feedback", strings.NewReader(`{"trace_id":"trace-3","value":1,"comment":"private"}`))
+	handler.Feedback(rec, r.WithContext(telemetry.WithNoLog(r.Context())))
+	if got := store.ForTrace("trace-3"); rec.Code != http.StatusCreated || len(got) != 1 || got[0].Comment != "" {
+		t.Errorf("expected comment to be dropped under X-No-Log, got %d %+v", rec.Code, got)
+	}
+
+	for _, body := range []string{
+		`{"value":1}`,
+		`{"trace_id":"trace-1"}`,
+		`{"trace_id":"trace-1","value":""}`,
+		`{"trace_id":"trace-1","value":[1]}`,
+	} {
+		if rec := post(body); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", body, rec.Code)
+		}
+	}
+}
+
+func TestNewGenerationTraceHeader(t *testing.T) {
+	rec := httptest.NewRecorder()
+	g := newGeneration(rec, "chat-completion", "chat/completions", "openai")
+	if got := rec.Header().Get(TraceIDHeader); got == "" || got != g.traceID {
+		t.Errorf("X-Trace-ID = %q, want %q", got, g.traceID)
+	}
+}
+
+func TestHistoryHandler(t *testing.T) {
+	dir := t.TempDir()
+	store, err := history.NewStore(filepath.Join(dir, "history.jsonl"), config.HistoryConfig{MaxBytes: 1 << 20}, nil)
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	defer store.Shutdown()
+	fb, _ := feedback.NewStore(filepath.Join(dir, "feedback.jsonl"), 1<<20)
+	handler := NewHistoryHandler(store, fb)
+
+	for _, query := range []string{"since=yesterday", "limit=0", "limit=1000"} {
+		rec := httptest.NewRecorder()
+		handler.ListRequests(rec, httptest.NewRequest("GET", "/admin/requests?"+query, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", query, rec.Code)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ListRequests(rec, httptest.NewRequest("GET", "/admin/requests?model=gpt-4.1&since=2026-10-18T15:00:00Z", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"data":[]`) {
+		t.Errorf("expected empty list, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	req := httptest.NewRequest("GET", "/admin/requests/missing", nil)
+	req.SetPathValue("id", "missing")
+	rec = httptest.NewRecorder()
+	handler.GetRequest(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404, got %d", rec.Code)
+	}
+}
+
+func TestAdminHandler(t *testing.T) {
+	reloads := 0
+	handler := NewAdminHandler(copilot.NewClient(nil, config.UpstreamConfig{}, false), nil, langfuse.NewClient(config.LangfuseConfig{}, false), map[string]Reloader{
+		"aliases": func() (string, error) { reloads++; return "2 aliases", nil },
+	})
+
+	rec := httptest.NewRecorder()
+	handler.Reload(rec, httptest.NewRequest("POST", "/admin/reload", nil))
+	if rec.Code != http.StatusOK || reloads != 1 || !strings.Contains(rec.Body.String(), `"aliases":"2 aliases"`) {
+		t.Errorf("unexpected reload response %d: %s", rec.Code, rec.Body.String())
+	}
+
+	handler.reloaders["keys"] = func() (string, error) { return "", errors.New("bad keys file") }
+	rec = httptest.NewRecorder()
+	handler.Reload(rec, httptest.NewRequest("POST", "/admin/reload", nil))
+	if rec.Code != http.StatusInternalServerError || reloads != 2 || !strings.Contains(rec.Body.String(), "keys: bad keys file") {
+		t.Errorf("expected failed reload to name the section, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	handler.FlushLangfuse(rec, httptest.NewRequest("POST", "/admin/langfuse/flush", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"enabled":false`) {
+		t.Errorf("unexpected Langfuse queue %d: %s", rec.Code, rec.Body.String())
+	}
+
+	defer logging.SetDebug(false)
+	for body, want := range map[string]int{`{}`: http.StatusBadRequest, `{"enabled":"yes"}`: http.StatusBadRequest, `{"enabled":true}`: http.StatusOK} {
+		rec = httptest.NewRecorder()
+		handler.SetDebug(rec, httptest.NewRequest("PUT", "/admin/debug", strings.NewReader(body)))
+		if rec.Code != want {
+			t.Errorf("%s: expected %d, got %d", body, want, rec.Code)
+		}
+	}
+	rec = httptest.NewRecorder()
+	handler.Debug(rec, httptest.NewRequest("GET", "/admin/debug", nil))
+	if !strings.Contains(rec.Body.String(), `"enabled":true`) || !strings.Contains(rec.Body.String(), `"log_level":"debug"`) {
+		t.Errorf("expected debug to be on, got %s", rec.Body.String())
+	}
+}
+
+func TestInFlightHandler(t *testing.T) {
+	registry := inflight.NewRegistry()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer registry.Start("req-1", "POST", "/v1/chat/completions", nil, cancel)()
+	handler := NewInFlightHandler(registry)
+
+	rec := httptest.NewRecorder()
+	handler.ListInFlight(rec, httptest.NewRequest("GET", "/admin/inflight", nil))
+	if !strings.Contains(rec.Body.String(), `"id":"req-1"`) {
+		t.Errorf("expected req-1 to be listed, got %s", rec.Body.String())
+	}
+
+	for id, want := range map[string]int{"missing": http.StatusNotFound, "req-1": http.StatusOK} {
+		req := httptest.NewRequest("POST", "/admin/inflight/"+id+"/cancel", nil)
+		req.SetPathValue("id", id)
+		rec = httptest.NewRecorder()
+		handler.CancelInFlight(rec, req)
+		if rec.Code != want {
+			t.Errorf("cancel %s: expected %d, got %d", id, want, rec.Code)
+		}
+	}
+	if ctx.Err() == nil {
+		t.Error("expected the request's context to be cancelled")
+	}
+}
+
+func TestSetMetricsModelBoundsLabels(t *testing.T) {
+	ctx, labels := metrics.WithLabels(context.Background(), "/v1/chat/completions", time.Now())
+	setMetricsModel(ctx, nil, "attacker-chosen-model")
+	if model, _ := labels.Values(); model != metrics.OtherModel {
+		t.Errorf("model label = %q, want %q", model, metrics.OtherModel)
+	}
+}
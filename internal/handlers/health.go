@@ -27,9 +27,9 @@ func (h *HealthHandler) Health(w http.ResponseWriter, r *http.Request) {
 		"service": "github-copilot-proxy",
 		"version": "1.0.0",
 		"endpoints": map[string][]string{
-			"openai":    {"/v1/chat/completions", "/v1/responses", "/v1/models"},
-			"anthropic": {"/v1/messages"},
-			"info":      {"/health", "/info", "/v1/account"},
+			"openai":    {"/v1/chat/completions", "/v1/responses", "/v1/embeddings", "/v1/models", "/v1/files", "/v1/batches"},
+			"anthropic": {"/v1/messages", "/v1/messages/batches"},
+			"info":      {"/health", "/info", "/metrics", "/v1/account", "/v1/usage"},
 		},
 	}
 
@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/rahulvramesh/gh-proxy-local/internal/feedback"
+	"github.com/rahulvramesh/gh-proxy-local/internal/history"
+)
+
+const (
+	defaultHistoryLimit = 50
+	maxHistoryLimit     = 500
+)
+
+// HistoryHandler handles the request history admin endpoints.
+type HistoryHandler struct {
+	store    *history.Store
+	feedback *feedback.Store
+}
+
+// NewHistoryHandler creates a new request history handler. Feedback given
+// to a request's trace is included in its details.
+func NewHistoryHandler(store *history.Store, feedback *feedback.Store) *HistoryHandler {
+	return &HistoryHandler{store: store, feedback: feedback}
+}
+
+// ListRequests handles GET /admin/requests. Requests are listed newest
+// first without their payloads, filtered by the since and until (RFC 3339)
+// time range, model, key, endpoint, status (ok, error or an HTTP status
+// code) and q, a case-insensitive text search over the whole request.
+// limit caps the results (default 50, at most 500).
+func (h *HistoryHandler) ListRequests(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	f := history.Filter{
+		Model:    query.Get("model"),
+		Key:      query.Get("key"),
+		Endpoint: query.Get("endpoint"),
+		Status:   query.Get("status"),
+		Text:     query.Get("q"),
+		Limit:    defaultHistoryLimit,
+	}
+	for name, t := range map[string]*time.Time{"since": &f.Since, "until": &f.Until} {
+		value := query.Get(name)
+		if value == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			writeOpenAIError(w, http.StatusBadRequest, fmt.Sprintf("%s must be an RFC 3339 time such as 2006-01-02T15:04:05Z", name))
+			return
+		}
+		*t = parsed
+	}
+	if l := query.Get("limit"); l != "" {
+		limit, err := strconv.Atoi(l)
+		if err != nil || limit <= 0 || limit > maxHistoryLimit {
+			writeOpenAIError(w, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxHistoryLimit))
+			return
+		}
+		f.Limit = limit
+	}
+
+	entries, hasMore := h.store.Query(f)
+	if entries == nil {
+		entries = []*history.Entry{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"object":   "list",
+		"data":     entries,
+		"has_more": hasMore,
+	})
+}
+
+// GetRequest handles GET /admin/requests/{id}, where id is a request ID or
+// trace ID. The response includes the inbound body, the Copilot payload,
+// the final response and any feedback on the trace.
+func (h *HistoryHandler) GetRequest(w http.ResponseWriter, r *http.Request) {
+	entry, ok := h.store.Get(r.PathValue("id"))
+	if !ok {
+		writeOpenAIError(w, http.StatusNotFound, fmt.Sprintf("No request %q in the history", r.PathValue("id")))
+		return
+	}
+
+	fb := h.feedback.ForTrace(entry.TraceID)
+	if fb == nil {
+		fb = []feedback.Feedback{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		*history.Entry
+		Feedback []feedback.Feedback `json:"feedback"`
+	}{entry, fb})
+}
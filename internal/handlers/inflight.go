@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/rahulvramesh/gh-proxy-local/internal/inflight"
+)
+
+// InFlightHandler handles the in-flight request admin endpoints.
+type InFlightHandler struct {
+	registry *inflight.Registry
+}
+
+// NewInFlightHandler creates a new in-flight request handler.
+func NewInFlightHandler(registry *inflight.Registry) *InFlightHandler {
+	return &InFlightHandler{registry: registry}
+}
+
+// ListInFlight handles GET /admin/inflight, listing the inference requests
+// being served, oldest first.
+func (h *InFlightHandler) ListInFlight(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"object": "list",
+		"data":   h.registry.List(),
+	})
+}
+
+// CancelInFlight handles POST /admin/inflight/{id}/cancel, cancelling the
+// request with the given request ID. The client receives an error and the
+// upstream call is aborted.
+func (h *InFlightHandler) CancelInFlight(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	n := h.registry.Cancel(id)
+	if n == 0 {
+		writeOpenAIError(w, http.StatusNotFound, fmt.Sprintf("No in-flight request %q", id))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"object":    "inflight_request",
+		"id":        id,
+		"cancelled": n,
+	})
+}
@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/rahulvramesh/gh-proxy-local/internal/apikeys"
+	"github.com/rahulvramesh/gh-proxy-local/internal/batch"
+	"github.com/rahulvramesh/gh-proxy-local/internal/logging"
+	"github.com/rahulvramesh/gh-proxy-local/internal/ratelimit"
+)
+
+// responseBuffer is an in-memory http.ResponseWriter used to run handlers
+// internally, e.g. for batch items.
+type responseBuffer struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newResponseBuffer() *responseBuffer {
+	return &responseBuffer{header: make(http.Header)}
+}
+
+func (b *responseBuffer) Header() http.Header {
+	return b.header
+}
+
+func (b *responseBuffer) Write(p []byte) (int, error) {
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+	return b.body.Write(p)
+}
+
+func (b *responseBuffer) WriteHeader(statusCode int) {
+	if b.status == 0 {
+		b.status = statusCode
+	}
+}
+
+func (b *responseBuffer) Flush() {}
+
+// ItemServer runs batch items through the local handlers as the API key
+// that submitted their batch, within that key's rate limits.
+type ItemServer struct {
+	keys    *apikeys.Store
+	limiter *ratelimit.Limiter
+}
+
+// NewItemServer creates an ItemServer resolving batch keys in keys, which
+// may be nil when only the single COPILOT_API_KEY is used. A nil limiter
+// applies no limits.
+func NewItemServer(keys *apikeys.Store, limiter *ratelimit.Limiter) *ItemServer {
+	return &ItemServer{keys: keys, limiter: limiter}
+}
+
+// serve runs handler against an in-memory request and returns the status
+// code, headers and body it produced. Batch items run with the current
+// settings of the key that submitted their batch, and fail once that key
+// is revoked or expired. They count against the key's request, token and
+// concurrency limits, waiting for the rate limits rather than failing,
+// and yield upstream concurrency to interactive requests. Each item gets
+// its own request ID.
+func (s *ItemServer) serve(ctx context.Context, handler http.HandlerFunc, method, path string, body []byte) (int, http.Header, []byte) {
+	ctx = ratelimit.WithPriority(ctx, ratelimit.Batch)
+	if logging.RequestID(ctx) == "" {
+		ctx = logging.WithRequestID(ctx, logging.NewRequestID())
+	}
+	key, status, keyErr := s.batchKey(batch.JobFromContext(ctx), path)
+	if key != nil {
+		ctx = apikeys.WithKey(ctx, key)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, path, bytes.NewReader(body))
+	if err != nil {
+		return http.StatusInternalServerError, nil, []byte(err.Error())
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	buf := newResponseBuffer()
+	if keyErr != nil {
+		WriteError(buf, req, status, keyErr.Error())
+		return buf.status, buf.header, buf.body.Bytes()
+	}
+	release, err := s.acquire(ctx)
+	if err != nil {
+		ratelimit.SetRetryAfter(buf.header, err)
+		WriteError(buf, req, http.StatusTooManyRequests, err.Error())
+		return buf.status, buf.header, buf.body.Bytes()
+	}
+	defer release()
+	handler(buf, req)
+	if buf.status == 0 {
+		buf.status = http.StatusOK
+	}
+	return buf.status, buf.header, buf.body.Bytes()
+}
+
+// batchKey looks up the key that submitted job in the registry. It fails
+// with the status to report when the key may no longer call path.
+func (s *ItemServer) batchKey(job *batch.Job, path string) (*apikeys.Key, int, error) {
+	if job == nil || job.APIKey == "" || job.APIKey == apikeys.Anonymous {
+		return nil, 0, nil
+	}
+	if job.APIKey == apikeys.Default {
+		return &apikeys.Key{Name: apikeys.Default}, 0, nil
+	}
+
+	var key *apikeys.Key
+	var ok bool
+	if s.keys != nil {
+		key, ok = s.keys.Get(job.APIKey)
+	}
+	if !ok {
+		return nil, http.StatusUnauthorized, fmt.Errorf("API key %q no longer exists", job.APIKey)
+	}
+	if err := key.Status(time.Now()); err != nil {
+		return nil, http.StatusUnauthorized, fmt.Errorf("%w: %q", err, key.Name)
+	}
+	if !key.AllowsEndpoint(path) {
+		return nil, http.StatusForbidden, fmt.Errorf("API key %q may not call %s", key.Name, path)
+	}
+	return key, 0, nil
+}
+
+// acquire waits until the request key's request and token buckets allow
+// another request, then takes one of its concurrency slots. The returned
+// function releases the slot.
+func (s *ItemServer) acquire(ctx context.Context) (func(), error) {
+	if s.limiter == nil {
+		return func() {}, nil
+	}
+
+	name := apikeys.NameFromContext(ctx)
+	limits := s.limiter.Limits(apikeys.FromContext(ctx))
+	for {
+		_, err := s.limiter.Allow(name, limits)
+		var limitErr *ratelimit.Error
+		if !errors.As(err, &limitErr) {
+			break
+		}
+		timer := time.NewTimer(limitErr.RetryAfter)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+	return s.limiter.AcquireKey(ctx, name, limits.MaxInFlight)
+}
@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/rahulvramesh/gh-proxy-local/internal/apikeys"
+)
+
+// KeysHandler handles the API key admin endpoints.
+type KeysHandler struct {
+	store *apikeys.Store
+}
+
+// NewKeysHandler creates a new API keys handler.
+func NewKeysHandler(store *apikeys.Store) *KeysHandler {
+	return &KeysHandler{store: store}
+}
+
+// keyObject renders a key without its hash.
+func keyObject(key *apikeys.Key) map[string]interface{} {
+	obj := map[string]interface{}{
+		"object":               "api_key",
+		"name":                 key.Name,
+		"owner":                key.Owner,
+		"prefix":               key.Prefix,
+		"allowed_models":       key.AllowedModels,
+		"allowed_endpoints":    key.AllowedEndpoints,
+		"default_model":        key.DefaultModel,
+		"requests_per_minute":  key.RequestsPerMinute,
+		"tokens_per_minute":    key.TokensPerMinute,
+		"max_in_flight":        key.MaxInFlight,
+		"daily_token_budget":   key.DailyTokenBudget,
+		"monthly_token_budget": key.MonthlyTokenBudget,
+		"created_at":           key.CreatedAt,
+		"expires_at":           key.ExpiresAt,
+		"rotated_at":           key.RotatedAt,
+		"revoked_at":           key.RevokedAt,
+		"status":               "active",
+	}
+	if err := key.Status(time.Now()); errors.Is(err, apikeys.ErrRevoked) {
+		obj["status"] = "revoked"
+	} else if errors.Is(err, apikeys.ErrExpired) {
+		obj["status"] = "expired"
+	}
+	return obj
+}
+
+// keyStatus maps key store errors to HTTP status codes.
+func keyStatus(err error) int {
+	switch {
+	case errors.Is(err, apikeys.ErrInvalidName):
+		return http.StatusBadRequest
+	case errors.Is(err, apikeys.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, apikeys.ErrExists), errors.Is(err, apikeys.ErrRevoked):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// ListKeys handles GET /admin/keys
+func (h *KeysHandler) ListKeys(w http.ResponseWriter, r *http.Request) {
+	keys := h.store.List()
+	data := make([]map[string]interface{}, len(keys))
+	for i, key := range keys {
+		data[i] = keyObject(key)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"object": "list",
+		"data":   data,
+	})
+}
+
+// CreateKey handles POST /admin/keys. The secret is only returned here and
+// by RotateKey.
+func (h *KeysHandler) CreateKey(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		apikeys.Spec
+		ExpiresIn string `json:"expires_in"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, fmt.Sprintf("Invalid request body: %v", err))
+		return
+	}
+	if req.ExpiresIn != "" {
+		d, err := time.ParseDuration(req.ExpiresIn)
+		if err != nil || d <= 0 {
+			writeOpenAIError(w, http.StatusBadRequest, "expires_in: must be a positive duration such as \"720h\"")
+			return
+		}
+		expires := time.Now().Add(d).UTC()
+		req.Spec.ExpiresAt = &expires
+	}
+
+	key, secret, err := h.store.Create(req.Spec)
+	if err != nil {
+		writeOpenAIError(w, keyStatus(err), err.Error())
+		return
+	}
+
+	obj := keyObject(key)
+	obj["key"] = secret
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(obj)
+}
+
+// GetKey handles GET /admin/keys/{name}
+func (h *KeysHandler) GetKey(w http.ResponseWriter, r *http.Request) {
+	key, ok := h.store.Get(r.PathValue("name"))
+	if !ok {
+		writeOpenAIError(w, http.StatusNotFound, fmt.Sprintf("No API key named %q", r.PathValue("name")))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(keyObject(key))
+}
+
+// RotateKey handles POST /admin/keys/{name}/rotate
+func (h *KeysHandler) RotateKey(w http.ResponseWriter, r *http.Request) {
+	key, secret, err := h.store.Rotate(r.PathValue("name"))
+	if err != nil {
+		writeOpenAIError(w, keyStatus(err), err.Error())
+		return
+	}
+
+	obj := keyObject(key)
+	obj["key"] = secret
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(obj)
+}
+
+// RevokeKey handles DELETE /admin/keys/{name}
+func (h *KeysHandler) RevokeKey(w http.ResponseWriter, r *http.Request) {
+	key, err := h.store.Revoke(r.PathValue("name"))
+	if err != nil {
+		writeOpenAIError(w, keyStatus(err), err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(keyObject(key))
+}
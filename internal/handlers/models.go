@@ -2,10 +2,12 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
 
 	"github.com/rahulvramesh/gh-proxy-local/internal/copilot"
+	"github.com/rahulvramesh/gh-proxy-local/internal/metrics"
 )
 
 // ModelsHandler handles model-related endpoints.
@@ -59,3 +61,15 @@ func (h *ModelsHandler) GetModel(w http.ResponseWriter, r *http.Request) {
 
 	http.Error(w, "Model not found", http.StatusNotFound)
 }
+
+// setMetricsModel labels the request's metrics with model, or with
+// metrics.OtherModel when Copilot does not serve it.
+func setMetricsModel(ctx context.Context, client *copilot.Client, model string) {
+	if metrics.LabelsFromContext(ctx) == nil {
+		return
+	}
+	if client == nil || !client.KnownModel(ctx, model) {
+		model = metrics.OtherModel
+	}
+	metrics.SetModel(ctx, model)
+}
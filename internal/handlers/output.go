@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/rahulvramesh/gh-proxy-local/internal/models"
+)
+
+// generationOutput assembles the assistant message a generation produced,
+// from a complete response or from stream deltas, in the OpenAI chat
+// format Langfuse renders.
+type generationOutput struct {
+	content      strings.Builder
+	reasoning    strings.Builder
+	toolCalls    []*outputToolCall
+	byIndex      map[int]*outputToolCall
+	finishReason string
+}
+
+// outputToolCall is a tool call whose arguments may still be streaming.
+type outputToolCall struct {
+	id        string
+	name      string
+	arguments strings.Builder
+}
+
+// responseOutput returns the output of a non-streamed response.
+func responseOutput(resp *models.OpenAIChatResponse) *generationOutput {
+	out := &generationOutput{}
+	if len(resp.Choices) == 0 {
+		return out
+	}
+	choice := resp.Choices[0]
+	out.content.WriteString(choice.Message.Content)
+	out.reasoning.WriteString(choice.Message.ReasoningText)
+	out.finishReason = choice.FinishReason
+
+	var toolCalls []struct {
+		ID       string `json:"id"`
+		Function struct {
+			Name      string `json:"name"`
+			Arguments string `json:"arguments"`
+		} `json:"function"`
+	}
+	if len(choice.Message.ToolCalls) > 0 {
+		json.Unmarshal(choice.Message.ToolCalls, &toolCalls)
+	}
+	for _, tc := range toolCalls {
+		call := &outputToolCall{id: tc.ID, name: tc.Function.Name}
+		call.arguments.WriteString(tc.Function.Arguments)
+		out.toolCalls = append(out.toolCalls, call)
+	}
+	return out
+}
+
+// addChunk adds the first choice of a parsed stream chunk.
+func (o *generationOutput) addChunk(chunk map[string]interface{}) {
+	choices, _ := chunk["choices"].([]interface{})
+	if len(choices) == 0 {
+		return
+	}
+	choice, _ := choices[0].(map[string]interface{})
+	if delta, ok := choice["delta"].(map[string]interface{}); ok {
+		if content, ok := delta["content"].(string); ok {
+			o.content.WriteString(content)
+		}
+		o.addReasoning(delta)
+		o.addToolCalls(delta["tool_calls"])
+	}
+	o.setFinishReason(choice["finish_reason"])
+}
+
+// addReasoning adds the reasoning text of a delta. Copilot streams it as
+// reasoning_text; other OpenAI-compatible backends use reasoning_content.
+func (o *generationOutput) addReasoning(delta map[string]interface{}) {
+	for _, field := range []string{"reasoning_text", "reasoning_content"} {
+		if text, ok := delta[field].(string); ok {
+			o.reasoning.WriteString(text)
+		}
+	}
+}
+
+// addToolCalls adds the tool call deltas of a chunk. A delta naming a new
+// index or carrying an id starts a call; the rest extend its arguments.
+func (o *generationOutput) addToolCalls(v interface{}) {
+	deltas, _ := v.([]interface{})
+	for _, d := range deltas {
+		delta, ok := d.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		index := len(o.toolCalls)
+		if i, ok := delta["index"].(float64); ok {
+			index = int(i)
+		}
+		id, _ := delta["id"].(string)
+		fn, _ := delta["function"].(map[string]interface{})
+
+		call, ok := o.byIndex[index]
+		if !ok || id != "" && call.id != "" && id != call.id {
+			call = &outputToolCall{id: id}
+			if o.byIndex == nil {
+				o.byIndex = make(map[int]*outputToolCall)
+			}
+			o.byIndex[index] = call
+			o.toolCalls = append(o.toolCalls, call)
+		}
+		if call.id == "" {
+			call.id = id
+		}
+		if name, _ := fn["name"].(string); name != "" {
+			call.name = name
+		}
+		if args, ok := fn["arguments"].(string); ok {
+			call.arguments.WriteString(args)
+		}
+	}
+}
+
+func (o *generationOutput) setFinishReason(v interface{}) {
+	if reason, ok := v.(string); ok && reason != "" {
+		o.finishReason = reason
+	}
+}
+
+// started reports whether any output has been produced.
+func (o *generationOutput) started() bool {
+	return o.content.Len() > 0 || o.reasoning.Len() > 0 || len(o.toolCalls) > 0
+}
+
+// message returns the assembled assistant message.
+func (o *generationOutput) message() map[string]interface{} {
+	msg := map[string]interface{}{
+		"role":    "assistant",
+		"content": o.content.String(),
+	}
+	if o.reasoning.Len() > 0 {
+		msg["reasoning"] = o.reasoning.String()
+	}
+	if len(o.toolCalls) > 0 {
+		toolCalls := make([]map[string]interface{}, len(o.toolCalls))
+		for i, call := range o.toolCalls {
+			toolCalls[i] = map[string]interface{}{
+				"id":   call.id,
+				"type": "function",
+				"function": map[string]interface{}{
+					"name":      call.name,
+					"arguments": call.arguments.String(),
+				},
+			}
+		}
+		msg["tool_calls"] = toolCalls
+	}
+	return msg
+}
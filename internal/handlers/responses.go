@@ -3,33 +3,39 @@ package handlers
 import (
 	"encoding/json"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"strings"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/rahulvramesh/gh-proxy-local/internal/apikeys"
+	"github.com/rahulvramesh/gh-proxy-local/internal/budget"
 	"github.com/rahulvramesh/gh-proxy-local/internal/converter"
 	"github.com/rahulvramesh/gh-proxy-local/internal/copilot"
 	"github.com/rahulvramesh/gh-proxy-local/internal/langfuse"
+	"github.com/rahulvramesh/gh-proxy-local/internal/logging"
+	"github.com/rahulvramesh/gh-proxy-local/internal/ratelimit"
+	"github.com/rahulvramesh/gh-proxy-local/internal/telemetry"
+	"github.com/rahulvramesh/gh-proxy-local/internal/tracing"
 )
 
 // ResponsesHandler handles OpenAI Responses API endpoints.
 type ResponsesHandler struct {
-	client   *copilot.Client
-	langfuse *langfuse.Client
-	debug    bool
+	client  *copilot.Client
+	sink    telemetry.Sink
+	budgets *budget.Tracker
+	debug   bool
 }
 
 // NewResponsesHandler creates a new responses handler.
-func NewResponsesHandler(client *copilot.Client, langfuseClient *langfuse.Client, debug bool) *ResponsesHandler {
-	return &ResponsesHandler{client: client, langfuse: langfuseClient, debug: debug}
+func NewResponsesHandler(client *copilot.Client, sink telemetry.Sink, budgets *budget.Tracker, debug bool) *ResponsesHandler {
+	return &ResponsesHandler{client: client, sink: sink, budgets: budgets, debug: debug}
 }
 
 // Responses handles POST /v1/responses and /responses
 func (h *ResponsesHandler) Responses(w http.ResponseWriter, r *http.Request) {
-	startTime := time.Now()
-	traceID := langfuse.GenerateTraceID()
-	genID := langfuse.GenerateSpanID()
+	gen := newGeneration(w, "openai-response", "responses", "openai-responses")
 
 	var req struct {
 		Model           string      `json:"model"`
@@ -40,15 +46,32 @@ func (h *ResponsesHandler) Responses(w http.ResponseWriter, r *http.Request) {
 		Stream          bool        `json:"stream"`
 		Tools           []interface{} `json:"tools"`
 		ToolChoice      interface{} `json:"tool_choice"`
+		User            string      `json:"user"`
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+	if err := decodeRequest(r, &req); err != nil {
 		http.Error(w, fmt.Sprintf("Invalid request body: %v", err), http.StatusBadRequest)
 		return
 	}
 
+	model, err := apikeys.CheckModel(r.Context(), req.Model)
+	if err != nil {
+		writeOpenAIError(w, http.StatusForbidden, err.Error())
+		return
+	}
+	req.Model = model
+	setMetricsModel(r.Context(), h.client, model)
+	gen.model, gen.input = model, req.Input
+
+	if err := h.budgets.Check(r.Context()); err != nil {
+		writeOpenAIError(w, http.StatusTooManyRequests, err.Error())
+		return
+	}
+
+	_, convertSpan := tracing.StartSpan(r.Context(), "convert request", tracing.Internal)
 	messages := converter.ConvertResponsesInputToMessages(req.Input, req.Instructions)
 	tools := h.filterFunctionTools(req.Tools)
+	convertSpan.End()
 
 	temperature := 0.7
 	if req.Temperature != nil {
@@ -68,9 +91,18 @@ func (h *ResponsesHandler) Responses(w http.ResponseWriter, r *http.Request) {
 		Stream:      req.Stream,
 		Tools:       tools,
 	}
+	gen.attribute(r, req.User, chatReq)
+
+	release, err := h.client.Admit(r.Context(), chatReq)
+	if err != nil {
+		ratelimit.SetRetryAfter(w.Header(), err)
+		writeOpenAIError(w, http.StatusTooManyRequests, err.Error())
+		return
+	}
+	defer release()
 
 	if req.Stream {
-		h.streamResponses(w, r, chatReq, req.Model, traceID, genID, startTime, req.Input)
+		h.streamResponses(w, r, chatReq, req.Model, gen)
 		return
 	}
 
@@ -80,7 +112,7 @@ func (h *ResponsesHandler) Responses(w http.ResponseWriter, r *http.Request) {
 		if strings.Contains(err.Error(), "API error") {
 			statusCode = http.StatusBadGateway
 		}
-		h.trackGeneration(traceID, genID, req.Model, req.Input, nil, nil, startTime, "ERROR", err.Error(), r)
+		gen.track(r, h.sink, h.budgets, nil, nil, err)
 		http.Error(w, err.Error(), statusCode)
 		return
 	}
@@ -104,46 +136,19 @@ func (h *ResponsesHandler) Responses(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Track to Langfuse
+	out := responseOutput(resp)
+	gen.finishReason = out.finishReason
 	lfUsage := &langfuse.UsageData{
 		PromptTokens:     resp.Usage.PromptTokens,
 		CompletionTokens: resp.Usage.CompletionTokens,
 		TotalTokens:      resp.Usage.TotalTokens,
 	}
-	h.trackGeneration(traceID, genID, req.Model, req.Input, output, lfUsage, startTime, "", "", r)
+	gen.track(r, h.sink, h.budgets, out.message(), lfUsage, nil)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
 
-// trackGeneration sends generation data to Langfuse.
-func (h *ResponsesHandler) trackGeneration(traceID, genID, model string, input, output interface{}, usage *langfuse.UsageData, startTime time.Time, level, statusMessage string, r *http.Request) {
-	if h.langfuse == nil || !h.langfuse.IsEnabled() {
-		return
-	}
-
-	metadata := map[string]interface{}{
-		"endpoint": "responses",
-		"api":      "openai-responses",
-	}
-
-	gen := &langfuse.GenerationBody{
-		ID:            genID,
-		TraceID:       traceID,
-		Name:          "openai-response",
-		Model:         model,
-		Input:         input,
-		Output:        output,
-		Usage:         usage,
-		Metadata:      metadata,
-		StartTime:     startTime,
-		EndTime:       time.Now(),
-		Level:         level,
-		StatusMessage: statusMessage,
-	}
-
-	h.langfuse.TrackGeneration(gen)
-}
-
 // filterFunctionTools filters only function type tools.
 func (h *ResponsesHandler) filterFunctionTools(tools []interface{}) []map[string]interface{} {
 	if len(tools) == 0 {
@@ -289,7 +294,7 @@ func (h *ResponsesHandler) extractUsage(resp interface{}) map[string]interface{}
 }
 
 // streamResponses handles streaming for Responses API.
-func (h *ResponsesHandler) streamResponses(w http.ResponseWriter, r *http.Request, req *copilot.ChatRequest, model string, traceID, genID string, startTime time.Time, input interface{}) {
+func (h *ResponsesHandler) streamResponses(w http.ResponseWriter, r *http.Request, req *copilot.ChatRequest, model string, gen *generation) {
 	flusher, ok := w.(http.Flusher)
 	if !ok {
 		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
@@ -349,6 +354,7 @@ func (h *ResponsesHandler) streamResponses(w http.ResponseWriter, r *http.Reques
 	})
 
 	var fullText strings.Builder
+	out := &generationOutput{}
 
 	err := h.client.ChatCompletionsStream(r.Context(), req, func(chunk []byte) error {
 		line := string(chunk)
@@ -379,6 +385,11 @@ func (h *ResponsesHandler) streamResponses(w http.ResponseWriter, r *http.Reques
 					}
 				}
 
+				out.addChunk(chunkData)
+				if out.started() {
+					gen.firstToken()
+				}
+
 				choices, _ := chunkData["choices"].([]interface{})
 				if len(choices) > 0 {
 					choice := choices[0].(map[string]interface{})
@@ -402,8 +413,8 @@ func (h *ResponsesHandler) streamResponses(w http.ResponseWriter, r *http.Reques
 	})
 
 	if err != nil {
-		if h.debug {
-			fmt.Printf("[DEBUG] Streaming error: %v\n", err)
+		if h.debug || logging.DebugEnabled() {
+			slog.DebugContext(r.Context(), "Streaming error", "key", apikeys.NameFromContext(r.Context()), "error", err)
 		}
 	}
 
@@ -477,18 +488,13 @@ func (h *ResponsesHandler) streamResponses(w http.ResponseWriter, r *http.Reques
 	})
 
 	// Track to Langfuse
-	level := ""
-	statusMsg := ""
-	if err != nil {
-		level = "ERROR"
-		statusMsg = err.Error()
-	}
 	lfUsage := &langfuse.UsageData{
 		PromptTokens:     usageData["input_tokens"],
 		CompletionTokens: usageData["output_tokens"],
 		TotalTokens:      usageData["total_tokens"],
 	}
-	h.trackGeneration(traceID, genID, model, input, text, lfUsage, startTime, level, statusMsg, r)
+	gen.finishReason = out.finishReason
+	gen.track(r, h.sink, h.budgets, out.message(), lfUsage, err)
 }
 
 // sendEvent sends an SSE event.
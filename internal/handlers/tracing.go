@@ -0,0 +1,19 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/rahulvramesh/gh-proxy-local/internal/tracing"
+)
+
+// decodeRequest decodes the JSON request body into v, traced as the parse
+// step of the request.
+func decodeRequest(r *http.Request, v interface{}) error {
+	_, span := tracing.StartSpan(r.Context(), "parse request", tracing.Internal)
+	defer span.End()
+
+	err := json.NewDecoder(r.Body).Decode(v)
+	span.SetError(err)
+	return err
+}
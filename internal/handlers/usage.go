@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/rahulvramesh/gh-proxy-local/internal/usage"
+)
+
+// UsageHandler handles premium request usage endpoints.
+type UsageHandler struct {
+	tracker *usage.Tracker
+}
+
+// NewUsageHandler creates a new usage handler.
+func NewUsageHandler(tracker *usage.Tracker) *UsageHandler {
+	return &UsageHandler{tracker: tracker}
+}
+
+// Usage handles GET /v1/usage. The optional since and until query
+// parameters (YYYY-MM-DD) limit the consumption breakdown; refresh=true
+// fetches a fresh quota snapshot first.
+func (h *UsageHandler) Usage(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	since, until := query.Get("since"), query.Get("until")
+	for name, value := range map[string]string{"since": since, "until": until} {
+		if value == "" {
+			continue
+		}
+		if _, err := time.Parse("2006-01-02", value); err != nil {
+			writeOpenAIError(w, http.StatusBadRequest, fmt.Sprintf("%s must be a date in YYYY-MM-DD format", name))
+			return
+		}
+	}
+
+	if query.Get("refresh") == "true" {
+		h.tracker.Refresh(r.Context())
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.tracker.Report(since, until))
+}
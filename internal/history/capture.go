@@ -0,0 +1,129 @@
+package history
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/rahulvramesh/gh-proxy-local/internal/telemetry"
+)
+
+// Capture collects what is recorded about one request while it is
+// handled: its bodies, the payload sent to Copilot and the telemetry
+// record of the generation it produced. Without payloads only the record
+// is kept.
+type Capture struct {
+	maxBody  int64
+	payloads bool
+
+	request  limitedBuffer
+	response limitedBuffer
+
+	mu       sync.Mutex
+	upstream []byte
+	record   *telemetry.Record
+}
+
+type captureKey struct{}
+
+// WithCapture returns a context carrying a new Capture whose bodies are
+// bounded by maxBody bytes. Bodies are only captured when payloads is set.
+func WithCapture(ctx context.Context, maxBody int64, payloads bool) (context.Context, *Capture) {
+	c := &Capture{
+		maxBody:  maxBody,
+		payloads: payloads,
+		request:  limitedBuffer{max: maxBody},
+		response: limitedBuffer{max: maxBody},
+	}
+	return context.WithValue(ctx, captureKey{}, c), c
+}
+
+// fromContext returns the request's Capture, or nil.
+func fromContext(ctx context.Context) *Capture {
+	c, _ := ctx.Value(captureKey{}).(*Capture)
+	return c
+}
+
+// RecordUpstream records body as the payload of the request's call to
+// Copilot. The last call of a request is kept.
+func RecordUpstream(ctx context.Context, body []byte) {
+	c := fromContext(ctx)
+	if c == nil || !c.payloads {
+		return
+	}
+	if int64(len(body)) > c.maxBody {
+		body = body[:c.maxBody]
+	}
+	c.mu.Lock()
+	c.upstream = append([]byte(nil), body...)
+	c.mu.Unlock()
+}
+
+// setRecord records the generation the request produced.
+func (c *Capture) setRecord(rec *telemetry.Record) {
+	c.mu.Lock()
+	c.record = rec
+	c.mu.Unlock()
+}
+
+// Body wraps a request body so that what the handler reads is captured.
+// It returns body unchanged when payloads are not captured.
+func (c *Capture) Body(body io.ReadCloser) io.ReadCloser {
+	if !c.payloads {
+		return body
+	}
+	return &teeBody{ReadCloser: body, buf: &c.request}
+}
+
+// ResponseWriter wraps w so that the response body is captured. It
+// returns w unchanged when payloads are not captured.
+func (c *Capture) ResponseWriter(w http.ResponseWriter) http.ResponseWriter {
+	if !c.payloads {
+		return w
+	}
+	return &teeWriter{ResponseWriter: w, buf: &c.response}
+}
+
+// limitedBuffer keeps the first max bytes written to it.
+type limitedBuffer struct {
+	max       int64
+	buf       bytes.Buffer
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) {
+	if room := b.max - int64(b.buf.Len()); int64(len(p)) > room {
+		p = p[:max(room, 0)]
+		b.truncated = true
+	}
+	b.buf.Write(p)
+}
+
+type teeBody struct {
+	io.ReadCloser
+	buf *limitedBuffer
+}
+
+func (t *teeBody) Read(p []byte) (int, error) {
+	n, err := t.ReadCloser.Read(p)
+	t.buf.Write(p[:n])
+	return n, err
+}
+
+type teeWriter struct {
+	http.ResponseWriter
+	buf *limitedBuffer
+}
+
+func (t *teeWriter) Write(p []byte) (int, error) {
+	t.buf.Write(p)
+	return t.ResponseWriter.Write(p)
+}
+
+func (t *teeWriter) Flush() {
+	if f, ok := t.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,363 @@
+// Package history keeps a local, size- and TTL-bounded record of the
+// requests the proxy served, for inspection through the admin API.
+package history
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rahulvramesh/gh-proxy-local/internal/config"
+	"github.com/rahulvramesh/gh-proxy-local/internal/redact"
+	"github.com/rahulvramesh/gh-proxy-local/internal/storage"
+	"github.com/rahulvramesh/gh-proxy-local/internal/telemetry"
+)
+
+// compactSlack is how far the history file may outgrow the retained
+// entries before it is rewritten.
+const compactSlack = 1 << 20
+
+// Entry is one request in the history. Its ID is the request ID, or the
+// generation ID when the request had none.
+type Entry struct {
+	ID string `json:"id"`
+	telemetry.Record
+	Method     string `json:"method"`
+	Path       string `json:"path"`
+	StatusCode int    `json:"status_code"`
+
+	// Request is the inbound body, Upstream the payload sent to Copilot
+	// and Response the body returned to the client, as JSON when they
+	// parse and as text otherwise.
+	Request   interface{} `json:"request,omitempty"`
+	Upstream  interface{} `json:"upstream_request,omitempty"`
+	Response  interface{} `json:"response,omitempty"`
+	Truncated bool        `json:"truncated,omitempty"`
+}
+
+// summary returns e without its payloads.
+func (e *Entry) summary() *Entry {
+	s := *e
+	s.Input, s.Output = nil, nil
+	s.Request, s.Upstream, s.Response = nil, nil, nil
+	return &s
+}
+
+// stored is an entry with its encoding, which bounds the history's size
+// and is searched by free-text queries.
+type stored struct {
+	summary *Entry
+	line    []byte
+}
+
+// Store keeps the history in memory, oldest first, and in a JSONL file
+// that is compacted as entries expire. A nil Store records nothing.
+type Store struct {
+	path     string
+	cfg      config.HistoryConfig
+	redactor *redact.Redactor
+	now      func() time.Time
+
+	mu        sync.RWMutex
+	entries   []stored
+	total     int64
+	fileBytes int64
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewStore opens the history at path. It returns nil when cfg disables
+// the history. Recorded payloads are redacted with redactor, which may be
+// nil.
+func NewStore(path string, cfg config.HistoryConfig, redactor *redact.Redactor) (*Store, error) {
+	if cfg.MaxBytes <= 0 {
+		return nil, nil
+	}
+	s := &Store{
+		path:     path,
+		cfg:      cfg,
+		redactor: redactor,
+		now:      time.Now,
+		done:     make(chan struct{}),
+	}
+
+	err := storage.ReadJSONL(path, func(line []byte) error {
+		var e Entry
+		if err := json.Unmarshal(line, &e); err != nil || e.ID == "" {
+			return nil
+		}
+		line = append([]byte(nil), line...)
+		s.entries = append(s.entries, stored{summary: e.summary(), line: line})
+		s.total += int64(len(line) + 1)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read request history: %w", err)
+	}
+	s.fileBytes = s.total
+	s.prune()
+	if err := s.compact(); err != nil {
+		return nil, err
+	}
+
+	s.wg.Add(1)
+	go s.pruner()
+	return s, nil
+}
+
+// IsEnabled reports whether requests are recorded. Store implements
+// telemetry.Sink.
+func (s *Store) IsEnabled() bool {
+	return s != nil
+}
+
+// Track attaches rec to the request's capture; the entry is recorded by
+// Finish once the response is complete.
+func (s *Store) Track(ctx context.Context, rec *telemetry.Record) {
+	if c := fromContext(ctx); c != nil {
+		c.setRecord(rec)
+	}
+}
+
+// Capture starts capturing a request. It returns ctx unchanged and a nil
+// Capture when the history is disabled. Payloads are captured only when
+// enabled and ctx is not marked with telemetry.WithNoLog.
+func (s *Store) Capture(ctx context.Context) (context.Context, *Capture) {
+	if s == nil {
+		return ctx, nil
+	}
+	return WithCapture(ctx, s.cfg.MaxBodyBytes, s.cfg.Payloads && !telemetry.NoLog(ctx))
+}
+
+// Finish records the request captured by c, if it produced a generation,
+// with the payloads c captured.
+func (s *Store) Finish(ctx context.Context, c *Capture, method, path string, status int) {
+	if s == nil || c == nil {
+		return
+	}
+	c.mu.Lock()
+	rec, upstream := c.record, c.upstream
+	c.mu.Unlock()
+	if rec == nil {
+		return
+	}
+
+	e := &Entry{
+		ID:         rec.RequestID,
+		Record:     *rec,
+		Method:     method,
+		Path:       path,
+		StatusCode: status,
+	}
+	if e.ID == "" {
+		e.ID = rec.GenerationID
+	}
+	// The inbound body supersedes the input
+	e.Input = nil
+	if c.payloads {
+		e.Request = s.redactor.Payload(body(c.request.buf.Bytes()))
+		e.Upstream = s.redactor.Payload(body(upstream))
+		e.Response = s.redactor.Payload(body(c.response.buf.Bytes()))
+		e.Truncated = c.request.truncated || c.response.truncated || int64(len(upstream)) >= c.maxBody
+	} else {
+		e.Output = nil
+	}
+
+	if err := s.add(e); err != nil {
+		slog.ErrorContext(ctx, "Failed to record request history", "component", "history", "error", err)
+	}
+}
+
+// body returns data as JSON when it parses, and as text otherwise.
+func body(data []byte) interface{} {
+	if len(data) == 0 {
+		return nil
+	}
+	if json.Valid(data) {
+		return json.RawMessage(data)
+	}
+	return string(data)
+}
+
+// add appends e to the history.
+func (s *Store) add(e *Entry) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("failed to marshal entry: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := storage.AppendLines(s.path, [][]byte{line}); err != nil {
+		return err
+	}
+	size := int64(len(line) + 1)
+	s.entries = append(s.entries, stored{summary: e.summary(), line: line})
+	s.total += size
+	s.fileBytes += size
+	s.prune()
+	return s.compact()
+}
+
+// prune evicts expired entries and the oldest entries beyond the size
+// bound. The caller must hold mu.
+func (s *Store) prune() {
+	cutoff := time.Time{}
+	if s.cfg.TTL > 0 {
+		cutoff = s.now().Add(-s.cfg.TTL)
+	}
+	n := 0
+	for n < len(s.entries) {
+		e := s.entries[n]
+		if s.total <= s.cfg.MaxBytes && !e.summary.StartTime.Before(cutoff) {
+			break
+		}
+		s.total -= int64(len(e.line) + 1)
+		n++
+	}
+	if n > 0 {
+		s.entries = append([]stored(nil), s.entries[n:]...)
+	}
+}
+
+// compact rewrites the history file once evicted entries make up most of
+// it, or all of it. The caller must hold mu.
+func (s *Store) compact() error {
+	if s.fileBytes == s.total || s.total > 0 && s.fileBytes <= 2*s.total+compactSlack {
+		return nil
+	}
+	var buf bytes.Buffer
+	for _, e := range s.entries {
+		buf.Write(e.line)
+		buf.WriteByte('\n')
+	}
+	if err := storage.WriteFile(s.path, buf.Bytes()); err != nil {
+		return fmt.Errorf("failed to compact request history: %w", err)
+	}
+	s.fileBytes = s.total
+	return nil
+}
+
+// pruner expires entries while no requests arrive.
+func (s *Store) pruner() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			s.mu.Lock()
+			s.prune()
+			if err := s.compact(); err != nil {
+				slog.Error("Failed to prune request history", "component", "history", "error", err)
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+// Shutdown stops expiring entries.
+func (s *Store) Shutdown() {
+	if s == nil {
+		return
+	}
+	close(s.done)
+	s.wg.Wait()
+}
+
+// Filter selects history entries. Zero fields match everything.
+type Filter struct {
+	Since, Until time.Time
+	Model        string
+	Key          string
+	Endpoint     string
+	// Status is ok, error or an HTTP status code.
+	Status string
+	// Text is matched case-insensitively against the whole entry,
+	// payloads included.
+	Text string
+	// Limit caps the entries returned.
+	Limit int
+}
+
+// match reports whether e satisfies f, apart from the text search.
+func (f *Filter) match(e *Entry) bool {
+	switch {
+	case !f.Since.IsZero() && e.StartTime.Before(f.Since),
+		!f.Until.IsZero() && !e.StartTime.Before(f.Until),
+		f.Model != "" && e.Model != f.Model,
+		f.Key != "" && e.Key != f.Key,
+		f.Endpoint != "" && e.Endpoint != f.Endpoint,
+		f.Status != "" && f.Status != e.Status && f.Status != strconv.Itoa(e.StatusCode):
+		return false
+	}
+	return true
+}
+
+// Query returns summaries of the entries matching f, newest first, and
+// whether more entries matched than f.Limit.
+func (s *Store) Query(f Filter) ([]*Entry, bool) {
+	if s == nil {
+		return nil, false
+	}
+	text := bytes.ToLower([]byte(f.Text))
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var out []*Entry
+	for i := len(s.entries) - 1; i >= 0; i-- {
+		e := s.entries[i]
+		if !f.match(e.summary) {
+			continue
+		}
+		if len(text) > 0 && !bytes.Contains(bytes.ToLower(e.line), text) {
+			continue
+		}
+		if f.Limit > 0 && len(out) == f.Limit {
+			return out, true
+		}
+		out = append(out, e.summary)
+	}
+	return out, false
+}
+
+// Get returns the newest entry with the given ID or trace ID, with its
+// payloads.
+func (s *Store) Get(id string) (*Entry, bool) {
+	if s == nil {
+		return nil, false
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for i := len(s.entries) - 1; i >= 0; i-- {
+		e := s.entries[i]
+		if e.summary.ID != id && e.summary.TraceID != id {
+			continue
+		}
+		var full Entry
+		if err := json.Unmarshal(e.line, &full); err != nil {
+			return nil, false
+		}
+		return &full, true
+	}
+	return nil, false
+}
+
+// Stats returns the number of entries and their size in bytes.
+func (s *Store) Stats() (entries int, bytes int64) {
+	if s == nil {
+		return 0, 0
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.entries), s.total
+}
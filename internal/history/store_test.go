@@ -0,0 +1,205 @@
+package history
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rahulvramesh/gh-proxy-local/internal/config"
+	"github.com/rahulvramesh/gh-proxy-local/internal/redact"
+	"github.com/rahulvramesh/gh-proxy-local/internal/telemetry"
+)
+
+func newTestStore(t *testing.T, cfg config.HistoryConfig, redactor *redact.Redactor) (*Store, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	s, err := NewStore(path, cfg, redactor)
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	t.Cleanup(s.Shutdown)
+	return s, path
+}
+
+// serve runs a request through a capture as the proxy does: the handler
+// reads the body, calls Copilot, tracks the generation and responds.
+func serve(s *Store, ctx context.Context, body, response string, rec *telemetry.Record) {
+	ctx, c := s.Capture(ctx)
+	r := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(body)).WithContext(ctx)
+	r.Body = c.Body(r.Body)
+	w := c.ResponseWriter(httptest.NewRecorder())
+
+	io.ReadAll(r.Body)
+	RecordUpstream(ctx, []byte(`{"model":"gpt-4.1","messages":[]}`))
+	s.Track(ctx, rec)
+	io.WriteString(w, response)
+	s.Finish(ctx, c, r.Method, r.URL.Path, 200)
+}
+
+func TestStoreRecordsRequest(t *testing.T) {
+	redactor, err := redact.New(config.RedactConfig{Detectors: []string{"email"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, path := newTestStore(t, config.HistoryConfig{MaxBytes: 1 << 20, Payloads: true, MaxBodyBytes: 1024}, redactor)
+
+	serve(s, context.Background(), `{"messages":[{"role":"user","content":"mail a@example.com"}]}`, `{"id":"chatcmpl-1"}`,
+		&telemetry.Record{RequestID: "req-1", TraceID: "trace-1", Model: "gpt-4.1", Status: telemetry.StatusOK, Input: "dropped"})
+	serve(s, telemetry.WithNoLog(context.Background()), `{"secret":true}`, `data: {}`,
+		&telemetry.Record{RequestID: "req-2", TraceID: "trace-2", Model: "gpt-4.1", Output: "hidden"})
+
+	// Requests that produced no generation are not recorded
+	_, c := s.Capture(context.Background())
+	s.Finish(context.Background(), c, "POST", "/v1/files", 200)
+
+	reloaded, err := NewStore(path, config.HistoryConfig{MaxBytes: 1 << 20}, nil)
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	defer reloaded.Shutdown()
+	if n, _ := reloaded.Stats(); n != 2 {
+		t.Fatalf("expected 2 entries after reload, got %d", n)
+	}
+
+	e, ok := reloaded.Get("trace-1")
+	if !ok {
+		t.Fatal("expected lookup by trace ID")
+	}
+	request, _ := json.Marshal(e.Request)
+	if !strings.Contains(string(request), "[REDACTED:email]") || e.Upstream == nil || e.Input != nil || e.StatusCode != 200 {
+		t.Errorf("unexpected entry %+v with request %s", e, request)
+	}
+	if resp, _ := e.Response.(map[string]interface{}); resp["id"] != "chatcmpl-1" {
+		t.Errorf("unexpected response %v", e.Response)
+	}
+
+	e, ok = reloaded.Get("req-2")
+	if !ok || e.Request != nil || e.Upstream != nil || e.Response != nil || e.Output != nil {
+		t.Errorf("expected no-log entry without payloads, got %+v", e)
+	}
+}
+
+func TestCaptureWithoutPayloads(t *testing.T) {
+	for name, tc := range map[string]struct {
+		payloads bool
+		ctx      context.Context
+	}{
+		"payloads off": {false, context.Background()},
+		"no-log":       {true, telemetry.WithNoLog(context.Background())},
+	} {
+		s, _ := newTestStore(t, config.HistoryConfig{MaxBytes: 1 << 20, Payloads: tc.payloads, MaxBodyBytes: 1024}, nil)
+		ctx, c := s.Capture(tc.ctx)
+		body := io.NopCloser(strings.NewReader("{}"))
+		w := httptest.NewRecorder()
+		if c.Body(body) != body || c.ResponseWriter(w) != w {
+			t.Errorf("%s: expected bodies to be left unwrapped", name)
+		}
+		RecordUpstream(ctx, []byte(`{"model":"gpt-4.1"}`))
+		if c.upstream != nil {
+			t.Errorf("%s: expected upstream payload not to be kept", name)
+		}
+	}
+}
+
+func TestStoreQuery(t *testing.T) {
+	s, _ := newTestStore(t, config.HistoryConfig{MaxBytes: 1 << 20, Payloads: true, MaxBodyBytes: 1024}, nil)
+	base := time.Date(2026, 10, 18, 15, 0, 0, 0, time.UTC)
+	for i, r := range []telemetry.Record{
+		{Model: "gpt-4.1", Key: "ide", Endpoint: "chat/completions", Status: telemetry.StatusOK},
+		{Model: "claude-sonnet-4", Key: "ci", Endpoint: "messages", Status: telemetry.StatusError},
+		{Model: "gpt-4.1", Key: "ci", Endpoint: "chat/completions", Status: telemetry.StatusOK},
+	} {
+		r.RequestID = fmt.Sprintf("req-%d", i)
+		r.StartTime = base.Add(time.Duration(i) * time.Minute)
+		serve(s, context.Background(), fmt.Sprintf(`{"prompt":"question %d"}`, i), "{}", &r)
+	}
+
+	ids := func(f Filter) string {
+		entries, _ := s.Query(f)
+		var out []string
+		for _, e := range entries {
+			if e.Request != nil {
+				t.Errorf("summary %s carries payloads", e.ID)
+			}
+			out = append(out, e.ID)
+		}
+		return strings.Join(out, ",")
+	}
+	for _, tt := range []struct {
+		filter Filter
+		want   string
+	}{
+		{Filter{}, "req-2,req-1,req-0"},
+		{Filter{Model: "gpt-4.1"}, "req-2,req-0"},
+		{Filter{Key: "ci", Endpoint: "chat/completions"}, "req-2"},
+		{Filter{Status: "error"}, "req-1"},
+		{Filter{Status: "200"}, "req-2,req-1,req-0"},
+		{Filter{Since: base.Add(time.Minute), Until: base.Add(2 * time.Minute)}, "req-1"},
+		{Filter{Text: "QUESTION 0"}, "req-0"},
+		{Filter{Limit: 1}, "req-2"},
+	} {
+		if got := ids(tt.filter); got != tt.want {
+			t.Errorf("Query(%+v) = %s, want %s", tt.filter, got, tt.want)
+		}
+	}
+	if _, more := s.Query(Filter{Limit: 2}); !more {
+		t.Error("expected has_more with limit 2")
+	}
+}
+
+func TestStoreBounds(t *testing.T) {
+	s, path := newTestStore(t, config.HistoryConfig{MaxBytes: 1500, TTL: time.Hour, Payloads: true, MaxBodyBytes: 64}, nil)
+	now := time.Now()
+	s.now = func() time.Time { return now }
+
+	long := `{"prompt":"` + strings.Repeat("x", 200) + `"}`
+	for i := 0; i < 10; i++ {
+		serve(s, context.Background(), long, "{}", &telemetry.Record{RequestID: fmt.Sprintf("req-%d", i), StartTime: now})
+	}
+	n, size := s.Stats()
+	if size > 1500 || n == 0 || n == 10 {
+		t.Errorf("expected size-bounded history, got %d entries of %d bytes", n, size)
+	}
+	if _, ok := s.Get("req-9"); !ok {
+		t.Error("expected newest entry to be kept")
+	}
+	if e, _ := s.Get("req-9"); !e.Truncated {
+		t.Error("expected body beyond MaxBodyBytes to be truncated")
+	}
+
+	// Once every entry expires the file is emptied
+	now = now.Add(2 * time.Hour)
+	s.mu.Lock()
+	s.prune()
+	s.compact()
+	s.mu.Unlock()
+	if n, _ := s.Stats(); n != 0 {
+		t.Errorf("expected expired entries to be evicted, %d left", n)
+	}
+	if info, err := os.Stat(path); err != nil || info.Size() != 0 {
+		t.Errorf("expected compacted empty file, got %v, %v", info, err)
+	}
+}
+
+func TestNewStoreDisabled(t *testing.T) {
+	s, err := NewStore(filepath.Join(t.TempDir(), "history.jsonl"), config.HistoryConfig{}, nil)
+	if err != nil || s != nil {
+		t.Fatalf("expected disabled store, got %v, %v", s, err)
+	}
+	if s.IsEnabled() {
+		t.Error("nil store reports enabled")
+	}
+	if ctx, c := s.Capture(context.Background()); c != nil || ctx == nil {
+		t.Error("nil store started a capture")
+	}
+	if entries, _ := s.Query(Filter{}); entries != nil {
+		t.Error("nil store returned entries")
+	}
+}
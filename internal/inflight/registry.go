@@ -0,0 +1,111 @@
+// Package inflight tracks the inference requests the proxy is serving.
+package inflight
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Request is a request being served.
+type Request struct {
+	ID         string    `json:"id"`
+	Method     string    `json:"method"`
+	Path       string    `json:"path"`
+	Model      string    `json:"model,omitempty"`
+	Key        string    `json:"api_key,omitempty"`
+	StartTime  time.Time `json:"start_time"`
+	DurationMS int64     `json:"duration_ms"`
+}
+
+// LabelsFunc reports the model and API key learnt while a request is
+// handled.
+type LabelsFunc func() (model, key string)
+
+type entry struct {
+	req    Request
+	labels LabelsFunc
+	cancel context.CancelFunc
+}
+
+// Registry holds the requests being served.
+type Registry struct {
+	now func() time.Time
+
+	mu       sync.Mutex
+	next     uint64
+	requests map[uint64]*entry
+}
+
+// NewRegistry creates an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{now: time.Now, requests: make(map[uint64]*entry)}
+}
+
+// Start registers a request until the returned function is called. labels
+// may be nil. cancel is called when the request is cancelled through
+// Cancel.
+func (r *Registry) Start(id, method, path string, labels LabelsFunc, cancel context.CancelFunc) func() {
+	e := &entry{
+		req:    Request{ID: id, Method: method, Path: path, StartTime: r.now()},
+		labels: labels,
+		cancel: cancel,
+	}
+
+	r.mu.Lock()
+	r.next++
+	seq := r.next
+	r.requests[seq] = e
+	r.mu.Unlock()
+
+	return func() {
+		r.mu.Lock()
+		delete(r.requests, seq)
+		r.mu.Unlock()
+	}
+}
+
+// List returns the requests being served, oldest first.
+func (r *Registry) List() []Request {
+	now := r.now()
+	r.mu.Lock()
+	out := make([]Request, 0, len(r.requests))
+	for _, e := range r.requests {
+		req := e.req
+		if e.labels != nil {
+			req.Model, req.Key = e.labels()
+		}
+		req.DurationMS = now.Sub(req.StartTime).Milliseconds()
+		out = append(out, req)
+	}
+	r.mu.Unlock()
+
+	sort.Slice(out, func(i, j int) bool { return out[i].StartTime.Before(out[j].StartTime) })
+	return out
+}
+
+// Cancel cancels the requests with the given ID and returns how many were
+// cancelled. Callers may reuse request IDs, so more than one may match.
+func (r *Registry) Cancel(id string) int {
+	r.mu.Lock()
+	var cancels []context.CancelFunc
+	for _, e := range r.requests {
+		if e.req.ID == id && e.cancel != nil {
+			cancels = append(cancels, e.cancel)
+		}
+	}
+	r.mu.Unlock()
+
+	for _, cancel := range cancels {
+		cancel()
+	}
+	return len(cancels)
+}
+
+// Len returns the number of requests being served.
+func (r *Registry) Len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.requests)
+}
@@ -0,0 +1,50 @@
+package inflight
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	r.now = func() time.Time { return now }
+
+	doneFirst := r.Start("req-1", "POST", "/v1/chat/completions", func() (string, string) { return "gpt-4.1", "ide" }, nil)
+	now = now.Add(time.Second)
+	doneSecond := r.Start("req-2", "POST", "/v1/messages", nil, nil)
+	now = now.Add(500 * time.Millisecond)
+
+	list := r.List()
+	if len(list) != 2 || r.Len() != 2 {
+		t.Fatalf("expected 2 requests, got %+v", list)
+	}
+	if got := list[0]; got.ID != "req-1" || got.Model != "gpt-4.1" || got.Key != "ide" || got.DurationMS != 1500 {
+		t.Errorf("unexpected first request %+v", got)
+	}
+	if got := list[1]; got.ID != "req-2" || got.Model != "" || got.DurationMS != 500 {
+		t.Errorf("unexpected second request %+v", got)
+	}
+
+	doneFirst()
+	doneSecond()
+	if r.Len() != 0 {
+		t.Errorf("expected finished requests to be removed, %d left", r.Len())
+	}
+}
+
+func TestRegistryCancel(t *testing.T) {
+	r := NewRegistry()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := r.Start("req-1", "POST", "/v1/responses", nil, cancel)
+	defer done()
+	r.Start("req-2", "POST", "/v1/responses", nil, func() { t.Error("cancelled the wrong request") })
+
+	if n := r.Cancel("missing"); n != 0 {
+		t.Errorf("cancelled %d requests for an unknown ID", n)
+	}
+	if n := r.Cancel("req-1"); n != 1 || ctx.Err() == nil {
+		t.Errorf("expected req-1 to be cancelled, got %d, %v", n, ctx.Err())
+	}
+}
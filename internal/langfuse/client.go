@@ -7,13 +7,15 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"sync"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/rahulvramesh/gh-proxy-local/internal/config"
+	"github.com/rahulvramesh/gh-proxy-local/internal/logging"
+	"github.com/rahulvramesh/gh-proxy-local/internal/metrics"
 )
 
 // Client is the Langfuse API client with async event ingestion.
@@ -32,6 +34,16 @@ type Client struct {
 	batchSize int
 	interval  time.Duration
 
+	maxRetries int
+	retryBase  time.Duration
+	spool      *spool
+	retries    chan []Event
+	wake       chan struct{}
+	flush      chan chan struct{}
+	replayNow  chan struct{}
+
+	sampler sampler
+
 	mu      sync.Mutex
 	enabled bool
 }
@@ -55,6 +67,30 @@ func NewClient(cfg config.LangfuseConfig, debug bool) *Client {
 		batchSize: cfg.BatchSize,
 		interval:  cfg.FlushInterval,
 		enabled:   true,
+
+		maxRetries: cfg.MaxRetries,
+		retryBase:  time.Second,
+		wake:       make(chan struct{}, 1),
+		flush:      make(chan chan struct{}),
+		replayNow:  make(chan struct{}, 1),
+
+		sampler: sampler{cfg: cfg.Sampling},
+	}
+
+	if cfg.SpoolDir != "" {
+		sp, err := openSpool(cfg.SpoolDir, cfg.SpoolMaxBytes)
+		if err != nil {
+			slog.Error("Langfuse spool disabled", "component", "langfuse", "error", err)
+		} else {
+			c.spool = sp
+			c.wg.Add(1)
+			go c.replayer()
+		}
+	}
+	if c.spool == nil {
+		c.retries = make(chan []Event, retryQueueSize)
+		c.wg.Add(1)
+		go c.retrier()
 	}
 
 	c.wg.Add(1)
@@ -68,10 +104,11 @@ func (c *Client) IsEnabled() bool {
 	return c.enabled
 }
 
-// debugLog prints debug messages if debugging is enabled.
-func (c *Client) debugLog(format string, args ...interface{}) {
-	if c.debug {
-		log.Printf("[LANGFUSE] "+format, args...)
+// debugLog logs a debug message if debugging is enabled. args are slog
+// key-value pairs.
+func (c *Client) debugLog(msg string, args ...interface{}) {
+	if c.debug || logging.DebugEnabled() {
+		slog.Debug(msg, append([]interface{}{"component", "langfuse"}, args...)...)
 	}
 }
 
@@ -83,39 +120,99 @@ func (c *Client) Track(event Event) {
 
 	select {
 	case c.events <- event:
-		c.debugLog("Event queued: %s (%s)", event.Type, event.ID)
+		c.debugLog("Event queued", "type", event.Type, "id", event.ID)
 	default:
-		c.debugLog("Event dropped (queue full): %s (%s)", event.Type, event.ID)
+		metrics.LangfuseDropped.Inc("queue_full")
+		c.debugLog("Event dropped (queue full)", "type", event.Type, "id", event.ID)
 	}
 }
 
+// QueueDepth returns the number of events waiting to be batched.
+func (c *Client) QueueDepth() int {
+	if !c.enabled {
+		return 0
+	}
+	return len(c.events)
+}
+
+// SpoolStats returns the number of events in the disk spool and their size
+// in bytes.
+func (c *Client) SpoolStats() (events int, bytes int64) {
+	if c.spool == nil {
+		return 0, 0
+	}
+	return c.spool.stats()
+}
+
 // TrackGeneration is a convenience method for tracking LLM generations.
-func (c *Client) TrackGeneration(gen *GenerationBody) {
+// Generations are sampled according to the
+// sampling configuration; those sent carry the request ID of ctx, and
+// the tail rule that kept them, in their metadata.
+func (c *Client) TrackGeneration(ctx context.Context, gen *GenerationBody) {
 	if !c.enabled {
 		return
 	}
 
-	if gen.ID == "" {
-		gen.ID = uuid.New().String()
+	keep, reason, capture := c.sampler.decide(gen)
+	if !keep {
+		return
 	}
-	if gen.TraceID == "" {
-		gen.TraceID = uuid.New().String()
+
+	// Work on a copy; gen is shared with the other sinks
+	g := *gen
+	g.Metadata = make(map[string]interface{}, len(gen.Metadata)+3)
+	for k, v := range gen.Metadata {
+		g.Metadata[k] = v
+	}
+	if g.ID == "" {
+		g.ID = uuid.New().String()
+	}
+	if g.TraceID == "" {
+		g.TraceID = uuid.New().String()
+	}
+	if id := logging.RequestID(ctx); id != "" {
+		g.Metadata["request_id"] = id
+	}
+	if reason != "head" {
+		g.Metadata["sampled_by"] = reason
+	}
+	if capture == CaptureMetadata {
+		g.Input, g.Output = nil, nil
+		g.Metadata["capture"] = CaptureMetadata
 	}
 
 	traceEvent := NewTraceEvent(&TraceBody{
-		ID:        gen.TraceID,
-		Name:      gen.Name,
-		Input:     gen.Input,
-		Output:    gen.Output,
-		Metadata:  gen.Metadata,
-		Timestamp: gen.StartTime,
+		ID:        g.TraceID,
+		Name:      g.Name,
+		UserID:    g.UserID,
+		SessionID: g.SessionID,
+		Input:     g.Input,
+		Output:    g.Output,
+		Metadata:  g.Metadata,
+		Tags:      g.Tags,
+		Timestamp: g.StartTime,
 	})
 	c.Track(traceEvent)
 
-	genEvent := NewGenerationEvent(gen)
+	genEvent := NewGenerationEvent(&g)
 	c.Track(genEvent)
 }
 
+// TrackScore queues a score for a trace. Scores are not sampled: feedback
+// is rare and worth keeping even for traces that were not sent.
+func (c *Client) TrackScore(score *ScoreBody) {
+	if !c.enabled {
+		return
+	}
+	if score.ID == "" {
+		score.ID = uuid.New().String()
+	}
+	if score.Timestamp.IsZero() {
+		score.Timestamp = time.Now()
+	}
+	c.Track(NewScoreEvent(score))
+}
+
 // worker is the background goroutine that batches and sends events.
 func (c *Client) worker() {
 	defer c.wg.Done()
@@ -134,16 +231,7 @@ func (c *Client) worker() {
 		copy(batchCopy, batch)
 		batch = batch[:0]
 
-		go func(events []Event) {
-			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-			defer cancel()
-
-			if err := c.sendBatch(ctx, events); err != nil {
-				c.debugLog("Failed to send batch: %v", err)
-			} else {
-				c.debugLog("Batch sent successfully: %d events", len(events))
-			}
-		}(batchCopy)
+		c.deliver(batchCopy)
 	}
 
 	for {
@@ -155,6 +243,20 @@ func (c *Client) worker() {
 			}
 		case <-ticker.C:
 			flush()
+		case ack := <-c.flush:
+			for drained := false; !drained; {
+				select {
+				case event := <-c.events:
+					batch = append(batch, event)
+					if len(batch) >= c.batchSize {
+						flush()
+					}
+				default:
+					drained = true
+				}
+			}
+			flush()
+			close(ack)
 		case <-c.done:
 			// Drain remaining events
 			for {
@@ -170,10 +272,207 @@ func (c *Client) worker() {
 	}
 }
 
-// sendBatch sends a batch of events to the Langfuse API.
-func (c *Client) sendBatch(ctx context.Context, events []Event) error {
+// retryQueueSize bounds the failed batches waiting for a retry when there
+// is no spool.
+const retryQueueSize = 16
+
+// deliver sends a batch once. The events of a failed batch are spooled,
+// if enabled, or handed to the retrier, so the worker keeps draining the
+// queue during an outage. While the spool holds events, new batches are
+// appended to it so that events replay in order.
+func (c *Client) deliver(events []Event) {
+	if c.spool != nil && c.spool.pending() {
+		c.spoolBatch(events)
+		return
+	}
+
+	retry, err := c.send(events)
+	if len(retry) == 0 {
+		return
+	}
+	if c.spool != nil {
+		c.spoolBatch(retry)
+		return
+	}
+	select {
+	case <-c.done:
+		// The retrier is stopping
+		c.drop(retry)
+		return
+	default:
+	}
+	select {
+	case c.retries <- retry:
+		c.debugLog("Batch queued for retry", "events", len(retry), "error", err)
+	default:
+		c.drop(retry)
+	}
+}
+
+// send sends a batch and returns the events worth retrying.
+func (c *Client) send(events []Event) ([]Event, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	retry, err := c.sendBatch(ctx, events)
+	switch {
+	case err == nil:
+		c.debugLog("Batch sent", "events", len(events))
+	case len(retry) == 0:
+		c.debugLog("Batch rejected", "events", len(events), "error", err)
+	}
+	return retry, err
+}
+
+// drop counts and logs events given up on.
+func (c *Client) drop(events []Event) {
+	metrics.LangfuseDropped.Add(float64(len(events)), "send_failed")
+	slog.Warn("Dropped Langfuse events after retries", "component", "langfuse", "events", len(events))
+}
+
+// retrier retries failed batches with exponential backoff when there is
+// no spool. Batches still waiting at shutdown are dropped.
+func (c *Client) retrier() {
+	defer c.wg.Done()
+
+	for {
+		select {
+		case <-c.done:
+			for {
+				select {
+				case events := <-c.retries:
+					c.drop(events)
+				default:
+					return
+				}
+			}
+		case events := <-c.retries:
+			c.retryBatch(events)
+		}
+	}
+}
+
+// retryBatch retries a failed batch up to maxRetries times, giving up
+// early on shutdown.
+func (c *Client) retryBatch(events []Event) {
+	for attempt := 0; attempt < c.maxRetries; attempt++ {
+		delay := c.backoff(attempt)
+		c.debugLog("Retrying batch", "events", len(events), "delay", delay)
+		timer := time.NewTimer(delay)
+		select {
+		case <-c.done:
+			timer.Stop()
+			c.drop(events)
+			return
+		case <-timer.C:
+		}
+
+		retry, _ := c.send(events)
+		if len(retry) == 0 {
+			return
+		}
+		events = retry
+	}
+	c.drop(events)
+}
+
+// spoolBatch writes a batch to the spool and wakes the replayer.
+func (c *Client) spoolBatch(events []Event) {
+	if err := c.spool.append(events); err != nil {
+		slog.Error("Failed to spool Langfuse events", "component", "langfuse", "events", len(events), "error", err)
+		return
+	}
+	c.debugLog("Batch spooled", "events", len(events))
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+}
+
+// replayer delivers spooled batches oldest first, backing off while
+// Langfuse is unavailable.
+func (c *Client) replayer() {
+	defer c.wg.Done()
+
+	failures := 0
+	for {
+		if !c.spool.pending() {
+			select {
+			case <-c.done:
+				return
+			case <-c.wake:
+			}
+			continue
+		}
+
+		delay := time.Duration(0)
+		if c.replayOldest() {
+			failures = 0
+		} else {
+			delay = c.backoff(failures)
+			failures++
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-c.done:
+			timer.Stop()
+			return
+		case <-c.replayNow:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// replayOldest sends the oldest spooled batch and reports whether the
+// spool made progress.
+func (c *Client) replayOldest() bool {
+	seq, events, err := c.spool.oldest()
+	if err != nil {
+		// An unreadable segment would block the spool forever
+		slog.Error("Dropping unreadable Langfuse spool segment", "component", "langfuse", "error", err)
+		c.spool.replace(seq, nil)
+		return true
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	retry, err := c.sendBatch(ctx, events)
+	cancel()
+	if err := c.spool.replace(seq, retry); err != nil {
+		slog.Error("Failed to update Langfuse spool", "component", "langfuse", "error", err)
+	}
+	metrics.LangfuseReplayed.Add(float64(len(events) - len(retry)))
+	if len(retry) > 0 {
+		c.debugLog("Replay failed", "events", len(retry), "error", err)
+		return false
+	}
+	c.debugLog("Replayed spooled batch", "events", len(events))
+	return true
+}
+
+// backoff returns the delay before retry attempt+1: exponential from
+// retryBase, capped at five minutes.
+func (c *Client) backoff(attempt int) time.Duration {
+	const maxBackoff = 5 * time.Minute
+	if attempt > 16 {
+		return maxBackoff
+	}
+	return min(c.retryBase<<attempt, maxBackoff)
+}
+
+// retryableStatus reports whether a batch or event that failed with status
+// may succeed later.
+func retryableStatus(status int) bool {
+	return status == http.StatusTooManyRequests || status >= 500
+}
+
+// sendBatch sends a batch of events to the Langfuse API. It returns the
+// events worth retrying: the whole batch after a network error, 429 or 5xx
+// response, or the events a 207 response reports failed with such a
+// status. Events rejected for other reasons are dropped.
+func (c *Client) sendBatch(ctx context.Context, events []Event) ([]Event, error) {
 	if len(events) == 0 {
-		return nil
+		return nil, nil
 	}
 
 	req := BatchIngestionRequest{
@@ -186,12 +485,13 @@ func (c *Client) sendBatch(ctx context.Context, events []Event) error {
 
 	body, err := json.Marshal(req)
 	if err != nil {
-		return fmt.Errorf("failed to marshal batch: %w", err)
+		metrics.LangfuseDropped.Add(float64(len(events)), "rejected")
+		return nil, fmt.Errorf("failed to marshal batch: %w", err)
 	}
 
 	httpReq, err := http.NewRequestWithContext(ctx, "POST", c.host+"/api/public/ingestion", bytes.NewReader(body))
 	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+		return events, fmt.Errorf("failed to create request: %w", err)
 	}
 
 	// Basic auth
@@ -201,25 +501,68 @@ func (c *Client) sendBatch(ctx context.Context, events []Event) error {
 
 	resp, err := c.httpClient.Do(httpReq)
 	if err != nil {
-		return fmt.Errorf("request failed: %w", err)
+		return events, fmt.Errorf("request failed: %w", err)
 	}
 	defer resp.Body.Close()
 
+	if retryableStatus(resp.StatusCode) {
+		return events, fmt.Errorf("API error: %d", resp.StatusCode)
+	}
 	if resp.StatusCode >= 400 {
-		return fmt.Errorf("API error: %d", resp.StatusCode)
+		metrics.LangfuseDropped.Add(float64(len(events)), "rejected")
+		return nil, fmt.Errorf("API error: %d", resp.StatusCode)
+	}
+
+	// Ingestion answers 207 with per-event results
+	var result BatchIngestionResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil || len(result.Errors) == 0 {
+		return nil, nil
+	}
+
+	failed := make(map[string]bool, len(result.Errors))
+	rejected := 0
+	for _, e := range result.Errors {
+		if retryableStatus(e.Status) {
+			failed[e.ID] = true
+			continue
+		}
+		rejected++
+		slog.Warn("Langfuse rejected event", "component", "langfuse", "id", e.ID, "status", e.Status, "message", e.Message)
 	}
+	metrics.LangfuseDropped.Add(float64(rejected), "rejected")
 
-	return nil
+	var retry []Event
+	for _, event := range events {
+		if failed[event.ID] {
+			retry = append(retry, event)
+		}
+	}
+	if len(retry) > 0 {
+		return retry, fmt.Errorf("%d of %d events failed", len(retry), len(events))
+	}
+	return nil, nil
 }
 
-// Flush forces sending all pending events.
+// Flush sends the queued events now and returns once they were sent,
+// spooled, queued for retry or dropped. A spool waiting out a Langfuse outage is retried
+// without further backoff.
 func (c *Client) Flush() {
 	if !c.enabled {
 		return
 	}
 
-	// Send a signal to flush by waiting for current batch cycle
-	time.Sleep(100 * time.Millisecond)
+	if c.spool != nil {
+		select {
+		case c.replayNow <- struct{}{}:
+		default:
+		}
+	}
+	ack := make(chan struct{})
+	select {
+	case c.flush <- ack:
+		<-ack
+	case <-c.done:
+	}
 }
 
 // Shutdown gracefully shuts down the client and flushes remaining events.
@@ -1,6 +1,14 @@
 package langfuse
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -86,6 +94,29 @@ func TestNewGenerationEvent(t *testing.T) {
 	}
 }
 
+func TestNewScoreEvent(t *testing.T) {
+	score := &ScoreBody{
+		ID:        "score-123",
+		TraceID:   "trace-123",
+		Name:      "user-feedback",
+		Value:     1.0,
+		DataType:  ScoreBoolean,
+		Timestamp: time.Now(),
+	}
+
+	event := NewScoreEvent(score)
+
+	if event.Type != "score-create" {
+		t.Errorf("expected type score-create, got %s", event.Type)
+	}
+	if event.Body["traceId"] != "trace-123" || event.Body["value"] != 1.0 || event.Body["dataType"] != ScoreBoolean {
+		t.Errorf("unexpected body %v", event.Body)
+	}
+	if _, ok := event.Body["comment"]; ok {
+		t.Error("expected empty comment to be omitted")
+	}
+}
+
 func TestTrackDisabledClient(t *testing.T) {
 	cfg := config.LangfuseConfig{
 		Enabled: false,
@@ -94,7 +125,7 @@ func TestTrackDisabledClient(t *testing.T) {
 
 	// Should not panic
 	client.Track(Event{ID: "test"})
-	client.TrackGeneration(&GenerationBody{})
+	client.TrackGeneration(context.Background(), &GenerationBody{})
 	client.Flush()
 	client.Shutdown()
 }
@@ -114,3 +145,309 @@ func TestGenerateIDs(t *testing.T) {
 		t.Error("expected different IDs")
 	}
 }
+
+// ingestionServer answers Langfuse ingestion requests with handle and
+// records the event IDs of each batch.
+func ingestionServer(t *testing.T, handle func(w http.ResponseWriter, ids []string)) (*httptest.Server, *[][]string) {
+	var mu sync.Mutex
+	var batches [][]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req BatchIngestionRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		ids := make([]string, len(req.Batch))
+		for i, event := range req.Batch {
+			ids[i] = event.ID
+		}
+		mu.Lock()
+		batches = append(batches, ids)
+		mu.Unlock()
+		handle(w, ids)
+	}))
+	t.Cleanup(server.Close)
+	return server, &batches
+}
+
+func testClient(host string, sp *spool) *Client {
+	return &Client{
+		host:       host,
+		httpClient: &http.Client{Timeout: 5 * time.Second},
+		enabled:    true,
+		maxRetries: 2,
+		retryBase:  time.Millisecond,
+		spool:      sp,
+		retries:    make(chan []Event, retryQueueSize),
+		done:       make(chan struct{}),
+		wake:       make(chan struct{}, 1),
+	}
+}
+
+func events(ids ...string) []Event {
+	batch := make([]Event, len(ids))
+	for i, id := range ids {
+		batch[i] = Event{ID: id, Type: "trace-create", Body: map[string]interface{}{"id": id}}
+	}
+	return batch
+}
+
+func TestSendBatchPartialFailure(t *testing.T) {
+	server, _ := ingestionServer(t, func(w http.ResponseWriter, ids []string) {
+		w.WriteHeader(http.StatusMultiStatus)
+		fmt.Fprint(w, `{"successes":[{"id":"a","status":201}],"errors":[{"id":"b","status":500,"message":"try later"},{"id":"c","status":400,"message":"invalid"}]}`)
+	})
+	client := testClient(server.URL, nil)
+
+	retry, err := client.sendBatch(context.Background(), events("a", "b", "c"))
+	if err == nil || len(retry) != 1 || retry[0].ID != "b" {
+		t.Errorf("sendBatch returned %v, %v; want only b to retry", retry, err)
+	}
+}
+
+func TestDeliverRetries(t *testing.T) {
+	var calls int
+	server, _ := ingestionServer(t, func(w http.ResponseWriter, ids []string) {
+		calls++
+		if calls < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusMultiStatus)
+		fmt.Fprint(w, `{"successes":[],"errors":[]}`)
+	})
+	client := testClient(server.URL, nil)
+
+	// The worker makes one attempt and leaves the rest to the retrier
+	client.deliver(events("a"))
+	if calls != 1 || len(client.retries) != 1 {
+		t.Fatalf("expected 1 attempt and a queued retry, got %d attempts, %d queued", calls, len(client.retries))
+	}
+	client.retryBatch(<-client.retries)
+	if calls != 3 {
+		t.Errorf("expected 3 attempts, got %d", calls)
+	}
+}
+
+func TestRetryStopsOnShutdown(t *testing.T) {
+	server, batches := ingestionServer(t, func(w http.ResponseWriter, ids []string) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	client := testClient(server.URL, nil)
+	client.retryBase = time.Hour
+	close(client.done)
+
+	finished := make(chan struct{})
+	go func() {
+		client.retryBatch(events("a"))
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-time.After(time.Second):
+		t.Fatal("retryBatch kept waiting after shutdown")
+	}
+	if len(*batches) != 0 {
+		t.Errorf("expected no attempts after shutdown, got %d", len(*batches))
+	}
+}
+
+func TestSpoolReplayInOrder(t *testing.T) {
+	var up atomic.Bool
+	server, batches := ingestionServer(t, func(w http.ResponseWriter, ids []string) {
+		if !up.Load() {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusMultiStatus)
+		fmt.Fprint(w, `{"successes":[],"errors":[]}`)
+	})
+	sp, err := openSpool(filepath.Join(t.TempDir(), "spool"), 1<<20)
+	if err != nil {
+		t.Fatalf("openSpool failed: %v", err)
+	}
+	client := testClient(server.URL, sp)
+
+	// Langfuse is down: the first batch is spooled after one attempt, the
+	// second goes straight to the spool behind it
+	client.deliver(events("1", "2"))
+	client.deliver(events("3"))
+	if n, _ := client.SpoolStats(); n != 3 {
+		t.Fatalf("expected 3 spooled events, got %d", n)
+	}
+	if len(*batches) != 1 {
+		t.Errorf("expected 1 attempt for the first batch only, got %d", len(*batches))
+	}
+
+	if client.replayOldest() {
+		t.Error("replay succeeded while Langfuse was down")
+	}
+
+	up.Store(true)
+	*batches = nil
+	for sp.pending() {
+		if !client.replayOldest() {
+			t.Fatal("replay failed")
+		}
+	}
+	if len(*batches) != 2 || (*batches)[0][0] != "1" || (*batches)[1][0] != "3" {
+		t.Errorf("replayed out of order: %v", *batches)
+	}
+	if n, size := client.SpoolStats(); n != 0 || size != 0 {
+		t.Errorf("spool not empty after replay: %d events, %d bytes", n, size)
+	}
+}
+
+func TestFlush(t *testing.T) {
+	server, batches := ingestionServer(t, func(w http.ResponseWriter, ids []string) {
+		w.WriteHeader(http.StatusOK)
+	})
+	client := NewClient(config.LangfuseConfig{
+		Enabled:       true,
+		Host:          server.URL,
+		PublicKey:     "pk",
+		SecretKey:     "sk",
+		BatchSize:     2,
+		FlushInterval: time.Hour,
+	}, false)
+	defer client.Shutdown()
+
+	for _, event := range events("a", "b", "c") {
+		client.Track(event)
+	}
+	client.Flush()
+
+	if client.QueueDepth() != 0 {
+		t.Errorf("expected an empty queue after Flush, %d left", client.QueueDepth())
+	}
+	if got := fmt.Sprint(*batches); got != "[[a b] [c]]" {
+		t.Errorf("expected queued events sent in batches, got %s", got)
+	}
+}
+
+func TestSpoolCap(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "spool")
+	segment, _ := encodeSegment(events("x"))
+	sp, err := openSpool(dir, int64(len(segment))*2)
+	if err != nil {
+		t.Fatalf("openSpool failed: %v", err)
+	}
+	for _, id := range []string{"a", "b", "c"} {
+		if err := sp.append(events(id)); err != nil {
+			t.Fatalf("append failed: %v", err)
+		}
+	}
+	if n, _ := sp.stats(); n != 2 {
+		t.Errorf("expected the oldest segment to be evicted, %d events left", n)
+	}
+
+	reopened, err := openSpool(dir, 1<<20)
+	if err != nil {
+		t.Fatalf("openSpool failed: %v", err)
+	}
+	_, oldest, err := reopened.oldest()
+	if err != nil || len(oldest) != 1 || oldest[0].ID != "b" {
+		t.Errorf("oldest after reopen = %v, %v; want b", oldest, err)
+	}
+	if err := reopened.append(events("d")); err != nil {
+		t.Fatalf("append after reopen failed: %v", err)
+	}
+	if n, _ := reopened.stats(); n != 3 {
+		t.Errorf("expected 3 events after reopen and append, got %d", n)
+	}
+}
+
+func TestSamplingDecide(t *testing.T) {
+	s := sampler{cfg: config.SamplingConfig{
+		Percent:         0,
+		EndpointPercent: map[string]float64{"messages": 100},
+		KeyPercent:      map[string]float64{"vip": 100, "ci": 0},
+		Capture:         CaptureFull,
+		EndpointCapture: map[string]string{"embeddings": CaptureMetadata},
+		KeyCapture:      map[string]string{"ci": CaptureMetadata},
+		KeepErrors:      true,
+		KeepSlow:        10 * time.Second,
+		KeepTokens:      1000,
+	}}
+	start := time.Now()
+	gen := func(endpoint, key string) *GenerationBody {
+		return &GenerationBody{
+			TraceID:   GenerateTraceID(),
+			Metadata:  map[string]interface{}{"endpoint": endpoint, "api_key": key},
+			StartTime: start,
+			EndTime:   start.Add(time.Second),
+			Usage:     &UsageData{TotalTokens: 10},
+		}
+	}
+
+	tests := []struct {
+		name        string
+		gen         *GenerationBody
+		wantKeep    bool
+		wantReason  string
+		wantCapture string
+	}{
+		{"sampled out", gen("chat/completions", "alice"), false, "", CaptureFull},
+		{"endpoint override", gen("messages", "alice"), true, "head", CaptureFull},
+		{"key override", gen("chat/completions", "vip"), true, "head", CaptureFull},
+		{"key beats endpoint", gen("messages", "ci"), false, "", CaptureMetadata},
+		{"endpoint capture", gen("embeddings", "vip"), true, "head", CaptureMetadata},
+		{"error", func() *GenerationBody { g := gen("chat/completions", "ci"); g.Level = "ERROR"; return g }(), true, "error", CaptureMetadata},
+		{"slow", func() *GenerationBody { g := gen("chat/completions", "alice"); g.EndTime = start.Add(time.Minute); return g }(), true, "slow", CaptureFull},
+		{"tokens", func() *GenerationBody { g := gen("chat/completions", "alice"); g.Usage.TotalTokens = 1001; return g }(), true, "tokens", CaptureFull},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keep, reason, capture := s.decide(tt.gen)
+			if keep != tt.wantKeep || reason != tt.wantReason || capture != tt.wantCapture {
+				t.Errorf("decide = %v, %q, %q; want %v, %q, %q", keep, reason, capture, tt.wantKeep, tt.wantReason, tt.wantCapture)
+			}
+		})
+	}
+}
+
+func TestHeadSampled(t *testing.T) {
+	kept := 0
+	for i := 0; i < 10000; i++ {
+		if headSampled(GenerateTraceID(), 25) {
+			kept++
+		}
+	}
+	if kept < 2200 || kept > 2800 {
+		t.Errorf("kept %d of 10000 traces at 25%%", kept)
+	}
+	id := GenerateTraceID()
+	if headSampled(id, 50) != headSampled(id, 50) {
+		t.Error("head sampling is not deterministic per trace")
+	}
+}
+
+func TestTrackGenerationMetadataCapture(t *testing.T) {
+	client := &Client{
+		enabled: true,
+		events:  make(chan Event, 10),
+		sampler: sampler{cfg: config.SamplingConfig{Percent: 100, Capture: CaptureMetadata}},
+	}
+	gen := &GenerationBody{
+		TraceID:   "trace-1",
+		Input:     "secret prompt",
+		Output:    "secret answer",
+		Metadata:  map[string]interface{}{"endpoint": "chat/completions"},
+		StartTime: time.Now(),
+	}
+
+	client.TrackGeneration(context.Background(), gen)
+
+	if len(client.events) != 2 {
+		t.Fatalf("expected trace and generation events, got %d", len(client.events))
+	}
+	for i := 0; i < 2; i++ {
+		event := <-client.events
+		if event.Body["input"] != nil || event.Body["output"] != nil {
+			t.Errorf("%s event captured payloads: %v", event.Type, event.Body)
+		}
+		if metadata, _ := event.Body["metadata"].(map[string]interface{}); metadata["capture"] != CaptureMetadata {
+			t.Errorf("%s event metadata = %v", event.Type, event.Body["metadata"])
+		}
+	}
+	if gen.Input != "secret prompt" || gen.Metadata["capture"] != nil {
+		t.Error("TrackGeneration modified the shared generation")
+	}
+}
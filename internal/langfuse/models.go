@@ -40,8 +40,33 @@ type GenerationBody struct {
 	CompletionStartTime  *time.Time             `json:"completionStartTime,omitempty"`
 	Level                string                 `json:"level,omitempty"`
 	StatusMessage        string                 `json:"statusMessage,omitempty"`
+	// Tags, UserID and SessionID are applied to the trace created by
+	// TrackGeneration.
+	Tags                 []string               `json:"-"`
+	UserID               string                 `json:"-"`
+	SessionID            string                 `json:"-"`
 }
 
+// ScoreBody represents the body of a score-create event. Value is a
+// float64 for numeric and boolean scores, where DataType is
+// ScoreBoolean and Value is 0 or 1, or a string for categorical scores.
+type ScoreBody struct {
+	ID        string      `json:"id"`
+	TraceID   string      `json:"traceId"`
+	Name      string      `json:"name"`
+	Value     interface{} `json:"value"`
+	DataType  string      `json:"dataType,omitempty"`
+	Comment   string      `json:"comment,omitempty"`
+	Timestamp time.Time   `json:"timestamp"`
+}
+
+// Score data types.
+const (
+	ScoreNumeric     = "NUMERIC"
+	ScoreBoolean     = "BOOLEAN"
+	ScoreCategorical = "CATEGORICAL"
+)
+
 // UsageData represents token usage information.
 type UsageData struct {
 	PromptTokens     int `json:"promptTokens,omitempty"`
@@ -55,10 +80,14 @@ type BatchIngestionRequest struct {
 	Metadata map[string]interface{} `json:"metadata,omitempty"`
 }
 
-// BatchIngestionResponse represents the batch ingestion API response.
+// BatchIngestionResponse represents the batch ingestion API response,
+// returned with status 207.
 type BatchIngestionResponse struct {
-	Successes []string `json:"successes"`
-	Errors    []struct {
+	Successes []struct {
+		ID     string `json:"id"`
+		Status int    `json:"status"`
+	} `json:"successes"`
+	Errors []struct {
 		ID      string `json:"id"`
 		Status  int    `json:"status"`
 		Message string `json:"message"`
@@ -155,3 +184,27 @@ func NewTraceEvent(trace *TraceBody) Event {
 		Body:      body,
 	}
 }
+
+// NewScoreEvent creates a new score event for the batch.
+func NewScoreEvent(score *ScoreBody) Event {
+	body := map[string]interface{}{
+		"id":      score.ID,
+		"traceId": score.TraceID,
+		"name":    score.Name,
+		"value":   score.Value,
+	}
+
+	if score.DataType != "" {
+		body["dataType"] = score.DataType
+	}
+	if score.Comment != "" {
+		body["comment"] = score.Comment
+	}
+
+	return Event{
+		ID:        score.ID,
+		Type:      "score-create",
+		Timestamp: score.Timestamp,
+		Body:      body,
+	}
+}
@@ -0,0 +1,76 @@
+package langfuse
+
+import (
+	"hash/fnv"
+
+	"github.com/rahulvramesh/gh-proxy-local/internal/config"
+	"github.com/rahulvramesh/gh-proxy-local/internal/metrics"
+)
+
+// Capture levels.
+const (
+	CaptureFull     = "full"
+	CaptureMetadata = "metadata"
+)
+
+// sampler applies the sampling policy to generations. Generations are
+// matched to endpoint and key overrides by their "endpoint" and "api_key"
+// metadata.
+type sampler struct {
+	cfg config.SamplingConfig
+}
+
+// decide returns whether gen is sent, why, and the capture level to send
+// it at. The reason is "head" for generations kept by head sampling, or
+// the tail rule that kept it: "error", "slow" or "tokens".
+func (s sampler) decide(gen *GenerationBody) (keep bool, reason, capture string) {
+	endpoint, _ := gen.Metadata["endpoint"].(string)
+	key, _ := gen.Metadata["api_key"].(string)
+
+	capture = s.cfg.Capture
+	if c, ok := s.cfg.EndpointCapture[endpoint]; ok {
+		capture = c
+	}
+	if c, ok := s.cfg.KeyCapture[key]; ok {
+		capture = c
+	}
+
+	percent := s.cfg.Percent
+	if p, ok := s.cfg.EndpointPercent[endpoint]; ok {
+		percent = p
+	}
+	if p, ok := s.cfg.KeyPercent[key]; ok {
+		percent = p
+	}
+
+	switch {
+	case headSampled(gen.TraceID, percent):
+		reason = "head"
+	case s.cfg.KeepErrors && gen.Level == "ERROR":
+		reason = "error"
+	case s.cfg.KeepSlow > 0 && !gen.EndTime.IsZero() && gen.EndTime.Sub(gen.StartTime) >= s.cfg.KeepSlow:
+		reason = "slow"
+	case s.cfg.KeepTokens > 0 && gen.Usage != nil && gen.Usage.TotalTokens > s.cfg.KeepTokens:
+		reason = "tokens"
+	default:
+		metrics.LangfuseSampled.Inc("dropped")
+		return false, "", capture
+	}
+	metrics.LangfuseSampled.Inc(reason)
+	return true, reason, capture
+}
+
+// headSampled reports whether a trace falls within percent. The decision
+// is derived from the trace ID, so it is the same for every event of a
+// trace.
+func headSampled(traceID string, percent float64) bool {
+	if percent >= 100 {
+		return true
+	}
+	if percent <= 0 {
+		return false
+	}
+	h := fnv.New32a()
+	h.Write([]byte(traceID))
+	return float64(h.Sum32()%10000) < percent*100
+}
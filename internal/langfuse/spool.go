@@ -0,0 +1,203 @@
+package langfuse
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/rahulvramesh/gh-proxy-local/internal/metrics"
+	"github.com/rahulvramesh/gh-proxy-local/internal/storage"
+)
+
+// spool buffers undeliverable batches on disk, one JSONL segment file per
+// batch, named by a sequence number so they replay in order.
+type spool struct {
+	dir      string
+	maxBytes int64
+
+	mu       sync.Mutex
+	segments []segment
+	bytes    int64
+	events   int
+	next     uint64
+}
+
+// segment is one spooled batch.
+type segment struct {
+	seq    uint64
+	size   int64
+	events int
+}
+
+func (s *spool) path(seq uint64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%020d.jsonl", seq))
+}
+
+// openSpool opens the spool in dir, picking up segments left by a
+// previous run.
+func openSpool(dir string, maxBytes int64) (*spool, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create spool directory: %w", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read spool directory: %w", err)
+	}
+
+	s := &spool{dir: dir, maxBytes: maxBytes}
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), ".jsonl")
+		if !ok || entry.IsDir() {
+			continue
+		}
+		seq, err := strconv.ParseUint(name, 10, 64)
+		if err != nil {
+			continue
+		}
+		seg := segment{seq: seq}
+		if info, err := entry.Info(); err == nil {
+			seg.size = info.Size()
+		}
+		storage.ReadJSONL(s.path(seq), func([]byte) error {
+			seg.events++
+			return nil
+		})
+		s.segments = append(s.segments, seg)
+		s.bytes += seg.size
+		s.events += seg.events
+	}
+	sort.Slice(s.segments, func(i, j int) bool { return s.segments[i].seq < s.segments[j].seq })
+	if n := len(s.segments); n > 0 {
+		s.next = s.segments[n-1].seq + 1
+	}
+	return s, nil
+}
+
+// pending reports whether any events are spooled.
+func (s *spool) pending() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.segments) > 0
+}
+
+// stats returns the number of spooled events and their size on disk.
+func (s *spool) stats() (events int, bytes int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.events, s.bytes
+}
+
+// append spools a batch as a new segment, evicting the oldest segments to
+// stay within the size cap.
+func (s *spool) append(events []Event) error {
+	data, err := encodeSegment(events)
+	if err != nil {
+		return err
+	}
+	if int64(len(data)) > s.maxBytes {
+		metrics.LangfuseDropped.Add(float64(len(events)), "spool_full")
+		return fmt.Errorf("batch of %d bytes exceeds the spool size", len(data))
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for len(s.segments) > 0 && s.bytes+int64(len(data)) > s.maxBytes {
+		oldest := s.segments[0]
+		os.Remove(s.path(oldest.seq))
+		s.segments = s.segments[1:]
+		s.bytes -= oldest.size
+		s.events -= oldest.events
+		metrics.LangfuseDropped.Add(float64(oldest.events), "spool_full")
+	}
+
+	seg := segment{seq: s.next, size: int64(len(data)), events: len(events)}
+	if err := storage.WriteFile(s.path(seg.seq), data); err != nil {
+		return err
+	}
+	s.next++
+	s.segments = append(s.segments, seg)
+	s.bytes += seg.size
+	s.events += seg.events
+	metrics.LangfuseSpooled.Add(float64(len(events)))
+	return nil
+}
+
+// oldest returns the oldest spooled batch.
+func (s *spool) oldest() (uint64, []Event, error) {
+	s.mu.Lock()
+	if len(s.segments) == 0 {
+		s.mu.Unlock()
+		return 0, nil, os.ErrNotExist
+	}
+	seq := s.segments[0].seq
+	s.mu.Unlock()
+
+	var events []Event
+	err := storage.ReadJSONL(s.path(seq), func(line []byte) error {
+		var event Event
+		if err := json.Unmarshal(line, &event); err != nil {
+			return err
+		}
+		events = append(events, event)
+		return nil
+	})
+	return seq, events, err
+}
+
+// replace rewrites a segment with the events still to be delivered, or
+// removes it when none are left.
+func (s *spool) replace(seq uint64, events []Event) error {
+	var data []byte
+	if len(events) > 0 {
+		var err error
+		if data, err = encodeSegment(events); err != nil {
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := sort.Search(len(s.segments), func(i int) bool { return s.segments[i].seq >= seq })
+	if i == len(s.segments) || s.segments[i].seq != seq {
+		// Evicted while it was being replayed
+		return nil
+	}
+	seg := &s.segments[i]
+
+	if len(events) == 0 {
+		if err := os.Remove(s.path(seq)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		s.bytes -= seg.size
+		s.events -= seg.events
+		s.segments = append(s.segments[:i], s.segments[i+1:]...)
+		return nil
+	}
+
+	if err := storage.WriteFile(s.path(seq), data); err != nil {
+		return err
+	}
+	s.bytes += int64(len(data)) - seg.size
+	s.events += len(events) - seg.events
+	seg.size, seg.events = int64(len(data)), len(events)
+	return nil
+}
+
+func encodeSegment(events []Event) ([]byte, error) {
+	var buf bytes.Buffer
+	for _, event := range events {
+		line, err := json.Marshal(event)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal event: %w", err)
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes(), nil
+}
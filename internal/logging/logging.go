@@ -0,0 +1,142 @@
+// Package logging configures structured logging with log/slog and carries
+// request IDs so every line logged for a request can be correlated.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync/atomic"
+
+	"github.com/google/uuid"
+	"github.com/rahulvramesh/gh-proxy-local/internal/config"
+)
+
+// RequestIDHeader is the header a request ID is accepted from and
+// returned in.
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds caller-supplied request IDs.
+const maxRequestIDLength = 128
+
+var (
+	// level is the level of the default logger, which SetDebug changes
+	// at runtime.
+	level slog.LevelVar
+	// configured is the level set up at startup.
+	configured atomic.Int64
+)
+
+// Setup installs a JSON or text handler at the configured level as the
+// default slog logger. Output of the log package is routed through it.
+func Setup(w io.Writer, cfg config.LogConfig) error {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(cfg.Level)); err != nil {
+		return fmt.Errorf("invalid log level %q", cfg.Level)
+	}
+	level.Set(l)
+	configured.Store(int64(l))
+
+	opts := &slog.HandlerOptions{Level: &level}
+	var handler slog.Handler
+	switch strings.ToLower(cfg.Format) {
+	case "", "text":
+		handler = slog.NewTextHandler(w, opts)
+	case "json":
+		handler = slog.NewJSONHandler(w, opts)
+	default:
+		return fmt.Errorf("invalid log format %q (want text or json)", cfg.Format)
+	}
+
+	slog.SetDefault(slog.New(contextHandler{handler}))
+	return nil
+}
+
+// SetLevel changes the configured level at runtime.
+func SetLevel(name string) error {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(name)); err != nil {
+		return fmt.Errorf("invalid log level %q", name)
+	}
+	level.Set(l)
+	configured.Store(int64(l))
+	return nil
+}
+
+// SetDebug switches debug logging on or off at runtime. Switching it off
+// restores the configured level, or info when that was debug.
+func SetDebug(on bool) {
+	switch l := slog.Level(configured.Load()); {
+	case on:
+		level.Set(slog.LevelDebug)
+	case l <= slog.LevelDebug:
+		level.Set(slog.LevelInfo)
+	default:
+		level.Set(l)
+	}
+}
+
+// DebugEnabled reports whether debug messages are logged.
+func DebugEnabled() bool {
+	return level.Level() <= slog.LevelDebug
+}
+
+// Level returns the current log level.
+func Level() slog.Level {
+	return level.Level()
+}
+
+// contextHandler adds the request ID of the context passed to the
+// *Context logging functions to each record.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+type requestIDKey struct{}
+
+// WithRequestID returns a context carrying a request ID.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request ID carried by ctx, or "".
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// NewRequestID generates a request ID.
+func NewRequestID() string {
+	return uuid.New().String()
+}
+
+// AcceptRequestID returns a caller-supplied request ID if it is safe to log
+// and echo, or a new one.
+func AcceptRequestID(id string) string {
+	if id == "" || len(id) > maxRequestIDLength {
+		return NewRequestID()
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("-_.:", c)) {
+			return NewRequestID()
+		}
+	}
+	return id
+}
@@ -0,0 +1,86 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/rahulvramesh/gh-proxy-local/internal/config"
+)
+
+func TestSetupJSON(t *testing.T) {
+	defer slog.SetDefault(slog.Default())
+
+	var buf bytes.Buffer
+	if err := Setup(&buf, config.LogConfig{Level: "info", Format: "json"}); err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+
+	ctx := WithRequestID(context.Background(), "req-123")
+	slog.DebugContext(ctx, "hidden")
+	slog.InfoContext(ctx, "visible", "model", "gpt-4.1")
+	slog.Info("no request")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines at info level, got %d: %q", len(lines), buf.String())
+	}
+	var record map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &record); err != nil {
+		t.Fatalf("line is not JSON: %v", err)
+	}
+	if record["msg"] != "visible" || record["request_id"] != "req-123" || record["model"] != "gpt-4.1" {
+		t.Errorf("unexpected record %v", record)
+	}
+	if strings.Contains(lines[1], "request_id") {
+		t.Errorf("line without a request carries a request ID: %s", lines[1])
+	}
+}
+
+func TestSetupInvalid(t *testing.T) {
+	if err := Setup(&bytes.Buffer{}, config.LogConfig{Level: "loud"}); err == nil {
+		t.Error("expected an error for an invalid level")
+	}
+	if err := Setup(&bytes.Buffer{}, config.LogConfig{Level: "info", Format: "xml"}); err == nil {
+		t.Error("expected an error for an invalid format")
+	}
+}
+
+func TestSetDebug(t *testing.T) {
+	defer slog.SetDefault(slog.Default())
+
+	var buf bytes.Buffer
+	if err := Setup(&buf, config.LogConfig{Level: "warn"}); err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	SetDebug(true)
+	slog.Debug("while debugging")
+	if !DebugEnabled() || !strings.Contains(buf.String(), "while debugging") {
+		t.Errorf("expected debug messages once switched on, got %q", buf.String())
+	}
+
+	SetDebug(false)
+	if DebugEnabled() || Level() != slog.LevelWarn {
+		t.Errorf("expected the configured level to be restored, got %v", Level())
+	}
+
+	Setup(&buf, config.LogConfig{Level: "debug"})
+	SetDebug(false)
+	if Level() != slog.LevelInfo {
+		t.Errorf("expected info after switching off a debug configuration, got %v", Level())
+	}
+}
+
+func TestAcceptRequestID(t *testing.T) {
+	if got := AcceptRequestID("client-abc_1.2:3"); got != "client-abc_1.2:3" {
+		t.Errorf("valid ID replaced with %q", got)
+	}
+	for _, id := range []string{"", "has space", "new\nline", strings.Repeat("a", maxRequestIDLength+1)} {
+		if got := AcceptRequestID(id); got == id || got == "" {
+			t.Errorf("AcceptRequestID(%q) = %q, want a generated ID", id, got)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package metrics
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRegistryWrite(t *testing.T) {
+	r := NewRegistry()
+	requests := r.NewCounterVec("test_requests_total", "Requests.", "endpoint", "status")
+	latency := r.NewHistogramVec("test_latency_seconds", "Latency.", []float64{0.1, 1}, "endpoint")
+	r.NewCounterVec("test_dropped_total", "Dropped.")
+	r.NewGaugeFunc("test_queue_depth", "Queue depth.", func() float64 { return 3 })
+
+	requests.Inc("/v1/chat/completions", "200")
+	requests.Add(2, "/v1/chat/completions", "200")
+	requests.Inc(`/a"b`, "500")
+	latency.Observe(0.05, "/v1/messages")
+	latency.Observe(0.5, "/v1/messages")
+
+	var out strings.Builder
+	if err := r.Write(&out); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	text := out.String()
+
+	for _, want := range []string{
+		"# TYPE test_requests_total counter\n",
+		`test_requests_total{endpoint="/v1/chat/completions",status="200"} 3` + "\n",
+		`test_requests_total{endpoint="/a\"b",status="500"} 1` + "\n",
+		"# TYPE test_latency_seconds histogram\n",
+		`test_latency_seconds_bucket{endpoint="/v1/messages",le="0.1"} 1` + "\n",
+		`test_latency_seconds_bucket{endpoint="/v1/messages",le="1"} 2` + "\n",
+		`test_latency_seconds_bucket{endpoint="/v1/messages",le="+Inf"} 2` + "\n",
+		`test_latency_seconds_sum{endpoint="/v1/messages"} 0.55` + "\n",
+		`test_latency_seconds_count{endpoint="/v1/messages"} 2` + "\n",
+		"test_dropped_total 0\n",
+		"# TYPE test_queue_depth gauge\ntest_queue_depth 3\n",
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("output missing %q:\n%s", want, text)
+		}
+	}
+	if strings.Index(text, "test_dropped_total") > strings.Index(text, "test_requests_total") {
+		t.Error("families should be sorted by name")
+	}
+}
+
+func TestLabels(t *testing.T) {
+	ctx, labels := WithLabels(context.Background(), "/v1/messages", time.Now())
+	SetModel(ctx, "claude-sonnet-4.5")
+	SetKey(ctx, "ci")
+	if model, key := labels.Values(); model != "claude-sonnet-4.5" || key != "ci" {
+		t.Errorf("Values = %q, %q", model, key)
+	}
+
+	FirstToken(ctx)
+	FirstToken(ctx)
+	var out strings.Builder
+	Default.Write(&out)
+	if !strings.Contains(out.String(), `copilot_proxy_time_to_first_token_seconds_count{endpoint="/v1/messages",model="claude-sonnet-4.5"} 1`) {
+		t.Error("time to first token should be recorded once")
+	}
+
+	// Contexts without labels are ignored
+	SetModel(context.Background(), "gpt-4.1")
+	FirstToken(context.Background())
+}
@@ -0,0 +1,110 @@
+package metrics
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Series exported at /metrics.
+var (
+	Requests = NewCounterVec("copilot_proxy_requests_total",
+		"HTTP requests by endpoint, model, status code and API key.", "endpoint", "model", "status", "key")
+	RequestDuration = NewHistogramVec("copilot_proxy_request_duration_seconds",
+		"HTTP request latency in seconds.", DefaultBuckets, "endpoint", "model")
+	TimeToFirstToken = NewHistogramVec("copilot_proxy_time_to_first_token_seconds",
+		"Time from request start to the first streamed upstream chunk, in seconds.", DefaultBuckets, "endpoint", "model")
+	Tokens = NewCounterVec("copilot_proxy_tokens_total",
+		"Tokens reported by the upstream, by model, API key and type (prompt or completion).", "model", "key", "type")
+	UpstreamRetries = NewCounterVec("copilot_proxy_upstream_retries_total",
+		"Retried upstream requests by reason.", "reason")
+	TokenRefreshes = NewCounterVec("copilot_proxy_token_refreshes_total",
+		"Copilot API token refreshes by result (success or failure).", "result")
+	ModelsCache = NewCounterVec("copilot_proxy_models_cache_requests_total",
+		"Models list lookups by cache result (hit or miss).", "result")
+	LangfuseDropped = NewCounterVec("copilot_proxy_langfuse_events_dropped_total",
+		"Langfuse events dropped, by reason (queue_full, rejected, send_failed or spool_full).", "reason")
+	LangfuseSpooled = NewCounterVec("copilot_proxy_langfuse_events_spooled_total",
+		"Langfuse events written to the disk spool.")
+	LangfuseReplayed = NewCounterVec("copilot_proxy_langfuse_events_replayed_total",
+		"Spooled Langfuse events delivered on replay.")
+	LangfuseSampled = NewCounterVec("copilot_proxy_langfuse_generations_total",
+		"Generations by sampling decision (head, error, slow, tokens or dropped).", "decision")
+	TelemetryRecords = NewCounterVec("copilot_proxy_telemetry_records_total",
+		"Request records delivered by the file, webhook and stdout telemetry sinks.", "sink")
+	TelemetryDropped = NewCounterVec("copilot_proxy_telemetry_records_dropped_total",
+		"Request records a telemetry sink dropped, by sink and reason (encode, write, closed, queue_full or send_failed).", "sink", "reason")
+)
+
+// Labels are request attributes learnt while a request is handled.
+type Labels struct {
+	endpoint string
+	start    time.Time
+
+	mu         sync.Mutex
+	model      string
+	key        string
+	firstToken bool
+}
+
+type labelsKey struct{}
+
+// WithLabels returns a context carrying new Labels for a request to
+// endpoint that started at start.
+func WithLabels(ctx context.Context, endpoint string, start time.Time) (context.Context, *Labels) {
+	l := &Labels{endpoint: endpoint, start: start}
+	return context.WithValue(ctx, labelsKey{}, l), l
+}
+
+// FirstToken records the time to first token of a streamed request. Only
+// the first call per request is recorded.
+func FirstToken(ctx context.Context) {
+	l, ok := ctx.Value(labelsKey{}).(*Labels)
+	if !ok {
+		return
+	}
+	l.mu.Lock()
+	first := !l.firstToken
+	l.firstToken = true
+	model := l.model
+	l.mu.Unlock()
+	if first {
+		TimeToFirstToken.Observe(time.Since(l.start).Seconds(), l.endpoint, model)
+	}
+}
+
+// OtherModel is the model label of requests for models the proxy does not
+// know, so clients cannot create series at will.
+const OtherModel = "other"
+
+// SetModel records the model of the request, if it is being measured.
+func SetModel(ctx context.Context, model string) {
+	if l, ok := ctx.Value(labelsKey{}).(*Labels); ok {
+		l.mu.Lock()
+		l.model = model
+		l.mu.Unlock()
+	}
+}
+
+// SetKey records the API key of the request, if it is being measured.
+func SetKey(ctx context.Context, key string) {
+	if l, ok := ctx.Value(labelsKey{}).(*Labels); ok {
+		l.mu.Lock()
+		l.key = key
+		l.mu.Unlock()
+	}
+}
+
+// LabelsFromContext returns the request's Labels, or nil when it is not
+// being measured.
+func LabelsFromContext(ctx context.Context) *Labels {
+	l, _ := ctx.Value(labelsKey{}).(*Labels)
+	return l
+}
+
+// Values returns the recorded model and key.
+func (l *Labels) Values() (model, key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.model, l.key
+}
@@ -1,5 +1,14 @@
 package models
 
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/rahulvramesh/gh-proxy-local/internal/storage"
+)
+
 // ModelAliases maps common model names to Copilot equivalents.
 var ModelAliases = map[string]string{
 	// Anthropic SDK model names -> Copilot names
@@ -26,8 +35,46 @@ var VendorOwnerMap = map[string]string{
 	"xAI":          "xai",
 }
 
+var (
+	aliasesMu sync.RWMutex
+	// configured are the aliases from the configuration and the aliases
+	// file, which take precedence over ModelAliases.
+	configured map[string]string
+)
+
+// SetAliases replaces the configured aliases.
+func SetAliases(aliases map[string]string) {
+	aliasesMu.Lock()
+	configured = aliases
+	aliasesMu.Unlock()
+}
+
+// LoadAliases reads aliases from a JSON object mapping names to Copilot
+// model names. A missing file holds no aliases.
+func LoadAliases(path string) (map[string]string, error) {
+	var aliases map[string]string
+	if err := storage.ReadJSON(path, &aliases); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read model aliases: %w", err)
+	}
+	for alias, model := range aliases {
+		if alias == "" || model == "" {
+			return nil, fmt.Errorf("invalid model alias %q -> %q in %s", alias, model, path)
+		}
+	}
+	return aliases, nil
+}
+
 // ResolveModel resolves a model alias to the actual Copilot model name.
 func ResolveModel(model string) string {
+	aliasesMu.RLock()
+	resolved, ok := configured[model]
+	aliasesMu.RUnlock()
+	if ok {
+		return resolved
+	}
 	if resolved, ok := ModelAliases[model]; ok {
 		return resolved
 	}
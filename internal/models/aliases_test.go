@@ -1,6 +1,9 @@
 package models
 
 import (
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -118,3 +121,33 @@ func TestModelAliasesComplete(t *testing.T) {
 		}
 	}
 }
+
+func TestLoadAliases(t *testing.T) {
+	dir := t.TempDir()
+	if aliases, err := LoadAliases(filepath.Join(dir, "missing.json")); err != nil || aliases != nil {
+		t.Fatalf("expected no aliases from a missing file, got %v, %v", aliases, err)
+	}
+
+	path := filepath.Join(dir, "aliases.json")
+	os.WriteFile(path, []byte(`{"fast": "gpt-4.1", "gpt-4-turbo": "gpt-5"}`), 0600)
+	aliases, err := LoadAliases(path)
+	if err != nil {
+		t.Fatalf("LoadAliases failed: %v", err)
+	}
+	SetAliases(aliases)
+	defer SetAliases(nil)
+	if got := ResolveModel("fast"); got != "gpt-4.1" {
+		t.Errorf("ResolveModel(fast) = %q", got)
+	}
+	if got := ResolveModel("gpt-4-turbo"); got != "gpt-5" {
+		t.Errorf("expected configured alias to override the built-in one, got %q", got)
+	}
+	if got := ResolveModel("claude-3-5-haiku-20241022"); got != "claude-haiku-4.5" {
+		t.Errorf("expected built-in aliases to remain, got %q", got)
+	}
+
+	os.WriteFile(path, []byte(`{"empty": ""}`), 0600)
+	if _, err := LoadAliases(path); err == nil || !strings.Contains(err.Error(), `"empty"`) {
+		t.Errorf("expected an error naming the invalid alias, got %v", err)
+	}
+}
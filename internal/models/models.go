@@ -149,6 +149,8 @@ type OpenAIChatResponse struct {
 			Role       string          `json:"role"`
 			Content    string          `json:"content,omitempty"`
 			ToolCalls  json.RawMessage `json:"tool_calls,omitempty"`
+			// ReasoningText is returned by Copilot for reasoning models.
+			ReasoningText string `json:"reasoning_text,omitempty"`
 		} `json:"message"`
 		FinishReason string `json:"finish_reason,omitempty"`
 	} `json:"choices"`
@@ -162,11 +164,15 @@ type Usage struct {
 	TotalTokens             int `json:"total_tokens"`
 	PromptTokensDetails     *TokenDetails `json:"prompt_tokens_details,omitempty"`
 	CompletionTokensDetails *TokenDetails `json:"completion_tokens_details,omitempty"`
+	// Prompt cache usage reported by Claude backends.
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens,omitempty"`
+	CacheReadInputTokens     int `json:"cache_read_input_tokens,omitempty"`
 }
 
 // TokenDetails represents detailed token information.
 type TokenDetails struct {
 	CachedTokens              int `json:"cached_tokens,omitempty"`
+	CacheCreationInputTokens  int `json:"cache_creation_input_tokens,omitempty"`
 	AcceptedPredictionTokens  int `json:"accepted_prediction_tokens,omitempty"`
 	RejectedPredictionTokens  int `json:"rejected_prediction_tokens,omitempty"`
 }
@@ -230,6 +236,27 @@ type AnthropicUsage struct {
 	CacheReadInputTokens     int `json:"cache_read_input_tokens,omitempty"`
 }
 
+// CopilotUserInfo represents the Copilot user endpoint response, which
+// carries the plan and quota snapshots.
+type CopilotUserInfo struct {
+	Login          string                   `json:"login,omitempty"`
+	CopilotPlan    string                   `json:"copilot_plan,omitempty"`
+	QuotaResetDate string                   `json:"quota_reset_date,omitempty"`
+	QuotaSnapshots map[string]QuotaSnapshot `json:"quota_snapshots,omitempty"`
+}
+
+// QuotaSnapshot is a single quota from the Copilot user endpoint, such as
+// "premium_interactions" or "chat".
+type QuotaSnapshot struct {
+	QuotaID          string  `json:"quota_id,omitempty"`
+	Entitlement      float64 `json:"entitlement"`
+	Remaining        float64 `json:"remaining"`
+	PercentRemaining float64 `json:"percent_remaining"`
+	Unlimited        bool    `json:"unlimited"`
+	OverageCount     float64 `json:"overage_count"`
+	OveragePermitted bool    `json:"overage_permitted"`
+}
+
 // GitHubUser represents GitHub user information.
 type GitHubUser struct {
 	Login     string `json:"login"`
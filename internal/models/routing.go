@@ -0,0 +1,60 @@
+package models
+
+import (
+	"errors"
+	"path"
+	"strings"
+	"sync"
+)
+
+// ErrModelNotAllowed is returned for models the proxy is not configured
+// to serve.
+var ErrModelNotAllowed = errors.New("model is not allowed on this proxy")
+
+var (
+	routingMu sync.RWMutex
+	// hiddenModels are left out of model listings. Embedding models are
+	// listed by the embeddings API instead.
+	hiddenModels = []string{"*embedding*", "oswe-*"}
+	// allowedModels restrict the models requests may use when set.
+	allowedModels []string
+)
+
+// SetRouting replaces the patterns of the models left out of model
+// listings and of the models requests may use. Patterns are matched
+// case-insensitively with path.Match; no allowed patterns allow every
+// model.
+func SetRouting(hidden, allowed []string) {
+	routingMu.Lock()
+	hiddenModels, allowedModels = hidden, allowed
+	routingMu.Unlock()
+}
+
+// Listed reports whether a model is shown in model listings.
+func Listed(id string) bool {
+	routingMu.RLock()
+	hidden := matchAny(hiddenModels, id)
+	routingMu.RUnlock()
+	return !hidden && Allowed(id)
+}
+
+// Allowed reports whether requests may use a model. Aliases match either
+// their own name or the model they resolve to.
+func Allowed(model string) bool {
+	routingMu.RLock()
+	defer routingMu.RUnlock()
+	if len(allowedModels) == 0 {
+		return true
+	}
+	return matchAny(allowedModels, model) || matchAny(allowedModels, ResolveModel(model))
+}
+
+func matchAny(patterns []string, model string) bool {
+	model = strings.ToLower(model)
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(strings.ToLower(pattern), model); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,32 @@
+package models
+
+import "testing"
+
+func TestRouting(t *testing.T) {
+	if !Listed("gpt-4o") || Listed("text-embedding-3-small") || Listed("oswe-vscode-prime") {
+		t.Error("expected embedding and oswe models to be hidden by default")
+	}
+
+	SetRouting([]string{"*-mini"}, []string{"GPT-*", "claude-sonnet-4"})
+	defer SetRouting([]string{"*embedding*", "oswe-*"}, nil)
+	SetAliases(map[string]string{"sonnet": "claude-sonnet-4"})
+	defer SetAliases(nil)
+
+	for model, want := range map[string]bool{
+		"gpt-4o":          true,
+		"claude-sonnet-4": true,
+		"sonnet":          true,
+		"claude-opus-4":   false,
+		"gemini-2.5-pro":  false,
+	} {
+		if got := Allowed(model); got != want {
+			t.Errorf("Allowed(%q) = %v, want %v", model, got, want)
+		}
+	}
+	if Listed("gpt-4o-mini") {
+		t.Error("expected gpt-4o-mini to be hidden")
+	}
+	if Listed("text-embedding-3-small") {
+		t.Error("expected models that are not allowed to be hidden")
+	}
+}
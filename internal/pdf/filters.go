@@ -0,0 +1,172 @@
+package pdf
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/zlib"
+	"encoding/ascii85"
+	"fmt"
+	"io"
+)
+
+// maxDecodedSize bounds the output of a single stream filter chain.
+const maxDecodedSize = 64 << 20
+
+// decode applies the stream's filters and returns its decoded data.
+func (doc *document) decode(s *stream) ([]byte, error) {
+	var filters []interface{}
+	switch f := doc.resolve(s.dict["Filter"]).(type) {
+	case name:
+		filters = []interface{}{f}
+	case array:
+		filters = f
+	}
+
+	var params []interface{}
+	switch p := doc.resolve(s.dict["DecodeParms"]).(type) {
+	case dict:
+		params = []interface{}{p}
+	case array:
+		params = p
+	}
+
+	data := s.data
+	for i, f := range filters {
+		filter, _ := doc.resolve(f).(name)
+		var parms dict
+		if i < len(params) {
+			parms = doc.dictOf(params[i])
+		}
+
+		var err error
+		switch filter {
+		case "FlateDecode", "Fl":
+			data, err = inflate(data)
+			if err == nil {
+				data, err = unpredict(data, parms)
+			}
+		case "ASCIIHexDecode", "AHx":
+			data = asciiHexDecode(data)
+		case "ASCII85Decode", "A85":
+			data, err = ascii85Decode(data)
+		default:
+			return nil, fmt.Errorf("unsupported filter %s", filter)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// inflate decompresses zlib data, falling back to raw deflate and keeping
+// whatever was recovered from truncated streams.
+func inflate(data []byte) ([]byte, error) {
+	var r io.Reader
+	if zr, err := zlib.NewReader(bytes.NewReader(data)); err == nil {
+		r = zr
+	} else if len(data) > 2 {
+		r = flate.NewReader(bytes.NewReader(data[2:]))
+	} else {
+		return nil, err
+	}
+
+	out, err := io.ReadAll(io.LimitReader(r, maxDecodedSize))
+	if err != nil && len(out) == 0 {
+		return nil, fmt.Errorf("flate: %w", err)
+	}
+	return out, nil
+}
+
+// unpredict reverses PNG predictors (Predictor >= 10).
+func unpredict(data []byte, parms dict) ([]byte, error) {
+	predictor, _ := parms["Predictor"].(float64)
+	if predictor < 10 {
+		return data, nil
+	}
+
+	columns := 1
+	if c, ok := parms["Columns"].(float64); ok && c > 0 {
+		columns = int(c)
+	}
+	colors := 1
+	if c, ok := parms["Colors"].(float64); ok && c > 0 {
+		colors = int(c)
+	}
+	bpc := 8
+	if b, ok := parms["BitsPerComponent"].(float64); ok && b > 0 {
+		bpc = int(b)
+	}
+
+	bpp := (colors*bpc + 7) / 8
+	rowLen := (columns*colors*bpc + 7) / 8
+	if rowLen <= 0 {
+		return data, nil
+	}
+
+	var out []byte
+	prev := make([]byte, rowLen)
+	for pos := 0; pos+1+rowLen <= len(data); pos += rowLen + 1 {
+		typ := data[pos]
+		row := append([]byte(nil), data[pos+1:pos+1+rowLen]...)
+		for i := range row {
+			var left, up, upLeft byte
+			if i >= bpp {
+				left = row[i-bpp]
+				upLeft = prev[i-bpp]
+			}
+			up = prev[i]
+			switch typ {
+			case 1:
+				row[i] += left
+			case 2:
+				row[i] += up
+			case 3:
+				row[i] += byte((int(left) + int(up)) / 2)
+			case 4:
+				row[i] += paeth(left, up, upLeft)
+			}
+		}
+		out = append(out, row...)
+		prev = row
+	}
+	return out, nil
+}
+
+func paeth(a, b, c byte) byte {
+	p := int(a) + int(b) - int(c)
+	pa, pb, pc := abs(p-int(a)), abs(p-int(b)), abs(p-int(c))
+	switch {
+	case pa <= pb && pa <= pc:
+		return a
+	case pb <= pc:
+		return b
+	}
+	return c
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+func asciiHexDecode(data []byte) []byte {
+	l := &lexer{data: append(append([]byte(nil), data...), '>')}
+	return l.hexString()
+}
+
+func ascii85Decode(data []byte) ([]byte, error) {
+	data = bytes.TrimSpace(data)
+	data = bytes.TrimPrefix(data, []byte("<~"))
+	if i := bytes.Index(data, []byte("~>")); i >= 0 {
+		data = data[:i]
+	}
+	out := make([]byte, 4*len(data)/5+4)
+	n, _, err := ascii85.Decode(out, data, true)
+	if err != nil {
+		return nil, fmt.Errorf("ascii85: %w", err)
+	}
+	return out[:n], nil
+}
@@ -0,0 +1,329 @@
+package pdf
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// font maps character codes in shown strings to Unicode text.
+type font struct {
+	// cmap is the font's ToUnicode mapping, if any.
+	cmap *cmap
+	// composite is set for Type0 fonts, whose codes are two bytes wide
+	// unless a ToUnicode codespace says otherwise.
+	composite bool
+	// encoding maps single-byte codes of simple fonts.
+	encoding [256]rune
+}
+
+// decode converts a shown string to text.
+func (f *font) decode(s []byte) string {
+	if f == nil {
+		return decodeSimple(&winAnsi, s)
+	}
+	if f.cmap != nil {
+		return f.cmap.decode(s)
+	}
+	if f.composite {
+		// Without ToUnicode the codes are glyph IDs; guess UTF-16.
+		var units []uint16
+		for i := 0; i+1 < len(s); i += 2 {
+			units = append(units, uint16(s[i])<<8|uint16(s[i+1]))
+		}
+		return string(utf16.Decode(units))
+	}
+	return decodeSimple(&f.encoding, s)
+}
+
+func decodeSimple(enc *[256]rune, s []byte) string {
+	var b strings.Builder
+	for _, c := range s {
+		if r := enc[c]; r != 0 {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// loadFont builds a font from its dictionary.
+func (doc *document) loadFont(v interface{}) *font {
+	d := doc.dictOf(v)
+	if d == nil {
+		return nil
+	}
+
+	f := &font{composite: doc.resolve(d["Subtype"]) == name("Type0")}
+	if s := doc.streamOf(d["ToUnicode"]); s != nil {
+		if data, err := doc.decode(s); err == nil {
+			f.cmap = parseCMap(data)
+		}
+	}
+
+	f.encoding = winAnsi
+	switch enc := doc.resolve(d["Encoding"]).(type) {
+	case name:
+		if enc == "MacRomanEncoding" {
+			f.encoding = macRoman
+		}
+	case dict:
+		if doc.resolve(enc["BaseEncoding"]) == name("MacRomanEncoding") {
+			f.encoding = macRoman
+		}
+		if diffs, ok := doc.resolve(enc["Differences"]).(array); ok {
+			code := 0
+			for _, item := range diffs {
+				switch x := doc.resolve(item).(type) {
+				case float64:
+					code = int(x)
+				case name:
+					if code >= 0 && code < 256 {
+						if r := glyphRune(string(x)); r != 0 {
+							f.encoding[code] = r
+						}
+					}
+					code++
+				}
+			}
+		}
+	}
+	return f
+}
+
+// glyphNames covers the common non-alphanumeric Adobe glyph names.
+var glyphNames = map[string]rune{
+	"space": ' ', "exclam": '!', "quotedbl": '"', "numbersign": '#',
+	"dollar": '$', "percent": '%', "ampersand": '&', "quotesingle": '\'',
+	"parenleft": '(', "parenright": ')', "asterisk": '*', "plus": '+',
+	"comma": ',', "hyphen": '-', "period": '.', "slash": '/',
+	"zero": '0', "one": '1', "two": '2', "three": '3', "four": '4',
+	"five": '5', "six": '6', "seven": '7', "eight": '8', "nine": '9',
+	"colon": ':', "semicolon": ';', "less": '<', "equal": '=',
+	"greater": '>', "question": '?', "at": '@', "bracketleft": '[',
+	"backslash": '\\', "bracketright": ']', "asciicircum": '^',
+	"underscore": '_', "grave": '`', "braceleft": '{', "bar": '|',
+	"braceright": '}', "asciitilde": '~', "quoteleft": '‘',
+	"quoteright": '’', "quotedblleft": '“', "quotedblright": '”',
+	"endash": '–', "emdash": '—', "bullet": '•', "ellipsis": '…',
+	"fi": 'ﬁ', "fl": 'ﬂ', "ff": 'ﬀ', "ffi": 'ﬃ', "ffl": 'ﬄ',
+	"copyright": '©', "registered": '®', "trademark": '™',
+	"degree": '°', "section": '§', "paragraph": '¶', "dagger": '†',
+	"minus": '−', "multiply": '×', "divide": '÷', "nbspace": ' ',
+}
+
+// glyphRune maps a glyph name to a rune.
+func glyphRune(n string) rune {
+	if len(n) == 1 {
+		return rune(n[0])
+	}
+	if r, ok := glyphNames[n]; ok {
+		return r
+	}
+	if strings.HasPrefix(n, "uni") && len(n) == 7 {
+		if v, err := strconv.ParseUint(n[3:], 16, 16); err == nil {
+			return rune(v)
+		}
+	}
+	return 0
+}
+
+// cmap is a parsed ToUnicode CMap.
+type cmap struct {
+	// widths lists the code byte lengths from the codespace ranges.
+	widths map[int]bool
+	chars  map[string]string
+	ranges []cmapRange
+}
+
+type cmapRange struct {
+	lo, hi []byte
+	dst    []byte
+	dsts   []string
+}
+
+// parseCMap reads bfchar and bfrange mappings from a ToUnicode stream.
+func parseCMap(data []byte) *cmap {
+	m := &cmap{widths: make(map[int]bool), chars: make(map[string]string)}
+	l := &lexer{data: data}
+
+	var operands []interface{}
+	for {
+		obj, err := l.object()
+		if err != nil {
+			break
+		}
+		kw, ok := obj.(keyword)
+		if !ok {
+			operands = append(operands, obj)
+			continue
+		}
+
+		switch kw {
+		case "endcodespacerange":
+			for i := 0; i+1 < len(operands); i += 2 {
+				if lo, ok := operands[i].([]byte); ok {
+					m.widths[len(lo)] = true
+				}
+			}
+		case "endbfchar":
+			for i := 0; i+1 < len(operands); i += 2 {
+				src, ok1 := operands[i].([]byte)
+				dst, ok2 := operands[i+1].([]byte)
+				if ok1 && ok2 {
+					m.chars[string(src)] = utf16BE(dst)
+					m.widths[len(src)] = true
+				}
+			}
+		case "endbfrange":
+			for i := 0; i+2 < len(operands); i += 3 {
+				lo, ok1 := operands[i].([]byte)
+				hi, ok2 := operands[i+1].([]byte)
+				if !ok1 || !ok2 || len(lo) != len(hi) {
+					continue
+				}
+				r := cmapRange{lo: lo, hi: hi}
+				switch dst := operands[i+2].(type) {
+				case []byte:
+					r.dst = dst
+				case array:
+					for _, d := range dst {
+						if b, ok := d.([]byte); ok {
+							r.dsts = append(r.dsts, utf16BE(b))
+						}
+					}
+				}
+				m.ranges = append(m.ranges, r)
+				m.widths[len(lo)] = true
+			}
+		}
+		operands = operands[:0]
+	}
+	return m
+}
+
+// decode maps a shown string through the CMap, trying code widths from
+// longest to shortest.
+func (m *cmap) decode(s []byte) string {
+	var b strings.Builder
+	for len(s) > 0 {
+		matched := false
+		for w := 4; w >= 1; w-- {
+			if !m.widths[w] || len(s) < w {
+				continue
+			}
+			if text, ok := m.lookup(s[:w]); ok {
+				b.WriteString(text)
+				s = s[w:]
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			// Skip an unmapped code of the narrowest known width.
+			w := 1
+			for i := 1; i <= 4; i++ {
+				if m.widths[i] {
+					w = i
+					break
+				}
+			}
+			if w > len(s) {
+				w = len(s)
+			}
+			s = s[w:]
+		}
+	}
+	return b.String()
+}
+
+func (m *cmap) lookup(code []byte) (string, bool) {
+	if text, ok := m.chars[string(code)]; ok {
+		return text, true
+	}
+	c := bytesToInt(code)
+	for _, r := range m.ranges {
+		if len(r.lo) != len(code) {
+			continue
+		}
+		lo, hi := bytesToInt(r.lo), bytesToInt(r.hi)
+		if c < lo || c > hi {
+			continue
+		}
+		offset := c - lo
+		if r.dsts != nil {
+			if offset < len(r.dsts) {
+				return r.dsts[offset], true
+			}
+			return "", true
+		}
+		dst := append([]byte(nil), r.dst...)
+		// Increment the last byte of the destination by the offset.
+		if len(dst) > 0 {
+			v := int(dst[len(dst)-1]) + offset
+			dst[len(dst)-1] = byte(v)
+			if v > 0xff && len(dst) > 1 {
+				dst[len(dst)-2] += byte(v >> 8)
+			}
+		}
+		return utf16BE(dst), true
+	}
+	return "", false
+}
+
+func bytesToInt(b []byte) int {
+	v := 0
+	for _, c := range b {
+		v = v<<8 | int(c)
+	}
+	return v
+}
+
+func utf16BE(b []byte) string {
+	units := make([]uint16, 0, len(b)/2)
+	for i := 0; i+1 < len(b); i += 2 {
+		units = append(units, uint16(b[i])<<8|uint16(b[i+1]))
+	}
+	if len(b)%2 == 1 {
+		units = append(units, uint16(b[len(b)-1]))
+	}
+	return string(utf16.Decode(units))
+}
+
+// winAnsi is the WinAnsiEncoding table, also used as the fallback for
+// fonts without an explicit encoding.
+var winAnsi = func() [256]rune {
+	var t [256]rune
+	for i := 0x20; i < 0x7f; i++ {
+		t[i] = rune(i)
+	}
+	for i := 0xa0; i <= 0xff; i++ {
+		t[i] = rune(i)
+	}
+	t['\t'], t['\n'], t['\r'] = '\t', '\n', '\r'
+	high := map[int]rune{
+		0x80: '€', 0x82: '‚', 0x83: 'ƒ', 0x84: '„', 0x85: '…', 0x86: '†',
+		0x87: '‡', 0x88: 'ˆ', 0x89: '‰', 0x8a: 'Š', 0x8b: '‹', 0x8c: 'Œ',
+		0x8e: 'Ž', 0x91: '‘', 0x92: '’', 0x93: '“', 0x94: '”', 0x95: '•',
+		0x96: '–', 0x97: '—', 0x98: '˜', 0x99: '™', 0x9a: 'š', 0x9b: '›',
+		0x9c: 'œ', 0x9e: 'ž', 0x9f: 'Ÿ',
+	}
+	for code, r := range high {
+		t[code] = r
+	}
+	return t
+}()
+
+// macRoman is the MacRomanEncoding table.
+var macRoman = func() [256]rune {
+	var t [256]rune
+	for i := 0x20; i < 0x7f; i++ {
+		t[i] = rune(i)
+	}
+	upper := []rune("ÄÅÇÉÑÖÜáàâäãåçéèêëíìîïñóòôöõúùûü†°¢£§•¶ß®©™´¨≠ÆØ∞±≤≥¥µ∂∑∏π∫ªºΩæø¿¡¬√ƒ≈∆«»… ÀÃÕŒœ–—“”‘’÷◊ÿŸ⁄€‹›ﬁﬂ‡·‚„‰ÂÊÁËÈÍÎÏÌÓÔÒÚÛÙıˆ˜¯˘˙˚¸˝˛ˇ")
+	for i, r := range upper {
+		if 0x80+i < 256 {
+			t[0x80+i] = r
+		}
+	}
+	return t
+}()
@@ -0,0 +1,491 @@
+// Package pdf implements a small, tolerant, pure-Go PDF reader that
+// extracts page text. It does not rely on the cross-reference table; objects
+// are located by scanning the file, which also copes with many damaged or
+// incrementally updated documents.
+package pdf
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+// name is a PDF name object such as /Type.
+type name string
+
+// keyword is a bare token: an operator in a content stream or a structural
+// keyword such as obj or stream.
+type keyword string
+
+// ref is an indirect object reference.
+type ref struct {
+	num, gen int
+}
+
+// dict is a PDF dictionary.
+type dict map[string]interface{}
+
+// array is a PDF array.
+type array []interface{}
+
+// stream is a stream object with its still-encoded data.
+type stream struct {
+	dict dict
+	data []byte
+}
+
+// errEOF is returned by the lexer at the end of input.
+var errEOF = errors.New("unexpected end of data")
+
+func isSpace(c byte) bool {
+	switch c {
+	case ' ', '\t', '\r', '\n', '\f', 0:
+		return true
+	}
+	return false
+}
+
+func isDelim(c byte) bool {
+	switch c {
+	case '(', ')', '<', '>', '[', ']', '{', '}', '/', '%':
+		return true
+	}
+	return false
+}
+
+// lexer reads PDF objects and tokens from a byte slice.
+type lexer struct {
+	data []byte
+	pos  int
+}
+
+func (l *lexer) eof() bool {
+	return l.pos >= len(l.data)
+}
+
+// skipSpace skips whitespace and comments.
+func (l *lexer) skipSpace() {
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		if isSpace(c) {
+			l.pos++
+			continue
+		}
+		if c == '%' {
+			for l.pos < len(l.data) && l.data[l.pos] != '\n' && l.data[l.pos] != '\r' {
+				l.pos++
+			}
+			continue
+		}
+		return
+	}
+}
+
+// regular reads a run of regular (non-space, non-delimiter) characters.
+func (l *lexer) regular() string {
+	start := l.pos
+	for l.pos < len(l.data) && !isSpace(l.data[l.pos]) && !isDelim(l.data[l.pos]) {
+		l.pos++
+	}
+	return string(l.data[start:l.pos])
+}
+
+// object reads the next object. Bare keywords are returned as keyword.
+func (l *lexer) object() (interface{}, error) {
+	l.skipSpace()
+	if l.eof() {
+		return nil, errEOF
+	}
+
+	c := l.data[l.pos]
+	switch {
+	case c == '/':
+		l.pos++
+		return name(decodeName(l.regular())), nil
+	case c == '(':
+		l.pos++
+		return l.literalString(), nil
+	case c == '<':
+		if l.pos+1 < len(l.data) && l.data[l.pos+1] == '<' {
+			l.pos += 2
+			return l.dictionary()
+		}
+		l.pos++
+		return l.hexString(), nil
+	case c == '[':
+		l.pos++
+		return l.array()
+	case c == ']' || c == '>' || c == ')' || c == '{' || c == '}':
+		l.pos++
+		if c == '>' && l.pos < len(l.data) && l.data[l.pos] == '>' {
+			l.pos++
+			return keyword(">>"), nil
+		}
+		return keyword(string(c)), nil
+	case c == '+' || c == '-' || c == '.' || (c >= '0' && c <= '9'):
+		return l.numberOrRef(), nil
+	default:
+		word := l.regular()
+		if word == "" {
+			l.pos++
+			return keyword(string(c)), nil
+		}
+		switch word {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		case "null":
+			return nil, nil
+		}
+		return keyword(word), nil
+	}
+}
+
+// numberOrRef reads a number, or an indirect reference "num gen R".
+func (l *lexer) numberOrRef() interface{} {
+	tok := l.regular()
+	n, err := strconv.ParseFloat(tok, 64)
+	if err != nil {
+		return keyword(tok)
+	}
+
+	// Look ahead for "gen R".
+	if isInteger(tok) {
+		save := l.pos
+		l.skipSpace()
+		genStart := l.pos
+		for l.pos < len(l.data) && l.data[l.pos] >= '0' && l.data[l.pos] <= '9' {
+			l.pos++
+		}
+		if l.pos > genStart {
+			gen, _ := strconv.Atoi(string(l.data[genStart:l.pos]))
+			l.skipSpace()
+			if l.pos < len(l.data) && l.data[l.pos] == 'R' && (l.pos+1 == len(l.data) || isSpace(l.data[l.pos+1]) || isDelim(l.data[l.pos+1])) {
+				l.pos++
+				return ref{num: int(n), gen: gen}
+			}
+		}
+		l.pos = save
+	}
+	return n
+}
+
+func isInteger(tok string) bool {
+	if tok == "" {
+		return false
+	}
+	for i := 0; i < len(tok); i++ {
+		if tok[i] < '0' || tok[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func (l *lexer) literalString() []byte {
+	var out []byte
+	depth := 1
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		l.pos++
+		switch c {
+		case '(':
+			depth++
+			out = append(out, c)
+		case ')':
+			depth--
+			if depth == 0 {
+				return out
+			}
+			out = append(out, c)
+		case '\\':
+			if l.pos >= len(l.data) {
+				return out
+			}
+			e := l.data[l.pos]
+			l.pos++
+			switch e {
+			case 'n':
+				out = append(out, '\n')
+			case 'r':
+				out = append(out, '\r')
+			case 't':
+				out = append(out, '\t')
+			case 'b':
+				out = append(out, '\b')
+			case 'f':
+				out = append(out, '\f')
+			case '\r':
+				if l.pos < len(l.data) && l.data[l.pos] == '\n' {
+					l.pos++
+				}
+			case '\n':
+			default:
+				if e >= '0' && e <= '7' {
+					v := int(e - '0')
+					for i := 0; i < 2 && l.pos < len(l.data) && l.data[l.pos] >= '0' && l.data[l.pos] <= '7'; i++ {
+						v = v*8 + int(l.data[l.pos]-'0')
+						l.pos++
+					}
+					out = append(out, byte(v))
+				} else {
+					out = append(out, e)
+				}
+			}
+		default:
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+func (l *lexer) hexString() []byte {
+	var digits []byte
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		l.pos++
+		if c == '>' {
+			break
+		}
+		if isHex(c) {
+			digits = append(digits, c)
+		}
+	}
+	if len(digits)%2 == 1 {
+		digits = append(digits, '0')
+	}
+	out := make([]byte, len(digits)/2)
+	for i := range out {
+		out[i] = unhex(digits[2*i])<<4 | unhex(digits[2*i+1])
+	}
+	return out
+}
+
+func isHex(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+func unhex(c byte) byte {
+	switch {
+	case c >= '0' && c <= '9':
+		return c - '0'
+	case c >= 'a' && c <= 'f':
+		return c - 'a' + 10
+	case c >= 'A' && c <= 'F':
+		return c - 'A' + 10
+	}
+	return 0
+}
+
+// decodeName resolves #xx escapes in a name.
+func decodeName(s string) string {
+	if !bytes.ContainsRune([]byte(s), '#') {
+		return s
+	}
+	var out []byte
+	for i := 0; i < len(s); i++ {
+		if s[i] == '#' && i+2 < len(s) && isHex(s[i+1]) && isHex(s[i+2]) {
+			out = append(out, unhex(s[i+1])<<4|unhex(s[i+2]))
+			i += 2
+			continue
+		}
+		out = append(out, s[i])
+	}
+	return string(out)
+}
+
+func (l *lexer) array() (array, error) {
+	var out array
+	for {
+		obj, err := l.object()
+		if err != nil {
+			return out, err
+		}
+		if kw, ok := obj.(keyword); ok && kw == "]" {
+			return out, nil
+		}
+		out = append(out, obj)
+	}
+}
+
+func (l *lexer) dictionary() (dict, error) {
+	out := make(dict)
+	for {
+		key, err := l.object()
+		if err != nil {
+			return out, err
+		}
+		if kw, ok := key.(keyword); ok && kw == ">>" {
+			return out, nil
+		}
+		k, ok := key.(name)
+		if !ok {
+			continue
+		}
+		value, err := l.object()
+		if err != nil {
+			return out, err
+		}
+		if kw, ok := value.(keyword); ok && kw == ">>" {
+			out[string(k)] = nil
+			return out, nil
+		}
+		out[string(k)] = value
+	}
+}
+
+// document holds every object found in a PDF file.
+type document struct {
+	objects map[int]interface{}
+}
+
+var objHeader = regexp.MustCompile(`(\d+)\s+(\d+)\s+obj\b`)
+
+// parse scans data for indirect objects and expands object streams.
+func parse(data []byte) (*document, error) {
+	if !bytes.Contains(data[:min(len(data), 1024)], []byte("%PDF-")) {
+		return nil, fmt.Errorf("not a PDF file")
+	}
+
+	doc := &document{objects: make(map[int]interface{})}
+
+	for _, m := range objHeader.FindAllSubmatchIndex(data, -1) {
+		num, err := strconv.Atoi(string(data[m[2]:m[3]]))
+		if err != nil {
+			continue
+		}
+		l := &lexer{data: data, pos: m[1]}
+		obj, err := l.object()
+		if err != nil {
+			continue
+		}
+
+		if d, ok := obj.(dict); ok {
+			save := l.pos
+			l.skipSpace()
+			if bytes.HasPrefix(data[l.pos:], []byte("stream")) {
+				obj = &stream{dict: d, data: streamData(data, l.pos+len("stream"), d)}
+			} else {
+				l.pos = save
+			}
+		}
+		// Later definitions win, matching incremental updates.
+		doc.objects[num] = obj
+	}
+
+	doc.expandObjectStreams()
+
+	if len(doc.objects) == 0 {
+		return nil, fmt.Errorf("no objects found")
+	}
+	return doc, nil
+}
+
+// streamData returns the raw bytes of a stream starting after the stream
+// keyword at pos.
+func streamData(data []byte, pos int, d dict) []byte {
+	if pos < len(data) && data[pos] == '\r' {
+		pos++
+	}
+	if pos < len(data) && data[pos] == '\n' {
+		pos++
+	}
+	if pos > len(data) {
+		return nil
+	}
+
+	if length, ok := d["Length"].(float64); ok {
+		end := pos + int(length)
+		if end <= len(data) && end >= pos {
+			rest := bytes.TrimLeft(data[end:min(len(data), end+32)], " \t\r\n")
+			if bytes.HasPrefix(rest, []byte("endstream")) {
+				return data[pos:end]
+			}
+		}
+	}
+
+	end := bytes.Index(data[pos:], []byte("endstream"))
+	if end < 0 {
+		return data[pos:]
+	}
+	return bytes.TrimRight(data[pos:pos+end], "\r\n")
+}
+
+// expandObjectStreams adds the objects packed inside /Type /ObjStm streams.
+func (doc *document) expandObjectStreams() {
+	var objStreams []*stream
+	for _, obj := range doc.objects {
+		if s, ok := obj.(*stream); ok && s.dict["Type"] == name("ObjStm") {
+			objStreams = append(objStreams, s)
+		}
+	}
+
+	for _, s := range objStreams {
+		data, err := doc.decode(s)
+		if err != nil {
+			continue
+		}
+		n, _ := s.dict["N"].(float64)
+		first, _ := s.dict["First"].(float64)
+		if first < 0 || int(first) > len(data) {
+			continue
+		}
+
+		header := &lexer{data: data[:int(first)]}
+		for i := 0; i < int(n); i++ {
+			numObj, err1 := header.object()
+			offObj, err2 := header.object()
+			if err1 != nil || err2 != nil {
+				break
+			}
+			num, ok1 := numObj.(float64)
+			off, ok2 := offObj.(float64)
+			if !ok1 || !ok2 {
+				break
+			}
+			if _, exists := doc.objects[int(num)]; exists {
+				continue
+			}
+			l := &lexer{data: data, pos: int(first) + int(off)}
+			if l.pos < 0 || l.pos >= len(data) {
+				continue
+			}
+			if obj, err := l.object(); err == nil {
+				doc.objects[int(num)] = obj
+			}
+		}
+	}
+}
+
+// resolve follows indirect references.
+func (doc *document) resolve(v interface{}) interface{} {
+	for i := 0; i < 32; i++ {
+		r, ok := v.(ref)
+		if !ok {
+			return v
+		}
+		v = doc.objects[r.num]
+	}
+	return nil
+}
+
+// dictOf resolves v and returns it as a dictionary, using the stream
+// dictionary for streams.
+func (doc *document) dictOf(v interface{}) dict {
+	switch o := doc.resolve(v).(type) {
+	case dict:
+		return o
+	case *stream:
+		return o.dict
+	}
+	return nil
+}
+
+// streamOf resolves v as a stream.
+func (doc *document) streamOf(v interface{}) *stream {
+	s, _ := doc.resolve(v).(*stream)
+	return s
+}
@@ -0,0 +1,184 @@
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// buildPDF assembles a PDF from object bodies numbered from 1. Object 1
+// must be the catalog.
+func buildPDF(objects []string) []byte {
+	var b bytes.Buffer
+	b.WriteString("%PDF-1.7\n")
+	offsets := make([]int, len(objects))
+	for i, obj := range objects {
+		offsets[i] = b.Len()
+		fmt.Fprintf(&b, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+	xref := b.Len()
+	fmt.Fprintf(&b, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&b, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&b, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return b.Bytes()
+}
+
+func streamObj(dict string, data []byte) string {
+	return fmt.Sprintf("<< %s /Length %d >>\nstream\n%s\nendstream", dict, len(data), data)
+}
+
+func deflate(data string) []byte {
+	var b bytes.Buffer
+	w := zlib.NewWriter(&b)
+	w.Write([]byte(data))
+	w.Close()
+	return b.Bytes()
+}
+
+func TestExtractPagesSimple(t *testing.T) {
+	page1 := "BT /F1 12 Tf 72 720 Td (Hello, World!) Tj 0 -14 Td [(Second) -300 (line)] TJ ET"
+	page2 := "BT /F1 12 Tf 72 720 Td (Caf\\351 \\(menu\\)) Tj ET"
+
+	data := buildPDF([]string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R 4 0 R] /Count 2 /Resources << /Font << /F1 5 0 R >> >> >>",
+		"<< /Type /Page /Parent 2 0 R /Contents 6 0 R >>",
+		"<< /Type /Page /Parent 2 0 R /Contents 7 0 R >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>",
+		streamObj("", []byte(page1)),
+		streamObj("/Filter /FlateDecode", deflate(page2)),
+	})
+
+	pages, err := ExtractPages(data)
+	if err != nil {
+		t.Fatalf("ExtractPages failed: %v", err)
+	}
+	if len(pages) != 2 {
+		t.Fatalf("Expected 2 pages, got %d", len(pages))
+	}
+	if pages[0] != "Hello, World!\nSecond line" {
+		t.Errorf("Unexpected page 1 text: %q", pages[0])
+	}
+	if pages[1] != "Café (menu)" {
+		t.Errorf("Unexpected page 2 text: %q", pages[1])
+	}
+}
+
+func TestExtractPagesToUnicode(t *testing.T) {
+	cmap := `/CIDInit /ProcSet findresource begin
+begincmap
+1 begincodespacerange <0000> <FFFF> endcodespacerange
+2 beginbfchar
+<0001> <0048>
+<0002> <0069>
+endbfchar
+1 beginbfrange
+<0010> <0012> <0061>
+endbfrange
+endcmap`
+	content := "BT /F1 10 Tf <00010002> Tj 0 -12 Td <001000110012> Tj ET"
+
+	data := buildPDF([]string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /Resources << /Font << /F1 4 0 R >> >> /Contents 5 0 R >>",
+		"<< /Type /Font /Subtype /Type0 /Encoding /Identity-H /ToUnicode 6 0 R >>",
+		streamObj("", []byte(content)),
+		streamObj("/Filter /FlateDecode", deflate(cmap)),
+	})
+
+	pages, err := ExtractPages(data)
+	if err != nil {
+		t.Fatalf("ExtractPages failed: %v", err)
+	}
+	if len(pages) != 1 || pages[0] != "Hi\nabc" {
+		t.Errorf("Unexpected pages: %q", pages)
+	}
+}
+
+func TestExtractPagesObjectStream(t *testing.T) {
+	// Objects 1-3 live inside the object stream (object 5).
+	packed := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /Contents 4 0 R >>",
+	}
+	var header, body strings.Builder
+	for i, obj := range packed {
+		fmt.Fprintf(&header, "%d %d ", i+1, body.Len())
+		body.WriteString(obj + "\n")
+	}
+	objStm := header.String() + body.String()
+
+	var b bytes.Buffer
+	b.WriteString("%PDF-1.5\n")
+	content := []byte("BT (Packed text) Tj ET")
+	fmt.Fprintf(&b, "4 0 obj\n%s\nendobj\n", streamObj("", content))
+	fmt.Fprintf(&b, "5 0 obj\n%s\nendobj\n", streamObj(fmt.Sprintf("/Type /ObjStm /N 3 /First %d /Filter /FlateDecode", header.Len()), deflate(objStm)))
+	b.WriteString("%%EOF\n")
+
+	pages, err := ExtractPages(b.Bytes())
+	if err != nil {
+		t.Fatalf("ExtractPages failed: %v", err)
+	}
+	if len(pages) != 1 || pages[0] != "Packed text" {
+		t.Errorf("Unexpected pages: %q", pages)
+	}
+}
+
+func TestExtractPagesMalformedObjectStream(t *testing.T) {
+	// A negative /First and a negative object offset must not panic; the
+	// page objects outside the object stream are still found.
+	for _, tt := range []struct{ name, header, first string }{
+		{"negative first", "9 0 ", "-5"},
+		{"negative offset", "9 -100 ", "7"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var b bytes.Buffer
+			b.WriteString("%PDF-1.5\n")
+			fmt.Fprintf(&b, "1 0 obj\n<< /Type /Catalog /Pages 2 0 R >>\nendobj\n")
+			fmt.Fprintf(&b, "2 0 obj\n<< /Type /Pages /Kids [3 0 R] /Count 1 >>\nendobj\n")
+			fmt.Fprintf(&b, "3 0 obj\n<< /Type /Page /Parent 2 0 R /Contents 4 0 R >>\nendobj\n")
+			fmt.Fprintf(&b, "4 0 obj\n%s\nendobj\n", streamObj("", []byte("BT (Still here) Tj ET")))
+			objStm := tt.header + "<< /Foo 1 >>\n"
+			fmt.Fprintf(&b, "5 0 obj\n%s\nendobj\n", streamObj("/Type /ObjStm /N 1 /First "+tt.first, []byte(objStm)))
+			b.WriteString("%%EOF\n")
+
+			pages, err := ExtractPages(b.Bytes())
+			if err != nil {
+				t.Fatalf("ExtractPages failed: %v", err)
+			}
+			if len(pages) != 1 || pages[0] != "Still here" {
+				t.Errorf("Unexpected pages: %q", pages)
+			}
+		})
+	}
+}
+
+func TestExtractPagesFormXObject(t *testing.T) {
+	data := buildPDF([]string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /Resources << /XObject << /X1 5 0 R >> >> /Contents 4 0 R >>",
+		streamObj("", []byte("BT (Body) Tj ET q /X1 Do Q BI /W 1 /H 1 ID \x00\xffEI EI BT (After) Tj ET")),
+		streamObj("/Type /XObject /Subtype /Form", []byte("BT 1 0 0 1 0 0 Tm (Footer) Tj ET")),
+	})
+
+	pages, err := ExtractPages(data)
+	if err != nil {
+		t.Fatalf("ExtractPages failed: %v", err)
+	}
+	if len(pages) != 1 || pages[0] != "Body\nFooter\nAfter" {
+		t.Errorf("Unexpected pages: %q", pages)
+	}
+}
+
+func TestExtractPagesNotPDF(t *testing.T) {
+	if _, err := ExtractPages([]byte("just some text")); err == nil {
+		t.Error("Expected error for non-PDF input")
+	}
+}
@@ -0,0 +1,309 @@
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// maxFormDepth bounds recursion into nested form XObjects.
+const maxFormDepth = 5
+
+// ExtractPages returns the text of each page of the PDF in data, in page
+// order. Pages without extractable text are returned as empty strings.
+// Documents that trip up the parser are reported as errors rather than
+// panics.
+func ExtractPages(data []byte) (texts []string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			texts, err = nil, fmt.Errorf("malformed PDF: %v", r)
+		}
+	}()
+
+	doc, err := parse(data)
+	if err != nil {
+		return nil, err
+	}
+
+	pages := doc.pages()
+	if len(pages) == 0 {
+		return nil, fmt.Errorf("no pages found")
+	}
+
+	texts = make([]string, len(pages))
+	for i, p := range pages {
+		texts[i] = doc.pageText(p)
+	}
+	return texts, nil
+}
+
+// page is a page dictionary with its inherited resources.
+type page struct {
+	dict      dict
+	resources dict
+}
+
+// pages walks the page tree from the document catalog. Documents without a
+// usable catalog fall back to every page object in object-number order.
+func (doc *document) pages() []page {
+	nums := make([]int, 0, len(doc.objects))
+	for num := range doc.objects {
+		nums = append(nums, num)
+	}
+	sort.Ints(nums)
+
+	var out []page
+	visited := make(map[interface{}]bool)
+	var walk func(v interface{}, resources dict, depth int)
+	walk = func(v interface{}, resources dict, depth int) {
+		if depth > 64 {
+			return
+		}
+		if r, ok := v.(ref); ok {
+			if visited[r] {
+				return
+			}
+			visited[r] = true
+		}
+		d := doc.dictOf(v)
+		if d == nil {
+			return
+		}
+		if res := doc.dictOf(d["Resources"]); res != nil {
+			resources = res
+		}
+		kids, isTree := doc.resolve(d["Kids"]).(array)
+		if isTree || doc.resolve(d["Type"]) == name("Pages") {
+			for _, kid := range kids {
+				walk(kid, resources, depth+1)
+			}
+			return
+		}
+		out = append(out, page{dict: d, resources: resources})
+	}
+
+	// Use the last catalog, which belongs to the newest incremental update.
+	for i := len(nums) - 1; i >= 0; i-- {
+		d := doc.dictOf(doc.objects[nums[i]])
+		if d != nil && doc.resolve(d["Type"]) == name("Catalog") {
+			walk(d["Pages"], nil, 0)
+			break
+		}
+	}
+	if len(out) > 0 {
+		return out
+	}
+
+	for _, num := range nums {
+		d, ok := doc.objects[num].(dict)
+		if ok && doc.resolve(d["Type"]) == name("Page") {
+			out = append(out, page{dict: d, resources: doc.dictOf(d["Resources"])})
+		}
+	}
+	return out
+}
+
+// pageText interprets the content streams of a page.
+func (doc *document) pageText(p page) string {
+	var content []byte
+	switch c := doc.resolve(p.dict["Contents"]).(type) {
+	case *stream:
+		content, _ = doc.decode(c)
+	case array:
+		for _, part := range c {
+			if s := doc.streamOf(part); s != nil {
+				if data, err := doc.decode(s); err == nil {
+					content = append(content, data...)
+					content = append(content, '\n')
+				}
+			}
+		}
+	}
+
+	w := &textWriter{}
+	doc.interpret(content, p.resources, w, 0)
+	return w.String()
+}
+
+// textWriter accumulates extracted text, inserting separators lazily so
+// that runs of positioning operators do not produce blank output.
+type textWriter struct {
+	b       strings.Builder
+	pending string
+}
+
+func (w *textWriter) write(s string) {
+	if s == "" {
+		return
+	}
+	if w.b.Len() > 0 && w.pending != "" {
+		w.b.WriteString(w.pending)
+	}
+	w.pending = ""
+	w.b.WriteString(s)
+}
+
+func (w *textWriter) newline() {
+	w.pending = "\n"
+}
+
+func (w *textWriter) space() {
+	if w.pending == "" && !strings.HasSuffix(w.b.String(), " ") {
+		w.pending = " "
+	}
+}
+
+func (w *textWriter) String() string {
+	lines := strings.Split(w.b.String(), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t")
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
+// interpret runs the text operators of a content stream.
+func (doc *document) interpret(content []byte, resources dict, w *textWriter, depth int) {
+	fonts := doc.dictOf(resources["Font"])
+	xobjects := doc.dictOf(resources["XObject"])
+	loaded := make(map[string]*font)
+
+	var current *font
+	var lineY float64
+	var haveLine bool
+	var operands []interface{}
+
+	l := &lexer{data: content}
+	for {
+		obj, err := l.object()
+		if err != nil {
+			return
+		}
+		op, ok := obj.(keyword)
+		if !ok {
+			operands = append(operands, obj)
+			continue
+		}
+
+		switch op {
+		case "BT":
+			haveLine = false
+		case "Tf":
+			if len(operands) >= 2 {
+				if n, ok := operands[len(operands)-2].(name); ok {
+					f, seen := loaded[string(n)]
+					if !seen && fonts != nil {
+						f = doc.loadFont(fonts[string(n)])
+						loaded[string(n)] = f
+					}
+					current = f
+				}
+			}
+		case "Tj":
+			if s, ok := lastString(operands); ok {
+				w.write(current.decode(s))
+			}
+		case "'":
+			w.newline()
+			if s, ok := lastString(operands); ok {
+				w.write(current.decode(s))
+			}
+		case "\"":
+			w.newline()
+			if s, ok := lastString(operands); ok {
+				w.write(current.decode(s))
+			}
+		case "TJ":
+			if len(operands) > 0 {
+				if items, ok := operands[len(operands)-1].(array); ok {
+					for _, item := range items {
+						switch x := item.(type) {
+						case []byte:
+							w.write(current.decode(x))
+						case float64:
+							// Large negative adjustments separate words.
+							if x < -200 {
+								w.space()
+							}
+						}
+					}
+				}
+			}
+		case "Td", "TD":
+			if len(operands) >= 2 {
+				tx, _ := operands[len(operands)-2].(float64)
+				ty, _ := operands[len(operands)-1].(float64)
+				if ty != 0 {
+					w.newline()
+				} else if tx != 0 {
+					w.space()
+				}
+			}
+		case "T*":
+			w.newline()
+		case "Tm":
+			if len(operands) >= 6 {
+				y, _ := operands[len(operands)-1].(float64)
+				if haveLine && y != lineY {
+					w.newline()
+				} else if haveLine {
+					w.space()
+				}
+				lineY, haveLine = y, true
+			}
+		case "ET":
+			w.space()
+		case "Do":
+			if depth >= maxFormDepth || xobjects == nil || len(operands) == 0 {
+				break
+			}
+			n, ok := operands[len(operands)-1].(name)
+			if !ok {
+				break
+			}
+			form := doc.streamOf(xobjects[string(n)])
+			if form == nil || doc.resolve(form.dict["Subtype"]) != name("Form") {
+				break
+			}
+			data, err := doc.decode(form)
+			if err != nil {
+				break
+			}
+			formResources := doc.dictOf(form.dict["Resources"])
+			if formResources == nil {
+				formResources = resources
+			}
+			w.newline()
+			doc.interpret(data, formResources, w, depth+1)
+			w.newline()
+		case "ID":
+			// Skip inline image data up to the EI operator.
+			end := bytes.Index(content[l.pos:], []byte("EI"))
+			for end >= 0 {
+				at := l.pos + end
+				if (at == 0 || isSpace(content[at-1])) && (at+2 == len(content) || isSpace(content[at+2])) {
+					l.pos = at + 2
+					break
+				}
+				next := bytes.Index(content[at+2:], []byte("EI"))
+				if next < 0 {
+					end = -1
+					break
+				}
+				end += 2 + next
+			}
+			if end < 0 {
+				return
+			}
+		}
+		operands = operands[:0]
+	}
+}
+
+func lastString(operands []interface{}) ([]byte, bool) {
+	if len(operands) == 0 {
+		return nil, false
+	}
+	s, ok := operands[len(operands)-1].([]byte)
+	return s, ok
+}
@@ -0,0 +1,106 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// waiter is a request queued on a gate.
+type waiter struct {
+	ready   chan struct{}
+	granted bool
+}
+
+// gate is a counting semaphore with a bounded wait queue. Interactive
+// waiters are always admitted before batch waiters; within a class the
+// queue is FIFO.
+type gate struct {
+	mu       sync.Mutex
+	limit    int
+	inFlight int
+	queue    [numPriorities][]*waiter
+}
+
+func (g *gate) queued() int {
+	n := 0
+	for _, q := range g.queue {
+		n += len(q)
+	}
+	return n
+}
+
+// acquire takes a slot, waiting up to timeout in a queue of at most
+// maxQueue requests. limit updates the gate's capacity; 0 means unlimited.
+func (g *gate) acquire(ctx context.Context, limit int, p Priority, maxQueue int, timeout time.Duration) error {
+	g.mu.Lock()
+	g.limit = limit
+	if limit <= 0 || (g.inFlight < limit && g.queued() == 0) {
+		g.inFlight++
+		g.mu.Unlock()
+		return nil
+	}
+	if g.queued() >= maxQueue {
+		g.mu.Unlock()
+		return errQueueFull
+	}
+	w := &waiter{ready: make(chan struct{})}
+	g.queue[p] = append(g.queue[p], w)
+	g.mu.Unlock()
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	var err error
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+		err = ctx.Err()
+	case <-expired:
+		err = errQueueTimeout
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if w.granted {
+		// The slot was handed over while giving up; pass it on.
+		g.releaseLocked()
+		return err
+	}
+	q := g.queue[p]
+	for i := range q {
+		if q[i] == w {
+			g.queue[p] = append(q[:i], q[i+1:]...)
+			break
+		}
+	}
+	return err
+}
+
+// release frees a slot, handing it to the next queued waiter.
+func (g *gate) release() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.releaseLocked()
+}
+
+func (g *gate) releaseLocked() {
+	if g.limit <= 0 || g.inFlight <= g.limit {
+		for p := range g.queue {
+			if len(g.queue[p]) == 0 {
+				continue
+			}
+			w := g.queue[p][0]
+			g.queue[p] = g.queue[p][1:]
+			w.granted = true
+			close(w.ready)
+			return
+		}
+	}
+	g.inFlight--
+}
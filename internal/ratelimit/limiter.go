@@ -0,0 +1,323 @@
+// Package ratelimit provides per-key token buckets and per-key and
+// per-model concurrency limits with a bounded priority wait queue.
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rahulvramesh/gh-proxy-local/internal/apikeys"
+	"github.com/rahulvramesh/gh-proxy-local/internal/config"
+	"github.com/rahulvramesh/gh-proxy-local/internal/models"
+)
+
+// Priority is the scheduling class of a request waiting for capacity.
+type Priority int
+
+const (
+	// Interactive requests are admitted before batch requests.
+	Interactive Priority = iota
+	// Batch requests only take slots no interactive request is waiting for.
+	Batch
+
+	numPriorities = 2
+)
+
+var (
+	errQueueFull    = errors.New("wait queue is full")
+	errQueueTimeout = errors.New("timed out waiting for capacity")
+)
+
+type priorityKey struct{}
+
+// WithPriority returns a context scheduling requests made with it at p.
+func WithPriority(ctx context.Context, p Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, p)
+}
+
+// PriorityFromContext returns the priority set by WithPriority, defaulting
+// to Interactive.
+func PriorityFromContext(ctx context.Context) Priority {
+	if p, ok := ctx.Value(priorityKey{}).(Priority); ok && p >= 0 && p < numPriorities {
+		return p
+	}
+	return Interactive
+}
+
+// Error is returned when a request is rejected by a limit.
+type Error struct {
+	Message    string
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// SetRetryAfter writes a Retry-After header, in whole seconds, when err is
+// a limit rejection.
+func SetRetryAfter(h http.Header, err error) {
+	var limitErr *Error
+	if !errors.As(err, &limitErr) {
+		return
+	}
+	seconds := int(math.Ceil(limitErr.RetryAfter.Seconds()))
+	h.Set("Retry-After", strconv.Itoa(max(seconds, 1)))
+}
+
+// Limits are the effective limits for one API key; 0 means unlimited.
+type Limits struct {
+	RequestsPerMinute int
+	TokensPerMinute   int
+	MaxInFlight       int
+}
+
+// Status describes a key's buckets after a request was counted.
+type Status struct {
+	RequestLimit      int
+	RequestsRemaining int
+	RequestsReset     time.Duration
+	TokenLimit        int
+	TokensRemaining   int
+	TokensReset       time.Duration
+}
+
+// SetHeaders writes OpenAI-style x-ratelimit-* and Anthropic-style
+// anthropic-ratelimit-* headers for the configured limits.
+func (s Status) SetHeaders(h http.Header) {
+	now := time.Now()
+	if s.RequestLimit > 0 {
+		h.Set("x-ratelimit-limit-requests", strconv.Itoa(s.RequestLimit))
+		h.Set("x-ratelimit-remaining-requests", strconv.Itoa(s.RequestsRemaining))
+		h.Set("x-ratelimit-reset-requests", formatReset(s.RequestsReset))
+		h.Set("anthropic-ratelimit-requests-limit", strconv.Itoa(s.RequestLimit))
+		h.Set("anthropic-ratelimit-requests-remaining", strconv.Itoa(s.RequestsRemaining))
+		h.Set("anthropic-ratelimit-requests-reset", now.Add(s.RequestsReset).UTC().Format(time.RFC3339))
+	}
+	if s.TokenLimit > 0 {
+		h.Set("x-ratelimit-limit-tokens", strconv.Itoa(s.TokenLimit))
+		h.Set("x-ratelimit-remaining-tokens", strconv.Itoa(s.TokensRemaining))
+		h.Set("x-ratelimit-reset-tokens", formatReset(s.TokensReset))
+		h.Set("anthropic-ratelimit-tokens-limit", strconv.Itoa(s.TokenLimit))
+		h.Set("anthropic-ratelimit-tokens-remaining", strconv.Itoa(s.TokensRemaining))
+		h.Set("anthropic-ratelimit-tokens-reset", now.Add(s.TokensReset).UTC().Format(time.RFC3339))
+	}
+}
+
+// formatReset renders a reset duration the way OpenAI does, e.g. "1s" or
+// "6m0s", rounded to whole milliseconds.
+func formatReset(d time.Duration) string {
+	if d <= 0 {
+		return "0s"
+	}
+	return d.Round(time.Millisecond).String()
+}
+
+// bucket is a token bucket holding up to capacity tokens, refilled
+// continuously at capacity per minute. It may go negative when actual
+// usage is debited after the fact.
+type bucket struct {
+	capacity float64
+	tokens   float64
+	last     time.Time
+}
+
+func (b *bucket) refill(capacity int, now time.Time) {
+	if b.capacity != float64(capacity) {
+		// The limit changed; keep the fraction already used.
+		if b.capacity > 0 {
+			b.tokens = b.tokens / b.capacity * float64(capacity)
+		} else {
+			b.tokens = float64(capacity)
+		}
+		b.capacity = float64(capacity)
+	}
+	b.tokens = math.Min(b.capacity, b.tokens+now.Sub(b.last).Minutes()*b.capacity)
+	b.last = now
+}
+
+// until returns how long until the bucket holds n tokens.
+func (b *bucket) until(n float64) time.Duration {
+	if b.tokens >= n || b.capacity == 0 {
+		return 0
+	}
+	return time.Duration((n - b.tokens) / b.capacity * float64(time.Minute))
+}
+
+// Limiter enforces rate and concurrency limits.
+type Limiter struct {
+	cfg atomic.Pointer[config.RateLimitConfig]
+
+	mu         sync.Mutex
+	requests   map[string]*bucket
+	tokens     map[string]*bucket
+	keyGates   map[string]*gate
+	modelGates map[string]*gate
+}
+
+// NewLimiter creates a limiter with the configured defaults.
+func NewLimiter(cfg config.RateLimitConfig) *Limiter {
+	l := &Limiter{
+		requests:   make(map[string]*bucket),
+		tokens:     make(map[string]*bucket),
+		keyGates:   make(map[string]*gate),
+		modelGates: make(map[string]*gate),
+	}
+	l.cfg.Store(&cfg)
+	return l
+}
+
+// SetConfig replaces the configured defaults. Buckets keep the fraction
+// of their limit already used, and requests already waiting keep the
+// previous queue settings.
+func (l *Limiter) SetConfig(cfg config.RateLimitConfig) {
+	l.cfg.Store(&cfg)
+}
+
+// Limits returns the limits for key: its own overrides, falling back to the
+// configured defaults.
+func (l *Limiter) Limits(key *apikeys.Key) Limits {
+	cfg := l.cfg.Load()
+	limits := Limits{
+		RequestsPerMinute: cfg.RequestsPerMinute,
+		TokensPerMinute:   cfg.TokensPerMinute,
+		MaxInFlight:       cfg.MaxInFlightPerKey,
+	}
+	if key == nil {
+		return limits
+	}
+	if key.RequestsPerMinute != 0 {
+		limits.RequestsPerMinute = key.RequestsPerMinute
+	}
+	if key.TokensPerMinute != 0 {
+		limits.TokensPerMinute = key.TokensPerMinute
+	}
+	if key.MaxInFlight != 0 {
+		limits.MaxInFlight = key.MaxInFlight
+	}
+	// Negative overrides lift the default limit for the key.
+	limits.RequestsPerMinute = max(limits.RequestsPerMinute, 0)
+	limits.TokensPerMinute = max(limits.TokensPerMinute, 0)
+	limits.MaxInFlight = max(limits.MaxInFlight, 0)
+	return limits
+}
+
+// Allow counts a request against the key's request bucket. It is rejected
+// when either the request bucket is empty or the token bucket has been
+// exhausted by earlier requests.
+func (l *Limiter) Allow(key string, limits Limits) (Status, error) {
+	now := time.Now()
+	status := Status{RequestLimit: limits.RequestsPerMinute, TokenLimit: limits.TokensPerMinute}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var reqs, toks *bucket
+	if limits.RequestsPerMinute > 0 {
+		reqs = l.bucket(l.requests, key, limits.RequestsPerMinute, now)
+		if reqs.tokens < 1 {
+			return status, &Error{
+				Message:    fmt.Sprintf("Rate limit exceeded for API key %q: %d requests per minute", key, limits.RequestsPerMinute),
+				RetryAfter: reqs.until(1),
+			}
+		}
+	}
+	if limits.TokensPerMinute > 0 {
+		toks = l.bucket(l.tokens, key, limits.TokensPerMinute, now)
+		if toks.tokens < 1 {
+			return status, &Error{
+				Message:    fmt.Sprintf("Rate limit exceeded for API key %q: %d tokens per minute", key, limits.TokensPerMinute),
+				RetryAfter: toks.until(1),
+			}
+		}
+		status.TokensRemaining = int(toks.tokens)
+		status.TokensReset = toks.until(toks.capacity)
+	}
+	if reqs != nil {
+		reqs.tokens--
+		status.RequestsRemaining = int(reqs.tokens)
+		status.RequestsReset = reqs.until(reqs.capacity)
+	}
+	return status, nil
+}
+
+// bucket returns the refilled bucket for key, creating a full one.
+func (l *Limiter) bucket(buckets map[string]*bucket, key string, capacity int, now time.Time) *bucket {
+	b, ok := buckets[key]
+	if !ok {
+		b = &bucket{capacity: float64(capacity), tokens: float64(capacity), last: now}
+		buckets[key] = b
+	}
+	b.refill(capacity, now)
+	return b
+}
+
+// AcquireKey takes one of the key's concurrency slots, queueing if all are
+// in use. The returned function releases the slot.
+func (l *Limiter) AcquireKey(ctx context.Context, key string, maxInFlight int) (func(), error) {
+	cfg := l.cfg.Load()
+	g := l.gate(l.keyGates, key)
+	if err := g.acquire(ctx, maxInFlight, PriorityFromContext(ctx), cfg.QueueSize, cfg.QueueTimeout); err != nil {
+		return nil, l.queueError(err, fmt.Sprintf("API key %q", key), maxInFlight)
+	}
+	return g.release, nil
+}
+
+// Acquire takes one of the upstream model's concurrency slots, queueing if
+// all are in use. The returned function releases the slot.
+func (l *Limiter) Acquire(ctx context.Context, model string) (func(), error) {
+	cfg := l.cfg.Load()
+	if cfg.MaxInFlightPerModel <= 0 {
+		return func() {}, nil
+	}
+	g := l.gate(l.modelGates, model)
+	if err := g.acquire(ctx, cfg.MaxInFlightPerModel, PriorityFromContext(ctx), cfg.QueueSize, cfg.QueueTimeout); err != nil {
+		return nil, l.queueError(err, fmt.Sprintf("model %q", model), cfg.MaxInFlightPerModel)
+	}
+	return g.release, nil
+}
+
+func (l *Limiter) gate(gates map[string]*gate, name string) *gate {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	g, ok := gates[name]
+	if !ok {
+		g = &gate{}
+		gates[name] = g
+	}
+	return g
+}
+
+func (l *Limiter) queueError(err error, subject string, limit int) error {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	return &Error{
+		Message:    fmt.Sprintf("Too many concurrent requests for %s (limit %d): %v", subject, limit, err),
+		RetryAfter: time.Second,
+	}
+}
+
+// ObserveTokens debits the token usage reported by the upstream from the
+// request key's token bucket.
+func (l *Limiter) ObserveTokens(ctx context.Context, model string, usage models.Usage) {
+	key := apikeys.NameFromContext(ctx)
+	used := usage.TotalTokens
+	if used == 0 {
+		used = usage.PromptTokens + usage.CompletionTokens
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if b, ok := l.tokens[key]; ok {
+		b.refill(int(b.capacity), time.Now())
+		b.tokens -= float64(used)
+	}
+}
@@ -0,0 +1,173 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/rahulvramesh/gh-proxy-local/internal/apikeys"
+	"github.com/rahulvramesh/gh-proxy-local/internal/config"
+	"github.com/rahulvramesh/gh-proxy-local/internal/models"
+)
+
+func TestAllowRequestsPerMinute(t *testing.T) {
+	l := NewLimiter(config.RateLimitConfig{RequestsPerMinute: 2})
+	limits := l.Limits(nil)
+
+	for i := 0; i < 2; i++ {
+		if _, err := l.Allow("ci", limits); err != nil {
+			t.Fatalf("request %d rejected: %v", i, err)
+		}
+	}
+	status, err := l.Allow("ci", limits)
+	var limitErr *Error
+	if !errors.As(err, &limitErr) {
+		t.Fatalf("expected *Error, got %v", err)
+	}
+	if limitErr.RetryAfter <= 0 || limitErr.RetryAfter > 30*time.Second {
+		t.Errorf("RetryAfter = %v", limitErr.RetryAfter)
+	}
+	if status.RequestLimit != 2 {
+		t.Errorf("RequestLimit = %d", status.RequestLimit)
+	}
+
+	// Buckets are per key
+	if _, err := l.Allow("other", limits); err != nil {
+		t.Errorf("other key rejected: %v", err)
+	}
+}
+
+func TestTokensPerMinute(t *testing.T) {
+	l := NewLimiter(config.RateLimitConfig{TokensPerMinute: 1000})
+	limits := l.Limits(nil)
+	ctx := apikeys.WithKey(context.Background(), &apikeys.Key{Name: "ci"})
+
+	status, err := l.Allow("ci", limits)
+	if err != nil || status.TokensRemaining != 1000 {
+		t.Fatalf("Allow = %+v, %v", status, err)
+	}
+
+	l.ObserveTokens(ctx, "gpt-4.1", models.Usage{PromptTokens: 900, CompletionTokens: 200})
+	if _, err := l.Allow("ci", limits); err == nil {
+		t.Error("expected rejection once the token bucket is exhausted")
+	}
+}
+
+func TestKeyOverrides(t *testing.T) {
+	l := NewLimiter(config.RateLimitConfig{RequestsPerMinute: 10, TokensPerMinute: 100, MaxInFlightPerKey: 2})
+	got := l.Limits(&apikeys.Key{RequestsPerMinute: 5, TokensPerMinute: -1})
+	want := Limits{RequestsPerMinute: 5, TokensPerMinute: 0, MaxInFlight: 2}
+	if got != want {
+		t.Errorf("Limits = %+v, want %+v", got, want)
+	}
+}
+
+func TestAcquirePriority(t *testing.T) {
+	l := NewLimiter(config.RateLimitConfig{MaxInFlightPerModel: 1, QueueSize: 10})
+	ctx := context.Background()
+
+	release, err := l.Acquire(ctx, "gpt-4.1")
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+
+	order := make(chan string, 2)
+	waitQueued := func(n int) {
+		g := l.gate(l.modelGates, "gpt-4.1")
+		for {
+			g.mu.Lock()
+			queued := g.queued()
+			g.mu.Unlock()
+			if queued == n {
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	acquire := func(name string, p Priority) {
+		release, err := l.Acquire(WithPriority(ctx, p), "gpt-4.1")
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			return
+		}
+		order <- name
+		release()
+	}
+
+	go acquire("batch", Batch)
+	waitQueued(1)
+	go acquire("interactive", Interactive)
+	waitQueued(2)
+
+	release()
+	if first, second := <-order, <-order; first != "interactive" || second != "batch" {
+		t.Errorf("admission order = %s, %s", first, second)
+	}
+}
+
+func TestAcquireQueueLimits(t *testing.T) {
+	l := NewLimiter(config.RateLimitConfig{QueueSize: 1, QueueTimeout: 20 * time.Millisecond})
+	ctx := context.Background()
+
+	release, err := l.AcquireKey(ctx, "ci", 1)
+	if err != nil {
+		t.Fatalf("AcquireKey failed: %v", err)
+	}
+	defer release()
+
+	done := make(chan error)
+	go func() {
+		_, err := l.AcquireKey(ctx, "ci", 1)
+		done <- err
+	}()
+	time.Sleep(5 * time.Millisecond)
+
+	if _, err := l.AcquireKey(ctx, "ci", 1); !isLimitError(err) {
+		t.Errorf("expected queue full, got %v", err)
+	}
+	if err := <-done; !isLimitError(err) {
+		t.Errorf("expected queue timeout, got %v", err)
+	}
+
+	// Unlimited keys are never queued
+	for i := 0; i < 3; i++ {
+		if _, err := l.AcquireKey(ctx, "open", 0); err != nil {
+			t.Fatalf("unlimited AcquireKey failed: %v", err)
+		}
+	}
+}
+
+func isLimitError(err error) bool {
+	var limitErr *Error
+	return errors.As(err, &limitErr)
+}
+
+func TestSetHeaders(t *testing.T) {
+	h := make(http.Header)
+	Status{RequestLimit: 60, RequestsRemaining: 59, RequestsReset: time.Second, TokenLimit: 1000, TokensRemaining: 1000}.SetHeaders(h)
+
+	for name, want := range map[string]string{
+		"x-ratelimit-limit-requests":             "60",
+		"x-ratelimit-remaining-requests":         "59",
+		"x-ratelimit-reset-requests":             "1s",
+		"x-ratelimit-remaining-tokens":           "1000",
+		"anthropic-ratelimit-requests-limit":     "60",
+		"anthropic-ratelimit-requests-remaining": "59",
+		"anthropic-ratelimit-tokens-limit":       "1000",
+	} {
+		if got := h.Get(name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+	if _, err := time.Parse(time.RFC3339, h.Get("anthropic-ratelimit-requests-reset")); err != nil {
+		t.Errorf("anthropic-ratelimit-requests-reset: %v", err)
+	}
+
+	h = make(http.Header)
+	SetRetryAfter(h, &Error{RetryAfter: 1500 * time.Millisecond})
+	if got := h.Get("Retry-After"); got != "2" {
+		t.Errorf("Retry-After = %q", got)
+	}
+}
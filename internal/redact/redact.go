@@ -0,0 +1,191 @@
+// Package redact removes personal data and secrets from payloads before
+// they are exported to telemetry sinks.
+package redact
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/rahulvramesh/gh-proxy-local/internal/config"
+)
+
+// Modes of a Redactor.
+const (
+	// ModeRedact replaces detected values with a placeholder.
+	ModeRedact = "redact"
+	// ModeHash replaces each payload with a hash of its content.
+	ModeHash = "hash"
+	// ModeTruncate redacts detected values, then cuts each payload to a
+	// fixed length.
+	ModeTruncate = "truncate"
+)
+
+// detector finds one kind of sensitive value.
+type detector struct {
+	name string
+	re   *regexp.Regexp
+	// valid filters matches, when set.
+	valid func(match string) bool
+}
+
+// builtins are the detectors that can be enabled by name.
+var builtins = []detector{
+	{name: "email", re: regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)},
+	{name: "jwt", re: regexp.MustCompile(`\beyJ[A-Za-z0-9_-]+\.eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+`)},
+	{name: "aws", re: regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b|(?i:aws_secret_access_key)["']?\s*[:=]\s*["']?[A-Za-z0-9/+=]{40}`)},
+	{name: "github", re: regexp.MustCompile(`\b(?:gh[pousr]_[A-Za-z0-9]{36,255}|github_pat_[A-Za-z0-9_]{22,255})\b`)},
+	{name: "openai", re: regexp.MustCompile(`\bsk-(?:proj-|svcacct-|admin-)?[A-Za-z0-9_-]{20,}`)},
+	{name: "card", re: regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`), valid: luhn},
+	{name: "phone", re: regexp.MustCompile(`(?:\+\d{1,3}[\s.-]?)?(?:\(\d{3}\)\s?|\b\d{3}[\s.-])\d{3}[\s.-]\d{4}\b|\+\d{1,3}(?:[\s.-]?\d{2,4}){3,5}\b`)},
+}
+
+// Detectors returns the names of the built-in detectors.
+func Detectors() []string {
+	names := make([]string, len(builtins))
+	for i, d := range builtins {
+		names[i] = d.name
+	}
+	return names
+}
+
+// Redactor applies the configured detectors and mode to payloads. A nil
+// Redactor leaves payloads unchanged.
+type Redactor struct {
+	detectors []detector
+	mode      string
+	truncate  int
+}
+
+// New creates a Redactor from cfg. It returns nil when cfg enables no
+// redaction.
+func New(cfg config.RedactConfig) (*Redactor, error) {
+	r := &Redactor{mode: cfg.Mode, truncate: cfg.TruncateLength}
+	switch r.mode {
+	case "":
+		r.mode = ModeRedact
+	case ModeRedact, ModeHash, ModeTruncate:
+	default:
+		return nil, fmt.Errorf("invalid redaction mode %q (want %s, %s or %s)", cfg.Mode, ModeRedact, ModeHash, ModeTruncate)
+	}
+	if r.mode == ModeTruncate && r.truncate <= 0 {
+		return nil, fmt.Errorf("truncate length must be positive, got %d", cfg.TruncateLength)
+	}
+
+	for _, name := range cfg.Detectors {
+		if name == "all" {
+			r.detectors = append(r.detectors[:0], builtins...)
+			break
+		}
+		found := false
+		for _, d := range builtins {
+			if d.name == name {
+				r.detectors = append(r.detectors, d)
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown redaction detector %q (want all or one of %s)", name, strings.Join(Detectors(), ", "))
+		}
+	}
+	for i, pattern := range cfg.Patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redaction pattern %d: %w", i+1, err)
+		}
+		r.detectors = append(r.detectors, detector{name: "custom", re: re})
+	}
+
+	if len(r.detectors) == 0 && r.mode == ModeRedact {
+		return nil, nil
+	}
+	return r, nil
+}
+
+// String redacts detected values in s.
+func (r *Redactor) String(s string) string {
+	if r == nil {
+		return s
+	}
+	for _, d := range r.detectors {
+		s = d.re.ReplaceAllStringFunc(s, func(match string) string {
+			if d.valid != nil && !d.valid(match) {
+				return match
+			}
+			return "[REDACTED:" + d.name + "]"
+		})
+	}
+	return s
+}
+
+// Payload returns a redacted copy of a JSON-encodable payload. In hash
+// mode the payload is replaced by a hash of its encoding, and in truncate
+// mode by its encoding cut to the configured length.
+func (r *Redactor) Payload(v interface{}) interface{} {
+	if r == nil || v == nil {
+		return v
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+
+	switch r.mode {
+	case ModeHash:
+		sum := sha256.Sum256(data)
+		return "sha256:" + hex.EncodeToString(sum[:])
+	case ModeTruncate:
+		s := r.String(string(data))
+		if len(s) > r.truncate {
+			s = strings.ToValidUTF8(s[:r.truncate], "") + "…"
+		}
+		return s
+	}
+
+	var generic interface{}
+	if err := json.Unmarshal(data, &generic); err != nil {
+		return nil
+	}
+	return r.walk(generic)
+}
+
+// walk redacts the strings of a decoded JSON value in place.
+func (r *Redactor) walk(v interface{}) interface{} {
+	switch v := v.(type) {
+	case string:
+		return r.String(v)
+	case map[string]interface{}:
+		for k, e := range v {
+			v[k] = r.walk(e)
+		}
+	case []interface{}:
+		for i, e := range v {
+			v[i] = r.walk(e)
+		}
+	}
+	return v
+}
+
+// luhn reports whether the digits of s pass the Luhn checksum, which
+// valid card numbers do.
+func luhn(s string) bool {
+	sum, n := 0, 0
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if n%2 == 1 {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		n++
+	}
+	return n >= 13 && sum%10 == 0
+}
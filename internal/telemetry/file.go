@@ -0,0 +1,130 @@
+package telemetry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/rahulvramesh/gh-proxy-local/internal/metrics"
+)
+
+// FileSink appends records as JSON lines to a file, rotating it once it
+// reaches a size limit. Rotated files are named path.1 (the newest) to
+// path.N.
+type FileSink struct {
+	path     string
+	maxBytes int64
+	maxFiles int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// NewFileSink opens the JSONL file at path. The file is rotated once
+// writing a record would grow it past maxBytes, keeping at most maxFiles
+// rotated files; maxBytes <= 0 disables rotation.
+func NewFileSink(path string, maxBytes int64, maxFiles int) (*FileSink, error) {
+	s := &FileSink{path: path, maxBytes: maxBytes, maxFiles: maxFiles}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// open opens the active file for appending.
+func (s *FileSink) open() error {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", filepath.Base(s.path), err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("failed to stat %s: %w", filepath.Base(s.path), err)
+	}
+	s.file, s.size = f, info.Size()
+	return nil
+}
+
+// IsEnabled reports whether the sink is open.
+func (s *FileSink) IsEnabled() bool {
+	return s != nil
+}
+
+// Track appends rec to the file.
+func (s *FileSink) Track(ctx context.Context, rec *Record) {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		metrics.TelemetryDropped.Inc("file", "encode")
+		return
+	}
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		metrics.TelemetryDropped.Inc("file", "closed")
+		return
+	}
+	if s.maxBytes > 0 && s.size > 0 && s.size+int64(len(line)) > s.maxBytes {
+		if err := s.rotate(); err != nil {
+			slog.Error("Failed to rotate telemetry file", "component", "telemetry", "path", s.path, "error", err)
+		}
+		if s.file == nil {
+			metrics.TelemetryDropped.Inc("file", "write")
+			return
+		}
+	}
+	n, err := s.file.Write(line)
+	s.size += int64(n)
+	if err != nil {
+		metrics.TelemetryDropped.Inc("file", "write")
+		slog.Error("Failed to write telemetry record", "component", "telemetry", "path", s.path, "error", err)
+		return
+	}
+	metrics.TelemetryRecords.Inc("file")
+}
+
+// rotate shifts the rotated files up by one, dropping the oldest, and
+// starts a new active file.
+func (s *FileSink) rotate() error {
+	s.file.Close()
+	s.file = nil
+
+	for i := s.maxFiles; i > 0; i-- {
+		src := s.path
+		if i > 1 {
+			src = fmt.Sprintf("%s.%d", s.path, i-1)
+		}
+		dst := fmt.Sprintf("%s.%d", s.path, i)
+		if err := os.Rename(src, dst); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if s.maxFiles <= 0 {
+		if err := os.Remove(s.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return s.open()
+}
+
+// Close closes the file. Records tracked afterwards are dropped.
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
@@ -0,0 +1,72 @@
+package telemetry
+
+import (
+	"context"
+
+	"github.com/rahulvramesh/gh-proxy-local/internal/langfuse"
+)
+
+// langfuseSink reports records to Langfuse as generations.
+type langfuseSink struct {
+	client *langfuse.Client
+}
+
+// Langfuse returns a sink that reports each record to client as a
+// generation in its own trace.
+func Langfuse(client *langfuse.Client) Sink {
+	return &langfuseSink{client: client}
+}
+
+func (s *langfuseSink) IsEnabled() bool {
+	return s.client.IsEnabled()
+}
+
+func (s *langfuseSink) Track(ctx context.Context, rec *Record) {
+	s.client.TrackGeneration(ctx, generationBody(rec))
+}
+
+// generationBody converts rec to a Langfuse generation. The endpoint, API
+// and key are recorded in its metadata, where the sampling policy looks
+// them up.
+func generationBody(rec *Record) *langfuse.GenerationBody {
+	metadata := map[string]interface{}{
+		"endpoint": rec.Endpoint,
+		"api":      rec.API,
+		"api_key":  rec.Key,
+	}
+	if rec.FinishReason != "" {
+		metadata["finish_reason"] = rec.FinishReason
+	}
+	for k, v := range rec.Metadata {
+		metadata[k] = v
+	}
+
+	gen := &langfuse.GenerationBody{
+		ID:                  rec.GenerationID,
+		TraceID:             rec.TraceID,
+		Name:                rec.Name,
+		Model:               rec.Model,
+		ModelParameters:     rec.ModelParameters,
+		Input:               rec.Input,
+		Output:              rec.Output,
+		Metadata:            metadata,
+		StartTime:           rec.StartTime,
+		EndTime:             rec.EndTime,
+		CompletionStartTime: rec.FirstTokenTime,
+		Tags:                []string{"key:" + rec.Key},
+		UserID:              rec.User,
+		SessionID:           rec.Session,
+	}
+	if rec.Usage != nil {
+		gen.Usage = &langfuse.UsageData{
+			PromptTokens:     rec.Usage.PromptTokens,
+			CompletionTokens: rec.Usage.CompletionTokens,
+			TotalTokens:      rec.Usage.TotalTokens,
+		}
+	}
+	if rec.Status == StatusError {
+		gen.Level = "ERROR"
+		gen.StatusMessage = rec.Error
+	}
+	return gen
+}
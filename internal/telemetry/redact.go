@@ -0,0 +1,65 @@
+package telemetry
+
+import (
+	"context"
+
+	"github.com/rahulvramesh/gh-proxy-local/internal/redact"
+)
+
+// NoLogHeader is the request header that keeps a request's payloads out
+// of telemetry. Token usage, latency and outcome are still reported.
+const NoLogHeader = "X-No-Log"
+
+type noLogKey struct{}
+
+// WithNoLog returns a context whose generations are reported without
+// their input and output.
+func WithNoLog(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noLogKey{}, true)
+}
+
+// NoLog reports whether payloads of ctx's request must not be exported.
+func NoLog(ctx context.Context) bool {
+	noLog, _ := ctx.Value(noLogKey{}).(bool)
+	return noLog
+}
+
+// redactSink redacts records before passing them on.
+type redactSink struct {
+	next     Sink
+	redactor *redact.Redactor
+}
+
+// Redact returns a sink that applies redactor to the input, output,
+// error, user, session and metadata of each record, and drops the input
+// and output of requests marked with WithNoLog, before passing it to next.
+// A nil redactor only honours WithNoLog.
+func Redact(next Sink, redactor *redact.Redactor) Sink {
+	return &redactSink{next: next, redactor: redactor}
+}
+
+func (s *redactSink) IsEnabled() bool {
+	return s.next.IsEnabled()
+}
+
+func (s *redactSink) Track(ctx context.Context, rec *Record) {
+	redacted := *rec
+	// User and session IDs are often email addresses
+	redacted.User = s.redactor.String(rec.User)
+	redacted.Session = s.redactor.String(rec.Session)
+	if rec.Metadata != nil || NoLog(ctx) {
+		redacted.Metadata = make(map[string]interface{}, len(rec.Metadata)+1)
+		for k, v := range rec.Metadata {
+			redacted.Metadata[k] = s.redactor.Payload(v)
+		}
+	}
+	if NoLog(ctx) {
+		redacted.Input, redacted.Output = nil, nil
+		redacted.Metadata["no_log"] = true
+	} else {
+		redacted.Input = s.redactor.Payload(rec.Input)
+		redacted.Output = s.redactor.Payload(rec.Output)
+	}
+	redacted.Error = s.redactor.String(rec.Error)
+	s.next.Track(ctx, &redacted)
+}
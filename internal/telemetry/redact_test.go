@@ -0,0 +1,60 @@
+package telemetry
+
+import (
+	"context"
+	"testing"
+
+	"github.com/rahulvramesh/gh-proxy-local/internal/config"
+	"github.com/rahulvramesh/gh-proxy-local/internal/redact"
+)
+
+type recordingSink struct {
+	recs []*Record
+}
+
+func (s *recordingSink) IsEnabled() bool { return true }
+
+func (s *recordingSink) Track(ctx context.Context, rec *Record) {
+	s.recs = append(s.recs, rec)
+}
+
+func TestRedact(t *testing.T) {
+	redactor, err := redact.New(config.RedactConfig{Detectors: []string{"email"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := &recordingSink{}
+	sink := Redact(rec, redactor)
+
+	r := &Record{
+		Input:    "from a@example.com",
+		Output:   "hi",
+		Usage:    &Usage{TotalTokens: 3},
+		Status:   StatusError,
+		Error:    "rejected a@example.com",
+		User:     "a@example.com",
+		Session:  "b@example.com",
+		Metadata: map[string]interface{}{"owner": "a@example.com", "tier": "pro"},
+	}
+	sink.Track(context.Background(), r)
+	sink.Track(WithNoLog(context.Background()), r)
+
+	if len(rec.recs) != 2 {
+		t.Fatalf("got %d records, want 2", len(rec.recs))
+	}
+	if got := rec.recs[0]; got.Input != "from [REDACTED:email]" || got.Output != "hi" || got.Error != "rejected [REDACTED:email]" {
+		t.Errorf("redacted record = %+v", got)
+	}
+	if got := rec.recs[1]; got.Input != nil || got.Output != nil || got.Usage == nil || got.Metadata["no_log"] != true {
+		t.Errorf("no-log record = %+v", got)
+	}
+	for _, got := range rec.recs {
+		if got.User != "[REDACTED:email]" || got.Session != "[REDACTED:email]" ||
+			got.Metadata["owner"] != "[REDACTED:email]" || got.Metadata["tier"] != "pro" {
+			t.Errorf("expected user, session and metadata to be redacted, got %+v", got)
+		}
+	}
+	if r.Input != "from a@example.com" || r.User != "a@example.com" || r.Metadata["owner"] != "a@example.com" || len(r.Metadata) != 2 {
+		t.Errorf("original record was modified: %+v", r)
+	}
+}
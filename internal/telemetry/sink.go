@@ -0,0 +1,109 @@
+// Package telemetry defines the normalized request record and the sinks
+// completed requests are reported to: Langfuse, OpenTelemetry, a rotating
+// JSONL file, a webhook and stdout.
+package telemetry
+
+import (
+	"context"
+	"time"
+)
+
+// Record statuses.
+const (
+	StatusOK    = "ok"
+	StatusError = "error"
+)
+
+// Record describes one completed model request.
+type Record struct {
+	RequestID    string `json:"request_id,omitempty"`
+	TraceID      string `json:"trace_id"`
+	GenerationID string `json:"generation_id"`
+	// Name is the generation name, e.g. chat-completion.
+	Name string `json:"name"`
+	// Endpoint is chat/completions, responses, messages or embeddings, and
+	// API the request format: openai, openai-responses or anthropic.
+	Endpoint string `json:"endpoint"`
+	API      string `json:"api"`
+	Model    string `json:"model"`
+	Key      string `json:"api_key"`
+	User     string `json:"user,omitempty"`
+	Session  string `json:"session_id,omitempty"`
+
+	StartTime time.Time `json:"start_time"`
+	EndTime   time.Time `json:"end_time"`
+	LatencyMS int64     `json:"latency_ms"`
+	// FirstTokenTime is when the first output token was relayed to a
+	// streaming client.
+	FirstTokenTime *time.Time `json:"first_token_time,omitempty"`
+
+	Status       string `json:"status"`
+	Error        string `json:"error,omitempty"`
+	FinishReason string `json:"finish_reason,omitempty"`
+	Usage        *Usage `json:"usage,omitempty"`
+
+	ModelParameters map[string]interface{} `json:"model_parameters,omitempty"`
+	// Input and Output are the request messages and the assistant
+	// message; sinks may leave them out.
+	Input  interface{} `json:"input,omitempty"`
+	Output interface{} `json:"output,omitempty"`
+	// Metadata holds annotations added on the way to the sinks.
+	Metadata map[string]interface{} `json:"metadata,omitempty"`
+}
+
+// Usage is the token usage of a request.
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+// Sink receives completed requests. ctx is the inbound request's context.
+// Sinks must not modify the record.
+type Sink interface {
+	IsEnabled() bool
+	Track(ctx context.Context, rec *Record)
+}
+
+// Sinks fans records out to several sinks.
+type Sinks []Sink
+
+// IsEnabled reports whether any sink is enabled.
+func (s Sinks) IsEnabled() bool {
+	for _, sink := range s {
+		if sink.IsEnabled() {
+			return true
+		}
+	}
+	return false
+}
+
+// Track sends rec to every enabled sink.
+func (s Sinks) Track(ctx context.Context, rec *Record) {
+	for _, sink := range s {
+		if sink.IsEnabled() {
+			sink.Track(ctx, rec)
+		}
+	}
+}
+
+// withoutPayloads drops input and output before passing records on.
+type withoutPayloads struct {
+	next Sink
+}
+
+// WithoutPayloads returns a sink that passes records to next without
+// their input and output.
+func WithoutPayloads(next Sink) Sink {
+	return &withoutPayloads{next: next}
+}
+
+func (s *withoutPayloads) IsEnabled() bool {
+	return s.next.IsEnabled()
+}
+
+func (s *withoutPayloads) Track(ctx context.Context, rec *Record) {
+	stripped := *rec
+	stripped.Input, stripped.Output = nil, nil
+	s.next.Track(ctx, &stripped)
+}
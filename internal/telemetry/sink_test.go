@@ -0,0 +1,145 @@
+package telemetry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSinksFanOut(t *testing.T) {
+	a, b := &recordingSink{}, &recordingSink{}
+	sinks := Sinks{a, WithoutPayloads(b)}
+
+	rec := &Record{Model: "gpt-4.1", Input: "hi", Output: "hello"}
+	sinks.Track(context.Background(), rec)
+
+	if len(a.recs) != 1 || a.recs[0].Input != "hi" {
+		t.Errorf("first sink got %+v", a.recs)
+	}
+	if len(b.recs) != 1 || b.recs[0].Input != nil || b.recs[0].Output != nil || b.recs[0].Model != "gpt-4.1" {
+		t.Errorf("payload-free sink got %+v", b.recs)
+	}
+	if rec.Input != "hi" {
+		t.Errorf("original record was modified: %+v", rec)
+	}
+}
+
+func TestGenerationBody(t *testing.T) {
+	gen := generationBody(&Record{
+		TraceID:      "trace-1",
+		Endpoint:     "messages",
+		API:          "anthropic",
+		Key:          "ci",
+		FinishReason: "stop",
+		Usage:        &Usage{PromptTokens: 3, CompletionTokens: 2, TotalTokens: 5},
+		Status:       StatusError,
+		Error:        "boom",
+		Metadata:     map[string]interface{}{"no_log": true},
+	})
+
+	if gen.TraceID != "trace-1" || gen.Level != "ERROR" || gen.StatusMessage != "boom" || gen.Usage.TotalTokens != 5 {
+		t.Errorf("unexpected generation %+v", gen)
+	}
+	for k, want := range map[string]interface{}{"endpoint": "messages", "api_key": "ci", "finish_reason": "stop", "no_log": true} {
+		if gen.Metadata[k] != want {
+			t.Errorf("metadata %s = %v, want %v", k, gen.Metadata[k], want)
+		}
+	}
+	if len(gen.Tags) != 1 || gen.Tags[0] != "key:ci" {
+		t.Errorf("tags = %v", gen.Tags)
+	}
+}
+
+func TestFileSinkRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "requests.jsonl")
+	line, _ := json.Marshal(&Record{Model: "gpt-4.1"})
+	sink, err := NewFileSink(path, int64(2*(len(line)+1)), 2)
+	if err != nil {
+		t.Fatalf("NewFileSink failed: %v", err)
+	}
+
+	for i := 0; i < 7; i++ {
+		sink.Track(context.Background(), &Record{Model: "gpt-4.1"})
+	}
+	sink.Close()
+
+	// Seven records at two per file: the active file holds one, the two
+	// rotated files two each, and the oldest file was dropped
+	for name, want := range map[string]int{"requests.jsonl": 1, "requests.jsonl.1": 2, "requests.jsonl.2": 2} {
+		data, err := os.ReadFile(filepath.Join(filepath.Dir(path), name))
+		if err != nil {
+			t.Fatalf("ReadFile %s: %v", name, err)
+		}
+		if got := strings.Count(string(data), "\n"); got != want {
+			t.Errorf("%s has %d records, want %d", name, got, want)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("expected at most 2 rotated files, stat .3: %v", err)
+	}
+}
+
+func TestWriterSink(t *testing.T) {
+	var buf bytes.Buffer
+	sink := NewWriterSink("stdout", &buf)
+	sink.Track(context.Background(), &Record{TraceID: "trace-1", Status: StatusOK})
+
+	var got map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("invalid JSON line %q: %v", buf.String(), err)
+	}
+	if got["trace_id"] != "trace-1" || got["status"] != "ok" {
+		t.Errorf("unexpected record %v", got)
+	}
+}
+
+func TestWebhookSink(t *testing.T) {
+	var mu sync.Mutex
+	var batches []int
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		timestamp := r.Header.Get(WebhookTimestampHeader)
+		if r.Header.Get(WebhookSignatureHeader) != Sign("secret", timestamp, body) {
+			t.Errorf("bad signature %q", r.Header.Get(WebhookSignatureHeader))
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		attempts++
+		if attempts == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var payload struct {
+			Records []Record `json:"records"`
+		}
+		json.Unmarshal(body, &payload)
+		batches = append(batches, len(payload.Records))
+	}))
+	defer server.Close()
+
+	sink := NewWebhookSink(server.URL, "secret", 2, time.Hour, 1)
+	sink.retryBase = time.Millisecond
+	for i := 0; i < 3; i++ {
+		sink.Track(context.Background(), &Record{Model: "gpt-4.1"})
+	}
+	sink.Shutdown()
+
+	mu.Lock()
+	defer mu.Unlock()
+	// The first batch fails once and is retried; the last record is
+	// flushed on shutdown
+	if len(batches) != 2 || batches[0] != 2 || batches[1] != 1 || attempts != 3 {
+		t.Errorf("batches = %v after %d attempts", batches, attempts)
+	}
+}
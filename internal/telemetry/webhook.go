@@ -0,0 +1,181 @@
+package telemetry
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rahulvramesh/gh-proxy-local/internal/metrics"
+)
+
+// Webhook signature headers. The signature is the hex HMAC-SHA256, keyed
+// with the webhook secret, of the timestamp, a dot and the body.
+const (
+	WebhookTimestampHeader = "X-Webhook-Timestamp"
+	WebhookSignatureHeader = "X-Webhook-Signature"
+)
+
+// WebhookSink posts batches of records as JSON to an HTTP endpoint.
+type WebhookSink struct {
+	url        string
+	secret     string
+	httpClient *http.Client
+
+	batchSize  int
+	interval   time.Duration
+	maxRetries int
+	retryBase  time.Duration
+
+	records chan *Record
+	done    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// NewWebhookSink starts a sink posting to url. Batches of up to batchSize
+// records are sent at least every interval and retried up to maxRetries
+// times. Requests are signed when secret is set.
+func NewWebhookSink(url, secret string, batchSize int, interval time.Duration, maxRetries int) *WebhookSink {
+	s := &WebhookSink{
+		url:        url,
+		secret:     secret,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+		batchSize:  batchSize,
+		interval:   interval,
+		maxRetries: maxRetries,
+		retryBase:  time.Second,
+		records:    make(chan *Record, 1000),
+		done:       make(chan struct{}),
+	}
+	s.wg.Add(1)
+	go s.worker()
+	return s
+}
+
+// IsEnabled reports whether the sink is set.
+func (s *WebhookSink) IsEnabled() bool {
+	return s != nil
+}
+
+// Track queues rec for the next batch.
+func (s *WebhookSink) Track(ctx context.Context, rec *Record) {
+	select {
+	case s.records <- rec:
+	default:
+		metrics.TelemetryDropped.Inc("webhook", "queue_full")
+	}
+}
+
+// Sign returns the signature of a webhook request.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte{'.'})
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// worker batches queued records and sends them.
+func (s *WebhookSink) worker() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	batch := make([]*Record, 0, s.batchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		s.deliver(batch)
+		batch = make([]*Record, 0, s.batchSize)
+	}
+
+	for {
+		select {
+		case rec := <-s.records:
+			batch = append(batch, rec)
+			if len(batch) >= s.batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-s.done:
+			for {
+				select {
+				case rec := <-s.records:
+					batch = append(batch, rec)
+					if len(batch) >= s.batchSize {
+						flush()
+					}
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// deliver sends a batch, retrying with exponential backoff on network
+// errors, 429 and 5xx responses.
+func (s *WebhookSink) deliver(batch []*Record) {
+	body, err := json.Marshal(map[string]interface{}{"records": batch})
+	if err != nil {
+		metrics.TelemetryDropped.Add(float64(len(batch)), "webhook", "encode")
+		return
+	}
+
+	for attempt := 0; ; attempt++ {
+		retry, err := s.send(body)
+		if err == nil {
+			metrics.TelemetryRecords.Add(float64(len(batch)), "webhook")
+			return
+		}
+		if !retry || attempt >= s.maxRetries {
+			metrics.TelemetryDropped.Add(float64(len(batch)), "webhook", "send_failed")
+			slog.Warn("Dropped telemetry webhook batch", "component", "telemetry", "records", len(batch), "error", err)
+			return
+		}
+		time.Sleep(s.retryBase << attempt)
+	}
+}
+
+// send posts one batch and reports whether a failure may be retried.
+func (s *WebhookSink) send(body []byte) (retry bool, err error) {
+	req, err := http.NewRequest("POST", s.url, bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.secret != "" {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set(WebhookTimestampHeader, timestamp)
+		req.Header.Set(WebhookSignatureHeader, Sign(s.secret, timestamp, body))
+	}
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return true, fmt.Errorf("request failed: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500,
+			fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return false, nil
+}
+
+// Shutdown sends queued records and stops the sink.
+func (s *WebhookSink) Shutdown() {
+	close(s.done)
+	s.wg.Wait()
+}
@@ -0,0 +1,47 @@
+package telemetry
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+
+	"github.com/rahulvramesh/gh-proxy-local/internal/metrics"
+)
+
+// WriterSink writes records as JSON lines to a writer, such as stdout for
+// collection by a log shipper.
+type WriterSink struct {
+	name string
+
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewWriterSink returns a sink writing to w. name labels its metrics.
+func NewWriterSink(name string, w io.Writer) *WriterSink {
+	return &WriterSink{name: name, w: w}
+}
+
+// IsEnabled reports whether the sink is set.
+func (s *WriterSink) IsEnabled() bool {
+	return s != nil
+}
+
+// Track writes rec as one line.
+func (s *WriterSink) Track(ctx context.Context, rec *Record) {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		metrics.TelemetryDropped.Inc(s.name, "encode")
+		return
+	}
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.w.Write(line); err != nil {
+		metrics.TelemetryDropped.Inc(s.name, "write")
+		return
+	}
+	metrics.TelemetryRecords.Inc(s.name)
+}
@@ -0,0 +1,205 @@
+// Package tracing records request spans and exports them to an
+// OpenTelemetry collector over OTLP/HTTP, propagating W3C trace context.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Kind is the OTLP span kind.
+type Kind int
+
+const (
+	Internal Kind = 1
+	Server   Kind = 2
+	Client   Kind = 3
+)
+
+// Status codes.
+const (
+	statusUnset = 0
+	statusError = 2
+)
+
+// SpanContext identifies a span across process boundaries.
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Sampled bool
+}
+
+// IsValid reports whether the trace and span IDs are set.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != [16]byte{} && sc.SpanID != [8]byte{}
+}
+
+// TraceParent renders the W3C traceparent header value.
+func (sc SpanContext) TraceParent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%s-%s-%s", hex.EncodeToString(sc.TraceID[:]), hex.EncodeToString(sc.SpanID[:]), flags)
+}
+
+// ParseTraceParent parses a W3C traceparent header value.
+func ParseTraceParent(value string) (SpanContext, bool) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return sc, false
+	}
+	if parts[0] == "00" && len(parts) != 4 {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return sc, false
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return sc, false
+	}
+	sc.Sampled = flags[0]&1 == 1
+	return sc, sc.IsValid()
+}
+
+// Span is a timed operation. A nil *Span is a valid no-op span, so callers
+// need not check whether tracing is enabled.
+type Span struct {
+	tracer *Tracer
+	sc     SpanContext
+	parent [8]byte
+	name   string
+	kind   Kind
+	start  time.Time
+
+	mu         sync.Mutex
+	end        time.Time
+	attributes map[string]interface{}
+	status     int
+	message    string
+}
+
+// SpanContext returns the span's identity.
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.sc
+}
+
+// SetAttributes sets attributes from alternating keys and values. Values
+// may be strings, bools, ints, int64s, float64s or string slices.
+func (s *Span) SetAttributes(kv ...interface{}) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := 0; i+1 < len(kv); i += 2 {
+		if key, ok := kv[i].(string); ok {
+			s.attributes[key] = kv[i+1]
+		}
+	}
+}
+
+// SetError marks the span as failed.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status = statusError
+	s.message = err.Error()
+}
+
+// SetName renames the span.
+func (s *Span) SetName(name string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.name = name
+}
+
+// End finishes the span and queues it for export. Only the first call has
+// an effect.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if !s.end.IsZero() {
+		s.mu.Unlock()
+		return
+	}
+	s.end = time.Now()
+	s.mu.Unlock()
+	if s.sc.Sampled {
+		s.tracer.enqueue(s)
+	}
+}
+
+type spanKey struct{}
+type remoteKey struct{}
+
+// SpanFromContext returns the current span, or nil.
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// StartSpan starts a child of the current span. Without a current span
+// tracing is off for the request and a nil span is returned.
+func StartSpan(ctx context.Context, name string, kind Kind) (context.Context, *Span) {
+	parent := SpanFromContext(ctx)
+	if parent == nil {
+		return ctx, nil
+	}
+	span := parent.tracer.newSpan(name, kind, parent.sc, parent.sc.SpanID)
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+// Extract returns a context carrying the remote span context from a
+// traceparent header, if present and valid.
+func Extract(ctx context.Context, h http.Header) context.Context {
+	if sc, ok := ParseTraceParent(h.Get("traceparent")); ok {
+		return context.WithValue(ctx, remoteKey{}, sc)
+	}
+	return ctx
+}
+
+// Inject writes the current span's traceparent header. With no span but a
+// remote parent, the remote context is passed through unchanged.
+func Inject(ctx context.Context, h http.Header) {
+	if span := SpanFromContext(ctx); span != nil {
+		h.Set("traceparent", span.sc.TraceParent())
+		return
+	}
+	if sc, ok := ctx.Value(remoteKey{}).(SpanContext); ok {
+		h.Set("traceparent", sc.TraceParent())
+	}
+}
+
+func randomID(b []byte) {
+	for {
+		rand.Read(b)
+		for _, c := range b {
+			if c != 0 {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,312 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rahulvramesh/gh-proxy-local/internal/config"
+	"github.com/rahulvramesh/gh-proxy-local/internal/logging"
+	"github.com/rahulvramesh/gh-proxy-local/internal/telemetry"
+)
+
+const scopeName = "github.com/rahulvramesh/gh-proxy-local"
+
+// Tracer starts request spans and exports finished spans in batches to an
+// OTLP/HTTP collector. A disabled Tracer starts no spans.
+type Tracer struct {
+	endpoint   string
+	headers    map[string]string
+	service    string
+	httpClient *http.Client
+	debug      bool
+
+	spans     chan *Span
+	done      chan struct{}
+	wg        sync.WaitGroup
+	batchSize int
+	interval  time.Duration
+
+	enabled atomic.Bool
+}
+
+// NewTracer creates a tracer exporting to cfg.Endpoint. Tracing is
+// disabled when no endpoint is configured.
+func NewTracer(cfg config.TracingConfig, debug bool) *Tracer {
+	if cfg.Endpoint == "" {
+		return &Tracer{}
+	}
+
+	t := &Tracer{
+		endpoint: cfg.Endpoint,
+		headers:  cfg.Headers,
+		service:  cfg.ServiceName,
+		httpClient: &http.Client{
+			Timeout: 30 * time.Second,
+		},
+		debug:     debug,
+		spans:     make(chan *Span, 2048),
+		done:      make(chan struct{}),
+		batchSize: cfg.BatchSize,
+		interval:  cfg.FlushInterval,
+	}
+	t.enabled.Store(true)
+
+	t.wg.Add(1)
+	go t.worker()
+
+	return t
+}
+
+// IsEnabled returns whether spans are exported.
+func (t *Tracer) IsEnabled() bool {
+	return t.enabled.Load()
+}
+
+// debugLog logs a debug message if debugging is enabled. args are slog
+// key-value pairs.
+func (t *Tracer) debugLog(msg string, args ...interface{}) {
+	if t.debug || logging.DebugEnabled() {
+		slog.Debug(msg, append([]interface{}{"component", "tracing"}, args...)...)
+	}
+}
+
+// Start starts a root span for an inbound request, continuing the remote
+// trace extracted into ctx if there is one.
+func (t *Tracer) Start(ctx context.Context, name string, kind Kind) (context.Context, *Span) {
+	if !t.enabled.Load() {
+		return ctx, nil
+	}
+	parent := SpanContext{Sampled: true}
+	var parentID [8]byte
+	if remote, ok := ctx.Value(remoteKey{}).(SpanContext); ok {
+		parent = remote
+		parentID = remote.SpanID
+	} else {
+		randomID(parent.TraceID[:])
+	}
+	span := t.newSpan(name, kind, parent, parentID)
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+// newSpan creates a span in parent's trace.
+func (t *Tracer) newSpan(name string, kind Kind, parent SpanContext, parentID [8]byte) *Span {
+	span := &Span{
+		tracer:     t,
+		sc:         SpanContext{TraceID: parent.TraceID, Sampled: parent.Sampled},
+		parent:     parentID,
+		name:       name,
+		kind:       kind,
+		start:      time.Now(),
+		attributes: make(map[string]interface{}),
+	}
+	randomID(span.sc.SpanID[:])
+	return span
+}
+
+// enqueue queues a finished span for export.
+func (t *Tracer) enqueue(span *Span) {
+	select {
+	case t.spans <- span:
+	default:
+		t.debugLog("Span dropped (queue full)", "span", span.name)
+	}
+}
+
+// Track records a request's model, token usage and outcome on the
+// request span, following the GenAI semantic conventions. It implements
+// telemetry.Sink.
+func (t *Tracer) Track(ctx context.Context, rec *telemetry.Record) {
+	span := SpanFromContext(ctx)
+	if span == nil {
+		return
+	}
+	span.SetAttributes("gen_ai.request.model", rec.Model)
+	if rec.User != "" {
+		span.SetAttributes("user.id", rec.User)
+	}
+	if rec.Session != "" {
+		span.SetAttributes("session.id", rec.Session)
+	}
+	if rec.Usage != nil {
+		span.SetAttributes(
+			"gen_ai.usage.input_tokens", rec.Usage.PromptTokens,
+			"gen_ai.usage.output_tokens", rec.Usage.CompletionTokens,
+		)
+	}
+	if rec.Status == telemetry.StatusError {
+		span.SetError(errors.New(rec.Error))
+	}
+}
+
+// worker is the background goroutine that batches and exports spans.
+func (t *Tracer) worker() {
+	defer t.wg.Done()
+
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+
+	batch := make([]*Span, 0, t.batchSize)
+
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+
+		spans := make([]*Span, len(batch))
+		copy(spans, batch)
+		batch = batch[:0]
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		if err := t.export(ctx, spans); err != nil {
+			t.debugLog("Failed to export spans", "spans", len(spans), "error", err)
+		} else {
+			t.debugLog("Exported spans", "spans", len(spans))
+		}
+	}
+
+	for {
+		select {
+		case span := <-t.spans:
+			batch = append(batch, span)
+			if len(batch) >= t.batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-t.done:
+			for {
+				select {
+				case span := <-t.spans:
+					batch = append(batch, span)
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// export posts spans to the collector as an OTLP JSON request.
+func (t *Tracer) export(ctx context.Context, spans []*Span) error {
+	body, err := json.Marshal(t.encode(spans))
+	if err != nil {
+		return fmt.Errorf("failed to marshal spans: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", t.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range t.headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := t.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("collector error: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// encode builds an ExportTraceServiceRequest in the OTLP JSON encoding.
+func (t *Tracer) encode(spans []*Span) map[string]interface{} {
+	encoded := make([]map[string]interface{}, len(spans))
+	for i, s := range spans {
+		s.mu.Lock()
+		span := map[string]interface{}{
+			"traceId":           hex.EncodeToString(s.sc.TraceID[:]),
+			"spanId":            hex.EncodeToString(s.sc.SpanID[:]),
+			"name":              s.name,
+			"kind":              int(s.kind),
+			"startTimeUnixNano": strconv.FormatInt(s.start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(s.end.UnixNano(), 10),
+			"attributes":        encodeAttributes(s.attributes),
+		}
+		if s.parent != [8]byte{} {
+			span["parentSpanId"] = hex.EncodeToString(s.parent[:])
+		}
+		if s.status != statusUnset {
+			span["status"] = map[string]interface{}{"code": s.status, "message": s.message}
+		}
+		s.mu.Unlock()
+		encoded[i] = span
+	}
+
+	return map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{
+					"attributes": encodeAttributes(map[string]interface{}{"service.name": t.service}),
+				},
+				"scopeSpans": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]interface{}{"name": scopeName},
+						"spans": encoded,
+					},
+				},
+			},
+		},
+	}
+}
+
+// encodeAttributes renders attributes as OTLP KeyValues.
+func encodeAttributes(attrs map[string]interface{}) []map[string]interface{} {
+	encoded := make([]map[string]interface{}, 0, len(attrs))
+	for key, value := range attrs {
+		encoded = append(encoded, map[string]interface{}{"key": key, "value": encodeValue(value)})
+	}
+	return encoded
+}
+
+func encodeValue(value interface{}) map[string]interface{} {
+	switch v := value.(type) {
+	case string:
+		return map[string]interface{}{"stringValue": v}
+	case bool:
+		return map[string]interface{}{"boolValue": v}
+	case int:
+		return map[string]interface{}{"intValue": strconv.Itoa(v)}
+	case int64:
+		return map[string]interface{}{"intValue": strconv.FormatInt(v, 10)}
+	case float64:
+		return map[string]interface{}{"doubleValue": v}
+	case []string:
+		values := make([]map[string]interface{}, len(v))
+		for i, s := range v {
+			values[i] = map[string]interface{}{"stringValue": s}
+		}
+		return map[string]interface{}{"arrayValue": map[string]interface{}{"values": values}}
+	default:
+		return map[string]interface{}{"stringValue": fmt.Sprint(v)}
+	}
+}
+
+// Shutdown exports remaining spans and stops the exporter.
+func (t *Tracer) Shutdown() {
+	if !t.enabled.CompareAndSwap(true, false) {
+		return
+	}
+
+	close(t.done)
+	t.wg.Wait()
+	t.debugLog("Tracer shutdown complete")
+}
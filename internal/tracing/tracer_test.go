@@ -0,0 +1,178 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rahulvramesh/gh-proxy-local/internal/config"
+	"github.com/rahulvramesh/gh-proxy-local/internal/telemetry"
+)
+
+const parent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+func TestParseTraceParent(t *testing.T) {
+	sc, ok := ParseTraceParent(parent)
+	if !ok || !sc.Sampled {
+		t.Fatalf("ParseTraceParent(%q) = %+v, %v", parent, sc, ok)
+	}
+	if got := sc.TraceParent(); got != parent {
+		t.Errorf("TraceParent() = %q, want %q", got, parent)
+	}
+
+	for _, value := range []string{
+		"",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"00-xyz92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+	} {
+		if _, ok := ParseTraceParent(value); ok {
+			t.Errorf("ParseTraceParent(%q) accepted an invalid value", value)
+		}
+	}
+}
+
+func TestPropagation(t *testing.T) {
+	incoming := http.Header{"Traceparent": {parent}}
+
+	// With tracing disabled the caller's context is passed through
+	ctx := Extract(context.Background(), incoming)
+	ctx, span := NewTracer(config.TracingConfig{}, false).Start(ctx, "request", Server)
+	if span != nil {
+		t.Fatal("disabled tracer started a span")
+	}
+	outgoing := http.Header{}
+	Inject(ctx, outgoing)
+	if got := outgoing.Get("traceparent"); got != parent {
+		t.Errorf("passed through traceparent = %q, want %q", got, parent)
+	}
+
+	// With tracing enabled the request joins the caller's trace
+	tracer := &Tracer{spans: make(chan *Span, 10)}
+	tracer.enabled.Store(true)
+	ctx, root := tracer.Start(Extract(context.Background(), incoming), "request", Server)
+	ctx, child := StartSpan(ctx, "upstream", Client)
+	Inject(ctx, outgoing)
+
+	sc, ok := ParseTraceParent(outgoing.Get("traceparent"))
+	if !ok || sc.TraceID != root.sc.TraceID || sc.SpanID != child.sc.SpanID {
+		t.Errorf("injected %q, want trace %x span %x", outgoing.Get("traceparent"), root.sc.TraceID, child.sc.SpanID)
+	}
+	if child.parent != root.sc.SpanID {
+		t.Error("child span is not parented to the request span")
+	}
+	remote, _ := ParseTraceParent(parent)
+	if root.parent != remote.SpanID {
+		t.Error("request span is not parented to the caller's span")
+	}
+}
+
+func TestExport(t *testing.T) {
+	requests := make(chan map[string]interface{}, 1)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		if r.Header.Get("x-api-key") != "secret" {
+			t.Errorf("missing collector header")
+		}
+		requests <- body
+	}))
+	defer collector.Close()
+
+	tracer := NewTracer(config.TracingConfig{
+		Endpoint:      collector.URL,
+		Headers:       map[string]string{"x-api-key": "secret"},
+		ServiceName:   "test",
+		BatchSize:     10,
+		FlushInterval: time.Hour,
+	}, false)
+
+	ctx, root := tracer.Start(context.Background(), "POST /v1/chat/completions", Server)
+	_, child := StartSpan(ctx, "chat gpt-4.1", Client)
+	child.SetAttributes("gen_ai.response.finish_reasons", []string{"stop"})
+	child.End()
+	tracer.Track(ctx, &telemetry.Record{
+		Model:  "gpt-4.1",
+		Usage:  &telemetry.Usage{PromptTokens: 12, CompletionTokens: 3},
+		Status: telemetry.StatusError, Error: "boom",
+	})
+	root.End()
+	tracer.Shutdown()
+
+	var body map[string]interface{}
+	select {
+	case body = <-requests:
+	default:
+		t.Fatal("no spans were exported")
+	}
+
+	resourceSpans := body["resourceSpans"].([]interface{})[0].(map[string]interface{})
+	spans := resourceSpans["scopeSpans"].([]interface{})[0].(map[string]interface{})["spans"].([]interface{})
+	if len(spans) != 2 {
+		t.Fatalf("exported %d spans, want 2", len(spans))
+	}
+
+	byName := make(map[string]map[string]interface{})
+	for _, s := range spans {
+		span := s.(map[string]interface{})
+		byName[span["name"].(string)] = span
+	}
+	server, client := byName["POST /v1/chat/completions"], byName["chat gpt-4.1"]
+	if client["parentSpanId"] != server["spanId"] || client["traceId"] != server["traceId"] {
+		t.Errorf("client span not linked to server span: %v %v", client, server)
+	}
+	if status := server["status"].(map[string]interface{}); status["code"] != float64(statusError) || status["message"] != "boom" {
+		t.Errorf("server status = %v", status)
+	}
+
+	attrs := make(map[string]interface{})
+	for _, a := range server["attributes"].([]interface{}) {
+		kv := a.(map[string]interface{})
+		attrs[kv["key"].(string)] = kv["value"]
+	}
+	if v := attrs["gen_ai.usage.input_tokens"].(map[string]interface{}); v["intValue"] != "12" {
+		t.Errorf("input tokens = %v", v)
+	}
+	if v := attrs["gen_ai.request.model"].(map[string]interface{}); v["stringValue"] != "gpt-4.1" {
+		t.Errorf("model = %v", v)
+	}
+}
+
+func TestShutdownWhileStarting(t *testing.T) {
+	tracer := NewTracer(config.TracingConfig{Endpoint: "http://127.0.0.1:0", BatchSize: 10, FlushInterval: time.Hour}, false)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			_, span := tracer.Start(context.Background(), "GET /health", Server)
+			span.End()
+		}
+	}()
+	tracer.Shutdown()
+	tracer.Shutdown()
+	<-done
+
+	if tracer.IsEnabled() {
+		t.Error("expected tracer to be disabled after shutdown")
+	}
+	if _, span := tracer.Start(context.Background(), "GET /health", Server); span != nil {
+		t.Error("expected no spans after shutdown")
+	}
+}
+
+func TestNilSpan(t *testing.T) {
+	ctx, span := StartSpan(context.Background(), "parse", Internal)
+	if span != nil || SpanFromContext(ctx) != nil {
+		t.Fatal("StartSpan without a request span should be a no-op")
+	}
+	span.SetAttributes("k", "v")
+	span.SetError(errors.New("ignored"))
+	span.End()
+}
@@ -0,0 +1,355 @@
+"use strict";
+
+// Admin API requests carry the dashboard session cookie; an expired
+// session returns to the login page.
+async function api(path, method = "GET") {
+  const resp = await fetch(path, { method, credentials: "same-origin", headers: { Accept: "application/json" } });
+  if (resp.status === 401 || resp.status === 403) {
+    location.href = "/ui/login?error=1";
+    throw new Error("not signed in");
+  }
+  if (!resp.ok) {
+    throw new Error(`${resp.status} ${await resp.text()}`);
+  }
+  return resp.json();
+}
+
+function escape(value) {
+  return String(value ?? "").replace(/[&<>"']/g, (c) => ({ "&": "&amp;", "<": "&lt;", ">": "&gt;", '"': "&quot;", "'": "&#39;" })[c]);
+}
+
+function number(value, digits = 0) {
+  return Number(value ?? 0).toLocaleString(undefined, { maximumFractionDigits: digits });
+}
+
+function time(value) {
+  return value ? new Date(value).toLocaleString() : "";
+}
+
+function $(id) {
+  return document.getElementById(id);
+}
+
+function table(headers, rows) {
+  if (rows.length === 0) {
+    return '<p class="muted">Nothing to show.</p>';
+  }
+  return `<table><thead><tr>${headers.map((h) => `<th>${escape(h)}</th>`).join("")}</tr></thead><tbody>${rows.join("")}</tbody></table>`;
+}
+
+function bars(entries, value, format) {
+  const sorted = Object.entries(entries || {}).sort((a, b) => value(b[1]) - value(a[1]));
+  if (sorted.length === 0) {
+    return '<p class="muted">No usage recorded.</p>';
+  }
+  const top = Math.max(...sorted.map(([, e]) => value(e)), 1);
+  return sorted.map(([name, e]) => `
+    <div class="bar">
+      <span class="label" title="${escape(name)}">${escape(name)}</span>
+      <div class="track"><div class="fill" style="width:${(100 * value(e)) / top}%"></div></div>
+      <span>${format(e)}</span>
+    </div>`).join("");
+}
+
+function gauge(percent) {
+  return `<div class="gauge"><div style="width:${Math.min(Math.max(percent, 0), 100)}%"></div></div>`;
+}
+
+function showError(target, err) {
+  target.innerHTML = `<p class="error">${escape(err.message)}</p>`;
+}
+
+// Overview
+
+async function loadOverview() {
+  const auth = $("auth-status");
+  try {
+    const account = await api("/admin/account");
+    const sub = account.subscription || {};
+    const features = Object.entries(sub.features || {}).filter(([, on]) => on).map(([name]) => `<span class="tag">${escape(name)}</span>`);
+    auth.innerHTML = `
+      <h3>GitHub Copilot <span class="ok">● authenticated</span></h3>
+      <dl>
+        <dt>User</dt><dd>${escape(account.user?.login)} ${account.user?.name ? `(${escape(account.user.name)})` : ""}</dd>
+        <dt>Plan</dt><dd>${escape(sub.type)} <span class="muted">${escape(sub.sku)}</span></dd>
+        <dt>Features</dt><dd>${features.join("") || '<span class="muted">none</span>'}</dd>
+        <dt>Models</dt><dd>${number(account.models?.total_count)}</dd>
+        <dt>API endpoint</dt><dd>${escape(account.endpoints?.api)}</dd>
+      </dl>`;
+  } catch (err) {
+    auth.innerHTML = `<h3>GitHub Copilot <span class="error">● not authenticated</span></h3><p class="muted">${escape(err.message)}</p>`;
+  }
+
+  const quota = $("quota-status");
+  try {
+    const usage = await api("/admin/usage");
+    const q = usage.quota;
+    const soft = usage.soft_limit;
+    if (!q.available) {
+      quota.innerHTML = '<h3>Premium requests</h3><p class="muted">No quota snapshot available.</p>';
+      return;
+    }
+    const remaining = q.remaining ?? q.snapshot_remaining;
+    quota.innerHTML = `
+      <h3>Premium requests</h3>
+      ${q.unlimited ? '<p>Unlimited</p>' : `
+        <p><strong>${number(remaining, 1)}</strong> of ${number(q.entitlement)} remaining</p>
+        ${gauge(q.entitlement ? (100 * remaining) / q.entitlement : 0)}`}
+      <dl>
+        <dt>Resets</dt><dd>${escape(q.reset_date) || '<span class="muted">unknown</span>'}</dd>
+        <dt>Overage</dt><dd>${number(q.overage_count)} ${q.overage_permitted ? "(permitted)" : "(not permitted)"}</dd>
+        <dt>Soft limit</dt><dd>${soft.enabled ? `${number(soft.threshold)} → ${escape(soft.action)}${soft.reached ? ' <span class="error">reached</span>' : ""}` : "off"}</dd>
+        <dt>Snapshot</dt><dd>${time(q.fetched_at)}</dd>
+      </dl>`;
+  } catch (err) {
+    showError(quota, err);
+  }
+}
+
+// Usage
+
+async function loadUsage() {
+  const form = new FormData($("usage-filters"));
+  const params = new URLSearchParams();
+  for (const name of ["since", "until"]) {
+    if (form.get(name)) params.set(name, form.get(name));
+  }
+  try {
+    const usage = await api(`/admin/usage?${params}`);
+    const c = usage.consumption;
+    const premium = (e) => e.premium_requests;
+    const label = (e) => `${number(e.premium_requests, 1)} <span class="muted">/ ${number(e.requests)}</span>`;
+    $("usage-by-model").innerHTML = bars(c.by_model, premium, label);
+    $("usage-by-key").innerHTML = bars(c.by_key, premium, label);
+
+    const days = Object.entries(c.by_day || {}).sort(([a], [b]) => a.localeCompare(b));
+    const top = Math.max(...days.map(([, e]) => e.requests), 1);
+    $("usage-by-day").innerHTML = days.length === 0 ? '<p class="muted">No usage recorded.</p>' : days.map(([day, e]) =>
+      `<div class="col" style="height:${(100 * e.requests) / top}%" title="${escape(day)}: ${e.requests} requests, ${number(e.premium_requests, 1)} premium"></div>`).join("");
+  } catch (err) {
+    showError($("usage-by-model"), err);
+  }
+
+  try {
+    const budgets = await api("/admin/budgets");
+    const window = (w) => w.limit > 0
+      ? `${number(w.used)} / ${number(w.limit)}${gauge(w.percent_used ?? 0)}`
+      : `${number(w.used)} <span class="muted">no limit</span>`;
+    $("budgets").innerHTML = table(["Key", "Today", "This month"], budgets.data.map((s) =>
+      `<tr><td>${escape(s.key)}</td><td>${window(s.daily)}</td><td>${window(s.monthly)}</td></tr>`));
+  } catch (err) {
+    showError($("budgets"), err);
+  }
+}
+
+// Live
+
+let liveTimer = null;
+
+async function loadLive() {
+  try {
+    const list = await api("/admin/inflight");
+    $("inflight").innerHTML = table(["Request", "Endpoint", "Model", "Key", "Started", "Elapsed", ""], list.data.map((r) =>
+      `<tr><td><code>${escape(r.id)}</code></td><td>${escape(r.method)} ${escape(r.path)}</td><td>${escape(r.model)}</td>
+       <td>${escape(r.api_key)}</td><td>${time(r.start_time)}</td><td>${(r.duration_ms / 1000).toFixed(1)}s</td>
+       <td><button type="button" data-cancel="${escape(r.id)}">Cancel</button></td></tr>`));
+  } catch (err) {
+    showError($("inflight"), err);
+  }
+}
+
+// Requests
+
+async function loadRequests() {
+  const form = new FormData($("request-filters"));
+  const params = new URLSearchParams();
+  for (const [name, value] of form) {
+    if (value) params.set(name, value);
+  }
+  try {
+    const list = await api(`/admin/requests?${params}`);
+    $("request-list").innerHTML = table(["Time", "Endpoint", "Model", "Key", "Status", "Tokens", "Latency"], list.data.map((e) =>
+      `<tr class="clickable" data-id="${escape(e.id)}">
+        <td>${time(e.start_time)}</td><td>${escape(e.endpoint)}</td><td>${escape(e.model)}</td><td>${escape(e.api_key)}</td>
+        <td class="${e.status === "error" ? "error" : "ok"}">${escape(e.status_code)}</td>
+        <td>${number(e.usage?.total_tokens)}</td><td>${number(e.latency_ms)} ms</td></tr>`));
+  } catch (err) {
+    showError($("request-list"), err);
+  }
+}
+
+function payload(title, value) {
+  if (value === undefined || value === null) {
+    return "";
+  }
+  const text = typeof value === "string" ? value : JSON.stringify(value, null, 2);
+  return `<details><summary>${escape(title)}</summary><pre>${escape(text)}</pre></details>`;
+}
+
+async function showRequest(id) {
+  const detail = $("request-detail");
+  detail.hidden = false;
+  document.querySelectorAll("#request-list tr.selected").forEach((row) => row.classList.remove("selected"));
+  document.querySelector(`#request-list tr[data-id="${CSS.escape(id)}"]`)?.classList.add("selected");
+  try {
+    const e = await api(`/admin/requests/${encodeURIComponent(id)}`);
+    detail.innerHTML = `
+      <h3>${escape(e.method)} ${escape(e.path)}</h3>
+      <dl>
+        <dt>Request ID</dt><dd><code>${escape(e.request_id)}</code></dd>
+        <dt>Trace ID</dt><dd><code>${escape(e.trace_id)}</code></dd>
+        <dt>Model</dt><dd>${escape(e.model)}</dd>
+        <dt>Key</dt><dd>${escape(e.api_key)}</dd>
+        <dt>Status</dt><dd class="${e.status === "error" ? "error" : "ok"}">${escape(e.status_code)} ${escape(e.error)}</dd>
+        <dt>Started</dt><dd>${time(e.start_time)}</dd>
+        <dt>Latency</dt><dd>${number(e.latency_ms)} ms</dd>
+        <dt>Tokens</dt><dd>${number(e.usage?.prompt_tokens)} in, ${number(e.usage?.completion_tokens)} out</dd>
+        <dt>Finish reason</dt><dd>${escape(e.finish_reason)}</dd>
+      </dl>
+      ${e.truncated ? '<p class="muted">Payloads were truncated.</p>' : ""}
+      ${payload("Request", e.request)}
+      ${payload("Upstream request", e.upstream_request)}
+      ${payload("Response", e.response)}
+      ${payload("Output", e.output)}
+      ${payload(`Feedback (${e.feedback.length})`, e.feedback.length ? e.feedback : null)}`;
+  } catch (err) {
+    showError(detail, err);
+  }
+}
+
+// Models
+
+let modelList = null;
+
+async function fetchModels() {
+  if (!modelList) {
+    modelList = (await api("/admin/models")).data.sort((a, b) => a.id.localeCompare(b.id));
+  }
+  return modelList;
+}
+
+async function loadModels() {
+  try {
+    const models = await fetchModels();
+    $("model-list").innerHTML = table(["Model", "Vendor", "Context", "Prompt", "Output", "Capabilities"], models.map((m) => {
+      const caps = Object.entries(m.capabilities || {}).filter(([, on]) => on).map(([name]) => `<span class="tag">${escape(name)}</span>`);
+      return `<tr><td><code>${escape(m.id)}</code>${m.preview ? ' <span class="tag">preview</span>' : ""}<br><span class="muted">${escape(m.name)}</span></td>
+        <td>${escape(m.owned_by)}</td><td>${number(m.limits?.max_context_window_tokens)}</td>
+        <td>${number(m.limits?.max_prompt_tokens)}</td><td>${number(m.limits?.max_output_tokens)}</td><td>${caps.join("")}</td></tr>`;
+    }));
+  } catch (err) {
+    showError($("model-list"), err);
+  }
+}
+
+// Playground
+
+const playgroundPaths = { chat: "/v1/chat/completions", responses: "/v1/responses", messages: "/v1/messages" };
+
+function playgroundBody(form) {
+  const model = form.model.value;
+  const system = form.system.value.trim();
+  const prompt = form.prompt.value;
+  const stream = form.stream.checked;
+  switch (form.api.value) {
+    case "responses":
+      return { model, stream, input: prompt, ...(system && { instructions: system }) };
+    case "messages":
+      return { model, stream, max_tokens: 1024, messages: [{ role: "user", content: prompt }], ...(system && { system }) };
+    default:
+      return { model, stream, messages: [...(system ? [{ role: "system", content: system }] : []), { role: "user", content: prompt }] };
+  }
+}
+
+async function loadPlayground() {
+  const select = document.querySelector("#playground-form select[name=model]");
+  if (select.options.length > 0) {
+    return;
+  }
+  try {
+    const models = await fetchModels();
+    select.innerHTML = models.map((m) => `<option value="${escape(m.id)}">${escape(m.id)}</option>`).join("");
+  } catch (err) {
+    showError($("playground-output"), err);
+  }
+}
+
+async function sendPlayground(event) {
+  event.preventDefault();
+  const form = event.target;
+  const output = $("playground-output");
+  const status = $("playground-status");
+
+  let body = playgroundBody(form);
+  if (form.body.value.trim()) {
+    try {
+      body = JSON.parse(form.body.value);
+    } catch (err) {
+      status.textContent = `invalid request body: ${err.message}`;
+      return;
+    }
+  }
+  const headers = { "Content-Type": "application/json" };
+  if (form.key.value) headers.Authorization = `Bearer ${form.key.value}`;
+  if (form.api.value === "messages") headers["anthropic-version"] = "2023-06-01";
+
+  output.textContent = "";
+  status.textContent = "sending…";
+  const start = performance.now();
+  try {
+    const resp = await fetch(playgroundPaths[form.api.value], { method: "POST", headers, body: JSON.stringify(body) });
+    const reader = resp.body.getReader();
+    const decoder = new TextDecoder();
+    let text = "";
+    for (;;) {
+      const { done, value } = await reader.read();
+      if (done) break;
+      text += decoder.decode(value, { stream: true });
+      output.textContent = text;
+    }
+    try {
+      output.textContent = JSON.stringify(JSON.parse(text), null, 2);
+    } catch {
+      // Streamed responses are shown as received
+    }
+    status.textContent = `${resp.status} in ${Math.round(performance.now() - start)} ms · request ${resp.headers.get("x-request-id") || ""}`;
+  } catch (err) {
+    status.textContent = err.message;
+  }
+}
+
+// Navigation
+
+const loaders = { overview: loadOverview, usage: loadUsage, live: loadLive, requests: loadRequests, models: loadModels, playground: loadPlayground };
+
+function route() {
+  const current = loaders[location.hash.slice(1)] ? location.hash.slice(1) : "overview";
+  for (const section of document.querySelectorAll("main > section")) {
+    section.hidden = section.id !== current;
+  }
+  for (const link of document.querySelectorAll("header nav a")) {
+    link.classList.toggle("active", link.getAttribute("href") === `#${current}`);
+  }
+  clearInterval(liveTimer);
+  if (current === "live") {
+    liveTimer = setInterval(loadLive, 2000);
+  }
+  loaders[current]();
+}
+
+$("usage-filters").addEventListener("submit", (e) => { e.preventDefault(); loadUsage(); });
+$("request-filters").addEventListener("submit", (e) => { e.preventDefault(); loadRequests(); });
+$("request-list").addEventListener("click", (e) => {
+  const row = e.target.closest("tr[data-id]");
+  if (row) showRequest(row.dataset.id);
+});
+$("inflight").addEventListener("click", async (e) => {
+  const id = e.target.dataset.cancel;
+  if (id && confirm(`Cancel request ${id}?`)) {
+    await api(`/admin/inflight/${encodeURIComponent(id)}/cancel`, "POST").catch((err) => alert(err.message));
+    loadLive();
+  }
+});
+$("playground-form").addEventListener("submit", sendPlayground);
+window.addEventListener("hashchange", route);
+route();
@@ -0,0 +1,131 @@
+// Package ui serves the embedded admin dashboard and the session that lets
+// a browser use the admin API.
+package ui
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"embed"
+	"encoding/hex"
+	"io/fs"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SessionCookie holds the dashboard session, which authenticates the
+// browser to the /ui and /admin endpoints.
+const SessionCookie = "copilot_proxy_admin"
+
+//go:embed static
+var static embed.FS
+
+// Handler serves the dashboard assets under /ui/.
+func Handler() http.Handler {
+	assets, err := fs.Sub(static, "static")
+	if err != nil {
+		panic(err)
+	}
+	return http.StripPrefix("/ui", http.FileServerFS(assets))
+}
+
+// LoginPage handles GET /ui/login.
+func LoginPage(w http.ResponseWriter, r *http.Request) {
+	http.ServeFileFS(w, r, static, "static/login.html")
+}
+
+// SessionTTL is how long a dashboard session lasts.
+const SessionTTL = 12 * time.Hour
+
+// Sessions holds the dashboard sessions, random IDs valid until they
+// expire or their browser logs out. Restarting the proxy, which a new
+// admin key requires, ends every session.
+type Sessions struct {
+	adminKey string
+	ttl      time.Duration
+
+	mu       sync.Mutex
+	sessions map[string]time.Time // session ID to expiry
+}
+
+// NewSessions creates the session store for adminKey.
+func NewSessions(adminKey string, ttl time.Duration) *Sessions {
+	return &Sessions{adminKey: adminKey, ttl: ttl, sessions: make(map[string]time.Time)}
+}
+
+// Authenticated reports whether r carries a live session.
+func (s *Sessions) Authenticated(r *http.Request) bool {
+	if s.adminKey == "" {
+		return false
+	}
+	cookie, err := r.Cookie(SessionCookie)
+	if err != nil {
+		return false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	expires, ok := s.sessions[cookie.Value]
+	if ok && time.Now().After(expires) {
+		delete(s.sessions, cookie.Value)
+		return false
+	}
+	return ok
+}
+
+// Login handles POST /ui/login, starting a session when the form's key
+// field holds the admin key.
+func (s *Sessions) Login(w http.ResponseWriter, r *http.Request) {
+	key := r.PostFormValue("key")
+	if s.adminKey == "" || subtle.ConstantTimeCompare([]byte(key), []byte(s.adminKey)) != 1 {
+		http.Redirect(w, r, "/ui/login?error=1", http.StatusSeeOther)
+		return
+	}
+
+	var id [32]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		http.Error(w, "failed to start session", http.StatusInternalServerError)
+		return
+	}
+	session := hex.EncodeToString(id[:])
+
+	now := time.Now()
+	s.mu.Lock()
+	for old, expires := range s.sessions {
+		if now.After(expires) {
+			delete(s.sessions, old)
+		}
+	}
+	s.sessions[session] = now.Add(s.ttl)
+	s.mu.Unlock()
+
+	setSession(w, r, session, int(s.ttl.Seconds()))
+	http.Redirect(w, r, "/ui/", http.StatusSeeOther)
+}
+
+// Logout handles POST /ui/logout, ending the request's session.
+func (s *Sessions) Logout(w http.ResponseWriter, r *http.Request) {
+	if cookie, err := r.Cookie(SessionCookie); err == nil {
+		s.mu.Lock()
+		delete(s.sessions, cookie.Value)
+		s.mu.Unlock()
+	}
+	setSession(w, r, "", -1)
+	http.Redirect(w, r, "/ui/login", http.StatusSeeOther)
+}
+
+// setSession sets or, with a negative maxAge, clears the session cookie.
+// The cookie is strictly same-site so that other sites cannot make
+// authenticated admin requests.
+func setSession(w http.ResponseWriter, r *http.Request, value string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookie,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   r.TLS != nil || strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https"),
+		SameSite: http.SameSiteStrictMode,
+	})
+}
@@ -0,0 +1,99 @@
+package ui
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandlerServesAssets(t *testing.T) {
+	for path, want := range map[string]string{
+		"/ui/":          "<title>Copilot Proxy · Dashboard</title>",
+		"/ui/app.js":    "/admin/inflight",
+		"/ui/style.css": ":root",
+	} {
+		rec := httptest.NewRecorder()
+		Handler().ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("GET %s: status %d, body missing %q", path, rec.Code, want)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	LoginPage(rec, httptest.NewRequest("GET", "/ui/login", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `action="/ui/login"`) {
+		t.Errorf("unexpected login page: %d", rec.Code)
+	}
+}
+
+func login(sessions *Sessions, key string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("POST", "/ui/login", strings.NewReader(url.Values{"key": {key}}.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	sessions.Login(rec, r)
+	return rec
+}
+
+func TestLoginSession(t *testing.T) {
+	sessions := NewSessions("admin-secret", time.Hour)
+	rec := login(sessions, "wrong")
+	if loc := rec.Header().Get("Location"); rec.Code != http.StatusSeeOther || loc != "/ui/login?error=1" || len(rec.Result().Cookies()) != 0 {
+		t.Fatalf("expected failed login to redirect without a session, got %d %s", rec.Code, loc)
+	}
+
+	rec = login(sessions, "admin-secret")
+	cookies := rec.Result().Cookies()
+	if rec.Header().Get("Location") != "/ui/" || len(cookies) != 1 {
+		t.Fatalf("expected session cookie and redirect to the dashboard, got %v", cookies)
+	}
+	cookie := cookies[0]
+	if cookie.Name != SessionCookie || !cookie.HttpOnly || cookie.SameSite != http.SameSiteStrictMode || cookie.MaxAge != 3600 || cookie.Value == "admin-secret" {
+		t.Errorf("unexpected session cookie %+v", cookie)
+	}
+
+	r := httptest.NewRequest("GET", "/admin/requests", nil)
+	r.AddCookie(cookie)
+	if !sessions.Authenticated(r) {
+		t.Error("expected session to authenticate")
+	}
+	if NewSessions("rotated-secret", time.Hour).Authenticated(r) {
+		t.Error("expected session to end when the proxy restarts")
+	}
+	if sessions.Authenticated(httptest.NewRequest("GET", "/admin/requests", nil)) {
+		t.Error("expected request without a session to be rejected")
+	}
+	if other := login(sessions, "admin-secret").Result().Cookies()[0]; other.Value == cookie.Value {
+		t.Error("expected each login to start a new session")
+	}
+
+	rec = httptest.NewRecorder()
+	logout := httptest.NewRequest("POST", "/ui/logout", nil)
+	logout.AddCookie(cookie)
+	sessions.Logout(rec, logout)
+	if cookies := rec.Result().Cookies(); len(cookies) != 1 || cookies[0].MaxAge >= 0 {
+		t.Errorf("expected logout to clear the session, got %v", cookies)
+	}
+	if sessions.Authenticated(r) {
+		t.Error("expected logout to end the session")
+	}
+}
+
+func TestSessionExpires(t *testing.T) {
+	sessions := NewSessions("admin-secret", time.Hour)
+	cookie := login(sessions, "admin-secret").Result().Cookies()[0]
+	r := httptest.NewRequest("GET", "/admin/requests", nil)
+	r.AddCookie(cookie)
+
+	sessions.mu.Lock()
+	sessions.sessions[cookie.Value] = time.Now().Add(-time.Second)
+	sessions.mu.Unlock()
+	if sessions.Authenticated(r) {
+		t.Error("expected expired session to be rejected")
+	}
+	if len(sessions.sessions) != 0 {
+		t.Error("expected expired session to be removed")
+	}
+}
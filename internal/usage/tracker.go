@@ -0,0 +1,393 @@
+// Package usage tracks Copilot premium request consumption and quota.
+package usage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rahulvramesh/gh-proxy-local/internal/apikeys"
+	"github.com/rahulvramesh/gh-proxy-local/internal/config"
+	"github.com/rahulvramesh/gh-proxy-local/internal/logging"
+	"github.com/rahulvramesh/gh-proxy-local/internal/storage"
+)
+
+// DefaultMultipliers lists premium request multipliers for paid plans.
+// Models with a multiplier of 0 are included in the plan and do not
+// consume premium requests.
+var DefaultMultipliers = map[string]float64{
+	"gpt-4.1":                   0,
+	"gpt-4o":                    0,
+	"gpt-5-mini":                0,
+	"grok-code-fast-1":          0,
+	"claude-haiku-4.5":          0.33,
+	"o4-mini":                   0.33,
+	"gemini-2.0-flash-001":      0.25,
+	"claude-sonnet-4":           1,
+	"claude-sonnet-4.5":         1,
+	"claude-3.5-sonnet":         1,
+	"claude-3.7-sonnet":         1,
+	"claude-3.7-sonnet-thought": 1.25,
+	"claude-opus-4.5":           3,
+	"claude-opus-41":            10,
+	"gemini-2.5-pro":            1,
+	"gemini-3-pro-preview":      1,
+	"gpt-5":                     1,
+	"gpt-5-codex":               1,
+	"gpt-5.1":                   1,
+	"gpt-5.1-codex":             1,
+	"o3":                        1,
+}
+
+// retention is how long daily usage is kept.
+const retention = 90 * 24 * time.Hour
+
+// ErrSoftLimit is returned by Admit when the soft quota limit blocks a
+// premium request.
+var ErrSoftLimit = errors.New("premium request quota soft limit reached")
+
+// Snapshot is the premium request quota reported by Copilot.
+type Snapshot struct {
+	Entitlement      float64   `json:"entitlement"`
+	Remaining        float64   `json:"remaining"`
+	PercentRemaining float64   `json:"percent_remaining"`
+	Unlimited        bool      `json:"unlimited"`
+	OverageCount     float64   `json:"overage_count"`
+	OveragePermitted bool      `json:"overage_permitted"`
+	ResetDate        string    `json:"reset_date,omitempty"`
+	FetchedAt        time.Time `json:"fetched_at"`
+}
+
+// SnapshotFunc fetches the current quota snapshot.
+type SnapshotFunc func(ctx context.Context) (*Snapshot, error)
+
+// Entry aggregates request counts.
+type Entry struct {
+	Requests        int     `json:"requests"`
+	PremiumRequests float64 `json:"premium_requests"`
+}
+
+func (e *Entry) add(o Entry) {
+	e.Requests += o.Requests
+	e.PremiumRequests += o.PremiumRequests
+}
+
+// state is the persisted form of the tracker: day -> model -> key -> entry.
+type state struct {
+	Days map[string]map[string]map[string]*Entry `json:"days"`
+}
+
+// Tracker records premium request consumption and polls the quota.
+type Tracker struct {
+	path     string
+	cfg      config.UsageConfig
+	snapshot SnapshotFunc
+	debug    bool
+
+	mu            sync.Mutex
+	state         state
+	dirty         bool
+	quota         *Snapshot
+	sinceSnapshot float64
+	warned        bool
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewTracker opens the usage store at path. snapshot may be nil, in which
+// case quota information is unavailable.
+func NewTracker(path string, cfg config.UsageConfig, snapshot SnapshotFunc, debug bool) (*Tracker, error) {
+	t := &Tracker{
+		path:     path,
+		cfg:      cfg,
+		snapshot: snapshot,
+		debug:    debug,
+		state:    state{Days: make(map[string]map[string]map[string]*Entry)},
+		done:     make(chan struct{}),
+	}
+
+	if err := storage.ReadJSON(path, &t.state); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read usage: %w", err)
+	}
+	if t.state.Days == nil {
+		t.state.Days = make(map[string]map[string]map[string]*Entry)
+	}
+	return t, nil
+}
+
+// Start begins quota polling and periodic persistence.
+func (t *Tracker) Start() {
+	t.wg.Add(1)
+	go t.loop()
+}
+
+// Shutdown stops polling and saves pending usage.
+func (t *Tracker) Shutdown() {
+	close(t.done)
+	t.wg.Wait()
+	t.save()
+}
+
+func (t *Tracker) loop() {
+	defer t.wg.Done()
+
+	t.Refresh(context.Background())
+
+	var poll <-chan time.Time
+	if t.cfg.PollInterval > 0 && t.snapshot != nil {
+		ticker := time.NewTicker(t.cfg.PollInterval)
+		defer ticker.Stop()
+		poll = ticker.C
+	}
+	saveTicker := time.NewTicker(30 * time.Second)
+	defer saveTicker.Stop()
+
+	for {
+		select {
+		case <-t.done:
+			return
+		case <-poll:
+			t.Refresh(context.Background())
+		case <-saveTicker.C:
+			t.save()
+		}
+	}
+}
+
+// Refresh fetches a new quota snapshot.
+func (t *Tracker) Refresh(ctx context.Context) {
+	if t.snapshot == nil {
+		return
+	}
+	snap, err := t.snapshot(ctx)
+	if err != nil {
+		if t.debug || logging.DebugEnabled() {
+			slog.DebugContext(ctx, "Failed to fetch quota snapshot", "component", "usage", "error", err)
+		}
+		return
+	}
+	snap.FetchedAt = time.Now()
+
+	t.mu.Lock()
+	// Warn again on the next crossing, or in the next quota period
+	if t.quota != nil && snap.ResetDate != t.quota.ResetDate || snap.Unlimited || snap.Remaining > t.cfg.SoftLimit {
+		t.warned = false
+	}
+	t.quota = snap
+	t.sinceSnapshot = 0
+	t.mu.Unlock()
+
+	if t.debug || logging.DebugEnabled() {
+		slog.DebugContext(ctx, "Quota snapshot", "component", "usage", "remaining", snap.Remaining, "entitlement", snap.Entitlement)
+	}
+}
+
+// save persists usage if it changed, dropping days past retention.
+func (t *Tracker) save() {
+	t.mu.Lock()
+	if !t.dirty {
+		t.mu.Unlock()
+		return
+	}
+	cutoff := time.Now().Add(-retention).Format("2006-01-02")
+	for day := range t.state.Days {
+		if day < cutoff {
+			delete(t.state.Days, day)
+		}
+	}
+	err := storage.WriteJSON(t.path, &t.state)
+	if err == nil {
+		t.dirty = false
+	}
+	t.mu.Unlock()
+
+	if err != nil {
+		slog.Error("Failed to save usage", "component", "usage", "error", err)
+	}
+}
+
+// Multiplier returns the premium request multiplier for a model.
+func (t *Tracker) Multiplier(model string) float64 {
+	if m, ok := t.cfg.Multipliers[model]; ok {
+		return m
+	}
+	if m, ok := DefaultMultipliers[model]; ok {
+		return m
+	}
+	return t.cfg.DefaultMultiplier
+}
+
+// Remaining returns the estimated remaining premium requests: the last
+// snapshot minus what was recorded since. ok is false when no snapshot is
+// available or the quota is unlimited.
+func (t *Tracker) Remaining() (remaining float64, ok bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.remainingLocked()
+}
+
+func (t *Tracker) remainingLocked() (float64, bool) {
+	if t.quota == nil || t.quota.Unlimited {
+		return 0, false
+	}
+	remaining := t.quota.Remaining - t.sinceSnapshot
+	if remaining < 0 {
+		remaining = 0
+	}
+	return remaining, true
+}
+
+// SoftLimitReached reports whether the remaining quota is at or below the
+// configured soft limit.
+func (t *Tracker) SoftLimitReached() bool {
+	if t.cfg.SoftLimit <= 0 {
+		return false
+	}
+	remaining, ok := t.Remaining()
+	return ok && remaining <= t.cfg.SoftLimit
+}
+
+// Admit refuses user-initiated premium requests once the soft limit is
+// reached and the soft action is "block". With "warn" it only logs.
+func (t *Tracker) Admit(ctx context.Context, model, initiator string) error {
+	if initiator != "user" || t.Multiplier(model) == 0 || !t.SoftLimitReached() {
+		return nil
+	}
+
+	if t.cfg.SoftAction == "block" {
+		return fmt.Errorf("%w: %s consumes premium requests and the remaining quota is at or below %.0f", ErrSoftLimit, model, t.cfg.SoftLimit)
+	}
+
+	t.mu.Lock()
+	warn := !t.warned
+	t.warned = true
+	t.mu.Unlock()
+	if warn {
+		slog.WarnContext(ctx, "Premium request quota at or below soft limit", "component", "usage", "soft_limit", t.cfg.SoftLimit)
+	}
+	return nil
+}
+
+// Record counts a request accepted by the upstream. Only user-initiated
+// requests consume premium requests.
+func (t *Tracker) Record(ctx context.Context, model, initiator string) {
+	entry := Entry{Requests: 1}
+	if initiator == "user" {
+		entry.PremiumRequests = t.Multiplier(model)
+	}
+	t.add(time.Now(), model, apikeys.NameFromContext(ctx), entry)
+}
+
+func (t *Tracker) add(at time.Time, model, key string, entry Entry) {
+	day := at.Format("2006-01-02")
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	models := t.state.Days[day]
+	if models == nil {
+		models = make(map[string]map[string]*Entry)
+		t.state.Days[day] = models
+	}
+	keys := models[model]
+	if keys == nil {
+		keys = make(map[string]*Entry)
+		models[model] = keys
+	}
+	e := keys[key]
+	if e == nil {
+		e = &Entry{}
+		keys[key] = e
+	}
+	e.add(entry)
+
+	t.sinceSnapshot += entry.PremiumRequests
+	t.dirty = true
+}
+
+// Report summarizes quota and consumption between since and until
+// (inclusive, YYYY-MM-DD).
+func (t *Tracker) Report(since, until string) map[string]interface{} {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	total := Entry{}
+	byModel := make(map[string]*Entry)
+	byKey := make(map[string]*Entry)
+	byDay := make(map[string]*Entry)
+
+	days := make([]string, 0, len(t.state.Days))
+	for day := range t.state.Days {
+		if (since == "" || day >= since) && (until == "" || day <= until) {
+			days = append(days, day)
+		}
+	}
+	sort.Strings(days)
+
+	for _, day := range days {
+		for model, keys := range t.state.Days[day] {
+			for key, e := range keys {
+				total.add(*e)
+				for _, bucket := range []struct {
+					m    map[string]*Entry
+					name string
+				}{{byModel, model}, {byKey, key}, {byDay, day}} {
+					if bucket.m[bucket.name] == nil {
+						bucket.m[bucket.name] = &Entry{}
+					}
+					bucket.m[bucket.name].add(*e)
+				}
+			}
+		}
+	}
+
+	quota := map[string]interface{}{"available": false}
+	if t.quota != nil {
+		quota = map[string]interface{}{
+			"available":          true,
+			"entitlement":        t.quota.Entitlement,
+			"snapshot_remaining": t.quota.Remaining,
+			"percent_remaining":  t.quota.PercentRemaining,
+			"unlimited":          t.quota.Unlimited,
+			"overage_count":      t.quota.OverageCount,
+			"overage_permitted":  t.quota.OveragePermitted,
+			"reset_date":         t.quota.ResetDate,
+			"fetched_at":         t.quota.FetchedAt,
+			"used_since_fetch":   t.sinceSnapshot,
+		}
+		if remaining, ok := t.remainingLocked(); ok {
+			quota["remaining"] = remaining
+		}
+	}
+
+	softLimit := map[string]interface{}{
+		"enabled":   t.cfg.SoftLimit > 0,
+		"threshold": t.cfg.SoftLimit,
+		"action":    t.cfg.SoftAction,
+	}
+	if remaining, ok := t.remainingLocked(); ok && t.cfg.SoftLimit > 0 {
+		softLimit["reached"] = remaining <= t.cfg.SoftLimit
+	}
+
+	return map[string]interface{}{
+		"object":     "usage",
+		"quota":      quota,
+		"soft_limit": softLimit,
+		"period": map[string]interface{}{
+			"since": since,
+			"until": until,
+		},
+		"consumption": map[string]interface{}{
+			"total":    total,
+			"by_model": byModel,
+			"by_key":   byKey,
+			"by_day":   byDay,
+		},
+	}
+}
@@ -0,0 +1,183 @@
+package usage
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rahulvramesh/gh-proxy-local/internal/apikeys"
+	"github.com/rahulvramesh/gh-proxy-local/internal/config"
+)
+
+func newTestTracker(t *testing.T, cfg config.UsageConfig, snap *Snapshot) *Tracker {
+	t.Helper()
+	var fn SnapshotFunc
+	if snap != nil {
+		fn = func(ctx context.Context) (*Snapshot, error) {
+			s := *snap
+			return &s, nil
+		}
+	}
+	tracker, err := NewTracker(filepath.Join(t.TempDir(), "usage.json"), cfg, fn, false)
+	if err != nil {
+		t.Fatalf("NewTracker failed: %v", err)
+	}
+	tracker.Refresh(context.Background())
+	return tracker
+}
+
+func TestMultiplier(t *testing.T) {
+	tracker := newTestTracker(t, config.UsageConfig{
+		Multipliers:       map[string]float64{"gpt-5": 2},
+		DefaultMultiplier: 1.5,
+	}, nil)
+
+	tests := []struct {
+		model    string
+		expected float64
+	}{
+		{"gpt-5", 2},
+		{"gpt-4.1", 0},
+		{"claude-haiku-4.5", 0.33},
+		{"unknown-model", 1.5},
+	}
+	for _, tt := range tests {
+		if got := tracker.Multiplier(tt.model); got != tt.expected {
+			t.Errorf("Multiplier(%q) = %v, want %v", tt.model, got, tt.expected)
+		}
+	}
+}
+
+func TestRecordAndReport(t *testing.T) {
+	tracker := newTestTracker(t, config.UsageConfig{DefaultMultiplier: 1}, &Snapshot{Entitlement: 300, Remaining: 100, ResetDate: "2026-11-01"})
+
+	ctx := apikeys.WithKey(context.Background(), &apikeys.Key{Name: "ci"})
+	tracker.Record(ctx, "claude-sonnet-4", "user")
+	tracker.Record(ctx, "claude-sonnet-4", "agent")
+	tracker.Record(context.Background(), "gpt-4.1", "user")
+
+	if remaining, ok := tracker.Remaining(); !ok || remaining != 99 {
+		t.Errorf("Remaining() = %v, %v, want 99, true", remaining, ok)
+	}
+
+	report := tracker.Report("", "")
+	consumption := report["consumption"].(map[string]interface{})
+	total := consumption["total"].(Entry)
+	if total.Requests != 3 || total.PremiumRequests != 1 {
+		t.Errorf("total = %+v, want 3 requests, 1 premium request", total)
+	}
+	byKey := consumption["by_key"].(map[string]*Entry)
+	if byKey["ci"].Requests != 2 || byKey["anonymous"].Requests != 1 {
+		t.Errorf("by_key = ci:%+v anonymous:%+v", byKey["ci"], byKey["anonymous"])
+	}
+	byModel := consumption["by_model"].(map[string]*Entry)
+	if byModel["gpt-4.1"].PremiumRequests != 0 {
+		t.Errorf("gpt-4.1 premium requests = %v, want 0", byModel["gpt-4.1"].PremiumRequests)
+	}
+	quota := report["quota"].(map[string]interface{})
+	if quota["reset_date"] != "2026-11-01" {
+		t.Errorf("reset_date = %v", quota["reset_date"])
+	}
+
+	future := time.Now().AddDate(0, 0, 1).Format("2006-01-02")
+	consumption = tracker.Report(future, "")["consumption"].(map[string]interface{})
+	if total := consumption["total"].(Entry); total.Requests != 0 {
+		t.Errorf("filtered total = %+v, want none", total)
+	}
+}
+
+func TestAdmitSoftLimit(t *testing.T) {
+	snap := &Snapshot{Entitlement: 300, Remaining: 10}
+
+	block := newTestTracker(t, config.UsageConfig{DefaultMultiplier: 1, SoftLimit: 10, SoftAction: "block"}, snap)
+	ctx := context.Background()
+	if err := block.Admit(ctx, "claude-sonnet-4", "user"); !errors.Is(err, ErrSoftLimit) {
+		t.Errorf("expected ErrSoftLimit, got %v", err)
+	}
+	if err := block.Admit(ctx, "claude-sonnet-4", "agent"); err != nil {
+		t.Errorf("agent requests should be admitted, got %v", err)
+	}
+	if err := block.Admit(ctx, "gpt-4.1", "user"); err != nil {
+		t.Errorf("included models should be admitted, got %v", err)
+	}
+
+	warn := newTestTracker(t, config.UsageConfig{DefaultMultiplier: 1, SoftLimit: 10, SoftAction: "warn"}, snap)
+	if err := warn.Admit(ctx, "claude-sonnet-4", "user"); err != nil {
+		t.Errorf("warn mode should admit, got %v", err)
+	}
+
+	unlimited := newTestTracker(t, config.UsageConfig{DefaultMultiplier: 1, SoftLimit: 10, SoftAction: "block"}, &Snapshot{Unlimited: true})
+	if err := unlimited.Admit(ctx, "claude-sonnet-4", "user"); err != nil {
+		t.Errorf("unlimited quota should admit, got %v", err)
+	}
+}
+
+func TestSoftLimitWarnsOnEachCrossing(t *testing.T) {
+	snap := Snapshot{Entitlement: 300, Remaining: 5, ResetDate: "2026-11-01"}
+	tracker, err := NewTracker(filepath.Join(t.TempDir(), "usage.json"), config.UsageConfig{DefaultMultiplier: 1, SoftLimit: 10, SoftAction: "warn"},
+		func(ctx context.Context) (*Snapshot, error) {
+			s := snap
+			return &s, nil
+		}, false)
+	if err != nil {
+		t.Fatalf("NewTracker failed: %v", err)
+	}
+	ctx := context.Background()
+	admit := func() bool {
+		tracker.Admit(ctx, "claude-sonnet-4", "user")
+		tracker.mu.Lock()
+		defer tracker.mu.Unlock()
+		return tracker.warned
+	}
+
+	tracker.Refresh(ctx)
+	if !admit() {
+		t.Fatal("expected a warning below the soft limit")
+	}
+	tracker.Refresh(ctx)
+	if !tracker.warned {
+		t.Error("expected the warning not to repeat while still below the soft limit")
+	}
+
+	// The quota recovers, then falls below the soft limit again
+	snap.Remaining = 100
+	tracker.Refresh(ctx)
+	if tracker.warned {
+		t.Error("expected the warning to reset once the quota is above the soft limit")
+	}
+	snap.Remaining = 5
+	tracker.Refresh(ctx)
+	if !admit() {
+		t.Error("expected a warning on the next crossing")
+	}
+
+	// A new quota period warns again even when it starts below the limit
+	snap.ResetDate = "2026-12-01"
+	tracker.Refresh(ctx)
+	if tracker.warned {
+		t.Error("expected the warning to reset with the quota period")
+	}
+}
+
+func TestPersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "usage.json")
+	cfg := config.UsageConfig{DefaultMultiplier: 1}
+
+	tracker, err := NewTracker(path, cfg, nil, false)
+	if err != nil {
+		t.Fatalf("NewTracker failed: %v", err)
+	}
+	tracker.Record(apikeys.WithKey(context.Background(), &apikeys.Key{Name: "ci"}), "gpt-5", "user")
+	tracker.save()
+
+	reopened, err := NewTracker(path, cfg, nil, false)
+	if err != nil {
+		t.Fatalf("NewTracker failed: %v", err)
+	}
+	total := reopened.Report("", "")["consumption"].(map[string]interface{})["total"].(Entry)
+	if total.Requests != 1 || total.PremiumRequests != 1 {
+		t.Errorf("total after reopen = %+v", total)
+	}
+}